@@ -29,6 +29,12 @@ func (op *AuthorizeOp) Type() string {
 	return OpTypeAccessCodeAuth
 }
 
+// TrafficClass returns the traffic class used for scheduling the operation's
+// messages.
+func (op *AuthorizeOp) TrafficClass() terminal.TrafficClass {
+	return terminal.TrafficClassControl
+}
+
 // AuthorizeToTerminal starts an authorization operation.
 func AuthorizeToTerminal(t terminal.Terminal) (*AuthorizeOp, *terminal.Error) {
 	op := &AuthorizeOp{}
@@ -66,8 +72,8 @@ func checkAccessCode(t terminal.Terminal, opID uint32, initData *container.Conta
 		return nil, terminal.ErrIncorrectUsage.With("terminal does not handle authorization")
 	}
 
-	// Grant permissions.
-	authTerm.GrantPermission(granted)
+	// Apply grant.
+	authTerm.ApplyGrant(granted)
 	log.Debugf("spn/access: granted %s permissions via %s zone", t.FmtID(), receivedToken.Zone)
 
 	// End successfully.
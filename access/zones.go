@@ -20,11 +20,18 @@ var (
 	// connect operations.
 	ExpandAndConnectZones = []string{"pblind1", "alpha2", "fallback1"}
 
-	zonePermissions = map[string]terminal.Permission{
-		"pblind1":   terminal.AddPermissions(terminal.MayExpand, terminal.MayConnect),
-		"alpha2":    terminal.AddPermissions(terminal.MayExpand, terminal.MayConnect),
-		"fallback1": terminal.AddPermissions(terminal.MayExpand, terminal.MayConnect),
+	// zoneGrants holds the grants given by the tokens of a zone.
+	// The zone is part of the token and is covered by its verification - eg.
+	// pblind tokens are signed together with their zone - so the scope of a
+	// grant is bound to the token. Zones with limited access, eg. web-only or
+	// bandwidth-capped, are registered with a restricted grant.
+	zoneGrants = make(map[string]*terminal.Grant)
+
+	// expandAndConnectGrant is the unrestricted grant of the primary zones.
+	expandAndConnectGrant = &terminal.Grant{
+		Permission: terminal.AddPermissions(terminal.MayExpand, terminal.MayConnect),
 	}
+
 	persistentZones = ExpandAndConnectZones
 
 	enableTestMode = abool.New()
@@ -69,6 +76,7 @@ func InitializeZones() error {
 	if err != nil {
 		return fmt.Errorf("failed to register pblind1 token handler: %w", err)
 	}
+	zoneGrants["pblind1"] = expandAndConnectGrant
 
 	// Register fallback1 zone as fallback when the issuer is not available.
 	sh, err := token.NewScrambleHandler(token.ScrambleOptions{
@@ -84,6 +92,7 @@ func InitializeZones() error {
 	if err != nil {
 		return fmt.Errorf("failed to register fallback1 token handler: %w", err)
 	}
+	zoneGrants["fallback1"] = expandAndConnectGrant
 
 	// Register alpha2 zone for transition phase.
 	sh, err = token.NewScrambleHandler(token.ScrambleOptions{
@@ -98,6 +107,7 @@ func InitializeZones() error {
 	if err != nil {
 		return fmt.Errorf("failed to register alpha2 token handler: %w", err)
 	}
+	zoneGrants["alpha2"] = expandAndConnectGrant
 
 	return nil
 }
@@ -116,9 +126,7 @@ func initializeTestZone() error {
 
 	// Set eligible zones.
 	ExpandAndConnectZones = []string{"unittest"}
-	zonePermissions = map[string]terminal.Permission{
-		"unittest": terminal.AddPermissions(terminal.MayExpand, terminal.MayConnect),
-	}
+	zoneGrants = make(map[string]*terminal.Grant)
 
 	// Register unittest zone as for testing.
 	sh, err := token.NewScrambleHandler(token.ScrambleOptions{
@@ -134,6 +142,26 @@ func initializeTestZone() error {
 	if err != nil {
 		return fmt.Errorf("failed to register unittest token handler: %w", err)
 	}
+	zoneGrants["unittest"] = expandAndConnectGrant
+
+	// Register unittest-web zone for testing restricted grants.
+	sh, err = token.NewScrambleHandler(token.ScrambleOptions{
+		Zone:          "unittest-web",
+		Algorithm:     lhash.BLAKE2b_256,
+		InitialTokens: []string{"AwQDBKRKBd9gaTPYzsmxgM4AfMkB2rBzBeVPf6MxxNpH"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create unittest-web token handler: %w", err)
+	}
+	err = token.RegisterScrambleHandler(sh)
+	if err != nil {
+		return fmt.Errorf("failed to register unittest-web token handler: %w", err)
+	}
+	zoneGrants["unittest-web"] = &terminal.Grant{
+		Permission: terminal.MayConnect,
+		Ports:      []uint16{80, 443},
+		MaxOps:     10,
+	}
 
 	return nil
 }
@@ -221,37 +249,36 @@ handlerSelection:
 }
 
 // VerifyRawToken verifies a raw token.
-func VerifyRawToken(data []byte) (granted terminal.Permission, err error) {
+func VerifyRawToken(data []byte) (granted *terminal.Grant, err error) {
 	t, err := token.ParseRawToken(data)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse token: %w", err)
+		return nil, fmt.Errorf("failed to parse token: %w", err)
 	}
 
 	return VerifyToken(t)
 }
 
-// VerifyToken verifies a token.
-func VerifyToken(t *token.Token) (granted terminal.Permission, err error) {
+// VerifyToken verifies a token and returns the grant of its zone.
+// As the zone is verified together with the token, the returned grant defines
+// the scope given by the token. It is nil if the zone does not grant anything
+// and must not be modified.
+func VerifyToken(t *token.Token) (granted *terminal.Grant, err error) {
 	handler, ok := token.GetHandler(t.Zone)
 	if !ok {
-		return terminal.NoPermission, token.ErrZoneUnknown
+		return nil, token.ErrZoneUnknown
 	}
 
 	// Check if the token is a fallback token.
 	if handler.IsFallback() && !healthCheck() {
-		return terminal.NoPermission, ErrFallbackNotAvailable
+		return nil, ErrFallbackNotAvailable
 	}
 
 	// Verify token.
 	err = handler.Verify(t)
 	if err != nil {
-		return 0, fmt.Errorf("failed to verify token: %w", err)
+		return nil, fmt.Errorf("failed to verify token: %w", err)
 	}
 
-	// Return permission of zone.
-	granted, ok = zonePermissions[t.Zone]
-	if !ok {
-		return terminal.NoPermission, nil
-	}
-	return granted, nil
+	// Return grant of zone.
+	return zoneGrants[t.Zone], nil
 }
@@ -11,6 +11,9 @@ import (
 	"github.com/safing/spn/hub"
 )
 
+// maxCoverTrafficRate defines the maximum cover traffic rate in KB/s.
+const maxCoverTrafficRate = 100_000
+
 // Configuration Keys.
 var (
 	// Name of the node.
@@ -85,6 +88,12 @@ var (
 	publicCfgOptionExit        config.StringArrayOption
 	publicCfgOptionExitDefault = []string{"- * TCP/25"}
 	publicCfgOptionExitOrder   = 522
+
+	// Cover Traffic.
+	publicCfgOptionCoverTrafficKey     = "spn/publicHub/coverTraffic"
+	publicCfgOptionCoverTraffic        config.IntOption
+	publicCfgOptionCoverTrafficDefault = 0
+	publicCfgOptionCoverTrafficOrder   = 523
 )
 
 func prepPublicHubConfig() error {
@@ -266,12 +275,47 @@ func prepPublicHubConfig() error {
 	}
 	publicCfgOptionExit = config.GetAsStringArray(publicCfgOptionExitKey, publicCfgOptionExitDefault)
 
+	err = config.Register(&config.Option{
+		Name:           "Cover Traffic",
+		Key:            publicCfgOptionCoverTrafficKey,
+		Description:    "Send constant cover traffic on lanes to other Hubs at the defined rate in KB/s. Gaps are filled with padding in order to hide the amount of real traffic. Hubs answer cover traffic up to their own configured rate. Zero disables cover traffic.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		DefaultValue:   publicCfgOptionCoverTrafficDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: publicCfgOptionCoverTrafficOrder,
+			config.UnitAnnotation:         "KB/s",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	publicCfgOptionCoverTraffic = config.Concurrent.GetAsInt(publicCfgOptionCoverTrafficKey, int64(publicCfgOptionCoverTrafficDefault))
+
 	// update defaults from system
 	setDynamicPublicDefaults()
 
 	return nil
 }
 
+// CoverTrafficRate returns the configured cover traffic rate for lanes to
+// other Hubs in bytes per second. Zero means that cover traffic is disabled.
+func CoverTrafficRate() uint32 {
+	if publicCfgOptionCoverTraffic == nil {
+		return 0
+	}
+
+	rate := publicCfgOptionCoverTraffic()
+	switch {
+	case rate <= 0:
+		return 0
+	case rate > maxCoverTrafficRate:
+		return maxCoverTrafficRate * 1000
+	default:
+		return uint32(rate) * 1000
+	}
+}
+
 func getPublicHubInfo() *hub.Announcement {
 	// get configuration
 	info := &hub.Announcement{
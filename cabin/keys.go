@@ -17,6 +17,9 @@ type providedExchKeyScheme struct {
 	id            string
 	securityLevel int //nolint:structcheck // TODO
 	tool          *tools.Tool
+	// postQuantum signifies that keys of this scheme are published in
+	// Status.PQKeys instead of Status.Keys.
+	postQuantum bool
 }
 
 var (
@@ -36,18 +39,32 @@ var (
 			id:            "ECDH-X25519",
 			securityLevel: 128, // informative only, security level of ECDH-X25519 is fixed
 		},
+		{
+			id:            HybridExchKeyScheme,
+			securityLevel: 128, // informative only, security level of the hybrid scheme is fixed
+			postQuantum:   true,
+		},
 		// TODO: test with rsa keys
 	}
 )
 
 func initProvidedExchKeySchemes() error {
+	available := make([]*providedExchKeyScheme, 0, len(provideExchKeySchemes))
 	for _, eks := range provideExchKeySchemes {
 		tool, err := tools.Get(eks.id)
-		if err != nil {
+		switch {
+		case err == nil:
+		case eks.postQuantum:
+			// Post-quantum schemes are only provided if they are available.
+			log.Infof("spn/cabin: exchange key scheme %s is not available", eks.id)
+			continue
+		default:
 			return err
 		}
 		eks.tool = tool
+		available = append(available, eks)
 	}
+	provideExchKeySchemes = available
 	return nil
 }
 
@@ -83,7 +100,12 @@ func (id *Identity) MaintainExchKeys(newStatus *hub.Status, now time.Time) (chan
 	}
 
 	// find or create current keys
+	var providesPQ bool
 	for _, eks := range provideExchKeySchemes {
+		if eks.postQuantum {
+			providesPQ = true
+		}
+
 		found := false
 		for _, exchKey := range id.ExchKeys {
 			if exchKey.key != nil &&
@@ -104,9 +126,10 @@ func (id *Identity) MaintainExchKeys(newStatus *hub.Status, now time.Time) (chan
 	}
 
 	// export most recent keys to HubStatus
-	if changed || len(newStatus.Keys) == 0 {
+	if changed || len(newStatus.Keys) == 0 || (providesPQ && len(newStatus.PQKeys) == 0) {
 		// reset
 		newStatus.Keys = make(map[string]*hub.Key)
+		newStatus.PQKeys = make(map[string]*hub.Key)
 
 		// find longest valid key for every provided scheme
 		for _, eks := range provideExchKeySchemes {
@@ -134,7 +157,11 @@ func (id *Identity) MaintainExchKeys(newStatus *hub.Status, now time.Time) (chan
 				return false, fmt.Errorf("failed to export %s exchange key: %w", longestValid.tool.Info.Name, err)
 			}
 			// add
-			newStatus.Keys[longestValid.key.ID] = hubKey
+			if eks.postQuantum {
+				newStatus.PQKeys[longestValid.key.ID] = hubKey
+			} else {
+				newStatus.Keys[longestValid.key.ID] = hubKey
+			}
 		}
 	}
 
@@ -0,0 +1,320 @@
+package cabin
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/mlkem"
+	"crypto/rand"
+	"crypto/sha3"
+	"errors"
+	"fmt"
+
+	"github.com/safing/jess"
+	"github.com/safing/jess/tools"
+	"github.com/safing/portbase/container"
+)
+
+/*
+
+Hybrid Exchange Key Scheme:
+combines ECDH-X25519 with ML-KEM-768, so that the encapsulated key stays secure
+as long as at least one of them is not broken.
+
+- Key [bytes; not blocked]
+	- Serialization Version [varint]
+	- X25519 Public Key [32 bytes]
+	- ML-KEM-768 Encapsulation Key [1184 bytes]
+	- X25519 Private Key [32 bytes; only on private keys]
+	- ML-KEM-768 Decapsulation Key Seed [64 bytes; only on private keys]
+
+- Encapsulated Key [bytes; not blocked]
+	- Serialization Version [varint]
+	- Ephemeral X25519 Public Key [32 bytes]
+	- ML-KEM-768 Ciphertext [1088 bytes]
+	- Key sealed with AES-256-GCM [bytes; not blocked]
+
+The sealing key is derived from both shared secrets like in X-Wing.
+
+The hybrid scheme is used with the wire suite SuiteWireHybridV1. It equals the
+jess suite SuiteWireV1, but uses the hybrid scheme instead of ECDH-X25519. As
+jess does not provide this suite yet, it is added to the jess suites here.
+
+*/
+
+const (
+	// HybridExchKeyScheme is the ID of the hybrid post-quantum exchange key scheme.
+	HybridExchKeyScheme = "X25519-MLKEM768"
+
+	// SuiteWireHybridV1 is the ID of the jess suite used with the hybrid
+	// exchange key scheme.
+	SuiteWireHybridV1 = "w1pq"
+
+	hybridKeyVersion = 1
+	hybridLabel      = `\.//^\` // X-Wing label.
+)
+
+var hybridGCMNonce = make([]byte, 12) // Every sealing key is only used once.
+
+func init() {
+	tool := &tools.Tool{
+		Info: &tools.ToolInfo{
+			Name:          HybridExchKeyScheme,
+			Purpose:       tools.PurposeKeyEncapsulation,
+			SecurityLevel: 128,
+			Comment:       "hybrid of ECDH-X25519 and ML-KEM-768",
+		},
+		Factory: func() tools.ToolLogic { return &hybridKEM{} },
+	}
+	tools.Register(tool)
+
+	// Jess initializes the static logic of tools in its own init function,
+	// which has already run at this point.
+	tool.StaticLogic = tool.Factory()
+	tool.StaticLogic.Init(tool, &jess.Helper{}, nil, nil)
+
+	// Add the wire suite, unless jess already provides it.
+	suites := jess.SuitesMap()
+	if _, ok := suites[SuiteWireHybridV1]; !ok {
+		suites[SuiteWireHybridV1] = &jess.Suite{
+			ID:            SuiteWireHybridV1,
+			Tools:         []string{HybridExchKeyScheme, "HKDF(BLAKE2b-256)", "CHACHA20-POLY1305"},
+			Provides:      jess.NewRequirements().Remove(jess.SenderAuthentication),
+			SecurityLevel: 128,
+			Status:        jess.SuiteStatusRecommended,
+		}
+	}
+}
+
+// WireSuite returns the jess suite to use for wire sessions to the given signet.
+func WireSuite(signet *jess.Signet) string {
+	if signet.Scheme == HybridExchKeyScheme {
+		return SuiteWireHybridV1
+	}
+	return jess.SuiteWireV1
+}
+
+type hybridPublicKey struct {
+	x   *ecdh.PublicKey
+	kem *mlkem.EncapsulationKey768
+}
+
+type hybridPrivateKey struct {
+	x   *ecdh.PrivateKey
+	kem *mlkem.DecapsulationKey768
+}
+
+// hybridKEM implements the jess tool for the hybrid exchange key scheme.
+type hybridKEM struct {
+	tools.ToolLogicBase
+}
+
+// EncapsulateKey implements the ToolLogic interface.
+func (h *hybridKEM) EncapsulateKey(key []byte, remote tools.SignetInt) ([]byte, error) {
+	pubKey, ok := remote.PublicKey().(*hybridPublicKey)
+	if !ok || pubKey == nil {
+		return nil, tools.ErrInvalidKey
+	}
+
+	// Create shared secrets.
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	xSecret, err := ephemeral.ECDH(pubKey.x)
+	if err != nil {
+		return nil, err
+	}
+	kemSecret, kemCiphertext := pubKey.kem.Encapsulate()
+
+	// Seal key.
+	aead, err := hybridAEAD(kemSecret, xSecret, ephemeral.PublicKey(), pubKey.x)
+	if err != nil {
+		return nil, err
+	}
+
+	c := container.New()
+	c.AppendNumber(hybridKeyVersion)
+	c.Append(ephemeral.PublicKey().Bytes())
+	c.Append(kemCiphertext)
+	c.Append(aead.Seal(nil, hybridGCMNonce, key, nil))
+	return c.CompileData(), nil
+}
+
+// UnwrapKey implements the ToolLogic interface.
+func (h *hybridKEM) UnwrapKey(wrappedKey []byte, local tools.SignetInt) ([]byte, error) {
+	privKey, ok := local.PrivateKey().(*hybridPrivateKey)
+	if !ok || privKey == nil {
+		return nil, tools.ErrInvalidKey
+	}
+
+	// Parse encapsulated key.
+	c := container.New(wrappedKey)
+	version, err := c.GetNextN8()
+	if err != nil || version != hybridKeyVersion {
+		return nil, errors.New("unsupported encapsulated key version")
+	}
+	ephemeralData, err := c.Get(32)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(ephemeralData)
+	if err != nil {
+		return nil, err
+	}
+	kemCiphertext, err := c.Get(mlkem.CiphertextSize768)
+	if err != nil {
+		return nil, err
+	}
+
+	// Recreate shared secrets.
+	xSecret, err := privKey.x.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	kemSecret, err := privKey.kem.Decapsulate(kemCiphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open key.
+	aead, err := hybridAEAD(kemSecret, xSecret, ephemeral, privKey.x.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	key, err := aead.Open(nil, hybridGCMNonce, c.CompileData(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open encapsulated key: %w", err)
+	}
+	return key, nil
+}
+
+// hybridAEAD derives the sealing key from both shared secrets.
+func hybridAEAD(kemSecret, xSecret []byte, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	hash := sha3.New256()
+	hash.Write(kemSecret)
+	hash.Write(xSecret)
+	hash.Write(ephemeral.Bytes())
+	hash.Write(recipient.Bytes())
+	hash.Write([]byte(hybridLabel))
+
+	block, err := aes.NewCipher(hash.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// LoadKey implements the ToolLogic interface.
+func (h *hybridKEM) LoadKey(signet tools.SignetInt) error {
+	key, public := signet.GetStoredKey()
+	c := container.New(key)
+
+	// Check serialization version.
+	version, err := c.GetNextN8()
+	if err != nil || version != hybridKeyVersion {
+		return tools.ErrInvalidKey
+	}
+
+	// Load public keys.
+	pubKey := &hybridPublicKey{}
+	data, err := c.Get(32)
+	if err != nil {
+		return tools.ErrInvalidKey
+	}
+	pubKey.x, err = ecdh.X25519().NewPublicKey(data)
+	if err != nil {
+		return tools.ErrInvalidKey
+	}
+	data, err = c.Get(mlkem.EncapsulationKeySize768)
+	if err != nil {
+		return tools.ErrInvalidKey
+	}
+	pubKey.kem, err = mlkem.NewEncapsulationKey768(data)
+	if err != nil {
+		return tools.ErrInvalidKey
+	}
+	if public {
+		signet.SetLoadedKeys(pubKey, nil)
+		return nil
+	}
+
+	// Load private keys.
+	privKey := &hybridPrivateKey{}
+	data, err = c.Get(32)
+	if err != nil {
+		return tools.ErrInvalidKey
+	}
+	privKey.x, err = ecdh.X25519().NewPrivateKey(data)
+	if err != nil {
+		return tools.ErrInvalidKey
+	}
+	data, err = c.Get(mlkem.SeedSize)
+	if err != nil {
+		return tools.ErrInvalidKey
+	}
+	privKey.kem, err = mlkem.NewDecapsulationKey768(data)
+	if err != nil {
+		return tools.ErrInvalidKey
+	}
+
+	signet.SetLoadedKeys(pubKey, privKey)
+	return nil
+}
+
+// StoreKey implements the ToolLogic interface.
+func (h *hybridKEM) StoreKey(signet tools.SignetInt) error {
+	pubKey, ok := signet.PublicKey().(*hybridPublicKey)
+	if !ok || pubKey == nil {
+		return fmt.Errorf("public key of invalid type %T", signet.PublicKey())
+	}
+	privKey, _ := signet.PrivateKey().(*hybridPrivateKey)
+	public := privKey == nil
+
+	// Create storage with serialization version.
+	c := container.New()
+	c.AppendNumber(hybridKeyVersion)
+
+	// Store keys.
+	c.Append(pubKey.x.Bytes())
+	c.Append(pubKey.kem.Bytes())
+	if !public {
+		c.Append(privKey.x.Bytes())
+		c.Append(privKey.kem.Bytes())
+	}
+
+	signet.SetStoredKey(c.CompileData(), public)
+	return nil
+}
+
+// GenerateKey implements the ToolLogic interface.
+func (h *hybridKEM) GenerateKey(signet tools.SignetInt) error {
+	xKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	kemKey, err := mlkem.GenerateKey768()
+	if err != nil {
+		return err
+	}
+
+	var pubKey crypto.PublicKey = &hybridPublicKey{
+		x:   xKey.PublicKey(),
+		kem: kemKey.EncapsulationKey(),
+	}
+	var privKey crypto.PrivateKey = &hybridPrivateKey{
+		x:   xKey,
+		kem: kemKey,
+	}
+	signet.SetLoadedKeys(pubKey, privKey)
+	return nil
+}
+
+// BurnKey implements the ToolLogic interface.
+// The standard library keys cannot be burnt, so only the references are
+// removed, like with other key types.
+func (h *hybridKEM) BurnKey(signet tools.SignetInt) error {
+	signet.SetLoadedKeys(nil, nil)
+	return nil
+}
@@ -1,9 +1,13 @@
 package cabin
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/jess"
 	"github.com/safing/spn/conf"
 )
 
@@ -37,7 +41,115 @@ func TestKeyMaintenance(t *testing.T) {
 	if iterations/changeCnt > 25 { // one new key every 24 hours/ticks
 		t.Fatal("more changes than expected")
 	}
-	if len(id.ExchKeys) > 17 { // one new key every day for two weeks + 3 in use
+	if len(id.ExchKeys) > 17*len(provideExchKeySchemes) { // one new key every day for two weeks + 3 in use, per scheme
 		t.Fatal("more keys than expected")
 	}
 }
+
+func TestHybridKEM(t *testing.T) {
+	t.Parallel()
+
+	kem := &hybridKEM{}
+
+	// Generate key and test storing and loading it.
+	signet := &jess.Signet{Scheme: HybridExchKeyScheme}
+	if err := kem.GenerateKey(signet); err != nil {
+		t.Fatal(err)
+	}
+	if err := kem.StoreKey(signet); err != nil {
+		t.Fatal(err)
+	}
+	loaded := &jess.Signet{Scheme: HybridExchKeyScheme, Key: signet.Key}
+	if err := kem.LoadKey(loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	// Encapsulate and unwrap a key.
+	key := []byte("0123456789abcdef0123456789abcdef")
+	wrapped, err := kem.EncapsulateKey(key, signet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapped, err := kem.UnwrapKey(wrapped, loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, key, unwrapped, "unwrapped key must match")
+
+	// Modified encapsulated keys must be rejected.
+	wrapped[len(wrapped)-1] ^= 1
+	_, err = kem.UnwrapKey(wrapped, loaded)
+	assert.Error(t, err, "modified encapsulated key must be rejected")
+}
+
+func TestHybridExchKeys(t *testing.T) {
+	t.Parallel()
+
+	id, err := CreateIdentity(module.Ctx, conf.MainMapName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hybrid keys must not be published where older versions would reject them.
+	assert.NotEmpty(t, id.Hub.Status.PQKeys, "status.PQKeys must be set")
+	for _, key := range id.Hub.Status.Keys {
+		assert.NotEqual(t, HybridExchKeyScheme, key.Scheme, "hybrid keys must not be in status.Keys")
+		assert.LessOrEqual(t, len(key.Key), 1024, "keys in status.Keys must be accepted by older versions")
+	}
+
+	// Clients must prefer the hybrid key.
+	signet := id.Hub.SelectSignet()
+	if assert.NotNil(t, signet, "a signet must be selected") {
+		assert.Equal(t, HybridExchKeyScheme, signet.Scheme, "hybrid key should be preferred")
+	}
+
+	// Test a wire session with the hybrid key in both directions.
+	env := jess.NewUnconfiguredEnvelope()
+	env.SuiteID = WireSuite(signet)
+	env.Recipients = []*jess.Signet{signet}
+	client, err := env.WireCorrespondence(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server *jess.Session
+	for i := 0; i < 5; i++ {
+		testData := []byte(fmt.Sprintf("hello %d", i))
+
+		// Client to server.
+		letter, err := client.Close(testData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if server == nil {
+			server, err = letter.WireCorrespondence(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		data, err := server.Open(letter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, testData, data, "client data must match")
+
+		// Server to client.
+		letter, err = server.Close(testData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err = client.Open(letter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, testData, data, "server data must match")
+	}
+
+	// Older versions only see the classic keys.
+	id.Hub.Lock()
+	id.Hub.Status.PQKeys = nil
+	id.Hub.Unlock()
+	signet = id.Hub.SelectSignet()
+	if assert.NotNil(t, signet, "a signet must be selected") {
+		assert.Equal(t, "ECDH-X25519", signet.Scheme, "classic key should be used as fallback")
+	}
+}
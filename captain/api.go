@@ -3,6 +3,8 @@ package captain
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/safing/portbase/api"
 	"github.com/safing/portbase/database"
@@ -12,6 +14,7 @@ import (
 
 const (
 	apiPathForSPNReInit = "spn/reinit"
+	apiPathForHubDrain  = "spn/hub/drain"
 )
 
 func registerAPIEndpoints() error {
@@ -26,9 +29,44 @@ func registerAPIEndpoints() error {
 		return err
 	}
 
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        apiPathForHubDrain,
+		Write:       api.PermitAdmin,
+		WriteMethod: http.MethodPost,
+		BelongsTo:   module,
+		ActionFunc:  handleDrain,
+		Name:        "Drain Hub",
+		Description: "Stops accepting new connections, moves clients to other Hubs and shuts down the Hub when all connections are gone or the deadline is reached.",
+		Parameters: []api.Parameter{
+			{
+				Method:      http.MethodPost,
+				Field:       "deadline",
+				Value:       "",
+				Description: "Specify the duration after which the Hub shuts down in any case, eg. \"1h\". Defaults to 30 minutes.",
+			},
+		},
+	}); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+func handleDrain(ar *api.Request) (msg string, err error) {
+	var deadline time.Duration
+	if deadlineParam := ar.URL.Query().Get("deadline"); deadlineParam != "" {
+		deadline, err = time.ParseDuration(deadlineParam)
+		if err != nil {
+			return "", fmt.Errorf("invalid deadline: %w", err)
+		}
+	}
+
+	if err := StartDraining(deadline); err != nil {
+		return "", err
+	}
+	return "Hub is draining.", nil
+}
+
 func handleReInit(ar *api.Request) (msg string, err error) {
 	// Disable module and check
 	changed := module.Disable()
@@ -0,0 +1,155 @@
+package captain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/spn/docks"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/ships"
+)
+
+const (
+	craneBondSize             = 2
+	craneBondMaintainInterval = 1 * time.Minute
+)
+
+// useCraneBonding returns whether the crane to the given Hub should be bonded.
+func useCraneBonding(dst *hub.Hub) bool {
+	return cfgOptionBondHomeHubConnection() &&
+		dst.Status != nil &&
+		dst.Status.HasFlag(hub.FlagCraneBonding)
+}
+
+// maintainCraneBond adds ships to the bonded crane until it has the desired
+// amount of ships. Ships that were dropped from the bond are replaced.
+func maintainCraneBond(ctx context.Context, crane *docks.Crane) {
+	ticker := time.NewTicker(craneBondMaintainInterval)
+	defer ticker.Stop()
+
+	for {
+		if crane.BondedShips() < craneBondSize {
+			if err := addBondedShip(ctx, crane); err != nil {
+				log.Warningf("spn/captain: failed to add ship to %s: %s", crane, err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-crane.Controller.Ctx().Done():
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// addBondedShip launches a new ship to the connected Hub of the crane and adds
+// it to the bond. Local interfaces and transports that differ from the ones
+// already in use are preferred, as they are more likely to take a different
+// path.
+func addBondedShip(ctx context.Context, crane *docks.Crane) error {
+	dst := crane.ConnectedHub
+	if dst.Info == nil {
+		return hub.ErrMissingInfo
+	}
+
+	// Sort transports so that unused ones come first.
+	inUse := crane.Transport().String()
+	transports := make([]*hub.Transport, 0, len(dst.Info.Transports))
+	for _, definition := range dst.Info.Transports {
+		t, err := hub.ParseTransport(definition)
+		if err != nil {
+			continue
+		}
+		if t.String() == inUse {
+			transports = append(transports, t)
+		} else {
+			transports = append([]*hub.Transport{t}, transports...)
+		}
+	}
+
+	// Except Hub IPs from the SPN while connecting.
+	setExceptions(dst.Info.IPv4, dst.Info.IPv6)
+	defer setExceptions(nil, nil)
+
+	// Prefer launching from another local interface, fall back to the default.
+	localIPs := []net.IP{nil}
+	if localIP := otherInterfaceIP(crane.LocalAddr()); localIP != nil {
+		localIPs = []net.IP{localIP, nil}
+	}
+
+	// Launch ship and add it to the bond.
+	var lastErr error
+	for _, localIP := range localIPs {
+		for _, transport := range transports {
+			ship, err := ships.LaunchFrom(ctx, dst, transport, nil, localIP)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if tErr := crane.AddShip(ctx, ship); tErr != nil {
+				ship.Sink()
+				return tErr
+			}
+			log.Infof("spn/captain: added %s from %s to %s", ship, ship.LocalAddr(), crane)
+			return nil
+		}
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no transports available")
+	}
+	return fmt.Errorf("failed to launch ship: %w", lastErr)
+}
+
+// otherInterfaceIP returns an IP of another network interface than the one
+// the given local address belongs to. Only IPs of the same IP version are
+// returned. Returns nil if there is no such interface.
+func otherInterfaceIP(inUse net.Addr) net.IP {
+	inUseIP, _, err := netutils.IPPortFromAddr(inUse)
+	if err != nil {
+		return nil
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		// Find a usable IP and skip the interface that is already in use.
+		var inUseIface bool
+		var candidate net.IP
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			switch {
+			case ipNet.IP.Equal(inUseIP):
+				inUseIface = true
+			case candidate == nil &&
+				ipNet.IP.IsGlobalUnicast() &&
+				(ipNet.IP.To4() == nil) == (inUseIP.To4() == nil):
+				candidate = ipNet.IP
+			}
+		}
+		if !inUseIface && candidate != nil {
+			return candidate
+		}
+	}
+
+	return nil
+}
@@ -157,6 +157,7 @@ reconnect:
 		maintainers:
 			for _, clientFunc := range []clientComponentFunc{
 				clientCheckHomeHubConnection,
+				clientMoveFromDrainingHubs,
 				clientCheckAccountAndTokens,
 				clientSetActiveConnectionStatus,
 			} {
@@ -458,6 +459,20 @@ func clientCheckHomeHubConnection(ctx context.Context) clientComponentResult {
 	return clientResultOk
 }
 
+func clientMoveFromDrainingHubs(ctx context.Context) clientComponentResult {
+	// Connect to another Home Hub if the current one is draining.
+	// Resumable connections are resumed via the new Home Hub.
+	home, _ := navigator.Main.GetHome()
+	if home != nil && home.GetState().Has(navigator.StateDraining) {
+		log.Infof("spn/captain: home hub %s is draining, connecting to another home hub", home.Hub)
+		return clientResultReconnect
+	}
+
+	// Move connections away from draining Transit Hubs.
+	crew.MoveFromDrainingHubs(ctx)
+	return clientResultOk
+}
+
 func pingHome(ctx context.Context, t terminal.Terminal, timeout time.Duration) (latency time.Duration, err *terminal.Error) {
 	started := time.Now()
 
@@ -38,6 +38,12 @@ var (
 	cfgOptionTrustNodeNodes      config.StringArrayOption
 	cfgOptionTrustNodeNodesOrder = 150
 
+	// CfgOptionBondHomeHubConnectionKey is the configuration key for whether to
+	// bond the connection to the home hub over multiple ships.
+	CfgOptionBondHomeHubConnectionKey   = "spn/bondHomeHubConnection"
+	cfgOptionBondHomeHubConnection      config.BoolOption
+	cfgOptionBondHomeHubConnectionOrder = 151
+
 	// Special Access Code.
 	cfgOptionSpecialAccessCodeKey     = "spn/specialAccessCode"
 	cfgOptionSpecialAccessCodeDefault = "none"
@@ -146,6 +152,23 @@ This setting mainly exists for when you need to simulate your presence in anothe
 	}
 	cfgOptionTrustNodeNodes = config.Concurrent.GetAsStringArray(CfgOptionTrustNodeNodesKey, []string{})
 
+	err = config.Register(&config.Option{
+		Name:           "Bond Home Node Connection",
+		Key:            CfgOptionBondHomeHubConnectionKey,
+		Description:    "Connect to your Home Node over two connections at the same time, preferably using different protocols. If one of the connections fails, for example because one of your internet uplinks goes down, the SPN continues to work over the other one without interruption. This is useful for setups with multiple internet uplinks. Reconnect to the SPN in order to apply.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionBondHomeHubConnectionOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionBondHomeHubConnection = config.Concurrent.GetAsBool(CfgOptionBondHomeHubConnectionKey, false)
+
 	err = config.Register(&config.Option{
 		Name:         "Special Access Code",
 		Key:          cfgOptionSpecialAccessCodeKey,
@@ -0,0 +1,81 @@
+package captain
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/docks"
+)
+
+const (
+	// DefaultDrainDeadline defines after how long a draining Hub shuts down,
+	// even if there still are active terminals.
+	DefaultDrainDeadline = 30 * time.Minute
+
+	drainCheckInterval = 10 * time.Second
+)
+
+// StartDraining puts the Hub into drain mode: The Hub publishes that it is
+// draining, refuses new expansions and connections and shuts down when all
+// terminals are gone or the given deadline is reached. Clients move their
+// connections to other Hubs in the meantime.
+// If the deadline is zero, DefaultDrainDeadline is used.
+func StartDraining(deadline time.Duration) error {
+	switch {
+	case !conf.PublicHub():
+		return errors.New("only public hubs can be drained")
+	case publicIdentity == nil:
+		return errors.New("hub is not ready")
+	case conf.Draining():
+		return errors.New("hub is already draining")
+	}
+	if deadline <= 0 {
+		deadline = DefaultDrainDeadline
+	}
+
+	// Enable drain mode and publish it in the status.
+	conf.EnableDraining(true)
+	TriggerHubStatusMaintenance()
+	log.Warningf("spn/captain: draining hub, shutting down in at most %s", deadline)
+
+	drainUntil := time.Now().Add(deadline)
+	module.StartServiceWorker("drain hub", 0, func(ctx context.Context) error {
+		return drainHub(ctx, drainUntil)
+	})
+	return nil
+}
+
+func drainHub(ctx context.Context, deadline time.Time) error {
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		activeTerminals := docks.GetActiveTerminalCount()
+		switch {
+		case activeTerminals == 0:
+			log.Warning("spn/captain: hub is drained, shutting down")
+		case time.Now().After(deadline):
+			log.Warningf("spn/captain: drain deadline reached with %d active terminals, shutting down", activeTerminals)
+		default:
+			log.Infof("spn/captain: draining hub, %d active terminals remaining", activeTerminals)
+
+			select {
+			case <-ticker.C:
+				continue
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		// Shut down outside of the worker, as shutting down waits for all
+		// workers to finish.
+		go func() {
+			_ = modules.Shutdown()
+		}()
+		return nil
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/safing/portbase/log"
+	"github.com/safing/spn/cabin"
 	"github.com/safing/spn/conf"
 	"github.com/safing/spn/docks"
 	"github.com/safing/spn/hub"
@@ -16,6 +17,12 @@ import (
 
 // EstablishCrane establishes a crane to another Hub.
 func EstablishCrane(callerCtx context.Context, dst *hub.Hub) (*docks.Crane, error) {
+	return establishCrane(callerCtx, dst, false)
+}
+
+// establishCrane establishes a crane to another Hub, optionally on a bonded
+// ship, so that more ships can be added to it later.
+func establishCrane(callerCtx context.Context, dst *hub.Hub, bonded bool) (*docks.Crane, error) {
 	if conf.PublicHub() && dst.ID == publicIdentity.ID {
 		return nil, errors.New("connecting to self")
 	}
@@ -33,11 +40,24 @@ func EstablishCrane(callerCtx context.Context, dst *hub.Hub) (*docks.Crane, erro
 		ship.MarkPublic()
 	}
 
+	// Start a bond on the ship, if requested.
+	if bonded {
+		ship, err = ships.NewBondedShip(ship)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start bond: %w", err)
+		}
+	}
+
 	crane, err := docks.NewCrane(ship, dst, publicIdentity)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create crane: %w", err)
 	}
 
+	// Request cover traffic on lanes to other Hubs.
+	if conf.PublicHub() {
+		crane.SetCoverTrafficRate(cabin.CoverTrafficRate())
+	}
+
 	err = crane.Start(callerCtx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to start crane: %w", err)
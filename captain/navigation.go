@@ -12,6 +12,7 @@ import (
 	"github.com/safing/portmaster/netenv"
 	"github.com/safing/portmaster/profile/endpoints"
 	"github.com/safing/spn/access"
+	"github.com/safing/spn/conf"
 	"github.com/safing/spn/docks"
 	"github.com/safing/spn/hub"
 	"github.com/safing/spn/navigator"
@@ -139,7 +140,8 @@ func connectToHomeHub(ctx context.Context, dst *hub.Hub) error {
 	defer setExceptions(nil, nil)
 
 	// Connect to hub.
-	crane, err := EstablishCrane(ctx, dst)
+	bonded := useCraneBonding(dst)
+	crane, err := establishCrane(ctx, dst, bonded)
 	if err != nil {
 		return err
 	}
@@ -165,7 +167,10 @@ func connectToHomeHub(ctx context.Context, dst *hub.Hub) error {
 	}
 
 	// Create communication terminal.
-	homeTerminal, initData, tErr := docks.NewLocalCraneTerminal(crane, nil, terminal.DefaultHomeHubTerminalOpts())
+	homeTerminalOpts := terminal.DefaultHomeHubTerminalOpts()
+	homeTerminalOpts.RequestCompression(crane.ConnectedHub)
+	homeTerminalOpts.RequestHeartbeat(crane.ConnectedHub)
+	homeTerminal, initData, tErr := docks.NewLocalCraneTerminal(crane, nil, homeTerminalOpts)
 	if tErr != nil {
 		return tErr.Wrap("failed to create home terminal")
 	}
@@ -199,12 +204,21 @@ func connectToHomeHub(ctx context.Context, dst *hub.Hub) error {
 	// Assign crane to home hub in order to query it later.
 	docks.AssignCrane(crane.ConnectedHub.ID, crane)
 
+	// Add more ships to bonded crane.
+	if bonded {
+		module.StartWorker("maintain crane bond", func(ctx context.Context) error {
+			maintainCraneBond(ctx, crane)
+			return nil
+		})
+	}
+
 	success = true
 	return nil
 }
 
 func optimizeNetwork(ctx context.Context, task *modules.Task) error {
-	if publicIdentity == nil {
+	// Do not build new lanes while draining.
+	if publicIdentity == nil || conf.Draining() {
 		return nil
 	}
 
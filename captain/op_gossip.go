@@ -47,6 +47,12 @@ func (op *GossipOp) Type() string {
 	return GossipOpType
 }
 
+// TrafficClass returns the traffic class used for scheduling the operation's
+// messages.
+func (op *GossipOp) TrafficClass() terminal.TrafficClass {
+	return terminal.TrafficClassControl
+}
+
 func init() {
 	terminal.RegisterOpType(terminal.OperationFactory{
 		Type:     GossipOpType,
@@ -34,6 +34,12 @@ func (op *GossipQueryOp) Type() string {
 	return GossipQueryOpType
 }
 
+// TrafficClass returns the traffic class used for scheduling the operation's
+// messages.
+func (op *GossipQueryOp) TrafficClass() terminal.TrafficClass {
+	return terminal.TrafficClassControl
+}
+
 func init() {
 	terminal.RegisterOpType(terminal.OperationFactory{
 		Type:     GossipQueryOpType,
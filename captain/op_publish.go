@@ -30,6 +30,12 @@ func (op *PublishOp) Type() string {
 	return PublishOpType
 }
 
+// TrafficClass returns the traffic class used for scheduling the operation's
+// messages.
+func (op *PublishOp) TrafficClass() terminal.TrafficClass {
+	return terminal.TrafficClassControl
+}
+
 func init() {
 	terminal.RegisterOpType(terminal.OperationFactory{
 		Type:     PublishOpType,
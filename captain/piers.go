@@ -10,6 +10,7 @@ import (
 	"github.com/safing/portmaster/intel"
 	"github.com/safing/portmaster/network/netutils"
 	"github.com/safing/portmaster/profile/endpoints"
+	"github.com/safing/spn/cabin"
 	"github.com/safing/spn/docks"
 	"github.com/safing/spn/hub"
 	"github.com/safing/spn/ships"
@@ -124,13 +125,26 @@ func checkDockingPermission(ctx context.Context, ship ships.Ship) error {
 func handleDockingRequest(ship ships.Ship) {
 	log.Infof("spn/captain: pemitting %s to dock", ship)
 
-	crane, err := docks.NewCrane(ship, nil, publicIdentity)
-	if err != nil {
-		log.Warningf("spn/captain: failed to commission crane for %s: %s", ship, err)
-		return
-	}
-
 	module.StartWorker("start crane", func(ctx context.Context) error {
+		// Check if the ship starts or joins a bond.
+		dockingShip, err := ships.CheckForBond(ship)
+		switch {
+		case err != nil:
+			log.Warningf("spn/captain: failed to check %s for bond: %s", ship, err)
+			ship.Sink()
+			return nil
+		case dockingShip == nil:
+			log.Infof("spn/captain: %s joined bond", ship)
+			return nil
+		}
+
+		crane, err := docks.NewCrane(dockingShip, nil, publicIdentity)
+		if err != nil {
+			log.Warningf("spn/captain: failed to commission crane for %s: %s", ship, err)
+			return nil
+		}
+		crane.SetCoverTrafficRate(cabin.CoverTrafficRate())
+
 		_ = crane.Start(ctx)
 		// Crane handles errors internally.
 		return nil
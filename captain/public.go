@@ -193,10 +193,13 @@ func maintainPublicStatus(ctx context.Context, task *modules.Task) error {
 	}
 
 	// Set flags.
-	var flags []string
+	flags := []string{hub.FlagDatagrams, hub.FlagResolve, hub.FlagMultipath, hub.FlagResumable, hub.FlagCompression, hub.FlagRekeying, hub.FlagHeartbeat, hub.FlagCraneResumption, hub.FlagCraneBonding, hub.FlagDirectionalCapacity, hub.FlagCongestionControl}
 	if !patrol.HTTPSConnectivityConfirmed() {
 		flags = append(flags, hub.FlagNetError)
 	}
+	if conf.Draining() {
+		flags = append(flags, hub.FlagDraining)
+	}
 	// Sort Lanes for comparing.
 	sort.Strings(flags)
 
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/captain"
+)
+
+// handleDrainSignal starts draining the Hub when receiving SIGUSR2.
+func handleDrainSignal() {
+	drainSignal := make(chan os.Signal, 1)
+	signal.Notify(drainSignal, syscall.SIGUSR2)
+
+	go func() {
+		for range drainSignal {
+			if err := captain.StartDraining(0); err != nil {
+				log.Warningf("hub: failed to start draining: %s", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,4 @@
+package main
+
+// handleDrainSignal is not supported on Windows. Use the API instead.
+func handleDrainSignal() {}
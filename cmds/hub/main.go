@@ -56,6 +56,9 @@ func main() {
 	// Set threshold.
 	modules.SetMaxConcurrentMicroTasks(microTasksThreshold)
 
+	// Drain the Hub on SIGUSR2.
+	handleDrainSignal()
+
 	// start
 	os.Exit(run.Run())
 }
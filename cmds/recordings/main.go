@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/safing/portbase/info"
+	"github.com/safing/spn/terminal"
+)
+
+var (
+	rootCmd = &cobra.Command{
+		Use:   "recordings",
+		Short: "A tool to inspect and replay terminal message recordings",
+		Long: `A tool to inspect and replay terminal message recordings.
+Recordings are created by running an SPN node with -record-terminal-msgs.`,
+	}
+
+	filterTerminal  string
+	filterOp        uint32
+	filterType      string
+	filterDirection string
+)
+
+func init() {
+	flags := rootCmd.PersistentFlags()
+	flags.StringVar(&filterTerminal, "terminal", "", "only use messages of the given terminal, eg. \"crane#8\"")
+	flags.Uint32Var(&filterOp, "op", 0, "only use messages of the given operation ID")
+	flags.StringVar(&filterType, "type", "", "only use messages of the given type: init, data, prio or stop")
+	flags.StringVar(&filterDirection, "direction", "", "only use messages of the given direction: recv or sent")
+}
+
+func main() {
+	info.Set("SPN Recordings", "0.1.0", "AGPLv3", false)
+
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// loadRecording reads the recording at the given path and applies the filters.
+func loadRecording(path string) ([]*terminal.RecordedMsg, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	msgs, err := terminal.ReadRecording(f)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := msgs[:0]
+	for _, msg := range msgs {
+		if matchesFilters(msg) {
+			filtered = append(filtered, msg)
+		}
+	}
+	return filtered, nil
+}
+
+func matchesFilters(msg *terminal.RecordedMsg) bool {
+	switch {
+	case filterTerminal != "" && msg.FmtTerminalID() != filterTerminal:
+		return false
+	case filterOp != 0 && msg.OpID != filterOp:
+		return false
+	case filterDirection != "" && !strings.EqualFold(msg.Direction.String(), filterDirection):
+		return false
+	case filterType != "" && !strings.EqualFold(msg.Type.String(), filterType):
+		return false
+	default:
+		return true
+	}
+}
+
+func checkSingleTerminal(msgs []*terminal.RecordedMsg) error {
+	for _, msg := range msgs {
+		if msg.FmtTerminalID() != msgs[0].FmtTerminalID() {
+			return fmt.Errorf("recording contains multiple terminals, select one with --terminal")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	printCmd = &cobra.Command{
+		Use:   "print <recording>",
+		Short: "Print the messages of a recording",
+		Args:  cobra.ExactArgs(1),
+		RunE:  printRecording,
+	}
+
+	printData bool
+)
+
+func init() {
+	rootCmd.AddCommand(printCmd)
+
+	printCmd.Flags().BoolVar(&printData, "data", false, "also print the recorded data of messages")
+}
+
+func printRecording(cmd *cobra.Command, args []string) error {
+	msgs, err := loadRecording(args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range msgs {
+		fmt.Println(msg)
+		if printData && len(msg.Data) > 0 {
+			fmt.Print(hex.Dump(msg.Data))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/safing/portbase/dataroot"
+	"github.com/safing/portbase/modules"
+	_ "github.com/safing/portmaster/core/base"
+	"github.com/safing/spn/terminal"
+)
+
+var (
+	replayCmd = &cobra.Command{
+		Use:   "replay <recording>",
+		Short: "Replay the received messages of a terminal into a test terminal",
+		Long: `Replay the received messages of a terminal into a test terminal.
+Only recordings that include the message data can be replayed.`,
+		Args: cobra.ExactArgs(1),
+		RunE: replayRecording,
+	}
+
+	replayWithTiming bool
+	replayGrant      uint16
+)
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+
+	flags := replayCmd.Flags()
+	flags.BoolVar(&replayWithTiming, "timing", false, "keep the recorded time between messages")
+	flags.Uint16Var(&replayGrant, "grant", uint16(terminal.MayExpand|terminal.MayConnect), "permissions to grant to the test terminal")
+}
+
+func replayRecording(cmd *cobra.Command, args []string) error {
+	msgs, err := loadRecording(args[0])
+	if err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		return errors.New("no messages to replay")
+	}
+	if err := checkSingleTerminal(msgs); err != nil {
+		return err
+	}
+
+	// Start the modules required for terminals.
+	dataDir, err := os.MkdirTemp("", "spn-replay-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dataDir) //nolint:errcheck
+	if err := dataroot.Initialize(dataDir, 0o0755); err != nil {
+		return fmt.Errorf("failed to initialize data root: %w", err)
+	}
+	if err := modules.Start(); err != nil {
+		return fmt.Errorf("failed to start modules: %w", err)
+	}
+	defer modules.Shutdown() //nolint:errcheck
+
+	// Replay into the remote end of a test terminal pair.
+	_, t, err := terminal.NewSimpleTestTerminalPair(0, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer t.Abandon(nil)
+	t.GrantPermission(terminal.Permission(replayGrant))
+
+	log.Printf("replaying %d messages of %s", len(msgs), msgs[0].FmtTerminalID())
+	if tErr := t.ReplayRecording(msgs, replayWithTiming); tErr != nil {
+		return tErr
+	}
+	log.Printf("replay finished")
+	return nil
+}
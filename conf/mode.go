@@ -7,6 +7,7 @@ import (
 var (
 	publicHub = abool.New()
 	client    = abool.New()
+	draining  = abool.New()
 )
 
 // PublicHub returns whether this is a public Hub.
@@ -28,3 +29,14 @@ func Client() bool {
 func EnableClient(enable bool) {
 	client.SetTo(enable)
 }
+
+// Draining returns whether the Hub is draining. A draining Hub does not accept
+// new connections and shuts down when the existing ones are gone.
+func Draining() bool {
+	return draining.IsSet()
+}
+
+// EnableDraining enables the drain mode.
+func EnableDraining(enable bool) {
+	draining.SetTo(enable)
+}
@@ -4,6 +4,12 @@ const (
 	// VersionOne is the first protocol version.
 	VersionOne = 1
 
+	// VersionTwo is the second protocol version, which adds feature
+	// negotiation to terminals.
+	VersionTwo = 2
+
 	// CurrentVersion always holds the newest version in production.
+	// Only raise when the network supports the newer version, as Hubs running
+	// an older version cannot negotiate down.
 	CurrentVersion = 1
 )
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -18,11 +19,51 @@ import (
 	"github.com/safing/spn/terminal"
 )
 
-// connectLock locks all routing operations to mitigate racy stuff for now.
-// TODO: Find a nice way to parallelize route creation.
-var connectLock sync.Mutex
+// hubLocks lock routing operations per Hub, so that routes via different
+// Hubs can be established in parallel.
+var (
+	hubLocks     = make(map[string]*sync.Mutex)
+	hubLocksLock sync.Mutex
+)
+
+// lockHubs locks routing operations for the given Hubs and returns a function
+// to unlock them again. Locks are acquired in a fixed order to prevent
+// deadlocks between routes that share Hubs.
+func lockHubs(hubIDs ...string) (unlock func()) {
+	hubIDs = append([]string(nil), hubIDs...)
+	sort.Strings(hubIDs)
+
+	// Get locks.
+	hubLocksLock.Lock()
+	locks := make([]*sync.Mutex, 0, len(hubIDs))
+	for i, hubID := range hubIDs {
+		if i > 0 && hubIDs[i-1] == hubID {
+			continue
+		}
+		lock, ok := hubLocks[hubID]
+		if !ok {
+			lock = &sync.Mutex{}
+			hubLocks[hubID] = lock
+		}
+		locks = append(locks, lock)
+	}
+	hubLocksLock.Unlock()
+
+	// Acquire locks in order.
+	for _, lock := range locks {
+		lock.Lock()
+	}
+
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+		}
+	}
+}
 
 // HandleSluiceRequest handles a sluice request to build a tunnel.
+// For UDP, conn may also implement net.PacketConn in order to exchange
+// datagrams with multiple peers, if the destination supports datagram mode.
 func HandleSluiceRequest(connInfo *network.Connection, conn net.Conn) {
 	if conn == nil {
 		log.Debugf("spn/crew: closing tunnel for %s before starting because of shutdown", connInfo)
@@ -50,6 +91,10 @@ type Tunnel struct {
 	route       *navigator.Route
 	failedTries int
 	stickied    bool
+
+	// resolvedIP is the IP the Destination Hub resolved the domain of the
+	// connection to, if it differs from the IP of the connection.
+	resolvedIP net.IP
 }
 
 func (t *Tunnel) connectWorker(ctx context.Context) (err error) {
@@ -89,8 +134,11 @@ func (t *Tunnel) connectWorker(ctx context.Context) (err error) {
 		return nil
 	}
 
+	// Resolve the domain again at the Destination Hub, if enabled.
+	t.resolveAtExit(ctx)
+
 	// Connect via established tunnel.
-	_, tErr := NewConnectOp(t)
+	op, tErr := NewConnectOp(t)
 	if tErr != nil {
 		tErr = tErr.Wrap("failed to initialize tunnel")
 		reportConnectError(tErr)
@@ -108,6 +156,19 @@ func (t *Tunnel) connectWorker(ctx context.Context) (err error) {
 	// Report time taken to find, build and check route and send connect request.
 	connectOpTTCRDurationHistogram.UpdateDuration(started)
 
+	// Add a second path in the background, if in multipath mode.
+	if op.multipath != nil {
+		module.StartWorker("tunnel multipath", func(ctx context.Context) error {
+			t.addMultipath(ctx, op)
+			return nil
+		})
+	}
+
+	// Record demand for the terminal pool.
+	if !t.stickied {
+		pool.recordDemand(t)
+	}
+
 	t.connInfo.Lock()
 	defer t.connInfo.Unlock()
 	addTunnelContextToConnection(t)
@@ -184,6 +245,9 @@ func (t *Tunnel) establish(ctx context.Context) (err error) {
 		return fmt.Errorf("no routes to %s", t.connInfo.Entity.IP)
 	}
 
+	// Prefer routes that are already established and checked.
+	preferWarmRoutes(routes.All)
+
 	// Try routes until one succeeds.
 	log.Tracer(ctx).Trace("spn/crew: establishing route...")
 	var dstPin *navigator.Pin
@@ -217,14 +281,18 @@ type hopCheck struct {
 }
 
 func establishRoute(route *navigator.Route) (dstPin *navigator.Pin, dstTerminal terminal.Terminal, err error) {
-	connectLock.Lock()
-	defer connectLock.Unlock()
-
 	// Check for path length.
 	if len(route.Path) < 1 {
 		return nil, nil, errors.New("path too short")
 	}
 
+	// Lock all Hubs of the route.
+	hubIDs := make([]string, 0, len(route.Path)-1)
+	for _, hop := range route.Path[1:] {
+		hubIDs = append(hubIDs, hop.HubID)
+	}
+	defer lockHubs(hubIDs...)()
+
 	// Check for failing hubs in path.
 	for _, hop := range route.Path[1:] {
 		if hop.Pin().GetState().Has(navigator.StateFailing) {
@@ -370,6 +438,182 @@ func establishRoute(route *navigator.Route) (dstPin *navigator.Pin, dstTerminal
 	return previousHop, previousTerminal, nil
 }
 
+// addMultipath establishes a second route to the Destination Hub, which is
+// disjoint from the route of the tunnel, and adds it to the multipath
+// connection of the given connect op.
+func (t *Tunnel) addMultipath(ctx context.Context, op *ConnectOp) {
+	dstTerminal, err := t.establishDisjointRoute(ctx)
+	if err != nil {
+		log.Tracer(ctx).Debugf("spn/crew: failed to establish second path for %s: %s", t.connInfo, err)
+		return
+	}
+
+	// The terminal is only used by this connection.
+	if !op.multipath.addOwnedTerminal(dstTerminal) {
+		dstTerminal.Abandon(nil)
+		return
+	}
+
+	_, tErr := newSessionPathOp(op.multipath, dstTerminal, false)
+	if tErr != nil {
+		log.Tracer(ctx).Debugf("spn/crew: failed to add second path for %s: %s", t.connInfo, tErr)
+		return
+	}
+	log.Tracer(ctx).Infof("spn/crew: added second path to %s for %s", t.dstPin.Hub, t.connInfo)
+}
+
+// resume resumes the given detached connection via a new route to the
+// Destination Hub of the tunnel.
+func (t *Tunnel) resume(ctx context.Context, mp *multipathConn) {
+	for {
+		// Stop trying when the connection was stopped or resumed otherwise.
+		if mp.ctx.Err() != nil || !mp.isDetached() {
+			return
+		}
+
+		// Find and establish a new route to the Destination Hub.
+		// Active terminals that failed are not used anymore.
+		dstTerminal, err := t.establishRouteToDstHub(ctx)
+		if err == nil {
+			_, tErr := newSessionPathOp(mp, dstTerminal, true)
+			switch {
+			case tErr == nil:
+				log.Tracer(ctx).Infof("spn/crew: resumed %s via new route to %s", t.connInfo, t.dstPin.Hub)
+				return
+			case tErr.Is(terminal.ErrInvalidOptions) || tErr.Is(terminal.ErrIncorrectUsage):
+				// The session does not exist anymore on the Destination Hub.
+				mp.stop(tErr.Wrap("failed to resume"))
+				return
+			}
+			err = tErr
+		}
+		log.Tracer(ctx).Debugf("spn/crew: failed to resume %s: %s", t.connInfo, err)
+
+		// Wait before trying again.
+		select {
+		case <-time.After(multipathResumeRetryInterval):
+		case <-mp.ctx.Done():
+			return
+		}
+	}
+}
+
+// establishRouteToDstHub establishes a route to the Destination Hub of the
+// tunnel and returns the terminal to it.
+func (t *Tunnel) establishRouteToDstHub(ctx context.Context) (terminal.Terminal, error) {
+	routes, err := navigator.Main.FindRouteToHub(t.dstPin.Hub.ID, t.connInfo.TunnelOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find routes: %w", err)
+	}
+
+	for _, route := range routes.All {
+		_, dstTerminal, err := establishRoute(route)
+		if err != nil {
+			log.Tracer(ctx).Tracef("spn/crew: failed to establish route %s: %s", route, err)
+			continue
+		}
+		return dstTerminal, nil
+	}
+
+	return nil, fmt.Errorf("failed to establish a route to %s", t.dstPin.Hub)
+}
+
+// establishDisjointRoute establishes a new terminal to the Destination Hub of
+// the tunnel via a route that does not share any Transit Hubs with the route
+// of the tunnel.
+func (t *Tunnel) establishDisjointRoute(ctx context.Context) (*docks.ExpansionTerminal, error) {
+	// Get the route that is actually used by the tunnel.
+	primary := t.dstPin.GetActiveRoute()
+	if primary == nil {
+		return nil, errors.New("tunnel has no active route")
+	}
+
+	routes, err := navigator.Main.FindRouteToHub(t.dstPin.Hub.ID, t.connInfo.TunnelOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find routes: %w", err)
+	}
+
+	for _, route := range routes.All {
+		// A disjoint route needs at least one Transit Hub.
+		if len(route.Path) < 3 || !routesAreDisjoint(primary, route) {
+			continue
+		}
+
+		// Establish route up to the last Transit Hub.
+		transitPin, transitTerminal, err := establishRoute(route.CopyUpTo(len(route.Path) - 1))
+		if err != nil {
+			log.Tracer(ctx).Tracef("spn/crew: failed to establish disjoint route %s: %s", route, err)
+			continue
+		}
+
+		// Check if the route actually used to reach the Transit Hub is disjoint too.
+		transitRoute := transitPin.GetActiveRoute()
+		if transitRoute == nil || !routesAreDisjoint(primary, transitRoute) {
+			continue
+		}
+
+		// Expand to the Destination Hub with a new terminal.
+		expansion, tErr := expandAndAuthorize(transitTerminal, transitPin, t.dstPin)
+		if tErr != nil {
+			log.Tracer(ctx).Tracef("spn/crew: failed to expand disjoint route %s: %s", route, tErr)
+			continue
+		}
+		return expansion, nil
+	}
+
+	return nil, errors.New("no disjoint route available")
+}
+
+// routesAreDisjoint returns whether the second route does not share any Transit
+// Hubs or the direct Lane with the primary route. The second route may end at
+// a Transit Hub.
+func routesAreDisjoint(primary, second *navigator.Route) bool {
+	if len(primary.Path) < 2 || len(second.Path) < 2 {
+		return false
+	}
+
+	// Collect Transit Hubs of the primary route.
+	dstHubID := primary.Path[len(primary.Path)-1].HubID
+	transitHubs := make(map[string]struct{}, len(primary.Path))
+	for _, hop := range primary.Path[1 : len(primary.Path)-1] {
+		transitHubs[hop.HubID] = struct{}{}
+	}
+
+	// Check Hubs of the second route.
+	for _, hop := range second.Path[1:] {
+		if _, ok := transitHubs[hop.HubID]; ok {
+			return false
+		}
+	}
+
+	// Check if both routes use the direct Lane to the Destination Hub.
+	return len(primary.Path) != 2 || len(second.Path) != 2 || second.Path[1].HubID != dstHubID
+}
+
+// expandAndAuthorize expands to the given Hub and waits for the authorization.
+func expandAndAuthorize(fromTerminal terminal.Terminal, from, to *navigator.Pin) (*docks.ExpansionTerminal, *terminal.Error) {
+	defer lockHubs(to.Hub.ID)()
+
+	expansion, authOp, tErr := expand(fromTerminal, from, to)
+	if tErr != nil {
+		return nil, tErr
+	}
+
+	select {
+	case tErr := <-authOp.Result:
+		if !tErr.Is(terminal.ErrExplicitAck) {
+			expansion.Abandon(nil)
+			return nil, tErr.Wrap("failed to authenticate to %s", to.Hub)
+		}
+	case <-time.After(5 * time.Second):
+		expansion.Abandon(nil)
+		return nil, terminal.ErrTimeout.With("waiting for auth to %s", to.Hub)
+	}
+
+	expansion.MarkReachable()
+	return expansion, nil
+}
+
 func expand(fromTerminal terminal.Terminal, from, to *navigator.Pin) (expansion *docks.ExpansionTerminal, authOp *access.AuthorizeOp, tErr *terminal.Error) {
 	expansion, tErr = docks.ExpandTo(fromTerminal, to.Hub.ID, to.Hub)
 	if tErr != nil {
@@ -0,0 +1,94 @@
+package crew
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/navigator"
+	"github.com/safing/spn/terminal"
+)
+
+// drainingHubAvoidDuration defines how long a draining Hub is avoided after it
+// refused a connection.
+const drainingHubAvoidDuration = 30 * time.Minute
+
+// MoveFromDrainingHubs stops using routes via draining Transit Hubs for new
+// connections and moves resumable connections on these routes to new routes.
+func MoveFromDrainingHubs(ctx context.Context) {
+	forgotten := navigator.Main.ForgetRoutesVia(navigator.StateDraining)
+	if len(forgotten) == 0 {
+		return
+	}
+	isForgotten := func(t terminal.Terminal) bool {
+		for _, f := range forgotten {
+			if t == terminal.Terminal(f) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Get resumable connections that have a path via a forgotten terminal.
+	multipathConnsLock.Lock()
+	entryConns := make([]*multipathConn, 0, len(multipathEntryConns))
+	for mp := range multipathEntryConns {
+		entryConns = append(entryConns, mp)
+	}
+	multipathConnsLock.Unlock()
+	toMove := make([]*multipathConn, 0, len(entryConns))
+	for _, mp := range entryConns {
+		if mp.resumable && mp.tunnel != nil && mp.hasPathVia(isForgotten) {
+			toMove = append(toMove, mp)
+		}
+	}
+
+	for _, mp := range toMove {
+		mp.tunnel.moveFrom(ctx, mp, isForgotten)
+	}
+}
+
+// moveFrom adds a path via a new route to the given connection and then stops
+// all paths using terminals matched by the given function.
+func (t *Tunnel) moveFrom(ctx context.Context, mp *multipathConn, matches func(terminal.Terminal) bool) {
+	if mp.ctx.Err() != nil || mp.isDetached() {
+		return
+	}
+
+	// Add path via a new route to the Destination Hub.
+	dstTerminal, err := t.establishRouteToDstHub(ctx)
+	if err != nil {
+		log.Tracer(ctx).Debugf("spn/crew: failed to move %s away from draining hub: %s", t.connInfo, err)
+		return
+	}
+	if _, tErr := newSessionPathOp(mp, dstTerminal, false); tErr != nil {
+		log.Tracer(ctx).Debugf("spn/crew: failed to move %s away from draining hub: %s", t.connInfo, tErr)
+		return
+	}
+
+	// Stop old paths. Unacknowledged data is resent on the new path.
+	mp.Lock()
+	paths := make([]*ConnectOp, len(mp.paths))
+	copy(paths, mp.paths)
+	mp.Unlock()
+	for _, op := range paths {
+		if matches(op.t) {
+			op.Stop(op, terminal.ErrHubDraining.With("moving connection to another route"))
+		}
+	}
+	log.Tracer(ctx).Infof("spn/crew: moved %s away from draining hub", t.connInfo)
+}
+
+// hasPathVia returns whether the connection has a path using a terminal
+// matched by the given function.
+func (mp *multipathConn) hasPathVia(matches func(terminal.Terminal) bool) bool {
+	mp.Lock()
+	defer mp.Unlock()
+
+	for _, op := range mp.paths {
+		if matches(op.t) {
+			return true
+		}
+	}
+	return false
+}
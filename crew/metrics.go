@@ -13,6 +13,10 @@ var (
 	newConnectOp           *metrics.Counter
 	connectOpIncomingBytes *metrics.Counter
 	connectOpOutgoingBytes *metrics.Counter
+	connectOpDroppedDgrams *metrics.Counter
+
+	connectOpMultipathFailovers *metrics.Counter
+	connectOpResumes            *metrics.Counter
 
 	connectOpTTCRDurationHistogram *metrics.Histogram
 	connectOpTTFBDurationHistogram *metrics.Histogram
@@ -86,6 +90,42 @@ func registerMetrics() (err error) {
 		return err
 	}
 
+	connectOpDroppedDgrams, err = metrics.NewCounter(
+		"spn/op/connect/datagrams/dropped",
+		nil,
+		&metrics.Options{
+			Name:       "SPN Connect Operation Dropped Datagrams",
+			Permission: api.PermitUser,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	connectOpMultipathFailovers, err = metrics.NewCounter(
+		"spn/op/connect/multipath/failovers",
+		nil,
+		&metrics.Options{
+			Name:       "SPN Connect Operation Multipath Failovers",
+			Permission: api.PermitUser,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	connectOpResumes, err = metrics.NewCounter(
+		"spn/op/connect/resumes",
+		nil,
+		&metrics.Options{
+			Name:       "SPN Connect Operation Resumes",
+			Permission: api.PermitUser,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
 	connectOpTTCRDurationHistogram, err = metrics.NewHistogram(
 		"spn/op/connect/histogram/ttcr/seconds",
 		nil,
@@ -4,10 +4,15 @@ import (
 	"time"
 
 	"github.com/safing/portbase/modules"
+	"github.com/safing/spn/conf"
 	"github.com/safing/spn/terminal"
 )
 
-var module *modules.Module
+var (
+	module *modules.Module
+
+	runningTests bool
+)
 
 func init() {
 	module = modules.Register("crew", nil, start, stop, "terminal", "docks", "navigator", "intel", "cabin")
@@ -17,6 +22,10 @@ func start() error {
 	module.NewTask("sticky cleaner", cleanStickyHubs).
 		Repeat(10 * time.Minute)
 
+	if conf.Client() {
+		startTerminalPool()
+	}
+
 	return registerMetrics()
 }
 
@@ -2,6 +2,7 @@ package crew
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
@@ -10,12 +11,14 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/tevino/abool"
+
 	"github.com/safing/portbase/container"
 	"github.com/safing/portbase/formats/dsd"
 	"github.com/safing/portbase/log"
-	"github.com/safing/portmaster/network/netutils"
 	"github.com/safing/portmaster/network/packet"
 	"github.com/safing/spn/conf"
+	"github.com/safing/spn/hub"
 	"github.com/safing/spn/terminal"
 )
 
@@ -50,13 +53,32 @@ type ConnectOp struct {
 	request *ConnectRequest
 	entry   bool
 	tunnel  *Tunnel
+
+	// Datagrams
+	datagrams *datagramState
+
+	// Multipath
+	multipath *multipathConn
+	resume    bool
+
+	// incompressible is set when the connection carries TLS.
+	incompressible abool.AtomicBool
 }
 
 // Type returns the type ID.
 func (op *ConnectOp) Type() string {
+	if op.resume {
+		return ResumeOpType
+	}
 	return ConnectOpType
 }
 
+// TrafficClass returns the traffic class used for scheduling the operation's
+// messages.
+func (op *ConnectOp) TrafficClass() terminal.TrafficClass {
+	return terminal.TrafficClassBulk
+}
+
 // Ctx returns the operation context.
 func (op *ConnectOp) Ctx() context.Context {
 	return op.ctx
@@ -70,6 +92,21 @@ type ConnectRequest struct {
 	Protocol            packet.IPProtocol `json:"p,omitempty"`
 	Port                uint16            `json:"po,omitempty"`
 	QueueSize           uint32            `json:"qs,omitempty"`
+	// Datagrams enables the datagram mode, which preserves packet boundaries,
+	// drops instead of queueing and supports multiple peers.
+	// Only supported for UDP.
+	Datagrams bool `json:"dg,omitempty"`
+	// SessionID enables the session mode, in which data is sequenced and
+	// acknowledged, so that the connection can be split across multiple connect
+	// ops using different routes or be resumed via a new route.
+	// Only supported for TCP.
+	SessionID []byte `json:"sid,omitempty"`
+	// MultipathJoin specifies that the connect op joins the existing session
+	// with the same ID as an additional path.
+	MultipathJoin bool `json:"mpj,omitempty"`
+	// Resumable specifies that the session is kept when all paths fail, so
+	// that it can be resumed via a new route.
+	Resumable bool `json:"rs,omitempty"`
 }
 
 // Address returns the address of the connext request.
@@ -106,6 +143,36 @@ func NewConnectOp(tunnel *Tunnel) (*ConnectOp, *terminal.Error) {
 		UsePriorityDataMsgs: terminal.UsePriorityDataMsgs,
 	}
 
+	// Connect to the IP the Destination Hub resolved the domain to.
+	if tunnel.resolvedIP != nil {
+		request.IP = tunnel.resolvedIP
+	}
+
+	// Use datagram mode for UDP, if supported by the destination.
+	if request.Protocol == packet.UDP &&
+		tunnel.dstPin.Hub.Status != nil &&
+		tunnel.dstPin.Hub.Status.HasFlag(hub.FlagDatagrams) {
+		request.Datagrams = true
+	}
+
+	// Use session mode for TCP, if multipath or resuming is requested and
+	// supported by the destination.
+	if request.Protocol == packet.TCP &&
+		tunnel.dstPin.Hub.Status != nil &&
+		tunnel.connInfo.TunnelOpts != nil {
+		multipath := tunnel.connInfo.TunnelOpts.Multipath &&
+			tunnel.dstPin.Hub.Status.HasFlag(hub.FlagMultipath)
+		request.Resumable = tunnel.connInfo.TunnelOpts.Resumable &&
+			tunnel.dstPin.Hub.Status.HasFlag(hub.FlagResumable)
+
+		if multipath || request.Resumable {
+			request.SessionID = make([]byte, sessionIDSize)
+			if _, err := rand.Read(request.SessionID); err != nil {
+				return nil, terminal.ErrInternalError.With("failed to create session ID: %w", err)
+			}
+		}
+	}
+
 	// Set defaults.
 	if request.QueueSize == 0 {
 		request.QueueSize = terminal.DefaultQueueSize
@@ -122,6 +189,14 @@ func NewConnectOp(tunnel *Tunnel) (*ConnectOp, *terminal.Error) {
 	}
 	op.ctx, op.cancelCtx = context.WithCancel(module.Ctx)
 	op.dfq = terminal.NewDuplexFlowQueue(op.Ctx(), request.QueueSize, op.submitUpstream)
+	if request.Datagrams {
+		op.datagrams = newDatagramState(request)
+	}
+	if request.SessionID != nil {
+		op.multipath = newMultipathConn(request, op.conn, true)
+		op.multipath.tunnel = tunnel
+		op.conn = nil
+	}
 
 	// Prepare init msg.
 	data, err := dsd.Dump(request, dsd.CBOR)
@@ -140,9 +215,15 @@ func NewConnectOp(tunnel *Tunnel) (*ConnectOp, *terminal.Error) {
 	op.outgoingTraffic = new(uint64)
 	op.started = time.Now()
 
-	module.StartWorker("connect op conn reader", op.connReader)
-	module.StartWorker("connect op conn writer", op.connWriter)
-	module.StartWorker("connect op flow handler", op.dfq.FlowHandler)
+	if op.multipath != nil {
+		if tErr := op.multipath.addPath(op); tErr != nil {
+			op.Stop(op, tErr)
+			return nil, tErr
+		}
+		op.multipath.startReader()
+		op.multipath.registerEntry()
+	}
+	op.startWorkers()
 
 	log.Infof("spn/crew: connected to %s via %s", request, tunnel.dstPin.Hub)
 	return op, nil
@@ -156,6 +237,9 @@ func startConnectOp(t terminal.Terminal, opID uint32, data *container.Container)
 	if !conf.PublicHub() {
 		return nil, terminal.ErrPermissionDenied.With("connecting is only allowed on public hubs")
 	}
+	if conf.Draining() {
+		return nil, terminal.ErrHubDraining.With("not accepting new connections")
+	}
 
 	// Parse connect request.
 	request := &ConnectRequest{}
@@ -168,9 +252,8 @@ func startConnectOp(t terminal.Terminal, opID uint32, data *container.Container)
 	}
 
 	// Check if connection target is in global scope.
-	ipScope := netutils.GetIPScope(request.IP)
-	if ipScope != netutils.Global {
-		return nil, terminal.ErrPermissionDenied.With("denied request to connect to non-global IP %s", request.IP)
+	if tErr := checkDestinationScope(request.IP); tErr != nil {
+		return nil, tErr
 	}
 
 	// Get protocol net for connecting.
@@ -183,16 +266,43 @@ func startConnectOp(t terminal.Terminal, opID uint32, data *container.Container)
 	default:
 		return nil, terminal.ErrIncorrectUsage.With("protocol %s is not supported", request.Protocol)
 	}
+	if request.Datagrams && request.Protocol != packet.UDP {
+		return nil, terminal.ErrIncorrectUsage.With("datagram mode is not supported for protocol %s", request.Protocol)
+	}
+	if request.SessionID != nil {
+		if tErr := request.checkSession(); tErr != nil {
+			return nil, tErr
+		}
+	}
 
 	// Check exit policy.
 	if tErr := checkExitPolicy(request); tErr != nil {
 		return nil, tErr
 	}
 
+	// Check if the request is within the scope of the granted access.
+	if tErr := checkGrantScope(t, request); tErr != nil {
+		return nil, tErr
+	}
+
+	// Join existing multipath connection instead of connecting.
+	if request.MultipathJoin {
+		return startSessionPathOp(t, opID, request, false)
+	}
+
 	// Connect to destination.
-	conn, err := net.DialTimeout(dialNet, request.Address(), 3*time.Second)
-	if err != nil {
-		return nil, terminal.ErrConnectionError.With("failed to connect to %s: %w", request, err)
+	var conn net.Conn
+	if request.Datagrams {
+		// Use an unconnected socket in order to support multiple peers.
+		conn, err = net.ListenUDP(dialNet, nil)
+		if err != nil {
+			return nil, terminal.ErrConnectionError.With("failed to open datagram socket for %s: %w", request, err)
+		}
+	} else {
+		conn, err = net.DialTimeout(dialNet, request.Address(), 3*time.Second)
+		if err != nil {
+			return nil, terminal.ErrConnectionError.With("failed to connect to %s: %w", request, err)
+		}
 	}
 
 	// Create and initialize operation.
@@ -205,20 +315,48 @@ func startConnectOp(t terminal.Terminal, opID uint32, data *container.Container)
 	op.InitOperationBase(t, opID)
 	op.ctx, op.cancelCtx = context.WithCancel(t.Ctx())
 	op.dfq = terminal.NewDuplexFlowQueue(op.Ctx(), request.QueueSize, op.submitUpstream)
+	if request.Datagrams {
+		op.datagrams = newDatagramState(request)
+	}
 
 	// Setup metrics.
 	op.incomingTraffic = new(uint64)
 	op.outgoingTraffic = new(uint64)
 
+	// Setup multipath connection for other paths to join.
+	if request.SessionID != nil {
+		op.multipath = newMultipathConn(request, conn, false)
+		op.conn = nil
+		if tErr := op.multipath.register(); tErr != nil {
+			op.cancelCtx()
+			_ = conn.Close()
+			return nil, tErr
+		}
+		_ = op.multipath.addPath(op)
+		op.multipath.startReader()
+	}
+
 	// Start worker.
-	module.StartWorker("connect op conn reader", op.connReader)
-	module.StartWorker("connect op conn writer", op.connWriter)
-	module.StartWorker("connect op flow handler", op.dfq.FlowHandler)
+	op.startWorkers()
 
 	log.Infof("spn/crew: connected op %s#%d to %s", op.t.FmtID(), op.ID(), request)
 	return op, nil
 }
 
+func (op *ConnectOp) startWorkers() {
+	switch {
+	case op.multipath != nil:
+		module.StartWorker("connect op multipath writer", op.multipathWriter)
+	case op.datagrams != nil:
+		module.StartWorker("connect op datagram reader", op.datagramReader)
+		module.StartWorker("connect op datagram writer", op.datagramWriter)
+	default:
+		module.StartWorker("connect op conn reader", op.connReader)
+		module.StartWorker("connect op conn writer", op.connWriter)
+	}
+	module.StartWorker("connect op flow handler", op.dfq.FlowHandler)
+}
+
 func (op *ConnectOp) submitUpstream(msg *terminal.Msg, timeout time.Duration) {
 	err := op.Send(msg, timeout)
 	if err != nil {
@@ -276,8 +414,14 @@ func (op *ConnectOp) connReader(_ context.Context) error {
 			rateLimiter.Limit(uint64(n))
 		}
 
+		// Check if the connection carries TLS with the first data.
+		if inBytes == uint64(n) && isIncompressible(op.request, buf[:n]) {
+			op.incompressible.Set()
+		}
+
 		// Create message from data.
 		msg := op.NewMsg(buf[:n])
+		msg.Incompressible = op.incompressible.IsSet()
 
 		// Define priority and possibly wait for slot.
 		switch {
@@ -360,15 +504,8 @@ writing:
 		}
 
 		// Special handling after first data was received on client.
-		if op.entry &&
-			out == uint64(len(data)) {
-			// Report time taken to receive first byte.
-			connectOpTTFBDurationHistogram.UpdateDuration(op.started)
-
-			// If not stickied yet, stick destination to Hub.
-			if !op.tunnel.stickied {
-				op.tunnel.stickDestinationToHub()
-			}
+		if out == uint64(len(data)) {
+			op.handleFirstReceivedData()
 		}
 
 		// Send all given data.
@@ -396,6 +533,21 @@ writing:
 	}
 }
 
+// handleFirstReceivedData handles the first data received from the other end.
+func (op *ConnectOp) handleFirstReceivedData() {
+	if !op.entry {
+		return
+	}
+
+	// Report time taken to receive first byte.
+	connectOpTTFBDurationHistogram.UpdateDuration(op.started)
+
+	// If not stickied yet, stick destination to Hub.
+	if !op.tunnel.stickied {
+		op.tunnel.stickDestinationToHub()
+	}
+}
+
 func (op *ConnectOp) connectedType() string {
 	if op.entry {
 		return "origin"
@@ -436,8 +588,21 @@ func (op *ConnectOp) HandleStop(err *terminal.Error) (errorToSend *terminal.Erro
 		op.tunnel.avoidDestinationHub()
 	}
 
+	// Avoid the Destination Hub until it went offline, if it is draining.
+	if op.entry && op.tunnel != nil &&
+		err.Is(terminal.ErrHubDraining) && err.IsExternal() {
+		op.tunnel.dstPin.MarkAsFailingFor(drainingHubAvoidDuration)
+	}
+
+	// Remove from multipath connection, which fails over to the remaining paths.
+	if op.multipath != nil {
+		op.multipath.removePath(op, err)
+	}
+
 	// If we are on the client, don't leak local errors to the server.
-	if op.entry && !err.IsExternal() {
+	// Moving away from a draining Hub is passed on, so that the server resends
+	// unacknowledged data on the remaining paths.
+	if op.entry && !err.IsExternal() && !err.Is(terminal.ErrHubDraining) {
 		return terminal.ErrStopping
 	}
 	return err
@@ -0,0 +1,43 @@
+package crew
+
+// tlsPorts holds ports that are commonly used for TLS or QUIC, including
+// ports where TLS is usually started with STARTTLS.
+var tlsPorts = map[uint16]struct{}{
+	25:   {}, // SMTP, STARTTLS
+	110:  {}, // POP3, STARTTLS
+	143:  {}, // IMAP, STARTTLS
+	443:  {}, // HTTPS, QUIC
+	465:  {}, // SMTPS
+	587:  {}, // SMTP Submission, STARTTLS
+	636:  {}, // LDAPS
+	853:  {}, // DoT, DoQ
+	990:  {}, // FTPS
+	993:  {}, // IMAPS
+	995:  {}, // POP3S
+	5061: {}, // SIPS
+	8443: {}, // HTTPS Alt
+}
+
+// carriesTLS returns whether the requested connection likely carries TLS,
+// based on the destination port.
+func (r *ConnectRequest) carriesTLS() bool {
+	_, ok := tlsPorts[r.Port]
+	return ok
+}
+
+// looksLikeTLS returns whether the given data looks like the start of a TLS
+// record: a known content type followed by the major version 3.
+func looksLikeTLS(data []byte) bool {
+	return len(data) >= 3 &&
+		data[0] >= 20 && data[0] <= 23 &&
+		data[1] == 3 &&
+		data[2] <= 4
+}
+
+// isIncompressible returns whether the data read from a connection should not
+// be compressed. This is the case when the connection carries TLS, as
+// compressing encrypted data does not save bandwidth and compressing it
+// together with other data may open side channels.
+func isIncompressible(request *ConnectRequest, firstData []byte) bool {
+	return request.carriesTLS() || looksLikeTLS(firstData)
+}
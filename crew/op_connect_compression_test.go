@@ -0,0 +1,20 @@
+package crew
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncompressibleConnections(t *testing.T) {
+	t.Parallel()
+
+	tlsClientHello := []byte{0x16, 0x03, 0x01, 0x02, 0x00, 0x01}
+	httpRequest := []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+
+	assert.True(t, isIncompressible(&ConnectRequest{Port: 443}, httpRequest), "TLS port should be incompressible")
+	assert.True(t, isIncompressible(&ConnectRequest{Port: 587}, []byte("EHLO example.com\r\n")), "STARTTLS port should be incompressible")
+	assert.True(t, isIncompressible(&ConnectRequest{Port: 8080}, tlsClientHello), "TLS data should be incompressible")
+	assert.False(t, isIncompressible(&ConnectRequest{Port: 80}, httpRequest), "plain HTTP should be compressible")
+	assert.False(t, looksLikeTLS([]byte{0x16}), "short data should not look like TLS")
+}
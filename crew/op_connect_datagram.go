@@ -0,0 +1,352 @@
+package crew
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/spn/terminal"
+)
+
+/*
+
+Connect Op Datagram Message Format:
+
+- Peer Address [bytes; blocked; empty for the requested destination]
+	- IP [4 or 16 bytes]
+	- Port [2 bytes; big endian]
+- Datagram [bytes; not blocked]
+
+In datagram mode, every message holds exactly one datagram. Datagrams are
+dropped instead of queued when flow control is exhausted, as loss tolerant
+protocols handle loss better than delay.
+
+Multiple peers are only possible if the connection of the entry side is a
+net.PacketConn, which returns the peer a datagram is destined to from ReadFrom
+and is given the peer a datagram was received from in WriteTo. The exit side
+checks every new peer like a new connect request and only accepts datagrams
+from peers that it has sent datagrams to.
+
+*/
+
+const (
+	// maxDatagramSize is the maximum size of an UDP datagram.
+	maxDatagramSize = 65535
+
+	// maxDatagramPeers is the maximum amount of peers of a single connect op in
+	// datagram mode.
+	maxDatagramPeers = 256
+)
+
+// datagramState holds the state of a connect op in datagram mode.
+type datagramState struct {
+	// defaultPeer is the destination of the connect request.
+	defaultPeer *net.UDPAddr
+
+	// peers holds the checked peers and whether they are permitted.
+	peers     map[string]bool
+	peersLock sync.Mutex
+
+	// dropped holds the amount of dropped datagrams.
+	dropped *uint64
+}
+
+func newDatagramState(request *ConnectRequest) *datagramState {
+	ds := &datagramState{
+		defaultPeer: &net.UDPAddr{
+			IP:   request.IP,
+			Port: int(request.Port),
+		},
+		peers:   make(map[string]bool),
+		dropped: new(uint64),
+	}
+	// The default peer was already checked with the request.
+	ds.peers[ds.defaultPeer.String()] = true
+
+	return ds
+}
+
+// isDefaultPeer returns whether the given address is the default peer.
+func (ds *datagramState) isDefaultPeer(addr *net.UDPAddr) bool {
+	return addr.Port == ds.defaultPeer.Port && addr.IP.Equal(ds.defaultPeer.IP)
+}
+
+// isKnownPeer returns whether the given peer was checked and permitted.
+func (ds *datagramState) isKnownPeer(addr *net.UDPAddr) bool {
+	ds.peersLock.Lock()
+	defer ds.peersLock.Unlock()
+
+	return ds.peers[addr.String()]
+}
+
+// checkPeer checks if the given peer may be sent datagrams to via the given
+// terminal.
+func (ds *datagramState) checkPeer(t terminal.Terminal, addr *net.UDPAddr) *terminal.Error {
+	ds.peersLock.Lock()
+	defer ds.peersLock.Unlock()
+
+	// Check if we already know the peer.
+	permitted, ok := ds.peers[addr.String()]
+	switch {
+	case ok && permitted:
+		return nil
+	case ok:
+		return terminal.ErrPermissionDenied.With("peer %s is not permitted", addr)
+	case len(ds.peers) >= maxDatagramPeers:
+		return terminal.ErrPermissionDenied.With("too many peers")
+	}
+
+	// Check peer like a new connect request.
+	tErr := checkDatagramPeer(t, addr)
+	ds.peers[addr.String()] = tErr == nil
+	return tErr
+}
+
+func checkDatagramPeer(t terminal.Terminal, addr *net.UDPAddr) *terminal.Error {
+	// Check if peer is in global scope.
+	if tErr := checkDestinationScope(addr.IP); tErr != nil {
+		return tErr
+	}
+
+	// Check exit policy and granted access.
+	request := &ConnectRequest{
+		IP:       addr.IP,
+		Protocol: packet.UDP,
+		Port:     uint16(addr.Port),
+	}
+	if tErr := checkExitPolicy(request); tErr != nil {
+		return tErr
+	}
+	return checkGrantScope(t, request)
+}
+
+func (ds *datagramState) drop() {
+	atomic.AddUint64(ds.dropped, 1)
+	connectOpDroppedDgrams.Inc()
+}
+
+func packDatagramPeer(addr *net.UDPAddr) []byte {
+	ip := addr.IP.To4()
+	if ip == nil {
+		ip = addr.IP.To16()
+	}
+	return binary.BigEndian.AppendUint16(append([]byte{}, ip...), uint16(addr.Port))
+}
+
+func parseDatagramPeer(data []byte) (*net.UDPAddr, error) {
+	switch len(data) {
+	case net.IPv4len + 2, net.IPv6len + 2:
+	default:
+		return nil, fmt.Errorf("invalid peer address length of %d", len(data))
+	}
+
+	return &net.UDPAddr{
+		IP:   net.IP(data[:len(data)-2]),
+		Port: int(binary.BigEndian.Uint16(data[len(data)-2:])),
+	}, nil
+}
+
+// readDatagram reads a datagram from the connection. If the connection is a
+// packet connection, the peer address is returned too.
+func (op *ConnectOp) readDatagram(buf []byte) (n int, peer *net.UDPAddr, err error) {
+	pc, ok := op.conn.(net.PacketConn)
+	if !ok {
+		n, err = op.conn.Read(buf)
+		return n, nil, err
+	}
+
+	n, addr, err := pc.ReadFrom(buf)
+	if err != nil {
+		return 0, nil, err
+	}
+	peer, _ = addr.(*net.UDPAddr)
+	return n, peer, nil
+}
+
+// writeDatagram writes a datagram to the given peer or the default peer, if
+// peer is nil.
+func (op *ConnectOp) writeDatagram(data []byte, peer *net.UDPAddr) error {
+	pc, ok := op.conn.(net.PacketConn)
+	switch {
+	case !ok && peer != nil:
+		return errors.New("connection does not support multiple peers")
+	case !ok:
+		_, err := op.conn.Write(data)
+		return err
+	case peer == nil:
+		peer = op.datagrams.defaultPeer
+	}
+
+	_, err := pc.WriteTo(data, peer)
+	return err
+}
+
+func (op *ConnectOp) datagramReader(_ context.Context) error {
+	// Metrics setup and submitting.
+	atomic.AddInt64(activeConnectOps, 1)
+	defer func() {
+		atomic.AddInt64(activeConnectOps, -1)
+		connectOpDurationHistogram.UpdateDuration(op.started)
+		connectOpIncomingDataHistogram.Update(float64(atomic.LoadUint64(op.incomingTraffic)))
+	}()
+
+	rateLimiter := terminal.NewRateLimiter(rateLimitMaxMbit)
+	buf := make([]byte, maxDatagramSize)
+
+	for {
+		// Read from connection.
+		n, peer, err := op.readDatagram(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				op.Stop(op, terminal.ErrStopping.With("connection to %s was closed on read", op.connectedType()))
+			} else {
+				op.Stop(op, terminal.ErrConnectionError.With("failed to read from %s: %w", op.connectedType(), err))
+			}
+			return nil
+		}
+
+		// Create peer address.
+		var peerData []byte
+		switch {
+		case peer == nil || op.datagrams.isDefaultPeer(peer):
+			// Default peer is sent as empty address.
+		case !op.entry && !op.datagrams.isKnownPeer(peer):
+			// Only accept datagrams from peers we have sent datagrams to.
+			op.datagrams.drop()
+			continue
+		default:
+			peerData = packDatagramPeer(peer)
+		}
+
+		// Submit metrics.
+		connectOpIncomingBytes.Add(n)
+		inBytes := atomic.AddUint64(op.incomingTraffic, uint64(n))
+
+		// Rate limit if over threshold.
+		if inBytes > rateLimitThreshold {
+			rateLimiter.Limit(uint64(n))
+		}
+
+		// Create message from datagram.
+		c := container.New()
+		c.AppendAsBlock(peerData)
+		c.Append(append([]byte{}, buf[:n]...))
+		msg := op.NewMsg(nil)
+		msg.Data = c
+		msg.Incompressible = op.request.carriesTLS()
+		if op.request.UsePriorityDataMsgs {
+			msg.Unit.MakeHighPriority()
+		}
+
+		// Send datagram or drop it, if flow control is exhausted.
+		tErr := op.dfq.TrySend(msg)
+		switch {
+		case tErr == nil:
+		case tErr.Is(terminal.ErrTryAgainLater):
+			op.datagrams.drop()
+		default:
+			op.Stop(op, tErr.Wrap("failed to send datagram (dfq) from %s", op.connectedType()))
+			return nil
+		}
+	}
+}
+
+func (op *ConnectOp) datagramWriter(_ context.Context) error {
+	// Metrics submitting.
+	defer func() {
+		connectOpOutgoingDataHistogram.Update(float64(atomic.LoadUint64(op.outgoingTraffic)))
+	}()
+
+	defer func() {
+		// Close connection.
+		_ = op.conn.Close()
+	}()
+
+	var msg *terminal.Msg
+	defer msg.Finish()
+
+	rateLimiter := terminal.NewRateLimiter(rateLimitMaxMbit)
+
+	for {
+		msg.Finish()
+
+		select {
+		case msg = <-op.dfq.Receive():
+		case <-op.ctx.Done():
+			op.Stop(op, terminal.ErrCanceled)
+			return nil
+		default:
+			// Handle all data before also listening for the context cancel.
+			// This ensures all data is written properly before stopping.
+			select {
+			case msg = <-op.dfq.Receive():
+			case op.doneWriting <- struct{}{}:
+				op.Stop(op, terminal.ErrStopping)
+				return nil
+			case <-op.ctx.Done():
+				op.Stop(op, terminal.ErrCanceled)
+				return nil
+			}
+		}
+
+		// Parse peer address.
+		peerData, err := msg.Data.GetNextBlock()
+		if err != nil {
+			op.Stop(op, terminal.ErrMalformedData.With("failed to parse datagram peer: %w", err))
+			return nil
+		}
+		var peer *net.UDPAddr
+		if len(peerData) > 0 {
+			peer, err = parseDatagramPeer(peerData)
+			if err != nil {
+				op.Stop(op, terminal.ErrMalformedData.With("failed to parse datagram peer: %w", err))
+				return nil
+			}
+
+			// Check if the peer is permitted.
+			if !op.entry {
+				if tErr := op.datagrams.checkPeer(op.t, peer); tErr != nil {
+					log.Tracef("spn/crew: connect op %s>%d dropped datagram: %s", op.t.FmtID(), op.ID(), tErr)
+					op.datagrams.drop()
+					continue
+				}
+			}
+		}
+
+		data := msg.Data.CompileData()
+
+		// Submit metrics.
+		connectOpOutgoingBytes.Add(len(data))
+		out := atomic.AddUint64(op.outgoingTraffic, uint64(len(data)))
+
+		// Rate limit if over threshold.
+		if out > rateLimitThreshold {
+			rateLimiter.Limit(uint64(len(data)))
+		}
+
+		// Special handling after first data was received on client.
+		if out == uint64(len(data)) {
+			op.handleFirstReceivedData()
+		}
+
+		// Write datagram.
+		err = op.writeDatagram(data, peer)
+		switch {
+		case err == nil:
+		case errors.Is(err, net.ErrClosed):
+			op.Stop(op, terminal.ErrStopping.With("connection to %s was closed on write", op.connectedType()))
+			return nil
+		default:
+			// Datagrams may be lost, do not stop the operation.
+			log.Tracef("spn/crew: connect op %s>%d failed to write datagram to %s: %s", op.t.FmtID(), op.ID(), op.connectedType(), err)
+			op.datagrams.drop()
+		}
+	}
+}
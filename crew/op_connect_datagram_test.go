@@ -0,0 +1,207 @@
+package crew
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/spn/cabin"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/navigator"
+	"github.com/safing/spn/terminal"
+)
+
+func TestDatagramPeers(t *testing.T) {
+	t.Parallel()
+
+	ds := newDatagramState(&ConnectRequest{
+		IP:       net.IPv4(1, 1, 1, 1),
+		Protocol: packet.UDP,
+		Port:     53,
+	})
+
+	// Test peer address encoding.
+	for _, addr := range []*net.UDPAddr{
+		{IP: net.IPv4(1, 1, 1, 1), Port: 53},
+		{IP: net.ParseIP("2606:4700:4700::1111"), Port: 443},
+	} {
+		peer, err := parseDatagramPeer(packDatagramPeer(addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.True(t, addr.IP.Equal(peer.IP), "IP should match")
+		assert.Equal(t, addr.Port, peer.Port, "port should match")
+	}
+	_, err := parseDatagramPeer([]byte{1, 2, 3})
+	assert.Error(t, err, "invalid peer address should fail")
+
+	// Test peer checks.
+	assert.True(t, ds.isDefaultPeer(&net.UDPAddr{IP: net.ParseIP("::ffff:1.1.1.1"), Port: 53}), "mapped IP should match default peer")
+	assert.True(t, ds.isKnownPeer(ds.defaultPeer), "default peer should be known")
+	assert.Nil(t, ds.checkPeer(nil, ds.defaultPeer), "default peer should be permitted")
+	privatePeer := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53}
+	assert.Error(t, ds.checkPeer(nil, privatePeer), "private peer should not be permitted")
+	assert.False(t, ds.isKnownPeer(privatePeer), "denied peer should not be known")
+}
+
+func TestDatagramConnectOp(t *testing.T) { //nolint:paralleltest // Sets the global exit policy.
+	// Start two echo servers as peers.
+	var peers []*net.UDPAddr
+	for i := 0; i < 2; i++ {
+		pc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = pc.Close()
+		}()
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				n, addr, err := pc.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				_, _ = pc.WriteTo(buf[:n], addr)
+			}
+		}()
+		peers = append(peers, pc.LocalAddr().(*net.UDPAddr)) //nolint:forcetypeassert
+	}
+
+	// Create test terminal pair.
+	a, b, err := terminal.NewSimpleTestTerminalPair(0, 0, &terminal.TerminalOpts{
+		FlowControl:     terminal.FlowControlDFQ,
+		FlowControlSize: testQueueSize,
+		Padding:         testPadding,
+	})
+	if err != nil {
+		t.Fatalf("failed to create test terminal pair: %s", err)
+	}
+
+	// Set up exit.
+	b.GrantPermission(terminal.MayConnect)
+	conf.EnablePublicHub(true)
+	identity, err := cabin.CreateIdentity(module.Ctx, "test")
+	if err != nil {
+		t.Fatalf("failed to create identity: %s", err)
+	}
+	_, err = identity.MaintainAnnouncement(&hub.Announcement{
+		Transports: []string{
+			"tcp:17",
+		},
+		Exit: []string{
+			"+ *",
+		},
+	}, true)
+	if err != nil {
+		t.Fatalf("failed to update identity: %s", err)
+	}
+	identity.Hub.Status = &hub.Status{
+		Flags: []string{hub.FlagDatagrams},
+	}
+	EnableConnecting(identity.Hub)
+
+	// Connect in datagram mode via a connection with multiple peers.
+	appConn := newTestDatagramConn()
+	op, tErr := NewConnectOp(&Tunnel{
+		connInfo: &network.Connection{
+			Entity: (&intel.Entity{
+				Protocol: uint8(packet.UDP),
+				Port:     uint16(peers[0].Port),
+				IP:       peers[0].IP,
+			}).Init(0),
+		},
+		conn:        appConn,
+		dstTerminal: a,
+		dstPin: &navigator.Pin{
+			Hub: identity.Hub,
+		},
+	})
+	if tErr != nil {
+		t.Fatalf("failed to start connect op: %s", tErr)
+	}
+	defer op.Stop(op, nil)
+	assert.True(t, op.request.Datagrams, "datagram mode should be used")
+
+	// Exchange datagrams with the requested destination and another peer.
+	for i := 0; i < 3; i++ {
+		for _, peer := range peers {
+			data := []byte(fmt.Sprintf("datagram %d to %s", i, peer))
+			appConn.send(data, peer)
+
+			select {
+			case dgram := <-appConn.received:
+				assert.Equal(t, data, dgram.data, "datagram should be echoed")
+				assert.Equal(t, peer.String(), dgram.addr.String(), "datagram should be received from the peer")
+			case <-time.After(3 * time.Second):
+				t.Fatalf("did not receive datagram from %s", peer)
+			}
+		}
+	}
+}
+
+type testDatagram struct {
+	data []byte
+	addr net.Addr
+}
+
+// testDatagramConn is a packet connection as provided by the entry side.
+// Datagrams sent by the app are read with the peer they are destined to and
+// datagrams written to the connection are received by the app.
+type testDatagramConn struct {
+	net.Conn
+
+	toSend   chan testDatagram
+	received chan testDatagram
+	closed   chan struct{}
+	close    sync.Once
+}
+
+var _ net.PacketConn = &testDatagramConn{}
+
+func newTestDatagramConn() *testDatagramConn {
+	return &testDatagramConn{
+		toSend:   make(chan testDatagram, 10),
+		received: make(chan testDatagram, 10),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (c *testDatagramConn) send(data []byte, to net.Addr) {
+	c.toSend <- testDatagram{data: data, addr: to}
+}
+
+func (c *testDatagramConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	select {
+	case dgram := <-c.toSend:
+		return copy(p, dgram.data), dgram.addr, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *testDatagramConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
+	select {
+	case c.received <- testDatagram{data: append([]byte{}, p...), addr: addr}:
+		return len(p), nil
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *testDatagramConn) Close() error {
+	c.close.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+func (c *testDatagramConn) LocalAddr() net.Addr { return &net.UDPAddr{} }
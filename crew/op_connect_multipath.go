@@ -0,0 +1,876 @@
+package crew
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tevino/abool"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/spn/terminal"
+)
+
+/*
+
+Connect Op Multipath Message Format:
+
+- Type [varint; 1: data, 2: ack]
+- Sequence Number [varint64]
+	- data: sequence number of the data
+	- ack: next sequence number expected, all data before was received
+- Data [bytes; not blocked; data only]
+
+In multipath mode, one connection is split across multiple connect ops, each
+using a different route to the same Destination Hub. Data is sent on any path
+with free capacity and reassembled in order by the other side. Sent data is
+kept until it is acknowledged, so that it can be resent on the remaining paths
+when a path fails.
+
+Resumable connections use the same format. When the last path fails, both
+sides keep the connection and buffer data until a new path is added by a
+resume operation or the resume timeout is reached.
+
+*/
+
+const (
+	multipathMsgTypeData uint8 = 1
+	multipathMsgTypeAck  uint8 = 2
+
+	// sessionIDSize is the size of session IDs.
+	sessionIDSize = 16
+
+	// maxMultipaths is the maximum amount of paths of a multipath connection.
+	maxMultipaths = 2
+
+	// multipathAckEvery defines after how many received messages an ack is sent.
+	multipathAckEvery = 16
+
+	// multipathMaxUnacked is the maximum amount of sent, but unacknowledged
+	// messages. It also limits the amount of messages held for reordering.
+	multipathMaxUnacked = 1000
+
+	// multipathResumeTimeout defines how long a resumable connection is kept
+	// without any paths.
+	multipathResumeTimeout = 2 * time.Minute
+
+	// multipathResumeRetryInterval defines the wait time between attempts to
+	// resume a connection.
+	multipathResumeRetryInterval = 5 * time.Second
+)
+
+var (
+	multipathConns = make(map[string]*multipathConn)
+	// multipathEntryConns holds the multipath connections started here.
+	multipathEntryConns = make(map[*multipathConn]struct{})
+	multipathConnsLock  sync.Mutex
+)
+
+// multipathConn is a connection that is split across one or more connect ops,
+// which may be added and removed while the connection is active.
+type multipathConn struct {
+	sync.Mutex
+
+	id        string
+	conn      net.Conn
+	request   *ConnectRequest
+	entry     bool
+	tunnel    *Tunnel
+	resumable bool
+
+	// paths holds the connect ops of all active paths.
+	paths    []*ConnectOp
+	nextPath int
+	stopping bool
+
+	// detached is set while a resumable connection has no paths and stops the
+	// connection when the resume timeout is reached.
+	detached *time.Timer
+
+	// ownedTerminals holds terminals that were created for this connection
+	// only and are abandoned when the connection is closed.
+	ownedTerminals []terminal.Terminal
+
+	// sendSeq is the next sequence number to send.
+	sendSeq uint64
+	// unacked holds sent, but unacknowledged data, starting at unackedSeq.
+	unacked    [][]byte
+	unackedSeq uint64
+	// ackSignal is closed and replaced when data is acknowledged.
+	ackSignal chan struct{}
+
+	// recvSeq is the next sequence number expected to be received.
+	recvSeq uint64
+	// reorder holds data that was received out of order.
+	reorder map[uint64][]byte
+	// ackedSeq is the sequence number that was last acknowledged.
+	ackedSeq uint64
+	// ackDue is set when an ack must be sent with the next received data,
+	// because acks sent on a failed path may have been lost.
+	ackDue bool
+	// ackPending is set while an ack waits for free capacity.
+	ackPending bool
+	// writeLock serializes writing to the connection.
+	writeLock sync.Mutex
+
+	// incompressible is set when the connection carries TLS.
+	incompressible abool.AtomicBool
+
+	// Metrics
+	incomingTraffic *uint64
+	firstReceived   *abool.AtomicBool
+	started         time.Time
+
+	ctx       context.Context
+	cancelCtx context.CancelFunc
+	closeOnce sync.Once
+}
+
+func newMultipathConn(request *ConnectRequest, conn net.Conn, entry bool) *multipathConn {
+	mp := &multipathConn{
+		id:              string(request.SessionID),
+		conn:            conn,
+		request:         request,
+		entry:           entry,
+		resumable:       request.Resumable,
+		ackSignal:       make(chan struct{}),
+		reorder:         make(map[uint64][]byte),
+		incomingTraffic: new(uint64),
+		firstReceived:   abool.New(),
+		started:         time.Now(),
+	}
+	mp.ctx, mp.cancelCtx = context.WithCancel(module.Ctx)
+	return mp
+}
+
+func (r *ConnectRequest) checkSession() *terminal.Error {
+	switch {
+	case len(r.SessionID) != sessionIDSize:
+		return terminal.ErrInvalidOptions.With("invalid session ID size of %d", len(r.SessionID))
+	case r.Protocol != packet.TCP:
+		return terminal.ErrIncorrectUsage.With("session mode is not supported for protocol %s", r.Protocol)
+	case r.Datagrams:
+		return terminal.ErrIncorrectUsage.With("session mode is not supported in datagram mode")
+	}
+	return nil
+}
+
+// register registers the multipath connection for other paths to join.
+func (mp *multipathConn) register() *terminal.Error {
+	multipathConnsLock.Lock()
+	defer multipathConnsLock.Unlock()
+
+	if _, ok := multipathConns[mp.id]; ok {
+		return terminal.ErrInvalidOptions.With("session ID already in use")
+	}
+	multipathConns[mp.id] = mp
+	return nil
+}
+
+// registerEntry registers the multipath connection as started here.
+func (mp *multipathConn) registerEntry() {
+	multipathConnsLock.Lock()
+	defer multipathConnsLock.Unlock()
+
+	// Do not register connections that were already closed.
+	if mp.ctx.Err() == nil {
+		multipathEntryConns[mp] = struct{}{}
+	}
+}
+
+func getMultipathConn(id []byte) *multipathConn {
+	multipathConnsLock.Lock()
+	defer multipathConnsLock.Unlock()
+
+	return multipathConns[string(id)]
+}
+
+// matches returns whether the given request is for the same destination.
+func (mp *multipathConn) matches(request *ConnectRequest) bool {
+	return request.IP.Equal(mp.request.IP) &&
+		request.Port == mp.request.Port &&
+		request.Protocol == mp.request.Protocol
+}
+
+// addPath adds a connect op as a path. If the connection was detached,
+// unacknowledged data is resent on the new path.
+func (mp *multipathConn) addPath(op *ConnectOp) *terminal.Error {
+	mp.Lock()
+
+	switch {
+	case mp.stopping:
+		mp.Unlock()
+		return terminal.ErrStopping.With("multipath connection is stopping")
+	case len(mp.paths) >= maxMultipaths:
+		mp.Unlock()
+		return terminal.ErrPermissionDenied.With("multipath connection has too many paths")
+	}
+	mp.paths = append(mp.paths, op)
+
+	// Continue normally if the connection was not detached.
+	if mp.detached == nil {
+		mp.Unlock()
+		return nil
+	}
+	mp.detached.Stop()
+	mp.detached = nil
+
+	// Copy unacknowledged data for resending.
+	// This must happen together with adding the path, so that no data is missed.
+	resend := make([][]byte, len(mp.unacked))
+	copy(resend, mp.unacked)
+	resendSeq := mp.unackedSeq
+	mp.Unlock()
+
+	log.Infof("spn/crew: resumed connection to %s via op %s>%d", mp.request, op.t.FmtID(), op.ID())
+	connectOpResumes.Inc()
+	mp.resend(resend, resendSeq)
+	return nil
+}
+
+// addOwnedTerminal adds a terminal that is abandoned when the connection is
+// closed. Returns false if the connection is already stopping.
+func (mp *multipathConn) addOwnedTerminal(t terminal.Terminal) bool {
+	mp.Lock()
+	defer mp.Unlock()
+
+	if mp.stopping {
+		return false
+	}
+	mp.ownedTerminals = append(mp.ownedTerminals, t)
+	return true
+}
+
+// removePath removes the given connect op from the paths. If the path was
+// stopped by an error, unacknowledged data is resent on the remaining paths.
+// If no paths remain, the connection is closed.
+func (mp *multipathConn) removePath(op *ConnectOp, err *terminal.Error) {
+	mp.Lock()
+
+	// Remove path.
+	var found bool
+	for i, path := range mp.paths {
+		if path == op {
+			mp.paths = append(mp.paths[:i], mp.paths[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		mp.Unlock()
+		return
+	}
+
+	// Paths that stopped regularly have delivered all their data.
+	failed := !mp.stopping && !err.Is(terminal.ErrStopping)
+	if failed {
+		mp.ackDue = true
+	}
+
+	switch {
+	case len(mp.paths) > 0 && !failed:
+		mp.Unlock()
+		return
+
+	case len(mp.paths) == 0 && (!failed || !mp.resumable):
+		// Close connection when the last path is gone.
+		mp.stopping = true
+		mp.Unlock()
+		mp.close()
+		return
+
+	case len(mp.paths) == 0:
+		// Keep resumable connection until a new path is added.
+		mp.detached = time.AfterFunc(multipathResumeTimeout, func() {
+			mp.stop(terminal.ErrTimeout.With("waiting for connection to be resumed"))
+		})
+		mp.Unlock()
+
+		log.Infof("spn/crew: connect op %s>%d failed, waiting for connection to be resumed: %s", op.t.FmtID(), op.ID(), err)
+		if mp.entry && mp.tunnel != nil {
+			module.StartWorker("tunnel resume", func(ctx context.Context) error {
+				mp.tunnel.resume(ctx, mp)
+				return nil
+			})
+		}
+		return
+	}
+
+	// Copy unacknowledged data for resending.
+	resend := make([][]byte, len(mp.unacked))
+	copy(resend, mp.unacked)
+	resendSeq := mp.unackedSeq
+	mp.Unlock()
+
+	log.Infof("spn/crew: connect op %s>%d failed, failing over to remaining path: %s", op.t.FmtID(), op.ID(), err)
+	connectOpMultipathFailovers.Inc()
+	mp.resend(resend, resendSeq)
+}
+
+// resend resends the given data, starting with the given sequence number.
+func (mp *multipathConn) resend(resend [][]byte, resendSeq uint64) {
+	if len(resend) == 0 {
+		return
+	}
+
+	module.StartWorker("connect op multipath resend", func(_ context.Context) error {
+		for i, data := range resend {
+			if tErr := mp.sendOnPath(resendSeq+uint64(i), data); tErr != nil {
+				mp.stop(tErr.Wrap("failed to resend data"))
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// isDetached returns whether the connection currently waits to be resumed.
+func (mp *multipathConn) isDetached() bool {
+	mp.Lock()
+	defer mp.Unlock()
+
+	return mp.detached != nil
+}
+
+// stop stops all paths with the given error.
+func (mp *multipathConn) stop(err *terminal.Error) {
+	mp.Lock()
+	if mp.stopping {
+		mp.Unlock()
+		return
+	}
+	mp.stopping = true
+	if mp.detached != nil {
+		mp.detached.Stop()
+		mp.detached = nil
+	}
+	paths := make([]*ConnectOp, len(mp.paths))
+	copy(paths, mp.paths)
+	mp.Unlock()
+
+	if len(paths) == 0 {
+		mp.close()
+		return
+	}
+	for _, op := range paths {
+		op.Stop(op, err)
+	}
+}
+
+func (mp *multipathConn) close() {
+	mp.closeOnce.Do(func() {
+		mp.cancelCtx()
+		_ = mp.conn.Close()
+
+		// Unregister.
+		multipathConnsLock.Lock()
+		if mp.entry {
+			delete(multipathEntryConns, mp)
+		} else {
+			delete(multipathConns, mp.id)
+		}
+		multipathConnsLock.Unlock()
+
+		// Abandon terminals that were created for this connection.
+		// This may be called by an operation of one of these terminals.
+		mp.Lock()
+		ownedTerminals := mp.ownedTerminals
+		mp.ownedTerminals = nil
+		mp.Unlock()
+		if len(ownedTerminals) > 0 {
+			module.StartWorker("abandon multipath terminals", func(_ context.Context) error {
+				for _, t := range ownedTerminals {
+					t.Abandon(nil)
+				}
+				return nil
+			})
+		}
+	})
+}
+
+func (mp *multipathConn) connectedType() string {
+	if mp.entry {
+		return "origin"
+	}
+	return "destination"
+}
+
+// getPaths returns the active paths, rotated for spreading load.
+func (mp *multipathConn) getPaths() []*ConnectOp {
+	mp.Lock()
+	defer mp.Unlock()
+
+	paths := make([]*ConnectOp, 0, len(mp.paths))
+	for i := range mp.paths {
+		paths = append(paths, mp.paths[(mp.nextPath+i)%len(mp.paths)])
+	}
+	mp.nextPath++
+	return paths
+}
+
+// sendData assigns the next sequence number to the given data and sends it.
+// It blocks while too much data is unacknowledged.
+func (mp *multipathConn) sendData(data []byte) *terminal.Error {
+	mp.Lock()
+	for len(mp.unacked) >= multipathMaxUnacked {
+		ackSignal := mp.ackSignal
+		mp.Unlock()
+		select {
+		case <-ackSignal:
+		case <-mp.ctx.Done():
+			return terminal.ErrStopping
+		}
+		mp.Lock()
+	}
+	seq := mp.sendSeq
+	mp.sendSeq++
+	mp.unacked = append(mp.unacked, data)
+	mp.Unlock()
+
+	return mp.sendOnPath(seq, data)
+}
+
+// sendOnPath sends the given data on the first path with free capacity.
+// If all paths are busy, it waits for capacity.
+func (mp *multipathConn) sendOnPath(seq uint64, data []byte) *terminal.Error {
+	paths := mp.getPaths()
+	if len(paths) == 0 {
+		// Data is sent when the connection is resumed.
+		if mp.isDetached() {
+			return nil
+		}
+		return terminal.ErrStopping.With("no paths left")
+	}
+
+	// Send on the first path that has free capacity.
+	// Failing paths are skipped, as they will be handled by failover.
+	for _, op := range paths {
+		if op.dfq.TrySend(op.newMultipathMsg(multipathMsgTypeData, seq, data)) == nil {
+			return nil
+		}
+	}
+
+	// Wait for capacity on any path.
+	var tErr *terminal.Error
+	for _, op := range paths {
+		msg := op.newMultipathMsg(multipathMsgTypeData, seq, data)
+		tErr = op.dfq.Send(msg, 30*time.Second)
+		if tErr == nil {
+			return nil
+		}
+		msg.Finish()
+	}
+	return tErr
+}
+
+func (op *ConnectOp) newMultipathMsg(msgType uint8, seq uint64, data []byte) *terminal.Msg {
+	c := container.New()
+	c.AppendNumber(uint64(msgType))
+	c.AppendNumber(seq)
+	if len(data) > 0 {
+		c.Append(data)
+	}
+
+	msg := op.NewMsg(nil)
+	msg.Data = c
+	msg.Incompressible = op.multipath != nil && op.multipath.incompressible.IsSet()
+	if msgType == multipathMsgTypeAck || op.request.UsePriorityDataMsgs {
+		msg.Unit.MakeHighPriority()
+	}
+	return msg
+}
+
+// handleAck removes the acknowledged data.
+func (mp *multipathConn) handleAck(seq uint64) *terminal.Error {
+	mp.Lock()
+	defer mp.Unlock()
+
+	// Ignore old acks.
+	if seq <= mp.unackedSeq {
+		return nil
+	}
+	n := seq - mp.unackedSeq
+	if n > uint64(len(mp.unacked)) {
+		return terminal.ErrMalformedData.With("received ack for unsent data")
+	}
+
+	// Remove acknowledged data.
+	for i := uint64(0); i < n; i++ {
+		mp.unacked[i] = nil
+	}
+	mp.unacked = mp.unacked[n:]
+	mp.unackedSeq = seq
+
+	// Signal waiting senders.
+	close(mp.ackSignal)
+	mp.ackSignal = make(chan struct{})
+
+	return nil
+}
+
+// reassemble adds the received data to the reorder buffer and returns all
+// data that can be written in order. If an ack should be sent, the sequence
+// number to acknowledge is returned too.
+func (mp *multipathConn) reassemble(seq uint64, data []byte) (toWrite [][]byte, ackSeq uint64, tErr *terminal.Error) {
+	mp.Lock()
+	defer mp.Unlock()
+
+	switch {
+	case seq < mp.recvSeq:
+		// Ignore duplicate, eg. resent after failover.
+		// Acknowledge again if the previous ack may have been lost.
+		return nil, mp.dueAck(), nil
+	case seq > mp.recvSeq:
+		// Hold for reordering.
+		if _, ok := mp.reorder[seq]; !ok && len(mp.reorder) >= multipathMaxUnacked {
+			return nil, 0, terminal.ErrMalformedData.With("too much data out of order")
+		}
+		mp.reorder[seq] = data
+		return nil, 0, nil
+	}
+
+	// Collect all data that is now in order.
+	toWrite = append(toWrite, data)
+	mp.recvSeq++
+	for {
+		next, ok := mp.reorder[mp.recvSeq]
+		if !ok {
+			break
+		}
+		delete(mp.reorder, mp.recvSeq)
+		toWrite = append(toWrite, next)
+		mp.recvSeq++
+	}
+
+	return toWrite, mp.dueAck(), nil
+}
+
+// dueAck returns the sequence number to acknowledge, if an ack is due.
+// Must be called with the lock held.
+func (mp *multipathConn) dueAck() uint64 {
+	if mp.ackDue || mp.recvSeq-mp.ackedSeq >= multipathAckEvery {
+		return mp.recvSeq
+	}
+	return 0
+}
+
+// markAcked records that the given sequence number was acknowledged.
+func (mp *multipathConn) markAcked(seq uint64) {
+	mp.Lock()
+	defer mp.Unlock()
+
+	if seq > mp.ackedSeq {
+		mp.ackedSeq = seq
+	}
+	mp.ackDue = false
+}
+
+// sendAck sends an ack for the given sequence number on the given path.
+// If the path has no free capacity, the ack is sent by a worker that waits
+// for capacity, as the other side may not send any more data until it
+// receives the ack.
+func (mp *multipathConn) sendAck(op *ConnectOp, ackSeq uint64) {
+	if op.dfq.TrySend(op.newMultipathMsg(multipathMsgTypeAck, ackSeq, nil)) == nil {
+		mp.markAcked(ackSeq)
+		return
+	}
+
+	// Start ack worker, if not already running.
+	mp.Lock()
+	defer mp.Unlock()
+	if mp.ackPending {
+		return
+	}
+	mp.ackPending = true
+
+	module.StartWorker("connect op multipath ack", func(_ context.Context) error {
+		for {
+			// Acknowledge all data received until now, while an ack is due.
+			mp.Lock()
+			ackSeq := mp.dueAck()
+			if ackSeq == 0 {
+				mp.ackPending = false
+				mp.Unlock()
+				return nil
+			}
+			mp.Unlock()
+
+			if tErr := op.dfq.Send(op.newMultipathMsg(multipathMsgTypeAck, ackSeq, nil), 30*time.Second); tErr != nil {
+				// Path failed, the ack is sent on the next path.
+				mp.Lock()
+				mp.ackPending = false
+				mp.Unlock()
+				return nil
+			}
+			mp.markAcked(ackSeq)
+		}
+	})
+}
+
+// receiveData reassembles the received data and writes it to the connection.
+func (mp *multipathConn) receiveData(op *ConnectOp, seq uint64, data []byte) *terminal.Error {
+	// Keep the write order while reassembling.
+	mp.writeLock.Lock()
+	defer mp.writeLock.Unlock()
+
+	toWrite, ackSeq, tErr := mp.reassemble(seq, data)
+	if tErr != nil {
+		return tErr
+	}
+
+	// Write data to connection.
+	for _, data := range toWrite {
+		for len(data) > 0 {
+			n, err := mp.conn.Write(data)
+			switch {
+			case err != nil:
+				if errors.Is(err, io.EOF) {
+					return terminal.ErrStopping.With("connection to %s was closed on write", mp.connectedType())
+				}
+				return terminal.ErrConnectionError.With("failed to send to %s: %w", mp.connectedType(), err)
+			case n == 0:
+				return terminal.ErrConnectionError.With("sent 0 bytes to %s", mp.connectedType())
+			}
+			data = data[n:]
+		}
+	}
+
+	// Send ack on the path the data was received on.
+	if ackSeq > 0 {
+		mp.sendAck(op, ackSeq)
+	}
+
+	return nil
+}
+
+func (mp *multipathConn) startReader() {
+	module.StartWorker("connect op multipath conn reader", mp.connReader)
+}
+
+func (mp *multipathConn) connReader(_ context.Context) error {
+	// Metrics setup and submitting.
+	atomic.AddInt64(activeConnectOps, 1)
+	defer func() {
+		atomic.AddInt64(activeConnectOps, -1)
+		connectOpDurationHistogram.UpdateDuration(mp.started)
+		connectOpIncomingDataHistogram.Update(float64(atomic.LoadUint64(mp.incomingTraffic)))
+	}()
+
+	rateLimiter := terminal.NewRateLimiter(rateLimitMaxMbit)
+
+	for {
+		// Read from connection.
+		buf := make([]byte, readBufSize)
+		n, err := mp.conn.Read(buf)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				mp.stop(terminal.ErrStopping.With("connection to %s was closed on read", mp.connectedType()))
+			} else {
+				mp.stop(terminal.ErrConnectionError.With("failed to read from %s: %w", mp.connectedType(), err))
+			}
+			return nil
+		}
+		if n == 0 {
+			continue
+		}
+
+		// Submit metrics.
+		connectOpIncomingBytes.Add(n)
+		inBytes := atomic.AddUint64(mp.incomingTraffic, uint64(n))
+
+		// Rate limit if over threshold.
+		if inBytes > rateLimitThreshold {
+			rateLimiter.Limit(uint64(n))
+		}
+
+		// Check if the connection carries TLS with the first data.
+		if inBytes == uint64(n) && isIncompressible(mp.request, buf[:n]) {
+			mp.incompressible.Set()
+		}
+
+		// Send data on any path.
+		if tErr := mp.sendData(buf[:n]); tErr != nil {
+			mp.stop(tErr.Wrap("failed to send data (multipath) from %s", mp.connectedType()))
+			return nil
+		}
+	}
+}
+
+func (op *ConnectOp) multipathWriter(_ context.Context) error {
+	// Metrics submitting.
+	defer func() {
+		connectOpOutgoingDataHistogram.Update(float64(atomic.LoadUint64(op.outgoingTraffic)))
+	}()
+
+	var msg *terminal.Msg
+	defer msg.Finish()
+
+	rateLimiter := terminal.NewRateLimiter(rateLimitMaxMbit)
+
+	for {
+		msg.Finish()
+
+		select {
+		case msg = <-op.dfq.Receive():
+		case <-op.ctx.Done():
+			op.Stop(op, terminal.ErrCanceled)
+			return nil
+		default:
+			// Handle all data before also listening for the context cancel.
+			// This ensures all data is written properly before stopping.
+			select {
+			case msg = <-op.dfq.Receive():
+			case op.doneWriting <- struct{}{}:
+				op.Stop(op, terminal.ErrStopping)
+				return nil
+			case <-op.ctx.Done():
+				op.Stop(op, terminal.ErrCanceled)
+				return nil
+			}
+		}
+
+		// Parse message.
+		msgType, err := msg.Data.GetNextN8()
+		if err != nil {
+			op.multipath.stop(terminal.ErrMalformedData.With("failed to parse multipath msg type: %w", err))
+			return nil
+		}
+		seq, err := msg.Data.GetNextN64()
+		if err != nil {
+			op.multipath.stop(terminal.ErrMalformedData.With("failed to parse multipath msg sequence: %w", err))
+			return nil
+		}
+
+		switch msgType {
+		case multipathMsgTypeAck:
+			if tErr := op.multipath.handleAck(seq); tErr != nil {
+				op.multipath.stop(tErr)
+				return nil
+			}
+
+		case multipathMsgTypeData:
+			data := msg.Data.CompileData()
+
+			// Submit metrics.
+			connectOpOutgoingBytes.Add(len(data))
+			out := atomic.AddUint64(op.outgoingTraffic, uint64(len(data)))
+
+			// Rate limit if over threshold.
+			if out > rateLimitThreshold {
+				rateLimiter.Limit(uint64(len(data)))
+			}
+
+			// Special handling after first data was received on client.
+			if op.multipath.firstReceived.SetToIf(false, true) {
+				op.handleFirstReceivedData()
+			}
+
+			if tErr := op.multipath.receiveData(op, seq, data); tErr != nil {
+				op.multipath.stop(tErr)
+				return nil
+			}
+
+		default:
+			op.multipath.stop(terminal.ErrMalformedData.With("unknown multipath msg type %d", msgType))
+			return nil
+		}
+	}
+}
+
+// newSessionPathOp adds a path via the given terminal to the given multipath
+// connection. If resume is true, a detached connection is resumed.
+func newSessionPathOp(mp *multipathConn, t terminal.Terminal, resume bool) (*ConnectOp, *terminal.Error) {
+	// Submit metrics.
+	newConnectOp.Inc()
+
+	// Create join request.
+	request := *mp.request
+	request.MultipathJoin = !resume
+
+	// Create new op.
+	op := &ConnectOp{
+		doneWriting: make(chan struct{}),
+		t:           t,
+		request:     &request,
+		entry:       true,
+		tunnel:      mp.tunnel,
+		multipath:   mp,
+		resume:      resume,
+	}
+	op.ctx, op.cancelCtx = context.WithCancel(module.Ctx)
+	op.dfq = terminal.NewDuplexFlowQueue(op.Ctx(), request.QueueSize, op.submitUpstream)
+
+	// Setup metrics.
+	op.incomingTraffic = new(uint64)
+	op.outgoingTraffic = new(uint64)
+	op.started = time.Now()
+
+	// Prepare init msg.
+	data, err := dsd.Dump(&request, dsd.CBOR)
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to pack connect request: %w", err)
+	}
+
+	// Initialize.
+	tErr := op.t.StartOperation(op, container.New(data), 5*time.Second)
+	if tErr != nil {
+		return nil, tErr
+	}
+
+	// Add as path.
+	if tErr := op.multipath.addPath(op); tErr != nil {
+		op.Stop(op, tErr)
+		return nil, tErr
+	}
+
+	op.startWorkers()
+	return op, nil
+}
+
+func startSessionPathOp(t terminal.Terminal, opID uint32, request *ConnectRequest, resume bool) (terminal.Operation, *terminal.Error) {
+	// Get multipath connection to join.
+	mp := getMultipathConn(request.SessionID)
+	switch {
+	case mp == nil:
+		return nil, terminal.ErrInvalidOptions.With("unknown session")
+	case !mp.matches(request):
+		return nil, terminal.ErrInvalidOptions.With("request does not match session")
+	case resume && !mp.resumable:
+		return nil, terminal.ErrIncorrectUsage.With("session is not resumable")
+	}
+
+	// Create and initialize operation.
+	op := &ConnectOp{
+		doneWriting: make(chan struct{}),
+		t:           t,
+		request:     request,
+		multipath:   mp,
+		resume:      resume,
+	}
+	op.InitOperationBase(t, opID)
+	op.ctx, op.cancelCtx = context.WithCancel(t.Ctx())
+	op.dfq = terminal.NewDuplexFlowQueue(op.Ctx(), request.QueueSize, op.submitUpstream)
+
+	// Setup metrics.
+	op.incomingTraffic = new(uint64)
+	op.outgoingTraffic = new(uint64)
+
+	// Add as path.
+	if tErr := mp.addPath(op); tErr != nil {
+		op.cancelCtx()
+		return nil, tErr
+	}
+
+	// Start worker.
+	op.startWorkers()
+
+	log.Infof("spn/crew: added op %s#%d as path to %s", op.t.FmtID(), op.ID(), request)
+	return op, nil
+}
@@ -0,0 +1,147 @@
+package crew
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/spn/navigator"
+	"github.com/safing/spn/terminal"
+)
+
+func TestMultipathReassembly(t *testing.T) {
+	t.Parallel()
+
+	mp := newMultipathConn(&ConnectRequest{}, nil, true)
+
+	// Receive out of order.
+	toWrite, _, tErr := mp.reassemble(1, []byte{1})
+	assert.Nil(t, tErr)
+	assert.Empty(t, toWrite, "out of order data should be held back")
+	toWrite, _, tErr = mp.reassemble(0, []byte{0})
+	assert.Nil(t, tErr)
+	assert.Equal(t, [][]byte{{0}, {1}}, toWrite, "data should be released in order")
+
+	// Ignore duplicates.
+	toWrite, _, tErr = mp.reassemble(1, []byte{1})
+	assert.Nil(t, tErr)
+	assert.Empty(t, toWrite, "duplicate data should be ignored")
+
+	// Ack is due after enough data.
+	var ackSeq uint64
+	for seq := uint64(2); seq < multipathAckEvery; seq++ {
+		_, ackSeq, tErr = mp.reassemble(seq, []byte{byte(seq)})
+		assert.Nil(t, tErr)
+	}
+	assert.Equal(t, uint64(multipathAckEvery), ackSeq, "ack should be due")
+	mp.markAcked(ackSeq)
+	_, ackSeq, _ = mp.reassemble(multipathAckEvery, nil)
+	assert.Zero(t, ackSeq, "ack should not be due again")
+
+	// Acknowledge again after a path failed, even for duplicates.
+	mp.ackDue = true
+	_, ackSeq, _ = mp.reassemble(1, []byte{1})
+	assert.Equal(t, uint64(multipathAckEvery+1), ackSeq, "ack should be due after failover")
+	mp.markAcked(ackSeq)
+	_, ackSeq, _ = mp.reassemble(1, []byte{1})
+	assert.Zero(t, ackSeq, "ack should not be due again")
+}
+
+func TestMultipathAcks(t *testing.T) {
+	t.Parallel()
+
+	mp := newMultipathConn(&ConnectRequest{}, nil, true)
+	mp.unacked = [][]byte{{0}, {1}, {2}}
+	mp.sendSeq = 3
+
+	ackSignal := mp.ackSignal
+	assert.Nil(t, mp.handleAck(2))
+	assert.Equal(t, [][]byte{{2}}, mp.unacked, "acknowledged data should be removed")
+	assert.Equal(t, uint64(2), mp.unackedSeq)
+	select {
+	case <-ackSignal:
+	default:
+		t.Error("ack signal should be closed")
+	}
+
+	assert.Nil(t, mp.handleAck(1), "old ack should be ignored")
+	assert.Len(t, mp.unacked, 1)
+	assert.NotNil(t, mp.handleAck(5), "ack of unsent data should fail")
+}
+
+func TestRoutesAreDisjoint(t *testing.T) {
+	t.Parallel()
+
+	route := func(hubIDs ...string) *navigator.Route {
+		r := &navigator.Route{}
+		for _, hubID := range hubIDs {
+			r.Path = append(r.Path, &navigator.Hop{HubID: hubID})
+		}
+		return r
+	}
+
+	assert.True(t, routesAreDisjoint(route("H", "A", "D"), route("H", "B", "D")))
+	assert.True(t, routesAreDisjoint(route("H", "A", "D"), route("H", "D")))
+	assert.True(t, routesAreDisjoint(route("H", "D"), route("H", "B", "D")))
+	assert.True(t, routesAreDisjoint(route("H", "D"), route("H", "B")), "route to transit hub should be checked too")
+	assert.False(t, routesAreDisjoint(route("H", "A", "D"), route("H", "B", "A", "D")))
+	assert.False(t, routesAreDisjoint(route("H", "A", "D"), route("H", "A")))
+	assert.False(t, routesAreDisjoint(route("H", "D"), route("H", "D")))
+}
+
+func TestMultipathResume(t *testing.T) {
+	t.Parallel()
+
+	a, _, err := terminal.NewSimpleTestTerminalPair(0, 0, &terminal.TerminalOpts{
+		FlowControl:     terminal.FlowControlDFQ,
+		FlowControlSize: testQueueSize,
+		Padding:         testPadding,
+	})
+	if err != nil {
+		t.Fatalf("failed to create test terminal pair: %s", err)
+	}
+	newPath := func(mp *multipathConn) *ConnectOp {
+		op := &ConnectOp{
+			t:         a,
+			request:   mp.request,
+			multipath: mp,
+		}
+		op.dfq = terminal.NewDuplexFlowQueue(module.Ctx, testQueueSize, nil)
+		return op
+	}
+	newConn := func(resumable bool) *multipathConn {
+		conn, _ := net.Pipe()
+		return newMultipathConn(&ConnectRequest{Resumable: resumable}, conn, true)
+	}
+
+	// Resumable connection is detached when the last path fails.
+	mp := newConn(true)
+	op1 := newPath(mp)
+	assert.Nil(t, mp.addPath(op1))
+	mp.removePath(op1, terminal.ErrAbandonedTerminal)
+	assert.True(t, mp.isDetached(), "connection should be detached")
+	assert.NoError(t, mp.ctx.Err(), "connection should not be closed")
+
+	// Data is buffered while detached.
+	assert.Nil(t, mp.sendData([]byte{1}))
+	assert.Len(t, mp.unacked, 1, "data should be buffered")
+
+	// Resume with a new path.
+	op2 := newPath(mp)
+	assert.Nil(t, mp.addPath(op2))
+	assert.False(t, mp.isDetached(), "connection should be resumed")
+
+	// Connection is closed when the last path stops regularly.
+	mp.removePath(op2, terminal.ErrStopping)
+	assert.Error(t, mp.ctx.Err(), "connection should be closed")
+	assert.NotNil(t, mp.addPath(newPath(mp)), "closed connection should not accept paths")
+
+	// Connection that is not resumable is closed when the last path fails.
+	mp = newConn(false)
+	op1 = newPath(mp)
+	assert.Nil(t, mp.addPath(op1))
+	mp.removePath(op1, terminal.ErrAbandonedTerminal)
+	assert.False(t, mp.isDetached(), "connection should not be detached")
+	assert.Error(t, mp.ctx.Err(), "connection should be closed")
+}
@@ -0,0 +1,50 @@
+package crew
+
+import (
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/terminal"
+)
+
+// ResumeOpType is the type ID for the resume operation, which resumes a
+// connect operation via a new route.
+const ResumeOpType string = "resume"
+
+func init() {
+	terminal.RegisterOpType(terminal.OperationFactory{
+		Type:     ResumeOpType,
+		Requires: terminal.MayConnect,
+		Start:    startResumeOp,
+	})
+}
+
+func startResumeOp(t terminal.Terminal, opID uint32, data *container.Container) (terminal.Operation, *terminal.Error) {
+	// Check if we are running a public hub.
+	if !conf.PublicHub() {
+		return nil, terminal.ErrPermissionDenied.With("connecting is only allowed on public hubs")
+	}
+	if conf.Draining() {
+		return nil, terminal.ErrHubDraining.With("not accepting resumed connections")
+	}
+
+	// Parse resume request.
+	request := &ConnectRequest{}
+	_, err := dsd.Load(data.CompileData(), request)
+	if err != nil {
+		return nil, terminal.ErrMalformedData.With("failed to parse resume request: %w", err)
+	}
+	if tErr := request.checkSession(); tErr != nil {
+		return nil, tErr
+	}
+	if request.QueueSize == 0 || request.QueueSize > terminal.MaxQueueSize {
+		return nil, terminal.ErrInvalidOptions.With("invalid queue size of %d", request.QueueSize)
+	}
+
+	// Check if the request is within the scope of the granted access.
+	if tErr := checkGrantScope(t, request); tErr != nil {
+		return nil, tErr
+	}
+
+	return startSessionPathOp(t, opID, request, true)
+}
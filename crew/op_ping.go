@@ -42,6 +42,12 @@ func (op *PingOp) Type() string {
 	return PingOpType
 }
 
+// TrafficClass returns the traffic class used for scheduling the operation's
+// messages.
+func (op *PingOp) TrafficClass() terminal.TrafficClass {
+	return terminal.TrafficClassControl
+}
+
 func init() {
 	terminal.RegisterOpType(terminal.OperationFactory{
 		Type:  PingOpType,
@@ -0,0 +1,422 @@
+package crew
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/terminal"
+)
+
+const (
+	// ResolveOpType is the type ID of the resolve operation.
+	ResolveOpType = "resolve"
+
+	resolveOpTimeout      = 10 * time.Second
+	resolveOpQueryTimeout = 5 * time.Second
+)
+
+var (
+	// resolveOpNameservers holds the nameservers used for resolving on the
+	// exit. It is loaded from the system configuration when first needed.
+	resolveOpNameservers     []string
+	resolveOpNameserversErr  error
+	resolveOpNameserversOnce sync.Once
+
+	// resolveOpPermittedTypes holds the query types that may be resolved.
+	resolveOpPermittedTypes = map[uint16]struct{}{
+		dns.TypeA:     {},
+		dns.TypeAAAA:  {},
+		dns.TypeCNAME: {},
+		dns.TypeHTTPS: {},
+		dns.TypeSVCB:  {},
+		dns.TypeMX:    {},
+		dns.TypeSRV:   {},
+		dns.TypeTXT:   {},
+	}
+)
+
+// ResolveOp is used to resolve domains on the exit Hub.
+type ResolveOp struct {
+	terminal.OneOffOperationBase
+
+	request *ResolveRequest
+
+	// Response holds the response of a successful resolve operation.
+	// It is available after ErrExplicitAck was received on the Result channel.
+	Response *ResolveResponse
+}
+
+// ResolveRequest is a resolve request.
+type ResolveRequest struct {
+	Domain string `json:"d,omitempty"`
+	QType  uint16 `json:"qt,omitempty"`
+
+	// Protocol and Port define the connection for which the domain is resolved
+	// and are required. The request is checked against the exit policy like a
+	// connect request to the domain, so that resolving only reveals what
+	// connecting to the domain would reveal too.
+	Protocol packet.IPProtocol `json:"p,omitempty"`
+	Port     uint16            `json:"po,omitempty"`
+}
+
+func (r *ResolveRequest) String() string {
+	return fmt.Sprintf("%s %s", r.Domain, dns.Type(r.QType))
+}
+
+// ResolveResponse is a resolve response.
+type ResolveResponse struct {
+	Rcode   int      `json:"rc,omitempty"`
+	Records []string `json:"r,omitempty"`
+}
+
+// RRs parses and returns the records of the response.
+func (r *ResolveResponse) RRs() ([]dns.RR, error) {
+	rrs := make([]dns.RR, 0, len(r.Records))
+	for _, record := range r.Records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse record %q: %w", record, err)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, nil
+}
+
+// Type returns the type ID.
+func (op *ResolveOp) Type() string {
+	return ResolveOpType
+}
+
+func init() {
+	terminal.RegisterOpType(terminal.OperationFactory{
+		Type:     ResolveOpType,
+		Requires: terminal.MayConnect,
+		Start:    startResolveOp,
+	})
+}
+
+// NewResolveOp starts a new resolve operation.
+func NewResolveOp(t terminal.Terminal, request *ResolveRequest) (*ResolveOp, *terminal.Error) {
+	// Check request.
+	if tErr := request.check(); tErr != nil {
+		return nil, tErr
+	}
+
+	// Create operation and init.
+	op := &ResolveOp{
+		request: request,
+	}
+	op.OneOffOperationBase.Init()
+
+	// Create request.
+	data, err := dsd.Dump(request, dsd.CBOR)
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to pack resolve request: %w", err)
+	}
+
+	// Start operation.
+	tErr := t.StartOperation(op, container.New(data), resolveOpTimeout)
+	if tErr != nil {
+		return nil, tErr
+	}
+
+	return op, nil
+}
+
+// Resolve resolves the given request via the given terminal and waits for the
+// response.
+func Resolve(t terminal.Terminal, request *ResolveRequest) (*ResolveResponse, *terminal.Error) {
+	op, tErr := NewResolveOp(t, request)
+	if tErr != nil {
+		return nil, tErr
+	}
+
+	select {
+	case tErr := <-op.Result:
+		if !tErr.Is(terminal.ErrExplicitAck) {
+			return nil, tErr
+		}
+		return op.Response, nil
+	case <-time.After(resolveOpTimeout):
+		op.Stop(op, terminal.ErrTimeout)
+		return nil, terminal.ErrTimeout.With("waiting for resolve response")
+	}
+}
+
+// Deliver delivers a message to the operation.
+func (op *ResolveOp) Deliver(msg *terminal.Msg) *terminal.Error {
+	defer msg.Finish()
+
+	// Parse response.
+	response := &ResolveResponse{}
+	_, err := dsd.Load(msg.Data.CompileData(), response)
+	if err != nil {
+		return terminal.ErrMalformedData.With("failed to parse resolve response: %w", err)
+	}
+	op.Response = response
+
+	return terminal.ErrExplicitAck
+}
+
+func (r *ResolveRequest) check() *terminal.Error {
+	r.Domain = dns.Fqdn(r.Domain)
+	if _, ok := dns.IsDomainName(r.Domain); !ok || r.Domain == "." {
+		return terminal.ErrInvalidOptions.With("invalid domain %q", r.Domain)
+	}
+	if _, ok := resolveOpPermittedTypes[r.QType]; !ok {
+		return terminal.ErrInvalidOptions.With("query type %s is not permitted", dns.Type(r.QType))
+	}
+
+	// Require a complete connection, as port scoped rules of the exit policy
+	// do not match without a port.
+	switch r.Protocol { //nolint:exhaustive // Only looking at specific values.
+	case packet.TCP, packet.UDP:
+	default:
+		return terminal.ErrInvalidOptions.With("protocol %s is not supported", r.Protocol)
+	}
+	if r.Port == 0 {
+		return terminal.ErrInvalidOptions.With("port is required")
+	}
+
+	return nil
+}
+
+func startResolveOp(t terminal.Terminal, opID uint32, data *container.Container) (terminal.Operation, *terminal.Error) {
+	// Check if we are running a public hub.
+	if !conf.PublicHub() {
+		return nil, terminal.ErrPermissionDenied.With("resolving is only allowed on public hubs")
+	}
+	if conf.Draining() {
+		return nil, terminal.ErrHubDraining.With("not accepting new resolve requests")
+	}
+
+	// Parse request.
+	request := &ResolveRequest{}
+	_, err := dsd.Load(data.CompileData(), request)
+	if err != nil {
+		return nil, terminal.ErrMalformedData.With("failed to parse resolve request: %w", err)
+	}
+	if tErr := request.check(); tErr != nil {
+		return nil, tErr
+	}
+
+	// Check exit policy and granted scope like a connect request to the domain.
+	connectRequest := &ConnectRequest{
+		Domain:   request.Domain,
+		Protocol: request.Protocol,
+		Port:     request.Port,
+	}
+	if tErr := checkExitPolicy(connectRequest); tErr != nil {
+		return nil, tErr
+	}
+	if tErr := checkGrantScope(t, connectRequest); tErr != nil {
+		return nil, tErr
+	}
+
+	// Create operation and resolve in the background.
+	op := &ResolveOp{
+		request: request,
+	}
+	op.InitOperationBase(t, opID)
+	module.StartWorker("resolve op", op.resolve)
+
+	return op, nil
+}
+
+func (op *ResolveOp) resolve(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, resolveOpTimeout)
+	defer cancel()
+
+	// Resolve request.
+	response, tErr := resolveOnExit(ctx, op.request)
+	if tErr != nil {
+		op.Stop(op, tErr)
+		return nil
+	}
+
+	// Send response.
+	data, err := dsd.Dump(response, dsd.CBOR)
+	if err != nil {
+		op.Stop(op, terminal.ErrInternalError.With("failed to pack resolve response: %w", err))
+		return nil
+	}
+	msg := op.NewMsg(data)
+	msg.Unit.MakeHighPriority()
+	tErr = op.Send(msg, resolveOpTimeout)
+	if tErr != nil {
+		op.Stop(op, tErr.Wrap("failed to send resolve response"))
+		return nil
+	}
+
+	// Operation is just one response and finished successfully.
+	op.Stop(op, nil)
+	return nil
+}
+
+func getResolveOpNameservers() ([]string, error) {
+	resolveOpNameserversOnce.Do(func() {
+		// Nameservers may be set already, eg. for testing.
+		if len(resolveOpNameservers) > 0 {
+			return
+		}
+
+		config, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil {
+			resolveOpNameserversErr = fmt.Errorf("failed to load system nameservers: %w", err)
+			return
+		}
+		for _, server := range config.Servers {
+			resolveOpNameservers = append(resolveOpNameservers, net.JoinHostPort(server, config.Port))
+		}
+	})
+
+	if len(resolveOpNameservers) == 0 && resolveOpNameserversErr == nil {
+		return nil, fmt.Errorf("no system nameservers configured")
+	}
+	return resolveOpNameservers, resolveOpNameserversErr
+}
+
+func resolveOnExit(ctx context.Context, request *ResolveRequest) (*ResolveResponse, *terminal.Error) {
+	nameservers, err := getResolveOpNameservers()
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("%w", err)
+	}
+
+	// Create query.
+	query := new(dns.Msg)
+	query.SetQuestion(request.Domain, request.QType)
+	query.SetEdns0(dns.DefaultMsgSize, false)
+
+	// Query nameservers until one responds.
+	var reply *dns.Msg
+	for _, nameserver := range nameservers {
+		client := &dns.Client{
+			Timeout: resolveOpQueryTimeout,
+		}
+		reply, _, err = client.ExchangeContext(ctx, query, nameserver)
+		if err == nil && reply.Truncated {
+			client.Net = "tcp"
+			reply, _, err = client.ExchangeContext(ctx, query, nameserver)
+		}
+		if err == nil {
+			break
+		}
+		log.Tracef("spn/crew: failed to resolve %s via %s: %s", request, nameserver, err)
+	}
+	if err != nil {
+		return nil, terminal.ErrConnectionError.With("failed to resolve %s: %w", request, err)
+	}
+
+	// Create response and only include records that are safe to return.
+	response := &ResolveResponse{
+		Rcode: reply.Rcode,
+	}
+	for _, rr := range reply.Answer {
+		var ip net.IP
+		switch v := rr.(type) {
+		case *dns.A:
+			ip = v.A
+		case *dns.AAAA:
+			ip = v.AAAA
+		}
+		if ip != nil && netutils.GetIPScope(ip) != netutils.Global {
+			continue
+		}
+
+		response.Records = append(response.Records, rr.String())
+	}
+
+	return response, nil
+}
+
+// resolveAtExit resolves the domain of the connection at the Destination Hub.
+// If the IP of the connection is not among the returned IPs, the connection is
+// made to a returned IP instead. This prevents connecting to a server that was
+// selected for the location of the local resolver instead of the Destination
+// Hub.
+func (t *Tunnel) resolveAtExit(ctx context.Context) {
+	entity := t.connInfo.Entity
+	if t.connInfo.TunnelOpts == nil ||
+		!t.connInfo.TunnelOpts.ResolveAtExit ||
+		entity.Domain == "" ||
+		t.dstPin.Hub.Status == nil ||
+		!t.dstPin.Hub.Status.HasFlag(hub.FlagResolve) {
+		return
+	}
+
+	// Resolve the same IP version as the connection.
+	qType := dns.TypeA
+	if entity.IP.To4() == nil {
+		qType = dns.TypeAAAA
+	}
+	response, tErr := Resolve(t.dstTerminal, &ResolveRequest{
+		Domain:   entity.Domain,
+		QType:    qType,
+		Protocol: packet.IPProtocol(entity.Protocol),
+		Port:     entity.Port,
+	})
+	if tErr != nil {
+		log.Tracer(ctx).Debugf("spn/crew: failed to resolve %s at %s: %s", entity.Domain, t.dstPin.Hub, tErr)
+		return
+	}
+	rrs, err := response.RRs()
+	if err != nil {
+		log.Tracer(ctx).Debugf("spn/crew: failed to parse resolve response for %s: %s", entity.Domain, err)
+		return
+	}
+
+	t.resolvedIP = selectResolvedIP(rrs, entity.IP)
+	if t.resolvedIP != nil {
+		log.Tracer(ctx).Debugf("spn/crew: %s resolves to %s at %s, using it instead of %s", entity.Domain, t.resolvedIP, t.dstPin.Hub, entity.IP)
+	}
+}
+
+// selectResolvedIP returns the first IP of the given records, if the given
+// current IP is not among them.
+func selectResolvedIP(rrs []dns.RR, current net.IP) net.IP {
+	var selected net.IP
+	for _, rr := range rrs {
+		var ip net.IP
+		switch v := rr.(type) {
+		case *dns.A:
+			ip = v.A
+		case *dns.AAAA:
+			ip = v.AAAA
+		default:
+			continue
+		}
+
+		if ip.Equal(current) {
+			return nil
+		}
+		if selected == nil {
+			selected = ip
+		}
+	}
+	return selected
+}
+
+// HandleStop gives the operation the ability to cleanly shut down.
+// The returned error is the error to send to the other side.
+// Should never be called directly. Call Stop() instead.
+func (op *ResolveOp) HandleStop(err *terminal.Error) (errorToSend *terminal.Error) {
+	// Prevent remote from sending explicit ack, as we use it as a success signal internally.
+	if err.Is(terminal.ErrExplicitAck) && err.IsExternal() {
+		err = terminal.ErrStopping.AsExternal()
+	}
+
+	// Continue with usual handling of inherited base.
+	return op.OneOffOperationBase.HandleStop(err)
+}
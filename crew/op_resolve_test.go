@@ -0,0 +1,154 @@
+package crew
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/spn/cabin"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/terminal"
+)
+
+func TestResolveOp(t *testing.T) { //nolint:paralleltest // Sets the global exit policy.
+	// Start local test nameserver.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			reply := new(dns.Msg)
+			reply.SetReply(r)
+			for _, record := range []string{
+				r.Question[0].Name + " 60 IN A 1.1.1.1",
+				r.Question[0].Name + " 60 IN A 10.0.0.1",
+			} {
+				rr, _ := dns.NewRR(record)
+				reply.Answer = append(reply.Answer, rr)
+			}
+			_ = w.WriteMsg(reply)
+		}),
+	}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	defer func() {
+		_ = server.Shutdown()
+	}()
+	resolveOpNameserversOnce.Do(func() {
+		resolveOpNameservers = []string{pc.LocalAddr().String()}
+	})
+
+	// Create test terminal pair.
+	a, b, err := terminal.NewSimpleTestTerminalPair(0, 0, nil)
+	if err != nil {
+		t.Fatalf("failed to create test terminal pair: %s", err)
+	}
+
+	// Set up exit.
+	b.GrantPermission(terminal.MayConnect)
+	conf.EnablePublicHub(true)
+	identity, err := cabin.CreateIdentity(module.Ctx, "test")
+	if err != nil {
+		t.Fatalf("failed to create identity: %s", err)
+	}
+	_, err = identity.MaintainAnnouncement(&hub.Announcement{
+		Transports: []string{
+			"tcp:17",
+		},
+		Exit: []string{
+			"+ * */443",
+			"- *",
+		},
+	}, true)
+	if err != nil {
+		t.Fatalf("failed to update identity: %s", err)
+	}
+	EnableConnecting(identity.Hub)
+
+	// Resolve permitted request.
+	response, tErr := Resolve(a, &ResolveRequest{
+		Domain:   "example.com",
+		QType:    dns.TypeA,
+		Protocol: packet.TCP,
+		Port:     443,
+	})
+	if tErr != nil {
+		t.Fatal(tErr)
+	}
+	rrs, err := response.RRs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, rrs, 1, "non-global records should be removed") {
+		assert.Equal(t, "1.1.1.1", rrs[0].(*dns.A).A.String()) //nolint:forcetypeassert
+	}
+
+	// Resolve request denied by exit policy.
+	_, tErr = Resolve(a, &ResolveRequest{
+		Domain:   "example.com",
+		QType:    dns.TypeA,
+		Protocol: packet.TCP,
+		Port:     80,
+	})
+	assert.True(t, tErr.Is(terminal.ErrPermissionDenied), "request should violate exit policy")
+
+	// Requests without a port must fail, as port scoped rules would not match.
+	_, tErr = Resolve(a, &ResolveRequest{
+		Domain:   "example.com",
+		QType:    dns.TypeA,
+		Protocol: packet.TCP,
+	})
+	assert.True(t, tErr.Is(terminal.ErrInvalidOptions), "request without port should fail")
+
+	// Unpermitted query types must fail.
+	_, tErr = Resolve(a, &ResolveRequest{
+		Domain: "example.com",
+		QType:  dns.TypeANY,
+	})
+	assert.True(t, tErr.Is(terminal.ErrInvalidOptions), "query type should not be permitted")
+
+	// Requests outside of the granted scope must fail.
+	c, d, err := terminal.NewSimpleTestTerminalPair(0, 0, nil)
+	if err != nil {
+		t.Fatalf("failed to create test terminal pair: %s", err)
+	}
+	d.ApplyGrant(&terminal.Grant{
+		Permission: terminal.MayConnect,
+		Ports:      []uint16{8443},
+	})
+	_, tErr = Resolve(c, &ResolveRequest{
+		Domain:   "example.com",
+		QType:    dns.TypeA,
+		Protocol: packet.TCP,
+		Port:     443,
+	})
+	assert.True(t, tErr.Is(terminal.ErrPermissionDenied), "request should not be within the granted ports")
+}
+
+func TestSelectResolvedIP(t *testing.T) {
+	t.Parallel()
+
+	rrs := make([]dns.RR, 0, 3)
+	for _, record := range []string{
+		"example.com. 60 IN CNAME cdn.example.net.",
+		"cdn.example.net. 60 IN A 1.1.1.1",
+		"cdn.example.net. 60 IN A 1.0.0.1",
+	} {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+
+	assert.Nil(t, selectResolvedIP(rrs, net.IPv4(1, 0, 0, 1)), "returned IP should be kept")
+	assert.Equal(t, "1.1.1.1", selectResolvedIP(rrs, net.IPv4(8, 8, 8, 8)).String(), "first returned IP should be selected")
+	assert.Nil(t, selectResolvedIP(rrs[:1], net.IPv4(8, 8, 8, 8)), "IP should be kept without returned IPs")
+}
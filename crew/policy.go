@@ -2,9 +2,11 @@ package crew
 
 import (
 	"context"
+	"net"
 	"sync"
 
 	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network/netutils"
 	"github.com/safing/portmaster/profile/endpoints"
 	"github.com/safing/spn/hub"
 	"github.com/safing/spn/terminal"
@@ -23,6 +25,20 @@ func EnableConnecting(my *hub.Hub) {
 	connectingHub = my
 }
 
+// checkDestinationScope checks if the given IP is in global scope.
+// Local destinations are permitted when running tests.
+func checkDestinationScope(ip net.IP) *terminal.Error {
+	switch netutils.GetIPScope(ip) {
+	case netutils.Global:
+		return nil
+	case netutils.HostLocal:
+		if runningTests {
+			return nil
+		}
+	}
+	return terminal.ErrPermissionDenied.With("denied request to connect to non-global IP %s", ip)
+}
+
 func checkExitPolicy(request *ConnectRequest) *terminal.Error {
 	connectingHubLock.Lock()
 	defer connectingHubLock.Unlock()
@@ -49,3 +65,36 @@ func checkExitPolicy(request *ConnectRequest) *terminal.Error {
 
 	return nil
 }
+
+// checkGrantScope checks if the connect request is within the scope of the
+// access granted to the terminal.
+func checkGrantScope(t terminal.Terminal, request *ConnectRequest) *terminal.Error {
+	authTerm, ok := t.(terminal.AuthorizingTerminal)
+	if !ok {
+		return nil
+	}
+	grant := authTerm.GetGrant()
+	if grant == nil {
+		return nil
+	}
+
+	// Check destination port.
+	if !grant.AllowsPort(request.Port) {
+		return terminal.ErrPermissionDenied.With("connect request for %s is not within the granted ports", request)
+	}
+
+	// Check destination country.
+	// Requests without an IP, such as resolve requests, are checked when
+	// connecting to the resolved IP.
+	if len(grant.Countries) > 0 && request.IP != nil {
+		entity := (&intel.Entity{
+			IP: request.IP,
+		}).Init(0)
+		country, ok := entity.GetCountry(context.TODO())
+		if !ok || !grant.AllowsCountry(country) {
+			return terminal.ErrPermissionDenied.With("connect request for %s is not within the granted countries", request)
+		}
+	}
+
+	return nil
+}
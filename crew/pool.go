@@ -0,0 +1,280 @@
+package crew
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/spn/navigator"
+)
+
+// Terminal Pool Configuration.
+const (
+	// poolWarmRoutes defines how many routes are kept warm per pool key.
+	poolWarmRoutes = 2
+
+	// poolMaxKeys defines the maximum amount of pool keys that are tracked.
+	poolMaxKeys = 10
+
+	// poolDemandTTL defines how long demand for a pool key is remembered.
+	poolDemandTTL = 10 * time.Minute
+
+	// poolRefreshInterval defines the interval in which warm routes are
+	// refreshed. It must be lower than the reachability check interval of
+	// establishRoute, so that warm routes stay ready for immediate use.
+	poolRefreshInterval = 45 * time.Second
+
+	// poolMinRefreshInterval defines the minimum wait time between refreshes
+	// triggered by changes of the navigator map.
+	poolMinRefreshInterval = 10 * time.Second
+
+	// poolMaxParallelWarming defines how many routes are warmed at the same
+	// time.
+	poolMaxParallelWarming = 3
+
+	// poolWarmCostTolerance defines by how much the cost of a warm route may
+	// exceed the cost of the best route in order to be preferred.
+	poolWarmCostTolerance = 0.1
+)
+
+// poolKey identifies a group of destinations for the terminal pool.
+type poolKey struct {
+	country        string
+	routingProfile string
+}
+
+// poolDemand holds the data needed to find routes for a pool key.
+type poolDemand struct {
+	ip       net.IP
+	opts     *navigator.Options
+	lastUsed time.Time
+}
+
+// terminalPool keeps routes to popular destinations warm, so that new tunnels
+// can use them without waiting for expansion and authorization.
+type terminalPool struct {
+	sync.Mutex
+
+	demand map[poolKey]*poolDemand
+}
+
+var (
+	pool = &terminalPool{
+		demand: make(map[poolKey]*poolDemand),
+	}
+	poolRefreshTask *modules.Task
+)
+
+func startTerminalPool() {
+	poolRefreshTask = module.NewTask("refresh terminal pool", pool.refresh).
+		Repeat(poolRefreshInterval)
+	module.StartServiceWorker("terminal pool change listener", 0, pool.changeListener)
+}
+
+// recordDemand records the use of the given tunnel and triggers a refresh,
+// if the pool key is new.
+func (p *terminalPool) recordDemand(t *Tunnel) {
+	if t.connInfo == nil || t.connInfo.Entity == nil || t.connInfo.TunnelOpts == nil {
+		return
+	}
+	key := poolKey{
+		country:        t.connInfo.Entity.Country,
+		routingProfile: t.connInfo.TunnelOpts.RoutingProfile,
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	// Update existing demand.
+	demand, ok := p.demand[key]
+	if ok {
+		demand.ip = t.connInfo.Entity.IP
+		demand.opts = t.connInfo.TunnelOpts.Copy()
+		demand.lastUsed = time.Now()
+		return
+	}
+
+	// Add new demand.
+	p.demand[key] = &poolDemand{
+		ip:       t.connInfo.Entity.IP,
+		opts:     t.connInfo.TunnelOpts.Copy(),
+		lastUsed: time.Now(),
+	}
+	p.cleanDemand()
+
+	if poolRefreshTask != nil {
+		poolRefreshTask.StartASAP()
+	}
+}
+
+// cleanDemand removes expired demand and keeps the least recently used
+// demand in check. The pool must be locked.
+func (p *terminalPool) cleanDemand() {
+	// Remove expired demand.
+	for key, demand := range p.demand {
+		if time.Since(demand.lastUsed) > poolDemandTTL {
+			delete(p.demand, key)
+		}
+	}
+
+	// Remove least recently used demand if there are too many.
+	if len(p.demand) <= poolMaxKeys {
+		return
+	}
+	keys := make([]poolKey, 0, len(p.demand))
+	for key := range p.demand {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return p.demand[keys[i]].lastUsed.After(p.demand[keys[j]].lastUsed)
+	})
+	for _, key := range keys[poolMaxKeys:] {
+		delete(p.demand, key)
+	}
+}
+
+// currentDemand returns a copy of the current demand.
+func (p *terminalPool) currentDemand() map[poolKey]*poolDemand {
+	p.Lock()
+	defer p.Unlock()
+
+	p.cleanDemand()
+
+	demand := make(map[poolKey]*poolDemand, len(p.demand))
+	for key, d := range p.demand {
+		demand[key] = &poolDemand{
+			ip:       d.ip,
+			opts:     d.opts.Copy(),
+			lastUsed: d.lastUsed,
+		}
+	}
+	return demand
+}
+
+// refresh establishes and checks the top routes of every pool key.
+func (p *terminalPool) refresh(ctx context.Context, _ *modules.Task) error {
+	// Check if the SPN is ready for tunneling.
+	home, homeTerminal := navigator.Main.GetHome()
+	if home == nil || homeTerminal == nil || homeTerminal.IsBeingAbandoned() {
+		return nil
+	}
+
+	// Collect the top routes of all pool keys, once per Destination Hub.
+	var routes []*navigator.Route
+	dstHubs := make(map[string]struct{})
+	for key, demand := range p.currentDemand() {
+		// Find routes like a tunnel would.
+		found, err := navigator.Main.FindRoutes(demand.ip, demand.opts)
+		if err != nil {
+			log.Debugf("spn/crew: failed to find routes for terminal pool %s/%s: %s", key.country, key.routingProfile, err)
+			continue
+		}
+
+		for i, route := range found.All {
+			if i >= poolWarmRoutes {
+				break
+			}
+			dstHubID := route.Path[len(route.Path)-1].HubID
+			if _, ok := dstHubs[dstHubID]; ok {
+				continue
+			}
+			dstHubs[dstHubID] = struct{}{}
+			routes = append(routes, route)
+		}
+	}
+
+	// Establish or check the routes in parallel.
+	// Route establishment locks the Hubs of the route, so that tunnels are
+	// only delayed if they use the same Hubs.
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, poolMaxParallelWarming)
+	for _, route := range routes {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return nil
+		}
+
+		wg.Add(1)
+		go func(route *navigator.Route) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			if _, _, err := establishRoute(route); err != nil {
+				log.Tracef("spn/crew: failed to warm route %s for terminal pool: %s", route, err)
+			}
+		}(route)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// changeListener refreshes the pool when the navigator map changes.
+func (p *terminalPool) changeListener(ctx context.Context) error {
+	for {
+		select {
+		case <-navigator.Main.Changed():
+			if poolRefreshTask != nil {
+				poolRefreshTask.StartASAP()
+			}
+		case <-ctx.Done():
+			return nil
+		}
+
+		// Do not refresh too often.
+		select {
+		case <-time.After(poolMinRefreshInterval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// preferWarmRoutes sorts routes that can be used immediately to the front, if
+// their cost is within the tolerance of the best route. The order is kept
+// otherwise.
+func preferWarmRoutes(routes []*navigator.Route) {
+	if len(routes) == 0 {
+		return
+	}
+
+	// Find the best cost, as the top routes may be randomized.
+	bestCost := routes[0].TotalCost
+	for _, route := range routes[1:] {
+		if route.TotalCost < bestCost {
+			bestCost = route.TotalCost
+		}
+	}
+	maxCost := bestCost * (1 + poolWarmCostTolerance)
+
+	preferred := func(route *navigator.Route) bool {
+		return route.TotalCost <= maxCost && isWarmRoute(route)
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return preferred(routes[i]) && !preferred(routes[j])
+	})
+}
+
+// isWarmRoute returns whether all Hubs of the route have an active and
+// recently checked terminal.
+func isWarmRoute(route *navigator.Route) bool {
+	if len(route.Path) < 2 {
+		return false
+	}
+
+	for _, hop := range route.Path[1:] {
+		activeTerminal := hop.Pin().GetActiveTerminal()
+		if activeTerminal == nil || activeTerminal.NeedsReachableCheck(1*time.Minute) {
+			return false
+		}
+	}
+	return true
+}
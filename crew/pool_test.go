@@ -0,0 +1,92 @@
+package crew
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/spn/navigator"
+)
+
+func TestTerminalPoolDemand(t *testing.T) {
+	t.Parallel()
+
+	p := &terminalPool{
+		demand: make(map[poolKey]*poolDemand),
+	}
+	newTunnel := func(country string) *Tunnel {
+		return &Tunnel{
+			connInfo: &network.Connection{
+				Entity: &intel.Entity{
+					IP:      net.IPv4(1, 1, 1, 1),
+					Country: country,
+				},
+				TunnelOpts: &navigator.Options{
+					RoutingProfile: "default",
+				},
+			},
+		}
+	}
+
+	// Record demand.
+	p.recordDemand(newTunnel("AT"))
+	p.recordDemand(newTunnel("AT"))
+	p.recordDemand(newTunnel("DE"))
+	assert.Len(t, p.currentDemand(), 2, "demand should be grouped by country")
+
+	// Expire demand.
+	p.demand[poolKey{country: "AT", routingProfile: "default"}].lastUsed = time.Now().Add(-2 * poolDemandTTL)
+	demand := p.currentDemand()
+	assert.Len(t, demand, 1, "expired demand should be removed")
+	assert.Contains(t, demand, poolKey{country: "DE", routingProfile: "default"})
+
+	// Exceed maximum.
+	for i := 0; i < poolMaxKeys+5; i++ {
+		p.recordDemand(newTunnel(fmt.Sprintf("C%d", i)))
+	}
+	demand = p.currentDemand()
+	assert.Len(t, demand, poolMaxKeys, "demand should be capped")
+	assert.NotContains(t, demand, poolKey{country: "DE", routingProfile: "default"}, "least recently used demand should be removed")
+}
+
+func TestLockHubs(t *testing.T) {
+	t.Parallel()
+
+	// Duplicate Hubs must not deadlock.
+	unlock := lockHubs("test-hub-b", "test-hub-a", "test-hub-b")
+
+	// Routes via other Hubs must not be blocked.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lockHubs("test-hub-c")()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("locking other hubs should not block")
+	}
+
+	// Routes via the same Hubs must wait.
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		lockHubs("test-hub-a", "test-hub-c")()
+	}()
+	select {
+	case <-done:
+		t.Fatal("locking the same hub should block")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("hub should be unlocked")
+	}
+}
@@ -76,9 +76,21 @@ func initCraneController(
 	// Copy the options to the crane itself.
 	crane.opts = *initMsg
 
+	// Remote cranes only send cover traffic up to their own configured rate.
+	// Local cranes start sending cover traffic when the remote crane reports
+	// the agreed rate.
+	if crane.IsMine() {
+		crane.opts.CoverTrafficRate = 0
+	} else if crane.opts.CoverTrafficRate > crane.coverTrafficRate {
+		crane.opts.CoverTrafficRate = crane.coverTrafficRate
+	}
+
 	// Grant crane controller permission.
 	t.GrantPermission(terminal.IsCraneController)
 
+	// Feed lane estimation with traffic.
+	t.SetFlowEstimator(crane.estimator)
+
 	// Start workers.
 	t.StartWorkers(module, "crane controller terminal")
 
@@ -105,6 +105,25 @@ type Crane struct {
 
 	// targetLoadSize defines the optimal loading size.
 	targetLoadSize int
+
+	// coverTrafficRate defines the maximum rate of cover traffic in bytes per
+	// second. It must be set before the crane is started.
+	coverTrafficRate uint32
+	// coverTrafficAgreed receives the cover traffic rate agreed by the remote
+	// crane, which switches the loader of a local crane to cover traffic.
+	coverTrafficAgreed chan uint32
+
+	// controllerInit holds the init data of the crane controller, which is
+	// needed for resuming the crane.
+	controllerInit []byte
+	// resumed indicates if the crane was resumed with a resumption ticket.
+	resumed bool
+
+	// estimator passively estimates the lane quality from the traffic.
+	estimator *terminal.FlowEstimator
+	// loadSampleStart and loadSampleBytes hold the current load sample.
+	loadSampleStart time.Time
+	loadSampleBytes uint64
 }
 
 // NewCrane returns a new crane.
@@ -129,7 +148,10 @@ func NewCrane(ship ships.Ship, connectedHub *hub.Hub, id *cabin.Identity) (*Cran
 		terminalMsgs:   make(chan *terminal.Msg, 100),
 		controllerMsgs: make(chan *terminal.Msg, 100),
 
+		coverTrafficAgreed: make(chan uint32, 1),
+
 		terminals: make(map[uint32]terminal.Terminal),
+		estimator: terminal.NewFlowEstimator(),
 	}
 	err := registerCrane(newCrane)
 	if err != nil {
@@ -228,6 +250,11 @@ func (crane *Crane) Authenticated() bool {
 	return crane.authenticated.IsSet()
 }
 
+// Resumed returns whether the crane was resumed with a resumption ticket.
+func (crane *Crane) Resumed() bool {
+	return crane.resumed
+}
+
 // Publish publishes the connection as a lane.
 func (crane *Crane) Publish() error {
 	// Check if crane is connected.
@@ -654,6 +681,11 @@ func (crane *Crane) loader(workerCtx context.Context) (err error) {
 	// Unclean shutdown safeguard.
 	defer crane.Stop(terminal.ErrUnknownError.With("loader died"))
 
+	// Use the cover traffic loader if cover traffic was negotiated.
+	if crane.opts.CoverTrafficRate > 0 {
+		return crane.coverTrafficLoader(nil)
+	}
+
 	// Return the loading wait channel if waiting.
 	loadNow := func() <-chan time.Time {
 		if loadingTimer != nil {
@@ -690,6 +722,10 @@ func (crane *Crane) loader(workerCtx context.Context) (err error) {
 				case msg = <-crane.terminalMsgs:
 				case <-loadNow():
 					break fillingShipment
+				case rate := <-crane.coverTrafficAgreed:
+					// Switch to cover traffic and continue with the current shipment.
+					crane.opts.CoverTrafficRate = rate
+					return crane.coverTrafficLoader(shipment)
 				case <-crane.ctx.Done():
 					crane.Stop(nil)
 					return nil
@@ -795,21 +831,7 @@ func (crane *Crane) load(c *container.Container) error {
 			paddingNeeded = maxPadding
 		}
 
-		if paddingNeeded > 0 {
-			// Add padding indicator.
-			c.Append([]byte{0})
-			paddingNeeded--
-
-			// Add needed padding data.
-			if paddingNeeded > 0 {
-				padding, err := rng.Bytes(paddingNeeded)
-				if err != nil {
-					log.Debugf("spn/docks: %s failed to get random padding data, using zeros instead", crane)
-					padding = make([]byte, paddingNeeded)
-				}
-				c.Append(padding)
-			}
-		}
+		crane.addPadding(c, paddingNeeded)
 	}
 
 	// Encrypt shipment.
@@ -831,10 +853,34 @@ func (crane *Crane) load(c *container.Container) error {
 	if err != nil {
 		return fmt.Errorf("failed to load ship: %w", err)
 	}
+	if crane.opts.CoverTrafficRate == 0 {
+		crane.sampleLoad(len(readyToSend))
+	}
 
 	return nil
 }
 
+// addPadding adds the given amount of padding to the container.
+func (crane *Crane) addPadding(c *container.Container, paddingNeeded int) {
+	if paddingNeeded <= 0 {
+		return
+	}
+
+	// Add padding indicator.
+	c.Append([]byte{0})
+	paddingNeeded--
+
+	// Add needed padding data.
+	if paddingNeeded > 0 {
+		padding, err := rng.Bytes(paddingNeeded)
+		if err != nil {
+			log.Debugf("spn/docks: %s failed to get random padding data, using zeros instead", crane)
+			padding = make([]byte, paddingNeeded)
+		}
+		c.Append(padding)
+	}
+}
+
 // Stop stops the crane.
 func (crane *Crane) Stop(err *terminal.Error) {
 	if !crane.stopped.SetToIf(false, true) {
@@ -0,0 +1,90 @@
+package docks
+
+import (
+	"context"
+
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/ships"
+	"github.com/safing/spn/terminal"
+)
+
+// CraneBondOpType is the type ID of the crane bond operation.
+const CraneBondOpType = "crane/bond"
+
+type craneBondRequest struct{}
+
+type craneBondResponse struct {
+	Token []byte
+}
+
+var craneBondMethod = &terminal.RPCMethod[craneBondRequest, craneBondResponse]{
+	Type:         CraneBondOpType,
+	Requires:     terminal.IsCraneController,
+	TrafficClass: terminal.TrafficClassControl,
+	Handler:      issueBondJoinToken,
+}
+
+func init() {
+	craneBondMethod.Register()
+}
+
+func issueBondJoinToken(t terminal.Terminal, _ *craneBondRequest) (*craneBondResponse, *terminal.Error) {
+	// Check if we are a on a crane controller.
+	controller, ok := t.(*CraneControllerTerminal)
+	if !ok {
+		return nil, terminal.ErrIncorrectUsage.With("can only be used with a crane controller")
+	}
+	crane := controller.Crane
+
+	// Only cranes on bonded ships started by the other side can be extended.
+	bond, ok := crane.ship.(*ships.BondedShip)
+	if !ok || crane.IsMine() {
+		return nil, terminal.ErrPermissionDenied.With("crane is not bonded")
+	}
+
+	token, err := bond.IssueJoinToken()
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to issue bond join token: %w", err)
+	}
+	return &craneBondResponse{Token: token}, nil
+}
+
+// Bonded returns whether the crane loads shipments over a bonded ship.
+func (crane *Crane) Bonded() bool {
+	_, ok := crane.ship.(*ships.BondedShip)
+	return ok
+}
+
+// BondedShips returns the amount of ships the crane is currently using.
+func (crane *Crane) BondedShips() int {
+	if bond, ok := crane.ship.(*ships.BondedShip); ok {
+		return bond.Members()
+	}
+	return 1
+}
+
+// AddShip adds the given ship to the bonded ship of the crane. The ship must
+// have been launched to the connected Hub.
+func (crane *Crane) AddShip(ctx context.Context, ship ships.Ship) *terminal.Error {
+	bond, ok := crane.ship.(*ships.BondedShip)
+	switch {
+	case !ok || !crane.IsMine():
+		return terminal.ErrIncorrectUsage.With("crane is not bonded")
+	case crane.ConnectedHub == nil ||
+		crane.ConnectedHub.Status == nil ||
+		!crane.ConnectedHub.Status.HasFlag(hub.FlagCraneBonding):
+		return terminal.ErrUnsupportedVersion.With("hub does not support crane bonding")
+	}
+
+	// Get join token via the encrypted crane controller.
+	response, tErr := craneBondMethod.Call(ctx, crane.Controller, &craneBondRequest{})
+	if tErr != nil {
+		return tErr.Wrap("failed to get bond join token")
+	}
+
+	// Join ship to bond.
+	if err := bond.Join(ship, response.Token); err != nil {
+		return terminal.ErrShipSunk.With("failed to join bond: %w", err)
+	}
+	return nil
+}
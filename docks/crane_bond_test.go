@@ -0,0 +1,65 @@
+package docks
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/ships"
+)
+
+func TestCraneBonding(t *testing.T) { //nolint:paralleltest // Resumption tickets are global.
+	identity, connectedHub := getTestIdentity(t)
+
+	// Advertise support for bonding.
+	if _, err := identity.MaintainStatus([]*hub.Lane{}, new(int), []string{hub.FlagCraneBonding}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Start crane on bonded ship.
+	shipA := ships.NewTestShip(false, 100)
+	bond, err := ships.NewBondedShip(shipA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteBond, err := ships.CheckForBond(shipA.Reverse())
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, remote := startTestCranesOn(t, bond, remoteBond, identity, connectedHub)
+	defer local.Stop(nil)
+	assert.True(t, local.Bonded(), "local crane should be bonded")
+	assert.True(t, remote.Bonded(), "remote crane should be bonded")
+
+	// Add second ship.
+	shipB := ships.NewTestShip(false, 100)
+	joinErr := make(chan error, 1)
+	go func() {
+		joined, err := ships.CheckForBond(shipB.Reverse())
+		if err == nil && joined != nil {
+			err = errors.New("ship should have joined the bond")
+		}
+		joinErr <- err
+	}()
+	if tErr := local.AddShip(module.Ctx, shipB); tErr != nil {
+		t.Fatalf("failed to add ship: %s", tErr)
+	}
+	if err := <-joinErr; err != nil {
+		t.Fatalf("failed to join ship: %s", err)
+	}
+	assert.Equal(t, 2, local.BondedShips(), "local crane should use two ships")
+	assert.Equal(t, 2, remote.BondedShips(), "remote crane should use two ships")
+	testCraneCounter(t, local)
+
+	// The crane must survive losing a ship.
+	shipA.Sink()
+	assert.Eventually(t, func() bool {
+		return local.BondedShips() == 1 && remote.BondedShips() == 1
+	}, 3*time.Second, 10*time.Millisecond, "sunk ship should be dropped")
+	testCraneCounter(t, local)
+	assert.False(t, local.Stopped(), "local crane should still be running")
+	assert.False(t, remote.Stopped(), "remote crane should still be running")
+}
@@ -0,0 +1,231 @@
+package docks
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/terminal"
+)
+
+const (
+	// CoverTrafficOpType is the type ID of the cover traffic operation, which
+	// reports the agreed cover traffic rate to the local crane.
+	CoverTrafficOpType = "crane/cover"
+
+	// coverTrafficMinInterval defines the minimum interval between two loads of
+	// cover traffic.
+	coverTrafficMinInterval = 1 * time.Millisecond
+)
+
+type coverTrafficRequest struct{}
+
+type coverTrafficResponse struct {
+	Rate uint32
+}
+
+var coverTrafficMethod = &terminal.RPCMethod[coverTrafficRequest, coverTrafficResponse]{
+	Type:         CoverTrafficOpType,
+	Requires:     terminal.IsCraneController,
+	TrafficClass: terminal.TrafficClassControl,
+	Handler:      reportCoverTrafficRate,
+}
+
+func init() {
+	coverTrafficMethod.Register()
+}
+
+// SetCoverTrafficRate sets the maximum rate of cover traffic in bytes per
+// second. Local cranes request this rate from the remote crane, while remote
+// cranes use it to cap the requested rate.
+// Must be called before the crane is started.
+func (crane *Crane) SetCoverTrafficRate(bytesPerSecond uint32) {
+	crane.coverTrafficRate = bytesPerSecond
+}
+
+func reportCoverTrafficRate(t terminal.Terminal, _ *coverTrafficRequest) (*coverTrafficResponse, *terminal.Error) {
+	// Check if we are a on a crane controller.
+	controller, ok := t.(*CraneControllerTerminal)
+	if !ok {
+		return nil, terminal.ErrIncorrectUsage.With("can only be used with a crane controller")
+	}
+	crane := controller.Crane
+
+	// Only remote cranes agree on the rate.
+	if crane.IsMine() {
+		return nil, terminal.ErrIncorrectUsage.With("cover traffic rate is agreed by the remote crane")
+	}
+
+	return &coverTrafficResponse{
+		Rate: crane.opts.CoverTrafficRate,
+	}, nil
+}
+
+// requestCoverTraffic requests the agreed cover traffic rate from the remote
+// crane and switches the loader to cover traffic, if a rate was agreed.
+func (crane *Crane) requestCoverTraffic() {
+	if !crane.IsMine() || crane.coverTrafficRate == 0 {
+		return
+	}
+
+	module.StartWorker("request cover traffic", func(ctx context.Context) error {
+		response, tErr := coverTrafficMethod.Call(ctx, crane.Controller, &coverTrafficRequest{})
+		if tErr != nil {
+			log.Debugf("spn/docks: %s failed to agree on cover traffic rate: %s", crane, tErr)
+			return nil
+		}
+
+		// Never exceed our own configured rate.
+		rate := response.Rate
+		if rate > crane.coverTrafficRate {
+			rate = crane.coverTrafficRate
+		}
+		if rate > 0 {
+			select {
+			case crane.coverTrafficAgreed <- rate:
+			default:
+			}
+		}
+		return nil
+	})
+}
+
+// coverTrafficInterval returns the interval in which loads of the given size
+// must be loaded in order to reach the given rate.
+func coverTrafficInterval(loadSize int, rate uint32) time.Duration {
+	interval := time.Duration(loadSize) * time.Second / time.Duration(rate)
+	if interval < coverTrafficMinInterval {
+		return coverTrafficMinInterval
+	}
+	return interval
+}
+
+// coverTrafficLoader loads shipments of the target load size at a constant
+// rate. Gaps are filled with padding. When more data is queued than fits into
+// the constant rate, full shipments are loaded immediately.
+// Loading continues with the given shipment, if not nil.
+func (crane *Crane) coverTrafficLoader(shipment *container.Container) error {
+	var tErr *terminal.Error
+	if shipment == nil {
+		shipment = container.New()
+	}
+
+	ticker := time.NewTicker(coverTrafficInterval(crane.targetLoadSize, crane.opts.CoverTrafficRate))
+	defer ticker.Stop()
+
+	// Make sure any received message is finished.
+	var msg, firstMsg *terminal.Msg
+	defer func() {
+		firstMsg.Finish()
+	}()
+
+	log.Debugf("spn/docks: %s is sending cover traffic at %d bytes/s", crane, crane.opts.CoverTrafficRate)
+
+	for {
+		// Prioritize messages from the controller.
+		select {
+		case msg = <-crane.controllerMsgs:
+		case <-crane.ctx.Done():
+			crane.Stop(nil)
+			return nil
+
+		default:
+			// Then listen for all.
+			select {
+			case msg = <-crane.controllerMsgs:
+			case msg = <-crane.terminalMsgs:
+			case <-ticker.C:
+				// Load the next shipment at the constant rate.
+				shipment, tErr = crane.loadFixedShipment(shipment)
+				if tErr != nil {
+					crane.Stop(tErr)
+					return nil
+				}
+				firstMsg.Finish()
+				firstMsg = nil
+				continue
+			case <-crane.ctx.Done():
+				crane.Stop(nil)
+				return nil
+			}
+		}
+
+		// Debug unit leaks.
+		msg.Debug()
+
+		// If there is no new segment, this might have been triggered by a
+		// closed channel. Check if the crane is still active.
+		if msg == nil {
+			if crane.stopped.IsSet() {
+				return nil
+			}
+			continue
+		}
+
+		// Pack msg and add to segment.
+		msg.Pack()
+		newSegment := msg.Data
+
+		// Check if this is the first message.
+		// This is the only message where we wait for a slot.
+		if firstMsg == nil {
+			firstMsg = msg
+			firstMsg.Unit.WaitForSlot()
+		} else {
+			msg.Finish()
+		}
+
+		// Check length.
+		if newSegment.Length() > maxSegmentLength {
+			log.Warningf("spn/docks: %s ignored oversized segment with length %d", crane, newSegment.Length())
+			continue
+		}
+
+		// Append to shipment.
+		shipment.AppendContainer(newSegment)
+
+		// Burst full shipments immediately.
+		for shipment.Length() >= crane.targetLoadSize {
+			shipment, tErr = crane.loadFixedShipment(shipment)
+			if tErr != nil {
+				crane.Stop(tErr)
+				return nil
+			}
+			firstMsg.Finish()
+			firstMsg = nil
+		}
+	}
+}
+
+// loadFixedShipment loads exactly the target load size from the given
+// shipment, fills any remaining space with padding and returns what is left of
+// the shipment.
+func (crane *Crane) loadFixedShipment(shipment *container.Container) (remainder *container.Container, tErr *terminal.Error) {
+	// Split shipment if it is over the target load size.
+	remainder = container.New()
+	if shipment.Length() > crane.targetLoadSize {
+		load, err := shipment.GetAsContainer(crane.targetLoadSize)
+		if err != nil {
+			return nil, terminal.ErrInternalError.With("failed to split segment: %w", err)
+		}
+		shipment, remainder = load, shipment
+	}
+
+	// Fill the rest of the shipment with padding.
+	paddingNeeded := crane.targetLoadSize - shipment.Length()
+	crane.addPadding(shipment, paddingNeeded)
+
+	// Load shipment.
+	err := crane.load(shipment)
+	if err != nil {
+		return nil, terminal.ErrShipSunk.With("failed to load shipment: %w", err)
+	}
+
+	// Submit metrics.
+	if paddingNeeded > 0 {
+		coverTrafficBytes.Add(paddingNeeded)
+	}
+
+	return remainder, nil
+}
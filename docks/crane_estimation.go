@@ -0,0 +1,105 @@
+package docks
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/spn/terminal"
+)
+
+// Passive Measurement Configuration.
+const (
+	passiveMeasurementInterval = 1 * time.Minute
+	craneLoadSampleDuration    = 1 * time.Second
+)
+
+// sampleLoad samples the throughput of the ship while the crane has more data
+// waiting to be loaded. It must only be called by the loader.
+func (crane *Crane) sampleLoad(size int) {
+	// Only sample while there is a backlog, as the ship is the limiting factor
+	// then.
+	backlogged := len(crane.terminalMsgs) > 0 || len(crane.controllerMsgs) > 0
+	if !backlogged {
+		crane.loadSampleStart = time.Time{}
+		return
+	}
+
+	// Start sampling from the next load.
+	now := time.Now()
+	if crane.loadSampleStart.IsZero() {
+		crane.loadSampleStart = now
+		crane.loadSampleBytes = 0
+		return
+	}
+
+	// Submit sample when sampled long enough.
+	crane.loadSampleBytes += uint64(size)
+	if elapsed := now.Sub(crane.loadSampleStart); elapsed >= craneLoadSampleDuration {
+		crane.estimator.AddDeliverySample(crane.loadSampleBytes, elapsed, false)
+		crane.loadSampleStart = now
+		crane.loadSampleBytes = 0
+	}
+}
+
+// Estimator returns the flow estimator of the crane, which passively
+// estimates the lane quality from the traffic of the crane.
+func (crane *Crane) Estimator() *terminal.FlowEstimator {
+	return crane.estimator
+}
+
+// hasPassiveMeasurements returns whether the crane recently had traffic from
+// which the lane quality could be estimated.
+func (crane *Crane) hasPassiveMeasurements() (latency, capacity bool) {
+	_, rttSampledAt := crane.estimator.RTT()
+	_, _, throughputSampledAt := crane.estimator.Throughput()
+	return time.Since(rttSampledAt) < passiveMeasurementInterval,
+		time.Since(throughputSampledAt) < passiveMeasurementInterval
+}
+
+// updatePassiveMeasurements updates the measurements of the connected Hub
+// with the passive estimations of the crane.
+func (crane *Crane) updatePassiveMeasurements() {
+	if crane.ConnectedHub == nil || crane.Stopped() {
+		return
+	}
+	measurements := crane.ConnectedHub.GetMeasurements()
+	latencyFresh, capacityFresh := crane.hasPassiveMeasurements()
+
+	// Update latency.
+	if latencyFresh {
+		if rtt, _ := crane.estimator.RTT(); rtt > 0 {
+			measurements.SetLatency(rtt)
+		}
+	}
+
+	// Update capacity.
+	// If the lane was not fully used, the estimation is only a lower bound and
+	// is only used if it is higher than the current capacity.
+	if capacityFresh {
+		bitRate, saturated, _ := crane.estimator.Throughput()
+		currentCapacity, _ := measurements.GetCapacity()
+		if bitRate > 0 && (saturated || bitRate > currentCapacity) {
+			measurements.SetCapacity(bitRate)
+		}
+	}
+
+	if latencyFresh || capacityFresh {
+		latency, _ := measurements.GetLatency()
+		capacity, _ := measurements.GetCapacity()
+		log.Tracef(
+			"spn/docks: passively measured lane to %s: %s %.2fMbit/s",
+			crane.ConnectedHub,
+			latency,
+			float64(capacity)/1000000,
+		)
+	}
+}
+
+func updatePassiveMeasurements(_ context.Context, _ *modules.Task) error {
+	for _, crane := range GetAllAssignedCranes() {
+		crane.updatePassiveMeasurements()
+	}
+	return nil
+}
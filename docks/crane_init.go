@@ -10,6 +10,7 @@ import (
 	"github.com/safing/portbase/formats/varint"
 	"github.com/safing/portbase/info"
 	"github.com/safing/portbase/log"
+	"github.com/safing/spn/cabin"
 	"github.com/safing/spn/conf"
 	"github.com/safing/spn/terminal"
 )
@@ -21,7 +22,7 @@ used by init procedures
 
 - Data [bytes block]
 	- MsgType [varint]
-	- Data [bytes; only when MsgType is Verify or Start*]
+	- Data [bytes; only when MsgType is Verify, Start* or Resume]
 
 Crane Init Response Format:
 
@@ -42,6 +43,7 @@ const (
 	CraneMsgTypeVerify           = 3
 	CraneMsgTypeStartEncrypted   = 4
 	CraneMsgTypeStartUnencrypted = 5
+	CraneMsgTypeResume           = 6
 )
 
 // Start starts the crane.
@@ -73,6 +75,21 @@ func (crane *Crane) Start(callerCtx context.Context) error {
 func (crane *Crane) startLocal(callerCtx context.Context) *terminal.Error {
 	module.StartWorker("crane unloader", crane.unloader)
 
+	// Try to resume a previous crane first.
+	resumed, tErr := crane.resumeLocal(callerCtx)
+	switch {
+	case tErr != nil:
+		return tErr
+	case resumed:
+		log.Debugf("spn/docks: %s resumed encrypted channel", crane)
+		crane.resumed = true
+		module.StartWorker("crane loader", crane.loader)
+		module.StartWorker("crane handler", crane.handler)
+		crane.requestResumptionTicket()
+		crane.requestCoverTraffic()
+		return nil
+	}
+
 	if !crane.ship.IsSecure() {
 		// Start encrypted channel.
 		// Check if we have all the data we need from the Hub.
@@ -131,7 +148,7 @@ func (crane *Crane) startLocal(callerCtx context.Context) *terminal.Error {
 
 		// Configure encryption.
 		env := jess.NewUnconfiguredEnvelope()
-		env.SuiteID = jess.SuiteWireV1
+		env.SuiteID = cabin.WireSuite(signet)
 		env.Recipients = []*jess.Signet{signet}
 
 		// Do not encrypt directly, rather get session for future use, then encrypt.
@@ -142,10 +159,13 @@ func (crane *Crane) startLocal(callerCtx context.Context) *terminal.Error {
 	}
 
 	// Create crane controller.
-	_, initData, tErr := NewLocalCraneControllerTerminal(crane, terminal.DefaultCraneControllerOpts())
+	controllerOpts := terminal.DefaultCraneControllerOpts()
+	controllerOpts.CoverTrafficRate = crane.coverTrafficRate
+	_, initData, tErr := NewLocalCraneControllerTerminal(crane, controllerOpts)
 	if tErr != nil {
 		return tErr.Wrap("failed to set up controller")
 	}
+	crane.controllerInit = initData.CompileData()
 
 	// Prepare init message for sending.
 	if crane.ship.IsSecure() {
@@ -173,6 +193,8 @@ func (crane *Crane) startLocal(callerCtx context.Context) *terminal.Error {
 	// Start remaining workers.
 	module.StartWorker("crane loader", crane.loader)
 	module.StartWorker("crane handler", crane.handler)
+	crane.requestResumptionTicket()
+	crane.requestCoverTraffic()
 
 	return nil
 }
@@ -261,9 +283,25 @@ handling:
 			// Start crane with initMsg.
 			log.Debugf("spn/docks: %s initiated encrypted channel", crane)
 			break handling
+
+		case CraneMsgTypeResume:
+			// Resume is a terminating request, if rejected.
+			resumeInitMsg, err := crane.handleCraneResume(request)
+			if err != nil {
+				return err
+			}
+			if resumeInitMsg != nil {
+				initMsg = resumeInitMsg
+				crane.resumed = true
+
+				// Start crane with initMsg.
+				log.Debugf("spn/docks: %s resumed encrypted channel", crane)
+				break handling
+			}
 		}
 	}
 
+	crane.controllerInit = initMsg.CompileData()
 	_, _, err := NewRemoteCraneControllerTerminal(crane, initMsg)
 	if err != nil {
 		return err.Wrap("failed to start crane controller")
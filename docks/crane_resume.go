@@ -0,0 +1,414 @@
+package docks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/safing/jess"
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/formats/varint"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/rng"
+	"github.com/safing/spn/cabin"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/terminal"
+)
+
+/*
+
+Crane Resumption:
+
+The remote crane issues resumption tickets to the local crane. A reconnecting
+crane can use a ticket to skip requesting and verifying the Hub info and to
+restore the crane controller with the options it had before. A fresh encryption
+session is set up with the Hub keys known from before, so resumption does not
+affect forward secrecy. If the Hub restarted or the keys are not available
+anymore, the resumption is rejected and the regular init procedure is used.
+
+Tickets are sealed with a key only known to the issuing Hub and may only be
+used once before they expire.
+
+Crane Resume Message Format:
+
+- MsgType [varint; CraneMsgTypeResume]
+- Letter [bytes; not blocked]
+	- encrypted to the Hub
+	- Ticket [bytes; not blocked]
+
+Crane Resume Response Format:
+
+- Data [bytes block]
+	- Resumed [varint; 1 if resumed, 0 if rejected]
+
+*/
+
+const (
+	// ResumptionTicketOpType is the type ID of the resumption ticket operation.
+	ResumptionTicketOpType = "crane/ticket"
+
+	resumptionTicketTTL = 1 * time.Hour
+	ticketKeyID         = "resumption-ticket-key"
+)
+
+// Resumption responses.
+const (
+	resumptionRejected = 0
+	resumptionAccepted = 1
+)
+
+var (
+	// resumptionTickets holds the resumption tickets received from Hubs.
+	resumptionTickets     = make(map[string]*resumptionTicket) // ID = Hub ID
+	resumptionTicketsLock sync.Mutex
+
+	// ticketSession is used to seal and open tickets issued by this Hub.
+	ticketSession     *jess.Session
+	ticketSessionErr  error
+	ticketSessionOnce sync.Once
+	ticketSessionLock sync.Mutex
+
+	// usedTickets holds the IDs of used tickets until they expire.
+	usedTickets     = make(map[string]time.Time)
+	usedTicketsLock sync.Mutex
+)
+
+// resumptionTicket is a ticket received from a Hub.
+type resumptionTicket struct {
+	Ticket         []byte
+	ControllerInit []byte
+	Expires        time.Time
+}
+
+// resumptionTicketInfo is the sealed content of a ticket.
+type resumptionTicketInfo struct {
+	ID             []byte
+	ControllerInit []byte
+	Expires        int64
+}
+
+type resumptionTicketRequest struct{}
+
+type resumptionTicketResponse struct {
+	Ticket  []byte
+	Expires int64
+}
+
+var resumptionTicketMethod = &terminal.RPCMethod[resumptionTicketRequest, resumptionTicketResponse]{
+	Type:         ResumptionTicketOpType,
+	Requires:     terminal.IsCraneController,
+	TrafficClass: terminal.TrafficClassControl,
+	Handler:      issueResumptionTicket,
+}
+
+func init() {
+	resumptionTicketMethod.Register()
+}
+
+func getTicketSession() (*jess.Session, error) {
+	ticketSessionOnce.Do(func() {
+		key, err := rng.Bytes(32)
+		if err != nil {
+			ticketSessionErr = err
+			return
+		}
+
+		env := jess.NewUnconfiguredEnvelope()
+		env.SuiteID = jess.SuiteKey
+		env.Secrets = []*jess.Signet{{
+			Version: 1,
+			ID:      ticketKeyID,
+			Scheme:  jess.SignetSchemeKey,
+			Key:     key,
+		}}
+		ticketSession, ticketSessionErr = env.Correspondence(nil)
+	})
+
+	return ticketSession, ticketSessionErr
+}
+
+func issueResumptionTicket(t terminal.Terminal, _ *resumptionTicketRequest) (*resumptionTicketResponse, *terminal.Error) {
+	// Check if we are a on a crane controller.
+	controller, ok := t.(*CraneControllerTerminal)
+	if !ok {
+		return nil, terminal.ErrIncorrectUsage.With("can only be used with a crane controller")
+	}
+	crane := controller.Crane
+
+	// Only encrypted cranes started by the other side can be resumed.
+	if crane.IsMine() || crane.identity == nil || crane.jession == nil || len(crane.controllerInit) == 0 {
+		return nil, terminal.ErrPermissionDenied.With("crane cannot be resumed")
+	}
+
+	// Create ticket.
+	id, err := rng.Bytes(16)
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to get random ticket ID: %w", err)
+	}
+	expires := time.Now().Add(resumptionTicketTTL)
+	ticketData, err := dsd.Dump(&resumptionTicketInfo{
+		ID:             id,
+		ControllerInit: crane.controllerInit,
+		Expires:        expires.Unix(),
+	}, dsd.CBOR)
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to pack ticket: %w", err)
+	}
+
+	// Seal ticket.
+	session, err := getTicketSession()
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to get ticket session: %w", err)
+	}
+	ticketSessionLock.Lock()
+	defer ticketSessionLock.Unlock()
+	letter, err := session.Close(ticketData)
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to seal ticket: %w", err)
+	}
+	sealed, err := letter.ToDSD(dsd.CBOR)
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to pack sealed ticket: %w", err)
+	}
+
+	return &resumptionTicketResponse{
+		Ticket:  sealed,
+		Expires: expires.Unix(),
+	}, nil
+}
+
+// openResumptionTicket opens the given ticket, checks if it is valid and marks
+// it as used.
+func openResumptionTicket(sealed []byte) (*resumptionTicketInfo, *terminal.Error) {
+	// Open ticket.
+	session, err := getTicketSession()
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to get ticket session: %w", err)
+	}
+	letter, err := jess.LetterFromDSD(sealed)
+	if err != nil {
+		return nil, terminal.ErrMalformedData.With("failed to parse ticket: %w", err)
+	}
+	ticketData, err := func() ([]byte, error) {
+		ticketSessionLock.Lock()
+		defer ticketSessionLock.Unlock()
+
+		return session.Open(letter)
+	}()
+	if err != nil {
+		return nil, terminal.ErrIntegrity.With("failed to open ticket: %w", err)
+	}
+	info := &resumptionTicketInfo{}
+	if _, err := dsd.Load(ticketData, info); err != nil {
+		return nil, terminal.ErrMalformedData.With("failed to parse ticket info: %w", err)
+	}
+
+	// Check if ticket expired.
+	expires := time.Unix(info.Expires, 0)
+	now := time.Now()
+	if now.After(expires) {
+		return nil, terminal.ErrPermissionDenied.With("ticket expired")
+	}
+
+	// Check if ticket was already used.
+	usedTicketsLock.Lock()
+	defer usedTicketsLock.Unlock()
+
+	for usedID, usedExpires := range usedTickets {
+		if now.After(usedExpires) {
+			delete(usedTickets, usedID)
+		}
+	}
+	if _, used := usedTickets[string(info.ID)]; used {
+		return nil, terminal.ErrPermissionDenied.With("ticket already used")
+	}
+	usedTickets[string(info.ID)] = expires
+
+	return info, nil
+}
+
+// requestResumptionTicket requests a resumption ticket from the remote crane,
+// if supported.
+func (crane *Crane) requestResumptionTicket() {
+	// Only encrypted cranes to Hubs that support resumption can be resumed.
+	if !crane.IsMine() ||
+		crane.jession == nil ||
+		crane.ConnectedHub == nil ||
+		crane.ConnectedHub.Status == nil ||
+		!crane.ConnectedHub.Status.HasFlag(hub.FlagCraneResumption) {
+		return
+	}
+
+	module.StartWorker("request crane resumption ticket", func(ctx context.Context) error {
+		tErr := crane.getResumptionTicket(ctx)
+		if tErr != nil {
+			log.Debugf("spn/docks: %s failed to get resumption ticket: %s", crane, tErr)
+		}
+		return nil
+	})
+}
+
+func (crane *Crane) getResumptionTicket(ctx context.Context) *terminal.Error {
+	response, tErr := resumptionTicketMethod.Call(ctx, crane.Controller, &resumptionTicketRequest{})
+	if tErr != nil {
+		return tErr
+	}
+
+	resumptionTicketsLock.Lock()
+	defer resumptionTicketsLock.Unlock()
+
+	resumptionTickets[crane.ConnectedHub.ID] = &resumptionTicket{
+		Ticket:         response.Ticket,
+		ControllerInit: crane.controllerInit,
+		Expires:        time.Unix(response.Expires, 0),
+	}
+	return nil
+}
+
+// popResumptionTicket returns and removes the resumption ticket for the given
+// Hub, if a valid one exists.
+func popResumptionTicket(hubID string) *resumptionTicket {
+	resumptionTicketsLock.Lock()
+	defer resumptionTicketsLock.Unlock()
+
+	ticket, ok := resumptionTickets[hubID]
+	if !ok {
+		return nil
+	}
+	delete(resumptionTickets, hubID)
+
+	if time.Now().After(ticket.Expires) {
+		return nil
+	}
+	return ticket
+}
+
+// resumeLocal tries to resume the crane with a resumption ticket.
+func (crane *Crane) resumeLocal(callerCtx context.Context) (resumed bool, tErr *terminal.Error) {
+	// Check if we have everything needed to resume.
+	if crane.ship.IsSecure() || crane.ConnectedHub == nil {
+		return false, nil
+	}
+	ticket := popResumptionTicket(crane.ConnectedHub.ID)
+	if ticket == nil {
+		return false, nil
+	}
+	signet := crane.ConnectedHub.SelectSignet()
+	if signet == nil {
+		return false, nil
+	}
+
+	// Restore crane controller options.
+	controllerOpts, tErr := terminal.ParseTerminalOpts(container.New(ticket.ControllerInit))
+	if tErr != nil {
+		return false, tErr.Wrap("failed to parse controller options of ticket")
+	}
+
+	// Configure encryption with the known Hub keys.
+	env := jess.NewUnconfiguredEnvelope()
+	env.SuiteID = cabin.WireSuite(signet)
+	env.Recipients = []*jess.Signet{signet}
+	jession, err := env.WireCorrespondence(nil)
+	if err != nil {
+		return false, terminal.ErrInternalError.With("failed to create encryption session: %w", err)
+	}
+
+	// Send resume message.
+	letter, err := jession.Close(ticket.Ticket)
+	if err != nil {
+		return false, terminal.ErrInternalError.With("failed to encrypt resume msg: %w", err)
+	}
+	resumeMsg, err := letter.ToWire()
+	if err != nil {
+		return false, terminal.ErrInternalError.With("failed to pack resume msg: %w", err)
+	}
+	resumeMsg.PrependNumber(CraneMsgTypeResume)
+	resumeMsg.PrependLength()
+	err = crane.ship.Load(resumeMsg.CompileData())
+	if err != nil {
+		return false, terminal.ErrShipSunk.With("failed to send resume msg: %w", err)
+	}
+
+	// Wait for reply.
+	var reply *container.Container
+	select {
+	case reply = <-crane.unloading:
+	case <-time.After(30 * time.Second):
+		return false, terminal.ErrTimeout.With("waiting for resume reply")
+	case <-crane.ctx.Done():
+		return false, terminal.ErrShipSunk.With("waiting for resume reply")
+	case <-callerCtx.Done():
+		return false, terminal.ErrCanceled.With("waiting for resume reply")
+	}
+	result, err := reply.GetNextN8()
+	if err != nil {
+		return false, terminal.ErrMalformedData.With("failed to parse resume reply: %w", err)
+	}
+	if result != resumptionAccepted {
+		log.Debugf("spn/docks: %s resumption was rejected", crane)
+		return false, nil
+	}
+
+	// Set up crane controller with the options known to the remote crane.
+	crane.jession = jession
+	_, _, tErr = NewLocalCraneControllerTerminal(crane, controllerOpts)
+	if tErr != nil {
+		return false, tErr.Wrap("failed to set up controller")
+	}
+	crane.controllerInit = ticket.ControllerInit
+
+	return true, nil
+}
+
+// handleCraneResume handles a resume request and returns the init message
+// of the crane controller, if the crane may be resumed. If the resumption is
+// rejected, no init message is returned and the init procedure continues.
+func (crane *Crane) handleCraneResume(request *container.Container) (initMsg *container.Container, tErr *terminal.Error) {
+	if crane.identity == nil {
+		return nil, terminal.ErrIncorrectUsage.With("cannot resume incoming crane without designated identity")
+	}
+
+	// Check resume request.
+	result := uint8(resumptionAccepted)
+	initMsg, tErr = crane.checkCraneResume(request)
+	if tErr != nil {
+		log.Debugf("spn/docks: %s rejected resumption: %s", crane, tErr)
+		crane.jession = nil
+		result = resumptionRejected
+	}
+
+	// Send reply.
+	reply := container.New(varint.Pack8(result))
+	reply.PrependLength()
+	err := crane.ship.Load(reply.CompileData())
+	if err != nil {
+		return nil, terminal.ErrShipSunk.With("failed to send resume reply: %w", err)
+	}
+
+	return initMsg, nil
+}
+
+func (crane *Crane) checkCraneResume(request *container.Container) (*container.Container, *terminal.Error) {
+	// Set up encryption.
+	letter, err := jess.LetterFromWire(container.New(request.CompileData()))
+	if err != nil {
+		return nil, terminal.ErrMalformedData.With("failed to unpack resume msg: %w", err)
+	}
+	crane.jession, err = letter.WireCorrespondence(crane.identity)
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to create encryption session: %w", err)
+	}
+	sealedTicket, err := crane.jession.Open(letter)
+	if err != nil {
+		return nil, terminal.ErrIntegrity.With("failed to decrypt resume msg: %w", err)
+	}
+
+	// Open and check ticket.
+	info, tErr := openResumptionTicket(sealedTicket)
+	if tErr != nil {
+		return nil, tErr
+	}
+
+	return container.New(info.ControllerInit), nil
+}
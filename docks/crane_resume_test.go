@@ -0,0 +1,94 @@
+package docks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/spn/cabin"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/ships"
+	"github.com/safing/spn/terminal"
+)
+
+func TestCraneResumption(t *testing.T) { //nolint:paralleltest // Resumption tickets are global.
+	identity, connectedHub := getTestIdentity(t)
+
+	// Start crane and get a resumption ticket.
+	crane1, _ := startTestCranes(t, identity, connectedHub)
+	assert.False(t, crane1.Resumed(), "first crane should not be resumed")
+	if tErr := crane1.getResumptionTicket(module.Ctx); tErr != nil {
+		t.Fatalf("failed to get resumption ticket: %s", tErr)
+	}
+	ticket := *resumptionTickets[connectedHub.ID]
+	crane1.Stop(nil)
+
+	// Resume crane with ticket.
+	crane3, crane4 := startTestCranes(t, identity, connectedHub)
+	assert.True(t, crane3.Resumed(), "local crane should be resumed")
+	assert.True(t, crane4.Resumed(), "remote crane should be resumed")
+	assert.Nil(t, popResumptionTicket(connectedHub.ID), "ticket should be used up")
+	testCraneCounter(t, crane3)
+	crane3.Stop(nil)
+
+	// Replaying the ticket must fall back to the regular init procedure.
+	resumptionTickets[connectedHub.ID] = &ticket
+	crane5, crane6 := startTestCranes(t, identity, connectedHub)
+	assert.False(t, crane5.Resumed(), "local crane should not be resumed with used ticket")
+	assert.False(t, crane6.Resumed(), "remote crane should not be resumed with used ticket")
+	testCraneCounter(t, crane5)
+	crane5.Stop(nil)
+}
+
+func startTestCranes(t *testing.T, identity *cabin.Identity, connectedHub *hub.Hub) (local, remote *Crane) {
+	t.Helper()
+
+	ship := ships.NewTestShip(false, 100)
+	return startTestCranesOn(t, ship, ship.Reverse(), identity, connectedHub)
+}
+
+func startTestCranesOn(
+	t *testing.T,
+	localShip, remoteShip ships.Ship,
+	identity *cabin.Identity, connectedHub *hub.Hub,
+) (local, remote *Crane) {
+	t.Helper()
+
+	local, err := NewCrane(localShip, connectedHub, nil)
+	if err != nil {
+		t.Fatalf("failed to create local crane: %s", err)
+	}
+	remote, err = NewCrane(remoteShip, nil, identity)
+	if err != nil {
+		t.Fatalf("failed to create remote crane: %s", err)
+	}
+
+	remoteErr := make(chan error)
+	go func() {
+		remoteErr <- remote.Start(module.Ctx)
+	}()
+	if err := local.Start(module.Ctx); err != nil {
+		t.Fatalf("failed to start local crane: %s", err)
+	}
+	if err := <-remoteErr; err != nil {
+		t.Fatalf("failed to start remote crane: %s", err)
+	}
+
+	return local, remote
+}
+
+func testCraneCounter(t *testing.T, crane *Crane) {
+	t.Helper()
+
+	op, tErr := terminal.NewCounterOp(crane.Controller, terminal.CounterOpts{
+		ClientCountTo: 100,
+		ServerCountTo: 100,
+	})
+	if tErr != nil {
+		t.Fatalf("failed to run counter op: %s", tErr)
+	}
+	op.Wait()
+	if op.Error != nil {
+		t.Fatalf("counter op failed: %s", op.Error)
+	}
+}
@@ -70,6 +70,9 @@ func initCraneTerminal(
 	}
 	t.SetTerminalExtension(ct)
 
+	// Feed lane estimation with traffic.
+	t.SetFlowEstimator(crane.estimator)
+
 	// Start workers.
 	t.StartWorkers(module, "crane terminal")
 
@@ -79,13 +82,20 @@ func initCraneTerminal(
 // GrantPermission grants the given permissions.
 // Additionally, it will mark the crane as authenticated, if not public.
 func (t *CraneTerminal) GrantPermission(grant terminal.Permission) {
-	// Forward granted permission to base terminal.
-	t.TerminalBase.GrantPermission(grant)
+	t.ApplyGrant(&terminal.Grant{Permission: grant})
+}
 
-	// Mark crane as authenticated if not public or already authenticated.
-	if !t.crane.Public() && !t.crane.Authenticated() {
-		t.crane.authenticated.Set()
+// ApplyGrant applies the given grant.
+// Additionally, it will mark the crane as authenticated, if not public.
+func (t *CraneTerminal) ApplyGrant(grant *terminal.Grant) {
+	// Forward grant to base terminal.
+	t.TerminalBase.ApplyGrant(grant)
+	if grant == nil || grant.Permission == terminal.NoPermission {
+		return
+	}
 
+	// Mark crane as authenticated if not public or already authenticated.
+	if !t.crane.Public() && t.crane.authenticated.SetToIf(false, true) {
 		// Submit metrics.
 		newAuthenticatedCranes.Inc()
 	}
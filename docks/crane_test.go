@@ -20,13 +20,14 @@ import (
 func TestCraneCommunication(t *testing.T) {
 	t.Parallel()
 
-	testCraneWithCounter(t, "plain-counter-load-100", false, 100, 1000)
-	testCraneWithCounter(t, "plain-counter-load-1000", false, 1000, 1000)
-	testCraneWithCounter(t, "plain-counter-load-10000", false, 10000, 1000)
-	testCraneWithCounter(t, "encrypted-counter", true, 1000, 1000)
+	testCraneWithCounter(t, "plain-counter-load-100", false, 100, 1000, 0)
+	testCraneWithCounter(t, "plain-counter-load-1000", false, 1000, 1000, 0)
+	testCraneWithCounter(t, "plain-counter-load-10000", false, 10000, 1000, 0)
+	testCraneWithCounter(t, "encrypted-counter", true, 1000, 1000, 0)
+	testCraneWithCounter(t, "cover-traffic-counter", true, 1000, 1000, 1_000_000)
 }
 
-func testCraneWithCounter(t *testing.T, testID string, encrypting bool, loadSize int, countTo uint64) { //nolint:unparam,thelper
+func testCraneWithCounter(t *testing.T, testID string, encrypting bool, loadSize int, countTo uint64, coverTrafficRate uint32) { //nolint:unparam,thelper
 	var identity *cabin.Identity
 	var connectedHub *hub.Hub
 	if encrypting {
@@ -47,6 +48,7 @@ func testCraneWithCounter(t *testing.T, testID string, encrypting bool, loadSize
 		if err != nil {
 			panic(fmt.Sprintf("crane test %s could not create crane1: %s", testID, err))
 		}
+		crane1.SetCoverTrafficRate(coverTrafficRate)
 		err = crane1.Start(module.Ctx)
 		if err != nil {
 			panic(fmt.Sprintf("crane test %s could not start crane1: %s", testID, err))
@@ -59,6 +61,7 @@ func testCraneWithCounter(t *testing.T, testID string, encrypting bool, loadSize
 		if err != nil {
 			panic(fmt.Sprintf("crane test %s could not create crane2: %s", testID, err))
 		}
+		crane2.SetCoverTrafficRate(coverTrafficRate / 2)
 		err = crane2.Start(module.Ctx)
 		if err != nil {
 			panic(fmt.Sprintf("crane test %s could not start crane2: %s", testID, err))
@@ -69,6 +72,14 @@ func testCraneWithCounter(t *testing.T, testID string, encrypting bool, loadSize
 	craneWg.Wait()
 	t.Logf("crane test %s setup complete", testID)
 
+	// Check negotiated cover traffic rates.
+	assert.Equal(t, coverTrafficRate/2, crane2.opts.CoverTrafficRate, "crane2 should cap the requested cover traffic rate")
+	coverTraffic, tErr := coverTrafficMethod.Call(module.Ctx, crane1.Controller, &coverTrafficRequest{})
+	if tErr != nil {
+		t.Fatalf("crane test %s failed to get agreed cover traffic rate: %s", testID, tErr)
+	}
+	assert.Equal(t, coverTrafficRate/2, coverTraffic.Rate, "crane2 should report the agreed cover traffic rate to crane1")
+
 	// Wait async for test to complete, print stack after timeout.
 	finished := make(chan struct{})
 	go func() {
@@ -28,13 +28,22 @@ const (
 // Hub.
 func MeasureHub(ctx context.Context, h *hub.Hub, checkExpiryWith time.Duration) *terminal.Error {
 	// Check if we are measuring before building a connection.
-	if capacityTestRunning.IsSet() {
-		return terminal.ErrTryAgainLater.With("another capacity op is already running")
+	if capacityTestsAtLimit() {
+		return terminal.ErrTryAgainLater.With("too many capacity ops are already running")
 	}
 
 	// Check if we have a connection to this Hub.
+	var passiveLatency, passiveCapacity bool
 	crane := GetAssignedCrane(h.ID)
-	if crane == nil {
+	if crane != nil {
+		// Use passive measurements from the traffic on the lane, if available.
+		// Active tests are only needed when the lane is idle.
+		crane.updatePassiveMeasurements()
+		passiveLatency, passiveCapacity = crane.hasPassiveMeasurements()
+		if passiveCapacity {
+			_, passiveCapacity, _ = crane.estimator.Throughput()
+		}
+	} else {
 		// Connect to Hub.
 		var err error
 		crane, err = establishCraneForMeasuring(ctx, h)
@@ -47,7 +56,8 @@ func MeasureHub(ctx context.Context, h *hub.Hub, checkExpiryWith time.Duration)
 
 	// Run latency test.
 	_, expires := h.GetMeasurements().GetLatency()
-	if checkExpiryWith == 0 || time.Now().Add(-checkExpiryWith).After(expires) {
+	if !passiveLatency &&
+		(checkExpiryWith == 0 || time.Now().Add(-checkExpiryWith).After(expires)) {
 		latOp, tErr := NewLatencyTestOp(crane.Controller)
 		if !tErr.IsOK() {
 			return tErr
@@ -67,8 +77,17 @@ func MeasureHub(ctx context.Context, h *hub.Hub, checkExpiryWith time.Duration)
 
 	// Run capacity test.
 	_, expires = h.GetMeasurements().GetCapacity()
-	if checkExpiryWith == 0 || time.Now().Add(-checkExpiryWith).After(expires) {
-		capOp, tErr := NewCapacityTestOp(crane.Controller, nil)
+	if !passiveCapacity &&
+		(checkExpiryWith == 0 || time.Now().Add(-checkExpiryWith).After(expires)) {
+		var opts *CapacityTestOptions
+		if h.Status != nil && h.Status.HasFlag(hub.FlagDirectionalCapacity) {
+			opts = &CapacityTestOptions{
+				TestVolume:  defaultCapacityTestVolume,
+				MaxTime:     defaultCapacityTestMaxTime,
+				Directional: true,
+			}
+		}
+		capOp, tErr := NewCapacityTestOp(crane.Controller, opts)
 		if !tErr.IsOK() {
 			return tErr
 		}
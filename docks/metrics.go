@@ -19,6 +19,7 @@ var (
 	trafficBytesPublicCranes        *metrics.Counter
 	trafficBytesAuthenticatedCranes *metrics.Counter
 	trafficBytesPrivateCranes       *metrics.Counter
+	coverTrafficBytes               *metrics.Counter
 
 	newExpandOp                  *metrics.Counter
 	expandOpDurationHistogram    *metrics.Histogram
@@ -177,6 +178,18 @@ func registerMetrics() (err error) {
 		return err
 	}
 
+	coverTrafficBytes, err = metrics.NewCounter(
+		"spn/cranes/cover/bytes",
+		nil,
+		&metrics.Options{
+			Name:       "SPN Crane Cover Traffic Padding",
+			Permission: api.PermitUser,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
 	// Lane Stats.
 
 	_, err = metrics.NewGauge(
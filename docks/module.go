@@ -26,6 +26,9 @@ func init() {
 }
 
 func start() error {
+	module.NewTask("update passive lane measurements", updatePassiveMeasurements).
+		Repeat(passiveMeasurementInterval)
+
 	return registerMetrics()
 }
 
@@ -103,6 +106,14 @@ func getAllCranes() map[string]*Crane {
 	return copiedCranes
 }
 
+// GetActiveTerminalCount returns the amount of terminals on all cranes.
+func GetActiveTerminalCount() (count int) {
+	for _, crane := range getAllCranes() {
+		count += crane.terminalCount()
+	}
+	return count
+}
+
 // GetAllAssignedCranes returns a copy of the map of all assigned cranes.
 func GetAllAssignedCranes() map[string]*Crane {
 	copiedCranes := make(map[string]*Crane, len(assignedCranes))
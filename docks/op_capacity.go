@@ -3,6 +3,7 @@ package docks
 import (
 	"bytes"
 	"context"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -27,13 +28,18 @@ const (
 
 	capacityTestMsgSize     = 1000
 	capacityTestSendTimeout = 1000 * time.Millisecond
+
+	// maxConcurrentCapacityTests defines how many capacity tests may run at
+	// the same time. Only one test may run per terminal.
+	maxConcurrentCapacityTests = 3
 )
 
 var (
 	capacityTestSendData           = make([]byte, capacityTestMsgSize)
 	capacityTestDataReceivedSignal = []byte("ACK")
 
-	capacityTestRunning = abool.New()
+	capacityTestsRunning     = make(map[terminal.Terminal]struct{})
+	capacityTestsRunningLock sync.Mutex
 )
 
 // CapacityTestOp is used for capacity test operations.
@@ -55,13 +61,24 @@ type CapacityTestOp struct { //nolint:maligned
 
 	testResult int
 	result     chan *terminal.Error
+
+	// client holds whether this side started the test.
+	client bool
+	// reservedOn holds the terminal the test is reserved on.
+	reservedOn terminal.Terminal
+	// upload and download hold the results of a directional test.
+	upload   *CapacityTestReport
+	download *CapacityTestReport
 }
 
 // CapacityTestOptions holds options for the capacity test.
 type CapacityTestOptions struct {
 	TestVolume int
 	MaxTime    time.Duration
-	testing    bool
+	// Directional specifies whether to measure upload and download separately.
+	// Must only be used if the other side has the FlagDirectionalCapacity.
+	Directional bool
+	testing     bool
 }
 
 // Type returns the type ID.
@@ -69,6 +86,12 @@ func (op *CapacityTestOp) Type() string {
 	return CapacityTestOpType
 }
 
+// TrafficClass returns the traffic class used for scheduling the operation's
+// messages.
+func (op *CapacityTestOp) TrafficClass() terminal.TrafficClass {
+	return terminal.TrafficClassBulk
+}
+
 func init() {
 	terminal.RegisterOpType(terminal.OperationFactory{
 		Type:     CapacityTestOpType,
@@ -88,8 +111,8 @@ func NewCapacityTestOp(t terminal.Terminal, opts *CapacityTestOptions) (*Capacit
 	}
 
 	// Check if another test is already running.
-	if !opts.testing && !capacityTestRunning.SetToIf(false, true) {
-		return nil, terminal.ErrTryAgainLater.With("another capacity op is already running")
+	if !opts.testing && !reserveCapacityTest(t) {
+		return nil, terminal.ErrTryAgainLater.With("too many capacity ops are already running")
 	}
 
 	// Create and init.
@@ -99,49 +122,62 @@ func NewCapacityTestOp(t terminal.Terminal, opts *CapacityTestOptions) (*Capacit
 		dataSent:        new(int64),
 		dataSentWasAckd: abool.New(),
 		result:          make(chan *terminal.Error, 1),
+		client:          true,
+	}
+	if !opts.testing {
+		op.reservedOn = t
+	}
+	if opts.Directional {
+		op.recvQueue = make(chan *terminal.Msg, 1000)
 	}
 
 	// Make capacity test request.
 	request, err := dsd.Dump(op.opts, dsd.CBOR)
 	if err != nil {
-		capacityTestRunning.UnSet()
+		releaseCapacityTest(t)
 		return nil, terminal.ErrInternalError.With("failed to serialize capactity test options: %w", err)
 	}
 
 	// Send test request.
 	tErr := t.StartOperation(op, container.New(request), 1*time.Second)
 	if tErr != nil {
-		capacityTestRunning.UnSet()
+		releaseCapacityTest(t)
 		return nil, tErr
 	}
 
 	// Start handler.
-	module.StartWorker("op capacity handler", op.handler)
+	if opts.Directional {
+		// The client measures the upload first.
+		module.StartWorker("op capacity handler", op.directionalHandler)
+		module.StartWorker("op capacity sender", op.directionalSender)
+	} else {
+		module.StartWorker("op capacity handler", op.handler)
+	}
 
 	return op, nil
 }
 
 func startCapacityTestOp(t terminal.Terminal, opID uint32, data *container.Container) (terminal.Operation, *terminal.Error) {
 	// Check if another test is already running.
-	if !capacityTestRunning.SetToIf(false, true) {
-		return nil, terminal.ErrTryAgainLater.With("another capacity op is already running")
+	if !reserveCapacityTest(t) {
+		return nil, terminal.ErrTryAgainLater.With("too many capacity ops are already running")
 	}
 
 	// Parse options.
 	opts := &CapacityTestOptions{}
 	_, err := dsd.Load(data.CompileData(), opts)
 	if err != nil {
-		capacityTestRunning.UnSet()
+		releaseCapacityTest(t)
 		return nil, terminal.ErrMalformedData.With("failed to parse options: %w", err)
 	}
 
 	// Check options.
 	if opts.TestVolume > maxCapacityTestVolume {
-		capacityTestRunning.UnSet()
+		releaseCapacityTest(t)
 		return nil, terminal.ErrInvalidOptions.With("maximum volume exceeded")
 	}
 	if opts.MaxTime > maxCapacityTestMaxTime {
-		capacityTestRunning.UnSet()
+		releaseCapacityTest(t)
 		return nil, terminal.ErrInvalidOptions.With("maximum maxtime exceeded")
 	}
 
@@ -152,19 +188,55 @@ func startCapacityTestOp(t terminal.Terminal, opID uint32, data *container.Conta
 		dataSent:        new(int64),
 		dataSentWasAckd: abool.New(),
 		result:          make(chan *terminal.Error, 1),
+		reservedOn:      t,
 	}
 	op.InitOperationBase(t, opID)
 
 	// Start handler and sender.
-	op.senderStarted = true
-	module.StartWorker("op capacity handler", op.handler)
-	module.StartWorker("op capacity sender", op.sender)
+	if opts.Directional {
+		// The sender is started when the upload of the client was measured.
+		module.StartWorker("op capacity handler", op.directionalHandler)
+	} else {
+		op.senderStarted = true
+		module.StartWorker("op capacity handler", op.handler)
+		module.StartWorker("op capacity sender", op.sender)
+	}
 
 	return op, nil
 }
 
+func reserveCapacityTest(t terminal.Terminal) bool {
+	capacityTestsRunningLock.Lock()
+	defer capacityTestsRunningLock.Unlock()
+
+	if _, running := capacityTestsRunning[t]; running ||
+		len(capacityTestsRunning) >= maxConcurrentCapacityTests {
+		return false
+	}
+	capacityTestsRunning[t] = struct{}{}
+	return true
+}
+
+func releaseCapacityTest(t terminal.Terminal) {
+	if t == nil {
+		return
+	}
+
+	capacityTestsRunningLock.Lock()
+	defer capacityTestsRunningLock.Unlock()
+
+	delete(capacityTestsRunning, t)
+}
+
+func capacityTestsAtLimit() bool {
+	capacityTestsRunningLock.Lock()
+	defer capacityTestsRunningLock.Unlock()
+
+	return len(capacityTestsRunning) >= maxConcurrentCapacityTests
+}
+
 func (op *CapacityTestOp) handler(ctx context.Context) error {
-	defer capacityTestRunning.UnSet()
+	defer releaseCapacityTest(op.reservedOn)
 
 	returnErr := terminal.ErrStopping
 	defer func() {
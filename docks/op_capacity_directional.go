@@ -0,0 +1,336 @@
+package docks
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/formats/varint"
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/ships"
+	"github.com/safing/spn/terminal"
+)
+
+/*
+
+Directional Capacity Test:
+
+The directional capacity test measures the upload and download of a lane
+separately, as links may be highly asymmetric. The test runs in two phases:
+
+1. The client sends test data to the server, until the test volume was sent or
+   the max test time was reached, and then signals that it is done. The server
+   measures the received data and reports the results back to the client.
+2. The server sends test data to the client in the same way. The client
+   measures the received data, reports the results back to the server and
+   ends the operation.
+
+Every data message holds the time since the sender started sending, which is
+used to calculate the jitter.
+
+Packet loss cannot be seen on the terminal stream, as all available ships
+retransmit lost packets themselves. Instead, the sender reads the sent and
+retransmitted segments of its ship before and after sending and includes them
+in the done message. Ships that do not provide segment stats send zeros, in
+which case no loss is reported.
+
+Done message format:
+
+- Msg Type [varint]
+- Sent Segments [varint]
+- Retransmitted Segments [varint]
+
+*/
+
+const (
+	capacityMsgData   = 1
+	capacityMsgDone   = 2
+	capacityMsgReport = 3
+)
+
+// CapacityTestReport holds the results of one direction of a capacity test.
+type CapacityTestReport struct {
+	// BitRate is the measured capacity in bit/s.
+	BitRate int
+	// Jitter is the measured variation of the latency.
+	Jitter time.Duration
+	// Loss is the share of retransmitted segments of the sending ship.
+	Loss float32
+	// LossMeasured signifies whether the sending ship provided segment stats.
+	LossMeasured bool
+}
+
+// capacityTestReceiver measures the received data of a capacity test.
+type capacityTestReceiver struct {
+	firstAt    time.Time
+	lastAt     time.Time
+	bytes      int
+	msgs       uint64
+	hasTransit bool
+	transit    time.Duration
+	jitter     float64
+	segments   ships.SegmentStats
+}
+
+func (r *capacityTestReceiver) add(sentAt time.Duration, size int) {
+	now := time.Now()
+	if r.msgs == 0 {
+		r.firstAt = now
+	} else {
+		// Do not count the first message, as the time it took is unknown.
+		r.bytes += size
+	}
+	r.lastAt = now
+	r.msgs++
+
+	// Calculate jitter as specified in RFC 3550.
+	// The clock offset between both sides does not matter, as only the
+	// difference between the transit times is used.
+	transit := now.Sub(r.firstAt) - sentAt
+	if r.hasTransit {
+		d := math.Abs(float64(transit - r.transit))
+		r.jitter += (d - r.jitter) / 16
+	}
+	r.transit = transit
+	r.hasTransit = true
+}
+
+func (r *capacityTestReceiver) report() *CapacityTestReport {
+	report := &CapacityTestReport{
+		Jitter: time.Duration(r.jitter),
+	}
+
+	if r.segments.Sent > 0 {
+		report.Loss = r.segments.Loss()
+		report.LossMeasured = true
+	}
+
+	if timeNeeded := r.lastAt.Sub(r.firstAt); timeNeeded > 0 {
+		report.BitRate = int(float64(r.bytes*8) / timeNeeded.Seconds())
+	}
+
+	return report
+}
+
+func (op *CapacityTestOp) directionalHandler(ctx context.Context) error {
+	defer releaseCapacityTest(op.reservedOn)
+
+	returnErr := terminal.ErrStopping
+	defer func() {
+		// Linters don't get that returnErr is used when directly used as defer.
+		op.Stop(op, returnErr)
+	}()
+
+	opTimeout := time.After(2*op.opts.MaxTime + capacityTestTimeout)
+	receiver := &capacityTestReceiver{}
+
+	// Setup unit handling
+	var msg *terminal.Msg
+	defer msg.Finish()
+
+	// Handle receives.
+	for {
+		msg.Finish()
+
+		select {
+		case <-ctx.Done():
+			returnErr = terminal.ErrCanceled
+			return nil
+
+		case <-opTimeout:
+			returnErr = terminal.ErrTimeout
+			return nil
+
+		case msg = <-op.recvQueue:
+		}
+
+		size := msg.Data.Length()
+		msgType, err := msg.Data.GetNextN8()
+		if err != nil {
+			returnErr = terminal.ErrMalformedData.With("failed to parse capacity msg type: %w", err)
+			return nil
+		}
+
+		switch msgType {
+		case capacityMsgData:
+			sentAt, err := msg.Data.GetNextN64()
+			if err != nil {
+				returnErr = terminal.ErrMalformedData.With("failed to parse capacity test data: %w", err)
+				return nil
+			}
+			receiver.add(time.Duration(sentAt), size)
+
+		case capacityMsgDone:
+			// Get segment stats of the sender.
+			receiver.segments.Sent, err = msg.Data.GetNextN64()
+			if err == nil {
+				receiver.segments.Retransmitted, err = msg.Data.GetNextN64()
+			}
+			if err != nil {
+				returnErr = terminal.ErrMalformedData.With("failed to parse capacity test segment stats: %w", err)
+				return nil
+			}
+
+			// Report measured download to the other side.
+			op.download = receiver.report()
+			if tErr := op.sendReport(op.download); tErr != nil {
+				returnErr = tErr
+				return nil
+			}
+
+			// Start measuring the upload, if not yet done.
+			if !op.senderStarted {
+				op.senderStarted = true
+				module.StartWorker("op capacity sender", op.directionalSender)
+			}
+
+		case capacityMsgReport:
+			report := &CapacityTestReport{}
+			_, err := dsd.Load(msg.Data.CompileData(), report)
+			if err != nil {
+				returnErr = terminal.ErrMalformedData.With("failed to parse capacity test report: %w", err)
+				return nil
+			}
+			op.upload = report
+
+		default:
+			returnErr = terminal.ErrMalformedData.With("unknown capacity msg type %d", msgType)
+			return nil
+		}
+
+		// Check if we can complete the test.
+		if op.upload != nil && op.download != nil {
+			returnErr = op.reportDirectionalCapacity()
+			return nil
+		}
+	}
+}
+
+func (op *CapacityTestOp) directionalSender(ctx context.Context) error {
+	var (
+		started  = time.Now()
+		sentData int
+	)
+	startSegments, segmentsOk := op.getSegmentStats()
+
+	for sentData < op.opts.TestVolume && time.Since(started) < op.opts.MaxTime {
+		// Check if op has ended.
+		if op.Stopped() || ctx.Err() != nil {
+			return nil
+		}
+
+		// Send next chunk.
+		data := varint.Pack8(capacityMsgData)
+		data = append(data, varint.Pack64(uint64(time.Since(started)))...)
+		data = append(data, capacityTestSendData[:capacityTestMsgSize-len(data)]...)
+		msg := op.NewMsg(data)
+		msg.Unit.MakeHighPriority()
+		tErr := op.Send(msg, capacityTestSendTimeout)
+		if tErr != nil {
+			op.Stop(op, tErr.Wrap("failed to send capacity test data"))
+			return nil
+		}
+
+		sentData += len(data)
+	}
+
+	// Get the segments sent during the test.
+	var segments ships.SegmentStats
+	if segmentsOk {
+		endSegments, ok := op.getSegmentStats()
+		if ok {
+			segments = endSegments.Since(startSegments)
+		}
+	}
+
+	// Signal that we are done sending.
+	data := varint.Pack8(capacityMsgDone)
+	data = append(data, varint.Pack64(segments.Sent)...)
+	data = append(data, varint.Pack64(segments.Retransmitted)...)
+	tErr := op.Send(op.NewMsg(data), capacityTestSendTimeout)
+	if tErr != nil {
+		op.Stop(op, tErr.Wrap("failed to send capacity test done signal"))
+	}
+
+	return nil
+}
+
+// getSegmentStats returns the segment stats of the ship of the crane the
+// operation runs on, if available.
+func (op *CapacityTestOp) getSegmentStats() (ships.SegmentStats, bool) {
+	controller, ok := op.Terminal().(*CraneControllerTerminal)
+	if !ok || controller.Crane.ship == nil {
+		return ships.SegmentStats{}, false
+	}
+	return ships.GetSegmentStats(controller.Crane.ship)
+}
+
+func (op *CapacityTestOp) sendReport(report *CapacityTestReport) *terminal.Error {
+	reportData, err := dsd.Dump(report, dsd.CBOR)
+	if err != nil {
+		return terminal.ErrInternalError.With("failed to serialize capacity test report: %w", err)
+	}
+
+	data := varint.Pack8(capacityMsgReport)
+	data = append(data, reportData...)
+	tErr := op.Send(op.NewMsg(data), capacityTestSendTimeout)
+	if tErr != nil {
+		return tErr.Wrap("failed to send capacity test report")
+	}
+
+	// Flush, as the test may end right after the report.
+	op.Flush(10 * time.Second)
+	return nil
+}
+
+func (op *CapacityTestOp) reportDirectionalCapacity() *terminal.Error {
+	// Use the worse direction for the combined results.
+	op.testResult = op.upload.BitRate
+	if op.download.BitRate < op.testResult {
+		op.testResult = op.download.BitRate
+	}
+	jitter := op.upload.Jitter
+	if op.download.Jitter > jitter {
+		jitter = op.download.Jitter
+	}
+	var (
+		loss         float32
+		lossMeasured bool
+	)
+	for _, report := range []*CapacityTestReport{op.upload, op.download} {
+		if report.LossMeasured {
+			lossMeasured = true
+			if report.Loss > loss {
+				loss = report.Loss
+			}
+		}
+	}
+
+	// Save the result to the crane.
+	if controller, ok := op.Terminal().(*CraneControllerTerminal); ok {
+		if controller.Crane.ConnectedHub != nil {
+			measurements := controller.Crane.ConnectedHub.GetMeasurements()
+			measurements.SetDirectionalCapacity(op.upload.BitRate, op.download.BitRate)
+			measurements.SetJitter(jitter)
+			if lossMeasured {
+				measurements.SetLoss(loss)
+			}
+			log.Infof(
+				"docks: measured capacity to %s: %.2f Mbit/s up / %.2f Mbit/s down with %s jitter and %.2f%% loss",
+				controller.Crane.ConnectedHub,
+				float64(op.upload.BitRate)/1000000,
+				float64(op.download.BitRate)/1000000,
+				jitter,
+				loss*100,
+			)
+			return nil
+		} else if controller.Crane.IsMine() {
+			return terminal.ErrInternalError.With("capacity operation was run on %s without a connected hub set", controller.Crane)
+		}
+	} else if !runningTests {
+		return terminal.ErrInternalError.With("capacity operation was run on terminal that is not a crane controller, but %T", op.Terminal())
+	}
+
+	return nil
+}
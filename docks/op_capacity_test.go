@@ -33,6 +33,22 @@ func TestCapacityOp(t *testing.T) { //nolint:paralleltest // Performance test.
 		MaxTime:    testCapacitytestMaxTime,
 		testing:    true,
 	})
+
+	// Directional.
+	testCapacityOp(t, &CapacityTestOptions{
+		TestVolume:  testCapacityTestVolume,
+		MaxTime:     testCapacitytestMaxTime,
+		Directional: true,
+		testing:     true,
+	})
+
+	// Directional, hit max time first.
+	testCapacityOp(t, &CapacityTestOptions{
+		TestVolume:  testCapacityTestVolume,
+		MaxTime:     100 * time.Millisecond,
+		Directional: true,
+		testing:     true,
+	})
 }
 
 func testCapacityOp(t *testing.T, opts *CapacityTestOptions) {
@@ -69,6 +85,17 @@ func testCapacityOp(t *testing.T, opts *CapacityTestOptions) {
 		t.Fatalf("op failed: %s", tErr)
 	}
 	t.Logf("measured capacity: %d bit/s", op.testResult)
+	if opts.Directional {
+		if op.upload == nil || op.download == nil {
+			t.Fatal("directional test should measure upload and download")
+		}
+		t.Logf("measured upload: %+v", op.upload)
+		t.Logf("measured download: %+v", op.download)
+		// Test terminals have no ship that could provide segment stats.
+		if op.upload.LossMeasured || op.download.LossMeasured {
+			t.Fatal("loss should not be measured without a ship")
+		}
+	}
 
 	// Calculate expected bandwidth.
 	expectedBitsPerSecond := float64(capacityTestMsgSize*8*int64(capTestQueueSize)) / float64(capTestDelay) * float64(time.Second)
@@ -124,6 +124,9 @@ func expand(t terminal.Terminal, opID uint32, data *container.Container) (termin
 	if !conf.PublicHub() {
 		return nil, terminal.ErrPermissionDenied.With("expanding is only allowed on public hubs")
 	}
+	if conf.Draining() {
+		return nil, terminal.ErrHubDraining.With("not accepting new expansions")
+	}
 
 	// Parse destination hub ID.
 	dstData, err := data.GetNextBlock()
@@ -173,6 +176,17 @@ func expand(t terminal.Terminal, opID uint32, data *container.Container) (termin
 		op.relayTerminal.deliverProxy = op.relayTerminal.flowControl.Deliver
 		op.relayTerminal.recvProxy = op.relayTerminal.flowControl.Receive
 		op.relayTerminal.sendProxy = op.submitForwardFlowControl
+	case terminal.FlowControlCongestion:
+		// Operation
+		op.flowControl = terminal.NewCongestionFlowQueue(op.ctx, opts.FlowControlSize, op.submitBackwardUpstream)
+		op.deliverProxy = op.flowControl.Deliver
+		op.recvProxy = op.flowControl.Receive
+		op.sendProxy = op.submitBackwardFlowControl
+		// Relay Terminal
+		op.relayTerminal.flowControl = terminal.NewCongestionFlowQueue(op.ctx, opts.FlowControlSize, op.submitForwardUpstream)
+		op.relayTerminal.deliverProxy = op.relayTerminal.flowControl.Deliver
+		op.relayTerminal.recvProxy = op.relayTerminal.flowControl.Receive
+		op.relayTerminal.sendProxy = op.submitForwardFlowControl
 	case terminal.FlowControlNone:
 		// Operation
 		deliverToOp := make(chan *terminal.Msg, opts.FlowControlSize)
@@ -52,6 +52,12 @@ func (op *LatencyTestOp) Type() string {
 	return LatencyTestOpType
 }
 
+// TrafficClass returns the traffic class used for scheduling the operation's
+// messages.
+func (op *LatencyTestOp) TrafficClass() terminal.TrafficClass {
+	return terminal.TrafficClassControl
+}
+
 func init() {
 	terminal.RegisterOpType(terminal.OperationFactory{
 		Type:     LatencyTestOpType,
@@ -29,6 +29,12 @@ func (op *SyncStateOp) Type() string {
 	return SyncStateOpType
 }
 
+// TrafficClass returns the traffic class used for scheduling the operation's
+// messages.
+func (op *SyncStateOp) TrafficClass() terminal.TrafficClass {
+	return terminal.TrafficClassControl
+}
+
 func init() {
 	terminal.RegisterOpType(terminal.OperationFactory{
 		Type:     SyncStateOpType,
@@ -18,6 +18,9 @@ type ExpansionTerminal struct {
 
 	relayOp *ExpansionTerminalRelayOp
 
+	// remoteHub is the Hub the terminal is connected to.
+	remoteHub *hub.Hub
+
 	changeNotifyFuncReady *abool.AtomicBool
 	changeNotifyFunc      func()
 
@@ -44,7 +47,12 @@ func ExpandTo(from terminal.Terminal, routeTo string, encryptFor *hub.Hub) (*Exp
 	// Create options and bare expansion terminal.
 	opts := terminal.DefaultExpansionTerminalOpts()
 	opts.Encrypt = encryptFor != nil
+	opts.RequestCompression(encryptFor)
+	opts.RequestRekeying(encryptFor)
+	opts.RequestHeartbeat(encryptFor)
+	opts.RequestCongestionControl(encryptFor, connectedHub(from))
 	expansion := &ExpansionTerminal{
+		remoteHub:             encryptFor,
 		changeNotifyFuncReady: abool.New(),
 	}
 	expansion.relayOp = &ExpansionTerminalRelayOp{
@@ -86,6 +94,18 @@ func ExpandTo(from terminal.Terminal, routeTo string, encryptFor *hub.Hub) (*Exp
 	return expansion, nil
 }
 
+// connectedHub returns the Hub the given terminal is connected to, if known.
+func connectedHub(t terminal.Terminal) *hub.Hub {
+	switch v := t.(type) {
+	case *CraneTerminal:
+		return v.crane.ConnectedHub
+	case *ExpansionTerminal:
+		return v.remoteHub
+	default:
+		return nil
+	}
+}
+
 // SetChangeNotifyFunc sets a callback function that is called when the terminal state changes.
 func (t *ExpansionTerminal) SetChangeNotifyFunc(f func()) {
 	if t.changeNotifyFuncReady.IsSet() {
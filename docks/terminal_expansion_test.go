@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/safing/spn/access"
+	"github.com/safing/spn/access/token"
 	"github.com/safing/spn/cabin"
 	"github.com/safing/spn/hub"
 	"github.com/safing/spn/ships"
@@ -111,7 +112,10 @@ func testExpansion( //nolint:maintidx,thelper
 	}()
 	go func() {
 		var err error
-		crane2to1, err = NewCrane(ship1to2.Reverse(), nil, identity2)
+		// Connect to crane2 like a client in order to test authentication.
+		ship2to1 := ship1to2.Reverse()
+		ship2to1.MarkPrivate()
+		crane2to1, err = NewCrane(ship2to1, nil, identity2)
 		if err != nil {
 			panic(fmt.Sprintf("expansion test %s could not create crane2to1: %s", testID, err))
 		}
@@ -120,7 +124,6 @@ func testExpansion( //nolint:maintidx,thelper
 		if err != nil {
 			panic(fmt.Sprintf("expansion test %s could not start crane2to1: %s", testID, err))
 		}
-		crane2to1.ship.MarkPublic()
 		craneWg.Done()
 	}()
 	go func() {
@@ -236,6 +239,9 @@ func testExpansion( //nolint:maintidx,thelper
 	if tErr.IsError() {
 		t.Fatalf("expansion test %s failed to auth with home terminal: %s", testID, tErr)
 	}
+	if !crane2to1.Authenticated() {
+		t.Fatalf("expansion test %s: crane2to1 was not marked as authenticated", testID)
+	}
 	expansionTerminalTo3, err := ExpandTo(homeTerminal, crane3HubID, connectedHub3)
 	if err != nil {
 		t.Fatalf("expansion test %s failed to expand to %s: %s", testID, crane3HubID, tErr)
@@ -303,3 +309,25 @@ func testExpansion( //nolint:maintidx,thelper
 		t.Fatalf("crane test %s counter op2 failed: %s", testID, op2.Error)
 	}
 }
+
+func TestScopedZoneGrant(t *testing.T) {
+	t.Parallel()
+
+	// Get and verify a token of the restricted test zone.
+	webToken, err := token.GetToken("unittest-web")
+	if err != nil {
+		t.Fatalf("failed to get token: %s", err)
+	}
+	grant, err := access.VerifyToken(webToken)
+	if err != nil {
+		t.Fatalf("failed to verify token: %s", err)
+	}
+
+	// Check that the grant is restricted by the zone of the token.
+	if grant.Permission.Has(terminal.MayExpand) {
+		t.Error("restricted grant should not allow expanding")
+	}
+	if !grant.AllowsPort(443) || grant.AllowsPort(22) {
+		t.Errorf("restricted grant should only allow web ports, got %v", grant.Ports)
+	}
+}
@@ -27,6 +27,20 @@ type Measurements struct {
 	// CapacityMeasuredAt holds when the capacity measurement expires.
 	CapacityMeasuredAt time.Time
 
+	// UploadCapacity and DownloadCapacity designate the available bandwidth
+	// to and from the Hub, if measured separately.
+	// They are specified in bit/s.
+	UploadCapacity   int
+	DownloadCapacity int
+
+	// Jitter designates the variation of the latency between these Hubs.
+	// It is specified in nanoseconds.
+	Jitter time.Duration
+
+	// Loss designates the share of lost packets between these Hubs.
+	// It is specified as a value between 0 and 1.
+	Loss float32
+
 	// CalculatedCost stores the calculated cost for direct access.
 	// It is not set automatically, but needs to be set when needed.
 	CalculatedCost float32
@@ -56,6 +70,10 @@ func (m *Measurements) Copy() *Measurements {
 		LatencyMeasuredAt:  m.LatencyMeasuredAt,
 		Capacity:           m.Capacity,
 		CapacityMeasuredAt: m.CapacityMeasuredAt,
+		UploadCapacity:     m.UploadCapacity,
+		DownloadCapacity:   m.DownloadCapacity,
+		Jitter:             m.Jitter,
+		Loss:               m.Loss,
 		CalculatedCost:     m.CalculatedCost,
 	}
 	copied.check()
@@ -158,6 +176,69 @@ func (m *Measurements) GetCapacity() (capacity int, measuredAt time.Time) {
 	return m.Capacity, m.CapacityMeasuredAt
 }
 
+// SetDirectionalCapacity sets the upload and download capacity to the given
+// values. As traffic is relayed in both directions, the capacity is set to the
+// lower of the two.
+// The capacity is measued in bit/s.
+func (m *Measurements) SetDirectionalCapacity(upload, download int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.UploadCapacity = upload
+	m.DownloadCapacity = download
+	m.Capacity = upload
+	if download < upload {
+		m.Capacity = download
+	}
+	m.CapacityMeasuredAt = time.Now()
+	m.persisted.UnSet()
+}
+
+// GetDirectionalCapacity returns the upload and download capacity and when
+// the capacity was measured. Both are zero if they were not measured
+// separately.
+// The capacity is measued in bit/s.
+func (m *Measurements) GetDirectionalCapacity() (upload, download int, measuredAt time.Time) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.UploadCapacity, m.DownloadCapacity, m.CapacityMeasuredAt
+}
+
+// SetJitter sets the jitter to the given value.
+func (m *Measurements) SetJitter(jitter time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.Jitter = jitter
+	m.persisted.UnSet()
+}
+
+// GetJitter returns the jitter.
+func (m *Measurements) GetJitter() time.Duration {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.Jitter
+}
+
+// SetLoss sets the packet loss to the given value.
+func (m *Measurements) SetLoss(loss float32) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.Loss = loss
+	m.persisted.UnSet()
+}
+
+// GetLoss returns the packet loss.
+func (m *Measurements) GetLoss() float32 {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.Loss
+}
+
 // SetCalculatedCost sets the calculated cost to the given value.
 // The calculated cost is not set automatically, but needs to be set when needed.
 func (m *Measurements) SetCalculatedCost(cost float32) {
@@ -9,6 +9,7 @@ import (
 	"github.com/mitchellh/copystructure"
 
 	"github.com/safing/jess"
+	"github.com/safing/jess/tools"
 	"github.com/safing/portbase/utils"
 )
 
@@ -19,6 +20,53 @@ const VersionOffline = "offline"
 const (
 	// FlagNetError signifies whether the Hub reports a network connectivity failure or impairment.
 	FlagNetError = "net-error"
+
+	// FlagDatagrams signifies that the Hub supports the datagram mode of
+	// connect operations.
+	FlagDatagrams = "datagrams"
+
+	// FlagResolve signifies that the Hub resolves domains for clients with
+	// resolve operations.
+	FlagResolve = "resolve"
+
+	// FlagMultipath signifies that the Hub supports connect operations that
+	// are split across multiple routes.
+	FlagMultipath = "multipath"
+
+	// FlagResumable signifies that the Hub supports resuming connect operations
+	// via a new route.
+	FlagResumable = "resumable"
+
+	// FlagCompression signifies that the Hub supports compression of terminal
+	// messages.
+	FlagCompression = "compression"
+
+	// FlagRekeying signifies that the Hub supports rekeying the encryption of
+	// terminals.
+	FlagRekeying = "rekeying"
+
+	// FlagHeartbeat signifies that the Hub answers heartbeats of terminals.
+	FlagHeartbeat = "heartbeat"
+
+	// FlagCraneResumption signifies that the Hub issues resumption tickets for
+	// resuming cranes.
+	FlagCraneResumption = "crane-resumption"
+
+	// FlagCraneBonding signifies that the Hub accepts cranes that are bonded
+	// over multiple ships.
+	FlagCraneBonding = "crane-bonding"
+
+	// FlagDirectionalCapacity signifies that the Hub supports capacity tests
+	// that measure upload and download separately.
+	FlagDirectionalCapacity = "directional-capacity"
+
+	// FlagCongestionControl signifies that the Hub supports the congestion
+	// aware flow control for terminals.
+	FlagCongestionControl = "congestion-control"
+
+	// FlagDraining signifies that the Hub is draining: It does not accept new
+	// connections and will go offline soon.
+	FlagDraining = "draining"
 )
 
 // Status is the message type used to update changing Hub Information. Changes are made automatically.
@@ -32,6 +80,11 @@ type Status struct {
 	Keys  map[string]*Key // public keys (with type)
 	Lanes []*Lane         // Connections to other Hubs.
 
+	// PQKeys holds public keys of post-quantum key schemes. They are kept
+	// separate from Keys, as they exceed the key size older versions accept
+	// in Keys.
+	PQKeys map[string]*Key `json:",omitempty"`
+
 	// Status Information
 	// Load describes max(CPU, Memory) in percent, averaged over at least 15
 	// minutes. Load is published in fixed steps only.
@@ -81,8 +134,21 @@ func (h *Hub) SelectSignet() *jess.Signet {
 		return nil
 	}
 
-	// TODO: select key based on preferred alg?
+	// Prefer post-quantum keys, if their scheme is supported.
 	now := time.Now().Unix()
+	for id, key := range h.Status.PQKeys {
+		if now < key.Expires && schemeSupported(key.Scheme) {
+			return &jess.Signet{
+				ID:     id,
+				Scheme: key.Scheme,
+				Key:    key.Key,
+				Public: true,
+			}
+		}
+	}
+
+	// Fall back to classic keys.
+	// TODO: select key based on preferred alg?
 	for id, key := range h.Status.Keys {
 		if now < key.Expires {
 			return &jess.Signet{
@@ -97,6 +163,12 @@ func (h *Hub) SelectSignet() *jess.Signet {
 	return nil
 }
 
+// schemeSupported returns whether a jess tool for the given scheme is available.
+func schemeSupported(scheme string) bool {
+	_, err := tools.Get(scheme)
+	return err == nil
+}
+
 // GetSignet returns the public key identified by the given ID from the Hub Status.
 func (h *Hub) GetSignet(id string, recipient bool) (*jess.Signet, error) {
 	h.Lock()
@@ -109,7 +181,10 @@ func (h *Hub) GetSignet(id string, recipient bool) (*jess.Signet, error) {
 	// check if ID exists
 	key, ok := h.Status.Keys[id]
 	if !ok {
-		return nil, jess.ErrSignetNotFound
+		key, ok = h.Status.PQKeys[id]
+		if !ok {
+			return nil, jess.ErrSignetNotFound
+		}
 	}
 	// transform and return
 	return &jess.Signet{
@@ -213,6 +288,20 @@ func (s *Status) validateFormatting() (err error) {
 			return err
 		}
 	}
+	if len(s.PQKeys) > 255 {
+		return fmt.Errorf("field PQKeys with array/slice length of %d exceeds max length of %d", len(s.PQKeys), 255)
+	}
+	for keyID, key := range s.PQKeys {
+		if err = checkStringFormat("PQKeys#ID", keyID, 255); err != nil {
+			return err
+		}
+		if err = checkStringFormat("PQKeys.Scheme", key.Scheme, 255); err != nil {
+			return err
+		}
+		if err = checkByteSliceFormat("PQKeys.Key", key.Key, 4096); err != nil {
+			return err
+		}
+	}
 
 	// connections
 	if len(s.Lanes) > 255 {
@@ -32,6 +32,10 @@ type Map struct {
 	analysisLock           sync.Mutex
 	regardedPins           []*Pin
 	lastDesegrationAttempt time.Time
+
+	// changeSignal is closed and replaced when the Map changes.
+	changeSignal     chan struct{}
+	changeSignalLock sync.Mutex
 }
 
 // NewMap returns a new and empty Map.
@@ -40,6 +44,7 @@ func NewMap(name string, enableMeasuring bool) *Map {
 		Name:             name,
 		all:              make(map[string]*Pin),
 		measuringEnabled: enableMeasuring,
+		changeSignal:     make(chan struct{}),
 	}
 	addMapToAPI(m)
 
@@ -60,6 +65,23 @@ func (m *Map) GetPin(hubID string) (pin *Pin, ok bool) {
 	return
 }
 
+// Changed returns a channel that is closed when the Map changes the next time.
+func (m *Map) Changed() <-chan struct{} {
+	m.changeSignalLock.Lock()
+	defer m.changeSignalLock.Unlock()
+
+	return m.changeSignal
+}
+
+// signalChange signals a change of the Map to everyone waiting for changes.
+func (m *Map) signalChange() {
+	m.changeSignalLock.Lock()
+	defer m.changeSignalLock.Unlock()
+
+	close(m.changeSignal)
+	m.changeSignal = make(chan struct{})
+}
+
 // GetHome returns the current home and it's accompanying terminal.
 // Both may be nil.
 func (m *Map) GetHome() (*Pin, *docks.CraneTerminal) {
@@ -98,6 +120,7 @@ func (m *Map) SetHome(id string, t *docks.CraneTerminal) (ok bool) {
 	}
 
 	m.PushPinChanges()
+	m.signalChange()
 	return true
 }
 
@@ -50,6 +50,9 @@ func (m *Map) measureHubs(ctx context.Context, _ *modules.Task) error {
 			continue
 		}
 
+		// Use round trip times measured by heartbeats of the active terminal.
+		pin.updateLatencyFromHeartbeats()
+
 		// Calculate dynamic TTL.
 		var checkWithTTL time.Duration
 		if pin.HopDistance == 2 { // Hub is directly connected.
@@ -117,6 +120,27 @@ func (m *Map) measureHubs(ctx context.Context, _ *modules.Task) error {
 	return nil
 }
 
+// updateLatencyFromHeartbeats lowers the measured latency of the Pin to the
+// round trip time measured by heartbeats of its active terminal. The terminal
+// is routed via other Hubs, so its round trip time is only an upper bound of
+// the latency of a direct connection to the Pin.
+func (pin *Pin) updateLatencyFromHeartbeats() {
+	activeTerminal := pin.GetActiveTerminal()
+	if activeTerminal == nil {
+		return
+	}
+	_, rtt := activeTerminal.HeartbeatRTT()
+	if rtt <= 0 {
+		return
+	}
+
+	latency, _ := pin.measurements.GetLatency()
+	if latency > 0 && rtt < latency {
+		pin.measurements.SetLatency(rtt)
+		log.Debugf("spn/navigator: lowered latency of %s to %s measured by heartbeats", pin.Hub, rtt)
+	}
+}
+
 // SaveMeasuredHubs saves all Hubs that have unsaved measurements.
 func (m *Map) SaveMeasuredHubs() {
 	m.RLock()
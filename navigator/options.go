@@ -37,6 +37,19 @@ type Options struct { //nolint:maligned
 
 	// RoutingProfile defines the algorithm to use to find a route.
 	RoutingProfile string
+
+	// Multipath defines whether connections should be split across two
+	// disjoint routes to the same Destination Hub, if supported.
+	Multipath bool
+
+	// Resumable defines whether connections should be kept when their route
+	// fails, so that they can be resumed via a new route, if supported.
+	Resumable bool
+
+	// ResolveAtExit defines whether the domain of a connection is resolved
+	// again at the Destination Hub, so that the connection goes to an IP that
+	// fits the location of the Destination Hub, if supported.
+	ResolveAtExit bool
 }
 
 // HomeHubOptions holds configuration options for Home Hub operations with the Map.
@@ -80,6 +93,9 @@ type HubOptions struct {
 func (o *Options) Copy() *Options {
 	copied := &Options{
 		RoutingProfile: o.RoutingProfile,
+		Multipath:      o.Multipath,
+		Resumable:      o.Resumable,
+		ResolveAtExit:  o.ResolveAtExit,
 	}
 	if o.Home != nil {
 		c := HomeHubOptions(HubOptions(*o.Home).Copy())
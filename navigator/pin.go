@@ -204,6 +204,17 @@ func (pin *Pin) GetActiveTerminal() *docks.ExpansionTerminal {
 	return pin.Connection.Terminal
 }
 
+// GetActiveRoute returns the route of the active terminal of the pin.
+func (pin *Pin) GetActiveRoute() *Route {
+	pin.Lock()
+	defer pin.Unlock()
+
+	if !pin.hasActiveTerminal() {
+		return nil
+	}
+	return pin.Connection.Route
+}
+
 // HasActiveTerminal returns whether the Pin has an active terminal.
 func (pin *Pin) HasActiveTerminal() bool {
 	pin.Lock()
@@ -178,6 +178,21 @@ func (r *Route) CopyUpTo(n int) *Route {
 	return newRoute
 }
 
+// TransitHas returns whether any Transit Hub of the Route has any of the given
+// states. The first Hub of the Route and the Destination Hub are not checked.
+func (r *Route) TransitHas(states PinState) bool {
+	if len(r.Path) < 3 {
+		return false
+	}
+
+	for _, hop := range r.Path[1 : len(r.Path)-1] {
+		if hop.pin.GetState().HasAnyOf(states) {
+			return true
+		}
+	}
+	return false
+}
+
 // makeExportReady fills in all the missing data fields which are meant for
 // exporting only.
 func (r *Routes) makeExportReady(algorithm string) {
@@ -75,6 +75,11 @@ const (
 	// This does not invalidate the Hub for all operations and not in all cases.
 	StateConnectivityIssues // 0x2000
 
+	// StateDraining signifies that the Hub is draining and will go offline
+	// soon. It does not accept new connections and existing connections should
+	// be moved to other Hubs.
+	StateDraining // 0x4000
+
 	// State Summaries.
 
 	// StateSummaryRegard summarizes all states that must always be set in order to take a Hub into consideration for any task.
@@ -87,7 +92,8 @@ const (
 		StateFailing |
 		StateOffline |
 		StateUsageDiscouraged |
-		StateIsHomeHub
+		StateIsHomeHub |
+		StateDraining
 )
 
 var allStates = []PinState{
@@ -104,6 +110,7 @@ var allStates = []PinState{
 	StateUsageAsDestinationDiscouraged,
 	StateIsHomeHub,
 	StateConnectivityIssues,
+	StateDraining,
 }
 
 // Add returns a new PinState with the given states added.
@@ -411,6 +418,8 @@ func (pinState PinState) Name() string {
 		return "IsHomeHub"
 	case StateConnectivityIssues:
 		return "ConnectivityIssues"
+	case StateDraining:
+		return "Draining"
 	case StateSummaryRegard, StateSummaryDisregard:
 		// Satisfy exhaustive linter.
 		fallthrough
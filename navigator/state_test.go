@@ -28,4 +28,7 @@ func TestStates(t *testing.T) {
 	assert.False(t, p.State.Has(StateSummaryDisregard))
 	assert.True(t, p.State.HasAnyOf(StateSummaryRegard))
 	assert.True(t, p.State.HasAnyOf(StateSummaryDisregard))
+
+	// Draining Hubs must not be used for new routes.
+	assert.True(t, StateSummaryDisregard.Has(StateDraining))
 }
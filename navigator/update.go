@@ -20,6 +20,7 @@ import (
 	"github.com/safing/portmaster/intel/geoip"
 	"github.com/safing/portmaster/netenv"
 	"github.com/safing/portmaster/profile"
+	"github.com/safing/spn/docks"
 	"github.com/safing/spn/hub"
 )
 
@@ -140,6 +141,7 @@ func (m *Map) RemoveHub(id string) {
 	mapDBController.PushUpdate(export)
 	// Push lane changes.
 	m.PushPinChanges()
+	m.signalChange()
 }
 
 // UpdateHub updates a Hub on the Map.
@@ -248,6 +250,11 @@ func (m *Map) updateHub(h *hub.Hub, lockMap, lockHub bool) {
 	} else {
 		pin.removeStates(StateConnectivityIssues)
 	}
+	if pin.Hub.Status.HasFlag(hub.FlagDraining) {
+		pin.addStates(StateDraining)
+	} else {
+		pin.removeStates(StateDraining)
+	}
 
 	// Update Trust and Advisory Statuses.
 	m.updateIntelStatuses(pin, cfgOptionTrustNodeNodes())
@@ -312,6 +319,7 @@ func (m *Map) updateHub(h *hub.Hub, lockMap, lockHub bool) {
 
 	// Push updates.
 	m.PushPinChanges()
+	m.signalChange()
 }
 
 const (
@@ -413,6 +421,30 @@ func (m *Map) ResetFailingStates(ctx context.Context) {
 	m.PushPinChanges()
 }
 
+// ForgetRoutesVia forgets the active terminals of all Pins that are reached via
+// a Transit Hub with any of the given states, so that new connections build
+// new routes. Returns the forgotten terminals.
+func (m *Map) ForgetRoutesVia(states PinState) (forgotten []*docks.ExpansionTerminal) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, pin := range m.all {
+		route := pin.GetActiveRoute()
+		if route == nil || !route.TransitHas(states) {
+			continue
+		}
+		if t := pin.GetActiveTerminal(); t != nil {
+			forgotten = append(forgotten, t)
+		}
+		pin.SetActiveTerminal(nil)
+	}
+
+	if len(forgotten) > 0 {
+		m.PushPinChanges()
+	}
+	return forgotten
+}
+
 func (m *Map) updateFailingStates(ctx context.Context, task *modules.Task) error {
 	m.Lock()
 	defer m.Unlock()
@@ -465,6 +497,7 @@ pinLoop:
 
 	// Update StateActive.
 	m.updateActiveHubs()
+	defer m.signalChange()
 
 	// Update StateReachable.
 	return m.recalculateReachableHubs()
@@ -0,0 +1,938 @@
+package ships
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tevino/abool"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/varint"
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/hub"
+)
+
+/*
+
+Ship Bonding:
+
+A bonded ship loads data over multiple member ships. Every load is sent as a
+sequenced data frame on the member with the lowest expected latency and is put
+back into order on the other side. Loads stay buffered until they are
+acknowledged, so that they can be sent again on the remaining members when a
+member fails.
+
+A bond is started by sending a bond start message on the first ship. Further
+ships join the bond with a join token, which is issued by the remote side of
+the bond and must be transferred over an already secured channel. As the
+joining ship may not be secure, the token itself is never sent. Instead, the
+joining side sends the ID of the token and proves that it knows the token with
+an HMAC over a nonce chosen by the remote side for this ship.
+
+Bond Hello Format:
+
+- Length [varint]
+- MsgType [varint; bondMsgTypeStart or bondMsgTypeJoin]
+- Join Token ID [bytes; not blocked; only with bondMsgTypeJoin]
+
+Bond Join Challenge Format:
+
+- Length [varint]
+- Nonce [bytes; not blocked]
+
+Bond Join Proof Format:
+
+- Length [varint]
+- HMAC-SHA256 of the Nonce with the Join Token [bytes; not blocked]
+
+Bond Frame Format:
+
+- Length [varint]
+- FrameType [varint]
+- Data Frame:
+	- Sequence Number [varint]
+	- Data [bytes; not blocked]
+- Ack Frame:
+	- Next Expected Sequence Number [varint]
+- Ping and Pong Frame:
+	- Timestamp [varint; unix nanoseconds]
+
+*/
+
+// Bond hello message types.
+// These must not collide with the message types used by cranes at the start
+// of a connection.
+const (
+	bondMsgTypeStart = 100
+	bondMsgTypeJoin  = 101
+)
+
+// Bond frame types.
+const (
+	bondFrameData = 1
+	bondFrameAck  = 2
+	bondFramePing = 3
+	bondFramePong = 4
+)
+
+const (
+	bondJoinTokenIDSize = 8
+	bondJoinTokenSize   = bondJoinTokenIDSize + 16
+	bondJoinTokenTTL    = 1 * time.Minute
+	bondJoinNonceSize   = 16
+
+	bondHelloTimeout = 30 * time.Second
+	bondPingInterval = 1 * time.Second
+	bondAckInterval  = 50 * time.Millisecond
+	bondAckThreshold = 32
+	bondMemberTTL    = 5 * time.Second
+
+	bondWindowSize      = 256
+	bondMemberQueueSize = 64
+	bondMaxFrameSize    = 65536
+	bondFrameOverhead   = 16
+	bondInitialRTT      = 100 * time.Millisecond
+)
+
+var (
+	bondJoinTokens     = make(map[string]*bondJoinToken)
+	bondJoinTokensLock sync.Mutex
+)
+
+type bondJoinToken struct {
+	bond    *BondedShip
+	token   []byte
+	expires time.Time
+}
+
+// BondedShip is a ship that loads data over multiple member ships.
+type BondedShip struct {
+	mine   bool
+	secure bool
+	public *abool.AtomicBool
+
+	ctx       context.Context
+	cancelCtx context.CancelFunc
+
+	members     []*bondMember
+	membersLock sync.Mutex
+
+	// Sending.
+	nextSeq      uint64
+	ackedSeq     uint64
+	unacked      map[uint64][]byte
+	sendLock     sync.Mutex
+	windowSignal chan struct{}
+
+	// Receiving.
+	expectedSeq  uint64
+	ackSentSeq   uint64
+	ackMember    *bondMember
+	ackRequested bool
+	pending      map[uint64][]byte
+	recvLock     sync.Mutex
+	deliverLock  sync.Mutex
+	delivering   chan []byte
+	deliveredTmp []byte
+}
+
+type bondMember struct {
+	ship   Ship
+	reader *bufio.Reader
+	queue  chan []byte
+
+	dropped  *abool.AtomicBool
+	dead     chan struct{}
+	rtt      atomic.Int64  // Smoothed round trip time in nanoseconds.
+	lastRecv atomic.Int64  // Unix nanoseconds.
+	sentSeq  atomic.Uint64 // Sequence number after the last data frame sent.
+}
+
+// NewBondedShip starts a new bond with the given ship as the first member.
+// More ships can be added with Join.
+func NewBondedShip(ship Ship) (*BondedShip, error) {
+	if !ship.IsMine() {
+		return nil, errors.New("only ships launched from here can start a bond")
+	}
+
+	// Signal the start of the bond to the other side.
+	hello := container.New(varint.Pack8(bondMsgTypeStart))
+	hello.PrependLength()
+	if err := ship.Load(hello.CompileData()); err != nil {
+		return nil, fmt.Errorf("failed to send bond start: %w", err)
+	}
+
+	bond := newBondedShip(ship)
+	if err := bond.addMember(ship, bufio.NewReader(shipReader{ship})); err != nil {
+		return nil, err
+	}
+	return bond, nil
+}
+
+func newBondedShip(first Ship) *BondedShip {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	bond := &BondedShip{
+		mine:         first.IsMine(),
+		secure:       first.IsSecure(),
+		public:       abool.New(),
+		ctx:          ctx,
+		cancelCtx:    cancelCtx,
+		unacked:      make(map[uint64][]byte),
+		windowSignal: make(chan struct{}, 1),
+		pending:      make(map[uint64][]byte),
+		delivering:   make(chan []byte, bondWindowSize),
+	}
+	go bond.manager()
+	return bond
+}
+
+// Join adds the given ship to the bond using a join token issued by the other
+// side of the bond.
+func (bond *BondedShip) Join(ship Ship, token []byte) error {
+	if len(token) != bondJoinTokenSize {
+		return errors.New("invalid bond join token")
+	}
+
+	// Sink ship if the other side does not respond.
+	timeout := time.AfterFunc(bondHelloTimeout, ship.Sink)
+	defer timeout.Stop()
+
+	// Request to join the bond.
+	hello := container.New(varint.Pack8(bondMsgTypeJoin), token[:bondJoinTokenIDSize])
+	hello.PrependLength()
+	if err := ship.Load(hello.CompileData()); err != nil {
+		return fmt.Errorf("failed to send bond join: %w", err)
+	}
+
+	// Prove knowledge of the token.
+	reader := bufio.NewReader(shipReader{ship})
+	nonce, err := readBondJoinMsg(reader, bondJoinNonceSize)
+	if err != nil {
+		return fmt.Errorf("failed to read bond join challenge: %w", err)
+	}
+	proof := container.New(bondJoinProof(token, nonce))
+	proof.PrependLength()
+	if err := ship.Load(proof.CompileData()); err != nil {
+		return fmt.Errorf("failed to send bond join proof: %w", err)
+	}
+
+	return bond.addMember(ship, reader)
+}
+
+// IssueJoinToken issues a single-use token that allows another ship to join
+// the bond. The token must be transferred over a secure channel.
+func (bond *BondedShip) IssueJoinToken() ([]byte, error) {
+	if bond.ctx.Err() != nil {
+		return nil, ErrSunk
+	}
+
+	token := make([]byte, bondJoinTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to get random token: %w", err)
+	}
+
+	bondJoinTokensLock.Lock()
+	defer bondJoinTokensLock.Unlock()
+
+	// Clean up expired tokens.
+	now := time.Now()
+	for key, joinToken := range bondJoinTokens {
+		if now.After(joinToken.expires) {
+			delete(bondJoinTokens, key)
+		}
+	}
+
+	bondJoinTokens[string(token[:bondJoinTokenIDSize])] = &bondJoinToken{
+		bond:    bond,
+		token:   append([]byte(nil), token...),
+		expires: now.Add(bondJoinTokenTTL),
+	}
+	return token, nil
+}
+
+// bondJoinProof returns the proof that the given token is known.
+func bondJoinProof(token, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, token)
+	_, _ = mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+// readBondJoinMsg reads a bond join message with the given size.
+func readBondJoinMsg(reader *bufio.Reader, size int) ([]byte, error) {
+	msgLen, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	if msgLen != uint64(size) {
+		return nil, fmt.Errorf("invalid message length %d", msgLen)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(reader, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// CheckForBond checks if the given incoming ship starts or joins a bond.
+// If the ship starts a bond, the new bond is returned.
+// If the ship joins an existing bond, it is added to it and nil is returned.
+// Otherwise, the ship is returned for regular use.
+func CheckForBond(ship Ship) (Ship, error) {
+	// Sink ship if it does not send anything.
+	timeout := time.AfterFunc(bondHelloTimeout, ship.Sink)
+	defer timeout.Stop()
+
+	// Peek at the first message without consuming it.
+	// A bond hello is always short, so the length fits into the first byte and
+	// the message type is in the second byte.
+	reader := bufio.NewReader(shipReader{ship})
+	start, err := reader.Peek(2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read first message: %w", err)
+	}
+	if start[0] >= 0x80 || (start[1] != bondMsgTypeStart && start[1] != bondMsgTypeJoin) {
+		return &peekedShip{Ship: ship, reader: reader}, nil
+	}
+
+	// Read full bond hello.
+	hello := make([]byte, 1+int(start[0]))
+	if _, err := io.ReadFull(reader, hello); err != nil {
+		return nil, fmt.Errorf("failed to read bond hello: %w", err)
+	}
+
+	switch hello[1] {
+	case bondMsgTypeStart:
+		bond := newBondedShip(ship)
+		if err := bond.addMember(ship, reader); err != nil {
+			return nil, err
+		}
+		return bond, nil
+
+	case bondMsgTypeJoin:
+		// Get bond of token.
+		// The token is removed right away, so that it can only be tried once.
+		bondJoinTokensLock.Lock()
+		joinToken, ok := bondJoinTokens[string(hello[2:])]
+		delete(bondJoinTokens, string(hello[2:]))
+		bondJoinTokensLock.Unlock()
+		if !ok || time.Now().After(joinToken.expires) {
+			return nil, errors.New("unknown or expired bond join token")
+		}
+
+		// Challenge the joining side to prove knowledge of the token.
+		nonce := make([]byte, bondJoinNonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, fmt.Errorf("failed to get random nonce: %w", err)
+		}
+		challenge := container.New(nonce)
+		challenge.PrependLength()
+		if err := ship.Load(challenge.CompileData()); err != nil {
+			return nil, fmt.Errorf("failed to send bond join challenge: %w", err)
+		}
+		proof, err := readBondJoinMsg(reader, sha256.Size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bond join proof: %w", err)
+		}
+		if !hmac.Equal(proof, bondJoinProof(joinToken.token, nonce)) {
+			return nil, errors.New("invalid bond join proof")
+		}
+
+		return nil, joinToken.bond.addMember(ship, reader)
+	}
+
+	return nil, errors.New("invalid bond hello")
+}
+
+func (bond *BondedShip) addMember(ship Ship, reader *bufio.Reader) error {
+	if ship.IsSecure() != bond.secure {
+		return errors.New("transport security of ship does not match bond")
+	}
+	if bond.public.IsSet() {
+		ship.MarkPublic()
+	}
+
+	member := &bondMember{
+		ship:    ship,
+		reader:  reader,
+		queue:   make(chan []byte, bondMemberQueueSize),
+		dropped: abool.New(),
+		dead:    make(chan struct{}),
+	}
+	member.rtt.Store(int64(bondInitialRTT))
+	member.lastRecv.Store(time.Now().UnixNano())
+
+	bond.membersLock.Lock()
+	defer bond.membersLock.Unlock()
+
+	if bond.ctx.Err() != nil {
+		return ErrSunk
+	}
+	bond.members = append(bond.members, member)
+
+	go bond.memberWriter(member)
+	go bond.memberReader(member)
+	member.queueFrame(makeBondFrame(bondFramePing, varint.Pack64(uint64(time.Now().UnixNano()))))
+
+	return nil
+}
+
+// Members returns the amount of ships in the bond.
+func (bond *BondedShip) Members() int {
+	bond.membersLock.Lock()
+	defer bond.membersLock.Unlock()
+
+	return len(bond.members)
+}
+
+func (bond *BondedShip) dropMember(member *bondMember, err error) {
+	if !member.dropped.SetToIf(false, true) {
+		return
+	}
+	close(member.dead)
+	member.ship.Sink()
+
+	// Remove from members.
+	bond.membersLock.Lock()
+	for i, m := range bond.members {
+		if m == member {
+			bond.members = append(bond.members[:i], bond.members[i+1:]...)
+			break
+		}
+	}
+	remaining := len(bond.members)
+	bond.membersLock.Unlock()
+
+	// Sink the bond if no members are left.
+	if remaining == 0 {
+		log.Warningf("spn/ships: dropped last ship %s of bond: %s", member.ship, err)
+		bond.Sink()
+		return
+	}
+	log.Warningf("spn/ships: dropped ship %s from bond, %d ships remaining: %s", member.ship, remaining, err)
+
+	// Resend all unacknowledged frames, as they might have been lost with the
+	// dropped member.
+	go func() {
+		seqs, frames := bond.getUnackedFrames()
+		for i, frame := range frames {
+			if bond.sendDataFrame(seqs[i], frame) != nil {
+				return
+			}
+		}
+	}()
+}
+
+func (bond *BondedShip) getUnackedFrames() (seqs []uint64, frames [][]byte) {
+	bond.sendLock.Lock()
+	defer bond.sendLock.Unlock()
+
+	// Collect in order of sequence.
+	seqs = make([]uint64, 0, len(bond.unacked))
+	frames = make([][]byte, 0, len(bond.unacked))
+	for seq := bond.ackedSeq; seq < bond.nextSeq; seq++ {
+		if frame, ok := bond.unacked[seq]; ok {
+			seqs = append(seqs, seq)
+			frames = append(frames, frame)
+		}
+	}
+	return seqs, frames
+}
+
+// selectMember returns the member with the lowest expected latency.
+func (bond *BondedShip) selectMember() *bondMember {
+	bond.membersLock.Lock()
+	defer bond.membersLock.Unlock()
+
+	var (
+		selected      *bondMember
+		selectedScore int64
+	)
+	for _, member := range bond.members {
+		score := member.rtt.Load() * int64(1+len(member.queue))
+		if selected == nil || score < selectedScore {
+			selected = member
+			selectedScore = score
+		}
+	}
+	return selected
+}
+
+// sendDataFrame sends the data frame with the given sequence number on the
+// member with the lowest expected latency.
+func (bond *BondedShip) sendDataFrame(seq uint64, frame []byte) error {
+	for {
+		member := bond.selectMember()
+		if member == nil {
+			return ErrSunk
+		}
+
+		// Remember what is sent on the member before sending, so that acks for
+		// these frames are accepted from it.
+		for {
+			sentSeq := member.sentSeq.Load()
+			if seq < sentSeq || member.sentSeq.CompareAndSwap(sentSeq, seq+1) {
+				break
+			}
+		}
+
+		select {
+		case member.queue <- frame:
+			return nil
+		case <-member.dead:
+			// Select another member.
+		case <-bond.ctx.Done():
+			return ErrSunk
+		}
+	}
+}
+
+func (member *bondMember) queueFrame(frame []byte) {
+	select {
+	case member.queue <- frame:
+	default:
+		// Control frames are sent again later.
+	}
+}
+
+func (bond *BondedShip) memberWriter(member *bondMember) {
+	for {
+		select {
+		case frame := <-member.queue:
+			if err := member.ship.Load(frame); err != nil {
+				bond.dropMember(member, err)
+				return
+			}
+		case <-member.dead:
+			return
+		case <-bond.ctx.Done():
+			return
+		}
+	}
+}
+
+func (bond *BondedShip) memberReader(member *bondMember) {
+	for {
+		// Read frame.
+		frameLen, err := binary.ReadUvarint(member.reader)
+		if err != nil {
+			bond.dropMember(member, err)
+			return
+		}
+		if frameLen == 0 || frameLen > bondMaxFrameSize {
+			bond.dropMember(member, fmt.Errorf("invalid frame length %d", frameLen))
+			return
+		}
+		frame := make([]byte, frameLen)
+		if _, err := io.ReadFull(member.reader, frame); err != nil {
+			bond.dropMember(member, err)
+			return
+		}
+		if member.dropped.IsSet() {
+			return
+		}
+		member.lastRecv.Store(time.Now().UnixNano())
+
+		// Handle frame.
+		if err := bond.handleFrame(member, container.New(frame)); err != nil {
+			bond.dropMember(member, err)
+			return
+		}
+	}
+}
+
+func (bond *BondedShip) handleFrame(member *bondMember, c *container.Container) error {
+	frameType, err := c.GetNextN8()
+	if err != nil {
+		return err
+	}
+	number, err := c.GetNextN64()
+	if err != nil {
+		return err
+	}
+
+	switch frameType {
+	case bondFrameData:
+		return bond.handleData(member, number, c.CompileData())
+
+	case bondFrameAck:
+		bond.handleAck(member, number)
+
+	case bondFramePing:
+		member.queueFrame(makeBondFrame(bondFramePong, varint.Pack64(number)))
+
+	case bondFramePong:
+		rtt := time.Now().UnixNano() - int64(number)
+		if rtt > 0 {
+			// Smooth like TCP does.
+			member.rtt.Store((member.rtt.Load()*7 + rtt) / 8)
+		}
+
+	default:
+		return fmt.Errorf("unknown frame type %d", frameType)
+	}
+
+	return nil
+}
+
+func (bond *BondedShip) handleData(member *bondMember, seq uint64, data []byte) error {
+	// Keep delivery in order across all member readers.
+	bond.deliverLock.Lock()
+	defer bond.deliverLock.Unlock()
+
+	ready, err := bond.sequenceData(member, seq, data)
+	if err != nil {
+		return err
+	}
+
+	// Deliver outside of the recvLock, as this may block.
+	for _, data := range ready {
+		select {
+		case bond.delivering <- data:
+		case <-bond.ctx.Done():
+			return ErrSunk
+		}
+	}
+	return nil
+}
+
+// sequenceData adds the received data to the pending data and returns all data
+// that is now in order.
+func (bond *BondedShip) sequenceData(member *bondMember, seq uint64, data []byte) (ready [][]byte, err error) {
+	bond.recvLock.Lock()
+	defer bond.recvLock.Unlock()
+
+	// Acknowledge on the member that received the latest data, as the other
+	// side only accepts acks for frames that were sent on the acking member.
+	bond.ackMember = member
+
+	switch {
+	case seq < bond.expectedSeq:
+		// Duplicate, probably resent after a member was dropped.
+		// Acknowledge again on this member.
+		bond.ackRequested = true
+		return nil, nil
+	case seq >= bond.expectedSeq+2*bondWindowSize:
+		return nil, fmt.Errorf("received data frame %d outside of window", seq)
+	}
+	bond.pending[seq] = data
+
+	// Collect all data that is in order.
+	for {
+		next, ok := bond.pending[bond.expectedSeq]
+		if !ok {
+			break
+		}
+		ready = append(ready, next)
+		delete(bond.pending, bond.expectedSeq)
+		bond.expectedSeq++
+	}
+
+	// Acknowledge early if a lot was received.
+	if bond.expectedSeq-bond.ackSentSeq >= bondAckThreshold {
+		bond.sendAck(false)
+	}
+
+	return ready, nil
+}
+
+// sendAck sends an acknowledgement. Must be called with the recvLock held.
+func (bond *BondedShip) sendAck(toAll bool) {
+	ack := makeBondFrame(bondFrameAck, varint.Pack64(bond.expectedSeq))
+	bond.ackSentSeq = bond.expectedSeq
+	bond.ackRequested = false
+
+	if toAll {
+		bond.membersLock.Lock()
+		defer bond.membersLock.Unlock()
+
+		for _, member := range bond.members {
+			member.queueFrame(ack)
+		}
+		return
+	}
+
+	if member := bond.ackMember; member != nil && !member.dropped.IsSet() {
+		member.queueFrame(ack)
+	} else if member := bond.selectMember(); member != nil {
+		member.queueFrame(ack)
+	}
+}
+
+// handleAck handles an acknowledgement received on the given member.
+// Only frames that were sent on the member are acknowledged, so that a member
+// cannot acknowledge frames that it never carried.
+func (bond *BondedShip) handleAck(member *bondMember, nextExpected uint64) {
+	bond.sendLock.Lock()
+	defer bond.sendLock.Unlock()
+
+	if sentSeq := member.sentSeq.Load(); nextExpected > sentSeq {
+		nextExpected = sentSeq
+	}
+	for ; bond.ackedSeq < nextExpected; bond.ackedSeq++ {
+		delete(bond.unacked, bond.ackedSeq)
+	}
+
+	// Signal free window space.
+	select {
+	case bond.windowSignal <- struct{}{}:
+	default:
+	}
+}
+
+// manager sends acks and pings and drops members that stopped responding.
+func (bond *BondedShip) manager() {
+	ackTicker := time.NewTicker(bondAckInterval)
+	defer ackTicker.Stop()
+	pingTicker := time.NewTicker(bondPingInterval)
+	defer pingTicker.Stop()
+
+	for {
+		select {
+		case <-ackTicker.C:
+			bond.recvLock.Lock()
+			if bond.expectedSeq != bond.ackSentSeq || bond.ackRequested {
+				bond.sendAck(false)
+			}
+			bond.recvLock.Unlock()
+
+		case <-pingTicker.C:
+			// Send acks to all members, in case one was lost.
+			bond.recvLock.Lock()
+			bond.sendAck(true)
+			bond.recvLock.Unlock()
+
+			// Ping all members and check if they are still alive.
+			bond.membersLock.Lock()
+			members := append([]*bondMember(nil), bond.members...)
+			bond.membersLock.Unlock()
+			now := time.Now()
+			for _, member := range members {
+				if now.Sub(time.Unix(0, member.lastRecv.Load())) > bondMemberTTL {
+					bond.dropMember(member, errors.New("ship stopped responding"))
+					continue
+				}
+				member.queueFrame(makeBondFrame(bondFramePing, varint.Pack64(uint64(now.UnixNano()))))
+			}
+
+		case <-bond.ctx.Done():
+			return
+		}
+	}
+}
+
+func makeBondFrame(frameType uint8, data ...[]byte) []byte {
+	c := container.New(varint.Pack8(frameType))
+	for _, d := range data {
+		c.Append(d)
+	}
+	c.PrependLength()
+	return c.CompileData()
+}
+
+// String returns a human readable informational summary about the ship.
+func (bond *BondedShip) String() string {
+	primary := bond.primary()
+	if primary == nil {
+		return "<BondedShip sunk>"
+	}
+	return fmt.Sprintf("<BondedShip with %d ships, primary %s>", bond.Members(), primary)
+}
+
+func (bond *BondedShip) primary() Ship {
+	bond.membersLock.Lock()
+	defer bond.membersLock.Unlock()
+
+	if len(bond.members) == 0 {
+		return nil
+	}
+	return bond.members[0].ship
+}
+
+// Transport returns the transport used for this ship.
+// The transport of the primary member is returned.
+func (bond *BondedShip) Transport() hub.Transport {
+	if primary := bond.primary(); primary != nil {
+		return primary.Transport()
+	}
+	return hub.Transport{}
+}
+
+// IsMine returns whether the ship was launched from here.
+func (bond *BondedShip) IsMine() bool {
+	return bond.mine
+}
+
+// IsSecure returns whether the ship provides transport security.
+func (bond *BondedShip) IsSecure() bool {
+	return bond.secure
+}
+
+// Public returns whether the ship is marked as public.
+func (bond *BondedShip) Public() bool {
+	return bond.public.IsSet()
+}
+
+// MarkPublic marks the ship as public.
+func (bond *BondedShip) MarkPublic() {
+	bond.public.Set()
+
+	bond.membersLock.Lock()
+	defer bond.membersLock.Unlock()
+
+	for _, member := range bond.members {
+		member.ship.MarkPublic()
+	}
+}
+
+// LoadSize returns the recommended data size that should be handed to Load().
+// This value will be most likely somehow related to the connection's MTU.
+// Alternatively, using a multiple of LoadSize is also recommended.
+func (bond *BondedShip) LoadSize() int {
+	bond.membersLock.Lock()
+	defer bond.membersLock.Unlock()
+
+	loadSize := defaultLoadSize
+	for i, member := range bond.members {
+		if i == 0 || member.ship.LoadSize() < loadSize {
+			loadSize = member.ship.LoadSize()
+		}
+	}
+	return loadSize - bondFrameOverhead
+}
+
+// Load loads data into the ship - ie. sends the data via the connection.
+// Returns ErrSunk if the ship has already sunk earlier.
+func (bond *BondedShip) Load(data []byte) error {
+	// Empty load is used as a signal to cease operation.
+	if len(data) == 0 {
+		bond.Sink()
+		return nil
+	}
+
+	// Wait for space in the window.
+	bond.sendLock.Lock()
+	for bond.nextSeq-bond.ackedSeq >= bondWindowSize {
+		bond.sendLock.Unlock()
+		select {
+		case <-bond.windowSignal:
+		case <-bond.ctx.Done():
+			return ErrSunk
+		}
+		bond.sendLock.Lock()
+	}
+
+	// Create data frame and keep it until acknowledged.
+	seq := bond.nextSeq
+	bond.nextSeq++
+	frame := makeBondFrame(bondFrameData, varint.Pack64(seq), data)
+	bond.unacked[seq] = frame
+	bond.sendLock.Unlock()
+
+	return bond.sendDataFrame(seq, frame)
+}
+
+// UnloadTo unloads data from the ship - ie. receives data from the
+// connection - puts it into the buf. It returns the amount of data
+// written and an optional error.
+// Returns ErrSunk if the ship has already sunk earlier.
+func (bond *BondedShip) UnloadTo(buf []byte) (n int, err error) {
+	// Get next delivered data, if there is nothing left from before.
+	if len(bond.deliveredTmp) == 0 {
+		select {
+		case bond.deliveredTmp = <-bond.delivering:
+		case <-bond.ctx.Done():
+			return 0, ErrSunk
+		}
+	}
+
+	// Copy data, possibly save remainder for later.
+	n = copy(buf, bond.deliveredTmp)
+	bond.deliveredTmp = bond.deliveredTmp[n:]
+	return n, nil
+}
+
+// LocalAddr returns the underlying local net.Addr of the primary member.
+func (bond *BondedShip) LocalAddr() net.Addr {
+	if primary := bond.primary(); primary != nil {
+		return primary.LocalAddr()
+	}
+	return nil
+}
+
+// RemoteAddr returns the underlying remote net.Addr of the primary member.
+func (bond *BondedShip) RemoteAddr() net.Addr {
+	if primary := bond.primary(); primary != nil {
+		return primary.RemoteAddr()
+	}
+	return nil
+}
+
+// Sink closes the underlying connections and cleans up any related resources.
+func (bond *BondedShip) Sink() {
+	bond.cancelCtx()
+
+	bond.membersLock.Lock()
+	members := bond.members
+	bond.members = nil
+	bond.membersLock.Unlock()
+
+	for _, member := range members {
+		if member.dropped.SetToIf(false, true) {
+			close(member.dead)
+			member.ship.Sink()
+		}
+	}
+}
+
+// MaskAddress masks the address, if enabled.
+func (bond *BondedShip) MaskAddress(addr net.Addr) string {
+	if primary := bond.primary(); primary != nil {
+		return primary.MaskAddress(addr)
+	}
+	return ""
+}
+
+// MaskIP masks an IP, if enabled.
+func (bond *BondedShip) MaskIP(ip net.IP) string {
+	if primary := bond.primary(); primary != nil {
+		return primary.MaskIP(ip)
+	}
+	return ""
+}
+
+// Mask masks a value.
+func (bond *BondedShip) Mask(value []byte) string {
+	if primary := bond.primary(); primary != nil {
+		return primary.Mask(value)
+	}
+	return ""
+}
+
+// peekedShip is a ship of which the first data was already read.
+type peekedShip struct {
+	Ship
+	reader *bufio.Reader
+}
+
+// UnloadTo unloads data from the ship - ie. receives data from the
+// connection - puts it into the buf. It returns the amount of data
+// written and an optional error.
+// Returns ErrSunk if the ship has already sunk earlier.
+func (ship *peekedShip) UnloadTo(buf []byte) (n int, err error) {
+	// Return data read when peeking first.
+	if ship.reader.Buffered() > 0 {
+		return ship.reader.Read(buf)
+	}
+	return ship.Ship.UnloadTo(buf)
+}
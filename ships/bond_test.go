@@ -0,0 +1,164 @@
+package ships
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tevino/abool"
+)
+
+func TestBondedShip(t *testing.T) {
+	t.Parallel()
+
+	// Start bond.
+	shipA := &failingShip{Ship: NewTestShip(false, 100), failed: abool.New()}
+	clientBond, err := NewBondedShip(shipA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srvShip, err := CheckForBond(shipA.Ship.(*TestShip).Reverse())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srvBond, ok := srvShip.(*BondedShip)
+	if !ok {
+		t.Fatalf("server ship should be a bond, but is %T", srvShip)
+	}
+	defer clientBond.Sink()
+	defer srvBond.Sink()
+
+	// Join second ship.
+	token, err := srvBond.IssueJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shipB := NewTestShip(false, 100)
+	joinErr := make(chan error, 1)
+	go func() {
+		joinErr <- clientBond.Join(shipB, token)
+	}()
+	joined, err := CheckForBond(shipB.Reverse())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, <-joinErr)
+	assert.Nil(t, joined, "joined ship should be absorbed by bond")
+	assert.Equal(t, 2, srvBond.Members(), "server bond should have two ships")
+
+	// Tokens may only be used once.
+	shipC := NewTestShip(false, 100)
+	go func() {
+		joinErr <- clientBond.Join(shipC, token)
+	}()
+	srvShipC := shipC.Reverse()
+	_, err = CheckForBond(srvShipC)
+	assert.Error(t, err, "token should not be usable twice")
+	srvShipC.Sink()
+	assert.Error(t, <-joinErr, "join should fail with a used token")
+
+	// Joining must fail without knowing the full token.
+	token, err = srvBond.IssueJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token[len(token)-1] ^= 1
+	shipD := NewTestShip(false, 100)
+	go func() {
+		joinErr <- clientBond.Join(shipD, token)
+	}()
+	srvShipD := shipD.Reverse()
+	_, err = CheckForBond(srvShipD)
+	assert.Error(t, err, "join proof with wrong token should be rejected")
+	srvShipD.Sink()
+	<-joinErr
+	assert.Equal(t, 2, srvBond.Members(), "server bond should still have two ships")
+
+	// Transfer data in both directions.
+	testBondTransfer(t, clientBond, srvBond, 0)
+	testBondTransfer(t, srvBond, clientBond, 0)
+
+	// Transfer must continue when a ship fails.
+	shipA.failed.Set()
+	testBondTransfer(t, clientBond, srvBond, 1000)
+	testBondTransfer(t, srvBond, clientBond, 1000)
+	assert.Eventually(t, func() bool {
+		return clientBond.Members() == 1
+	}, 3*time.Second, 10*time.Millisecond, "failed ship should be dropped")
+
+	// Non-bond ships must be returned unchanged.
+	plainShip := NewTestShip(false, 100)
+	assert.NoError(t, plainShip.Load(testData))
+	checked, err := CheckForBond(plainShip.Reverse())
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := getTestBuf()
+	_, err = io.ReadFull(shipReader{checked}, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, testData, buf, "peeked data should be returned")
+}
+
+func testBondTransfer(t *testing.T, from, to Ship, offset int) {
+	t.Helper()
+
+	for i := offset; i < offset+500; i++ {
+		load := []byte(fmt.Sprintf("load %04d", i))
+		if err := from.Load(load); err != nil {
+			t.Fatalf("failed to load: %s", err)
+		}
+
+		buf := make([]byte, len(load))
+		if _, err := io.ReadFull(shipReader{to}, buf); err != nil {
+			t.Fatalf("failed to unload: %s", err)
+		}
+		assert.Equal(t, string(load), string(buf), "loads should arrive in order")
+	}
+}
+
+// failingShip is a ship that can be set to fail.
+type failingShip struct {
+	Ship
+	failed *abool.AtomicBool
+}
+
+func (ship *failingShip) Load(data []byte) error {
+	if ship.failed.IsSet() {
+		return ErrSunk
+	}
+	return ship.Ship.Load(data)
+}
+
+func (ship *failingShip) UnloadTo(buf []byte) (n int, err error) {
+	n, err = ship.Ship.UnloadTo(buf)
+	if ship.failed.IsSet() {
+		return 0, ErrSunk
+	}
+	return n, err
+}
+
+func (ship *failingShip) Sink() {
+	ship.failed.Set()
+}
+
+func TestBondedShipAcks(t *testing.T) {
+	t.Parallel()
+
+	bond := newBondedShip(NewTestShip(false, 100))
+	defer bond.Sink()
+
+	// Prepare unacknowledged frames, of which only some were sent on the member.
+	for seq := uint64(0); seq < 5; seq++ {
+		bond.unacked[seq] = []byte{}
+	}
+	bond.nextSeq = 5
+	member := &bondMember{}
+	member.sentSeq.Store(2)
+
+	// Members may only acknowledge frames that were sent on them.
+	bond.handleAck(member, 5)
+	assert.Equal(t, uint64(2), bond.ackedSeq, "only frames sent on the member should be acknowledged")
+	assert.Len(t, bond.unacked, 3, "frames not sent on the member should stay unacknowledged")
+}
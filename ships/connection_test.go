@@ -64,7 +64,16 @@ func TestConnections(t *testing.T) {
 			}()
 
 			// connect to listener
-			ship, err := builder.LaunchShip(ctx, transport, localhost)
+			// wss piers listen in plain HTTP and need TLS termination in front.
+			shipTransport := transport
+			if protocol == "wss" {
+				shipTransport = &hub.Transport{
+					Protocol: protocol,
+					Domain:   "example.com",
+					Port:     startTestTLSProxy(t, transport.Port),
+				}
+			}
+			ship, err := builder.LaunchShip(ctx, shipTransport, localhost, localhost)
 			if err != nil {
 				t.Fatal(err)
 			}
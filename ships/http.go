@@ -0,0 +1,200 @@
+package ships
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/hub"
+)
+
+// HTTPUpgradeProtocol is the protocol name used in the HTTP upgrade header.
+const HTTPUpgradeProtocol = "SPN"
+
+// HTTPShip is a ship that uses an upgraded HTTP connection.
+type HTTPShip struct {
+	ShipBase
+}
+
+// HTTPPier is a pier that uses an upgraded HTTP connection.
+type HTTPPier struct {
+	PierBase
+}
+
+func init() {
+	Register("http", &Builder{
+		LaunchShip:    launchHTTPShip,
+		EstablishPier: establishHTTPPier,
+	})
+}
+
+func launchHTTPShip(ctx context.Context, transport *hub.Transport, ip, localIP net.IP) (Ship, error) {
+	dialer := newDialer(localIP)
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), portToA(transport.Port)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Build upgrade request.
+	path := transport.Path
+	if path == "" {
+		path = "/"
+	}
+	host := transport.Domain
+	if host == "" {
+		host = ip.String()
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+net.JoinHostPort(host, portToA(transport.Port))+path, nil)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to build upgrade request: %w", err)
+	}
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", HTTPUpgradeProtocol)
+
+	// Send upgrade request and wait for response.
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+	}
+	err = request.Write(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to send upgrade request: %w", err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, request)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to read upgrade response: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		_ = conn.Close()
+		return nil, fmt.Errorf("upgrade request failed: %s", resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	ship := &HTTPShip{
+		ShipBase: ShipBase{
+			conn:      newBufferedConn(conn, reader),
+			transport: transport,
+			mine:      true,
+			secure:    false,
+		},
+	}
+
+	ship.calculateLoadSize(ip, nil, TCPHeaderMTUSize)
+	ship.initBase()
+	return ship, nil
+}
+
+func establishHTTPPier(transport *hub.Transport, dockingRequests chan *DockingRequest) (Pier, error) {
+	listener := newHTTPPierListener(transport.Port, transport.Path)
+	pier := &HTTPPier{
+		PierBase: PierBase{
+			transport:       transport,
+			listener:        listener,
+			dockingRequests: dockingRequests,
+		},
+	}
+	err := listener.register(pier.handleUpgrade)
+	if err != nil {
+		return nil, err
+	}
+
+	pier.PierBase.dockShip = pier.dockShip
+	pier.initBase()
+	return pier, nil
+}
+
+func (pier *HTTPPier) handleUpgrade(w http.ResponseWriter, r *http.Request) {
+	// Check if this is a valid upgrade request.
+	if r.Method != http.MethodGet ||
+		r.Header.Get("Upgrade") != HTTPUpgradeProtocol {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	// Take over connection.
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		log.Warningf("spn/ships: failed to hijack http connection from %s: %s", r.RemoteAddr, err)
+		return
+	}
+
+	// Confirm upgrade.
+	_, err = buf.WriteString(
+		"HTTP/1.1 101 Switching Protocols\r\n" +
+			"Connection: Upgrade\r\n" +
+			"Upgrade: " + HTTPUpgradeProtocol + "\r\n\r\n",
+	)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		log.Warningf("spn/ships: failed to confirm http upgrade to %s: %s", r.RemoteAddr, err)
+		_ = conn.Close()
+		return
+	}
+
+	// Reset deadlines that might have been set by the http server.
+	_ = conn.SetDeadline(time.Time{})
+
+	// Submit connection to pier.
+	pier.PierBase.listener.(*httpPierListener).submit(newBufferedConn(conn, buf.Reader))
+}
+
+func (pier *HTTPPier) dockShip() (Ship, error) {
+	conn, err := pier.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	ship := &HTTPShip{
+		ShipBase: ShipBase{
+			transport: pier.transport,
+			conn:      conn,
+			mine:      false,
+			secure:    false,
+		},
+	}
+
+	ship.calculateLoadSize(nil, conn.RemoteAddr(), TCPHeaderMTUSize)
+	ship.initBase()
+	return ship, nil
+}
+
+// bufferedConn is a net.Conn that first reads from a buffered reader, which
+// might already hold data read from the connection.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func newBufferedConn(conn net.Conn, reader *bufio.Reader) net.Conn {
+	// Skip buffer if there is nothing left in it.
+	if reader == nil || reader.Buffered() == 0 {
+		return conn
+	}
+
+	return &bufferedConn{
+		Conn:   conn,
+		reader: reader,
+	}
+}
+
+// Read reads data from the connection.
+func (bc *bufferedConn) Read(b []byte) (n int, err error) {
+	return bc.reader.Read(b)
+}
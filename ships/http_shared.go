@@ -0,0 +1,197 @@
+package ships
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+)
+
+// sharedHTTPServer is an HTTP server that is shared by all HTTP based piers
+// that listen on the same port. Requests are routed by their path.
+type sharedHTTPServer struct {
+	port     uint16
+	server   *http.Server
+	listener net.Listener
+
+	handlers     map[string]http.HandlerFunc
+	handlersLock sync.RWMutex
+}
+
+var (
+	sharedHTTPServers     = make(map[uint16]*sharedHTTPServer)
+	sharedHTTPServersLock sync.Mutex
+)
+
+// addHTTPHandler registers the given handler for the path on the shared HTTP
+// server of the given port. The server is started if it does not exist yet.
+func addHTTPHandler(port uint16, path string, handler http.HandlerFunc) (net.Addr, error) {
+	sharedHTTPServersLock.Lock()
+	defer sharedHTTPServersLock.Unlock()
+
+	// Use root path if none is given.
+	if path == "" {
+		path = "/"
+	}
+
+	// Register handler with existing server.
+	shared, ok := sharedHTTPServers[port]
+	if ok {
+		shared.handlersLock.Lock()
+		defer shared.handlersLock.Unlock()
+
+		if _, ok := shared.handlers[path]; ok {
+			return nil, fmt.Errorf("path %s is already in use on port %d", path, port)
+		}
+		shared.handlers[path] = handler
+		return shared.listener.Addr(), nil
+	}
+
+	// Create new listener and server.
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{
+		Port: int(port),
+	})
+	if err != nil {
+		return nil, err
+	}
+	shared = &sharedHTTPServer{
+		port:     port,
+		listener: listener,
+		handlers: map[string]http.HandlerFunc{
+			path: handler,
+		},
+	}
+	shared.server = &http.Server{
+		Handler:           shared,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	sharedHTTPServers[port] = shared
+
+	// Start serving.
+	go func() {
+		err := shared.server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Warningf("spn/ships: shared http server on port %d failed: %s", port, err)
+		}
+	}()
+
+	return listener.Addr(), nil
+}
+
+// removeHTTPHandler removes the handler for the path from the shared HTTP
+// server of the given port. The server is shut down when no handlers are left.
+func removeHTTPHandler(port uint16, path string) {
+	sharedHTTPServersLock.Lock()
+	defer sharedHTTPServersLock.Unlock()
+
+	// Use root path if none is given.
+	if path == "" {
+		path = "/"
+	}
+
+	shared, ok := sharedHTTPServers[port]
+	if !ok {
+		return
+	}
+
+	shared.handlersLock.Lock()
+	defer shared.handlersLock.Unlock()
+
+	delete(shared.handlers, path)
+	if len(shared.handlers) > 0 {
+		return
+	}
+
+	// Shut down server if no handlers are left.
+	delete(sharedHTTPServers, port)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = shared.server.Shutdown(ctx)
+}
+
+// ServeHTTP routes the request to the handler registered for its path.
+func (shared *sharedHTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	shared.handlersLock.RLock()
+	handler, ok := shared.handlers[r.URL.Path]
+	shared.handlersLock.RUnlock()
+
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	handler(w, r)
+}
+
+// httpPierListener is a net.Listener that receives connections from the
+// handler registered at a shared HTTP server. This enables HTTP based piers to
+// use the PierBase as is.
+type httpPierListener struct {
+	port uint16
+	path string
+	addr net.Addr
+
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newHTTPPierListener(port uint16, path string) *httpPierListener {
+	return &httpPierListener{
+		port:   port,
+		path:   path,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// register registers the given handler for the path of the listener on the
+// shared HTTP server of the listener's port.
+func (ln *httpPierListener) register(handler http.HandlerFunc) error {
+	addr, err := addHTTPHandler(ln.port, ln.path, handler)
+	if err != nil {
+		return err
+	}
+	ln.addr = addr
+	return nil
+}
+
+// submit hands a new connection to the listener. If the listener is closed,
+// the connection is closed and false is returned.
+func (ln *httpPierListener) submit(conn net.Conn) (accepted bool) {
+	select {
+	case ln.conns <- conn:
+		return true
+	case <-ln.closed:
+		_ = conn.Close()
+		return false
+	}
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (ln *httpPierListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.conns:
+		return conn, nil
+	case <-ln.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener and removes the handler from the shared HTTP server.
+func (ln *httpPierListener) Close() error {
+	ln.closeOnce.Do(func() {
+		close(ln.closed)
+		removeHTTPHandler(ln.port, ln.path)
+	})
+	return nil
+}
+
+// Addr returns the listener's network address.
+func (ln *httpPierListener) Addr() net.Addr {
+	return ln.addr
+}
@@ -19,7 +19,12 @@ func init() {
 	})
 }
 
-func launchKCPShip(ctx context.Context, transport *hub.Transport, ip net.IP) (Ship, error) {
+func launchKCPShip(ctx context.Context, transport *hub.Transport, ip, localIP net.IP) (Ship, error) {
+	// kcp.Dial always uses the local address chosen by the operating system.
+	if localIP != nil {
+		return nil, errors.New("kcp does not support launching from a specific local IP")
+	}
+
 	conn, err := kcp.Dial(net.JoinHostPort(ip.String(), portToA(transport.Port)))
 	if err != nil {
 		return nil, err
@@ -78,4 +83,14 @@ func (pier *KCPPier) dockShip() (Ship, error) {
 	ship.initBase()
 	return ship, nil
 }
+
+// SegmentStats returns the segment stats of all KCP connections, as kcp only
+// counts them globally.
+func (ship *KCPShip) SegmentStats() (stats SegmentStats, ok bool) {
+	snmp := kcp.DefaultSnmp.Copy()
+	return SegmentStats{
+		Sent:          snmp.OutSegs,
+		Retransmitted: snmp.RetransSegs + snmp.LostSegs,
+	}, true
+}
 */
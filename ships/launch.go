@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/safing/portbase/log"
 	"github.com/safing/portmaster/netenv"
@@ -12,6 +13,14 @@ import (
 
 // Launch launches a new ship to the given Hub.
 func Launch(ctx context.Context, h *hub.Hub, transport *hub.Transport, ip net.IP) (Ship, error) {
+	return LaunchFrom(ctx, h, transport, ip, nil)
+}
+
+// LaunchFrom launches a new ship to the given Hub from the given local IP.
+// If localIP is nil, the operating system chooses the local address.
+// Binding to a specific local IP makes it possible to use another network
+// interface than the default one.
+func LaunchFrom(ctx context.Context, h *hub.Hub, transport *hub.Transport, ip, localIP net.IP) (Ship, error) {
 	var transports []*hub.Transport
 	var ips []net.IP
 
@@ -79,7 +88,7 @@ func Launch(ctx context.Context, h *hub.Hub, transport *hub.Transport, ip net.IP
 	var firstErr error
 	for _, ip := range ips {
 		for _, tr := range transports {
-			ship, err := connectTo(ctx, h, tr, ip)
+			ship, err := connectTo(ctx, h, tr, ip, localIP)
 			if err == nil {
 				return ship, nil // return on success
 			} else if firstErr == nil {
@@ -91,16 +100,40 @@ func Launch(ctx context.Context, h *hub.Hub, transport *hub.Transport, ip net.IP
 	return nil, firstErr
 }
 
-func connectTo(ctx context.Context, h *hub.Hub, transport *hub.Transport, ip net.IP) (Ship, error) {
+func connectTo(ctx context.Context, h *hub.Hub, transport *hub.Transport, ip, localIP net.IP) (Ship, error) {
 	builder := GetBuilder(transport.Protocol)
 	if builder == nil {
 		return nil, fmt.Errorf("protocol %s not supported", transport.Protocol)
 	}
 
-	ship, err := builder.LaunchShip(ctx, transport, ip)
+	obfuscation, err := getObfuscation(transport)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to %s using %s (%s): %w", h, transport, ip, err)
 	}
 
+	ship, err := builder.LaunchShip(ctx, transport, ip, localIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s using %s (%s): %w", h, transport, ip, err)
+	}
+
+	// Wrap ship with obfuscation, if configured.
+	if obfuscation != nil {
+		ship, err = obfuscateShip(ctx, ship, obfuscation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s using %s (%s): %w", h, transport, ip, err)
+		}
+	}
+
 	return ship, nil
 }
+
+// newDialer returns a new dialer that binds to the given local IP, if set.
+func newDialer(localIP net.IP) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+	}
+	if localIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: localIP}
+	}
+	return dialer
+}
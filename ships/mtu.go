@@ -9,6 +9,14 @@ const (
 	IPv6HeaderMTUSize = 40   // Without options, as not common.
 	TCPHeaderMTUSize  = 60   // Maximum size with options.
 	UDPHeaderMTUSize  = 8    // Has no options.
+
+	// QUICBaseMTU is the MTU QUIC starts with. QUIC uses this to calculate the
+	// initial maximum datagram size: 1252 bytes for IPv4 and 1232 for IPv6.
+	QUICBaseMTU = 1280
+	// QUICHeaderMTUSize is the overhead of QUIC for stream data in a packet:
+	// Short header with connection ID and packet number (1+20+4), AEAD tag (16)
+	// and the stream frame header (1+8+8+8).
+	QUICHeaderMTUSize = 66
 )
 
 func (ship *ShipBase) calculateLoadSize(ip net.IP, addr net.Addr, subtract ...int) {
@@ -36,7 +44,7 @@ func (ship *ShipBase) calculateLoadSize(ip net.IP, addr net.Addr, subtract ...in
 	}
 
 	// Subtract others.
-	for sub := range subtract {
+	for _, sub := range subtract {
 		ship.loadSize -= sub
 	}
 
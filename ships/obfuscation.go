@@ -0,0 +1,261 @@
+package ships
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/hub"
+)
+
+// Obfuscation disguises the traffic of a ship in order to make it harder to
+// detect and block using deep packet inspection.
+// Obfuscations are selected by the option of a transport, eg. "tcp:443#tls-mimic".
+type Obfuscation interface {
+	// Handshake performs the initial exchange before any data is transferred.
+	// The given ship must only be used for information, as data must be read
+	// from r and written to w.
+	Handshake(ship Ship, r *bufio.Reader, w io.Writer) error
+
+	// Wrap wraps the given data into a frame for sending.
+	Wrap(data []byte) []byte
+
+	// Unwrap reads the next frame from the reader and returns the contained data.
+	Unwrap(r *bufio.Reader) ([]byte, error)
+
+	// Overhead returns the maximum amount of bytes a frame adds to the data.
+	Overhead() int
+}
+
+// ObfuscationFactory creates a new obfuscation instance for a ship.
+type ObfuscationFactory func() Obfuscation
+
+var (
+	obfuscations     = make(map[string]ObfuscationFactory)
+	obfuscationsLock sync.Mutex
+
+	obfuscationHandshakeTimeout = 30 * time.Second
+)
+
+// RegisterObfuscation registers a new obfuscation for the given transport option.
+func RegisterObfuscation(option string, factory ObfuscationFactory) {
+	obfuscationsLock.Lock()
+	defer obfuscationsLock.Unlock()
+
+	obfuscations[option] = factory
+}
+
+// getObfuscation returns the obfuscation factory for the given transport
+// option. It returns nil without error, if the transport has no option.
+func getObfuscation(transport *hub.Transport) (ObfuscationFactory, error) {
+	if transport.Option == "" {
+		return nil, nil //nolint:nilnil // No option means no obfuscation.
+	}
+
+	obfuscationsLock.Lock()
+	defer obfuscationsLock.Unlock()
+
+	factory, ok := obfuscations[transport.Option]
+	if !ok {
+		return nil, fmt.Errorf("unknown transport option %q", transport.Option)
+	}
+	return factory, nil
+}
+
+// ObfuscatedShip is a ship that wraps another ship with an obfuscation.
+type ObfuscatedShip struct {
+	Ship
+
+	obfuscation Obfuscation
+	reader      *bufio.Reader
+	unloaded    []byte
+	loadLock    sync.Mutex
+}
+
+// obfuscateShip wraps the given ship with the given obfuscation and executes
+// the handshake.
+func obfuscateShip(ctx context.Context, ship Ship, factory ObfuscationFactory) (*ObfuscatedShip, error) {
+	oShip := &ObfuscatedShip{
+		Ship:        ship,
+		obfuscation: factory(),
+	}
+	oShip.reader = bufio.NewReader(shipReader{ship: ship})
+
+	// Execute handshake, but do not wait forever.
+	handshakeErr := make(chan error, 1)
+	go func() {
+		handshakeErr <- oShip.obfuscation.Handshake(ship, oShip.reader, shipWriter{ship: ship})
+	}()
+	var err error
+	select {
+	case err = <-handshakeErr:
+	case <-time.After(obfuscationHandshakeTimeout):
+		err = errors.New("timed out")
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		ship.Sink()
+		return nil, fmt.Errorf("obfuscation handshake failed: %w", err)
+	}
+
+	return oShip, nil
+}
+
+// LoadSize returns the recommended data size that should be handed to Load().
+func (ship *ObfuscatedShip) LoadSize() int {
+	return ship.Ship.LoadSize() - ship.obfuscation.Overhead()
+}
+
+// Load loads data into the ship - ie. sends the data via the connection.
+func (ship *ObfuscatedShip) Load(data []byte) error {
+	// Empty load is used as a signal to cease operation.
+	if len(data) == 0 {
+		return ship.Ship.Load(data)
+	}
+
+	ship.loadLock.Lock()
+	defer ship.loadLock.Unlock()
+
+	return ship.Ship.Load(ship.obfuscation.Wrap(data))
+}
+
+// UnloadTo unloads data from the ship - ie. receives data from the
+// connection - puts it into the buf. It returns the amount of data
+// written and an optional error.
+func (ship *ObfuscatedShip) UnloadTo(buf []byte) (n int, err error) {
+	// Get next frame, if all data was unloaded.
+	for len(ship.unloaded) == 0 {
+		ship.unloaded, err = ship.obfuscation.Unwrap(ship.reader)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Copy as much data as possible.
+	n = copy(buf, ship.unloaded)
+	ship.unloaded = ship.unloaded[n:]
+	return n, nil
+}
+
+// ObfuscatedPier is a pier that wraps another pier with an obfuscation.
+type ObfuscatedPier struct {
+	Pier
+
+	factory         ObfuscationFactory
+	innerRequests   chan *DockingRequest
+	dockingRequests chan *DockingRequest
+}
+
+// establishObfuscatedPier establishes a pier using the given builder and wraps
+// it with the given obfuscation.
+func establishObfuscatedPier(
+	builder *Builder,
+	transport *hub.Transport,
+	dockingRequests chan *DockingRequest,
+	factory ObfuscationFactory,
+) (*ObfuscatedPier, error) {
+	innerRequests := make(chan *DockingRequest, 1)
+	pier, err := builder.EstablishPier(transport, innerRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObfuscatedPier{
+		Pier:            pier,
+		factory:         factory,
+		innerRequests:   innerRequests,
+		dockingRequests: dockingRequests,
+	}, nil
+}
+
+// Docking is the blocking (!) procedure that docks new ships and sends docking requests. This should be run as a worker by the caller.
+func (pier *ObfuscatedPier) Docking(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go pier.handleDockingRequests(ctx)
+	return pier.Pier.Docking(ctx)
+}
+
+func (pier *ObfuscatedPier) handleDockingRequests(ctx context.Context) {
+	for {
+		select {
+		case r := <-pier.innerRequests:
+			pier.handleDockingRequest(ctx, r)
+		case <-ctx.Done():
+			// Forward remaining errors.
+			for {
+				select {
+				case r := <-pier.innerRequests:
+					pier.handleDockingRequest(ctx, r)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (pier *ObfuscatedPier) handleDockingRequest(ctx context.Context, r *DockingRequest) {
+	// Forward errors.
+	if r.Ship == nil {
+		select {
+		case pier.dockingRequests <- &DockingRequest{
+			Pier: pier,
+			Err:  r.Err,
+		}:
+		default:
+		}
+		return
+	}
+
+	// Execute handshake in the background to not block other ships.
+	go func() {
+		ship, err := obfuscateShip(ctx, r.Ship, pier.factory)
+		if err != nil {
+			log.Debugf("spn/ships: failed to dock %s at %s: %s", r.Ship, pier, err)
+			return
+		}
+
+		select {
+		case pier.dockingRequests <- &DockingRequest{
+			Pier: pier,
+			Ship: ship,
+		}:
+		case <-ctx.Done():
+			ship.Sink()
+		}
+	}()
+}
+
+// shipReader is an io.Reader that reads from a ship.
+type shipReader struct {
+	ship Ship
+}
+
+func (sr shipReader) Read(p []byte) (n int, err error) {
+	return sr.ship.UnloadTo(p)
+}
+
+// shipWriter is an io.Writer that writes to a ship.
+type shipWriter struct {
+	ship Ship
+}
+
+func (sw shipWriter) Write(p []byte) (n int, err error) {
+	// Empty loads would sink the ship.
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if err := sw.ship.Load(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,144 @@
+package ships
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// httpMimicMaxChunkSize is the maximum accepted chunk size.
+	httpMimicMaxChunkSize = 0xFFFF
+
+	// httpMimicOverhead is the maximum overhead of a chunk: the hex encoded
+	// size of up to 4 characters and two line endings.
+	httpMimicOverhead = 4 + 2 + 2
+)
+
+// httpMimic makes the ship traffic look like a HTTP/1.1 request and response
+// with chunked bodies.
+type httpMimic struct{}
+
+func init() {
+	RegisterObfuscation("http-mimic", func() Obfuscation {
+		return httpMimic{}
+	})
+}
+
+// Handshake performs the initial exchange before any data is transferred.
+func (hm httpMimic) Handshake(ship Ship, r *bufio.Reader, w io.Writer) error {
+	transport := ship.Transport()
+
+	if ship.IsMine() {
+		// Client: Send request headers and wait for response headers.
+		path := transport.Path
+		if path == "" {
+			path = "/"
+		}
+		host := transport.Domain
+		if host == "" {
+			host, _, _ = net.SplitHostPort(ship.RemoteAddr().String())
+		}
+		_, err := fmt.Fprintf(w,
+			"POST %s HTTP/1.1\r\n"+
+				"Host: %s\r\n"+
+				"User-Agent: Mozilla/5.0\r\n"+
+				"Accept: */*\r\n"+
+				"Content-Type: application/octet-stream\r\n"+
+				"Transfer-Encoding: chunked\r\n\r\n",
+			path, host,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to send request: %w", err)
+		}
+
+		// The body must not be closed, as it is read directly from now on.
+		resp, err := http.ReadResponse(r, nil) //nolint:bodyclose
+		if err != nil {
+			return fmt.Errorf("failed to receive response: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("received unexpected response: %s", resp.Status)
+		}
+		return nil
+	}
+
+	// Server: Wait for request headers and send response headers.
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return fmt.Errorf("failed to receive request: %w", err)
+	}
+	if req.Method != http.MethodPost {
+		return fmt.Errorf("received unexpected request method %s", req.Method)
+	}
+	_, err = io.WriteString(w,
+		"HTTP/1.1 200 OK\r\n"+
+			"Content-Type: application/octet-stream\r\n"+
+			"Cache-Control: no-store\r\n"+
+			"Transfer-Encoding: chunked\r\n\r\n",
+	)
+	if err != nil {
+		return fmt.Errorf("failed to send response: %w", err)
+	}
+	return nil
+}
+
+// Wrap wraps the given data into a frame for sending.
+func (hm httpMimic) Wrap(data []byte) []byte {
+	frame := make([]byte, 0, len(data)+(len(data)/httpMimicMaxChunkSize+1)*httpMimicOverhead)
+	for len(data) > 0 {
+		size := len(data)
+		if size > httpMimicMaxChunkSize {
+			size = httpMimicMaxChunkSize
+		}
+		frame = strconv.AppendUint(frame, uint64(size), 16)
+		frame = append(frame, '\r', '\n')
+		frame = append(frame, data[:size]...)
+		frame = append(frame, '\r', '\n')
+		data = data[size:]
+	}
+	return frame
+}
+
+// Unwrap reads the next frame from the reader and returns the contained data.
+func (hm httpMimic) Unwrap(r *bufio.Reader) ([]byte, error) {
+	// Read chunk size.
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		// Ignore chunk extensions.
+		line = line[:i]
+	}
+	size, err := strconv.ParseUint(line, 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chunk size: %w", err)
+	}
+	if size == 0 {
+		// The last chunk ends the body.
+		return nil, io.EOF
+	}
+
+	// Read chunk and line ending.
+	chunk := make([]byte, size+2)
+	_, err = io.ReadFull(r, chunk)
+	if err != nil {
+		return nil, err
+	}
+	if chunk[size] != '\r' || chunk[size+1] != '\n' {
+		return nil, errors.New("missing line ending after chunk")
+	}
+	return chunk[:size], nil
+}
+
+// Overhead returns the maximum amount of bytes a frame adds to the data.
+func (hm httpMimic) Overhead() int {
+	return httpMimicOverhead
+}
@@ -0,0 +1,83 @@
+package ships
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	// randomPadHeaderSize is the size of the frame header: 2 bytes for the
+	// data length and 1 byte for the padding length.
+	randomPadHeaderSize = 3
+
+	// randomPadMaxPadding is the maximum amount of padding added to a frame.
+	randomPadMaxPadding = 64
+
+	// randomPadMaxDataSize is the maximum amount of data in one frame.
+	randomPadMaxDataSize = 0xFFFF
+)
+
+// randomPad adds a random amount of random padding to every frame in order
+// to disguise the sizes of the transferred data.
+type randomPad struct{}
+
+func init() {
+	RegisterObfuscation("random-pad", func() Obfuscation {
+		return randomPad{}
+	})
+}
+
+// Handshake performs the initial exchange before any data is transferred.
+func (rp randomPad) Handshake(ship Ship, r *bufio.Reader, w io.Writer) error {
+	// No handshake needed.
+	return nil
+}
+
+// Wrap wraps the given data into a frame for sending.
+func (rp randomPad) Wrap(data []byte) []byte {
+	frame := make([]byte, 0, len(data)+(len(data)/randomPadMaxDataSize+1)*rp.Overhead())
+	for len(data) > 0 {
+		size := len(data)
+		if size > randomPadMaxDataSize {
+			size = randomPadMaxDataSize
+		}
+
+		// Get random padding. The first random byte determines the length.
+		padding := make([]byte, randomPadMaxPadding+1)
+		_, _ = rand.Read(padding)
+		padLen := int(padding[0]) % (randomPadMaxPadding + 1)
+
+		frame = binary.BigEndian.AppendUint16(frame, uint16(size))
+		frame = append(frame, byte(padLen))
+		frame = append(frame, data[:size]...)
+		frame = append(frame, padding[1:1+padLen]...)
+		data = data[size:]
+	}
+	return frame
+}
+
+// Unwrap reads the next frame from the reader and returns the contained data.
+func (rp randomPad) Unwrap(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, randomPadHeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint16(header[:2]))
+	padLen := int(header[2])
+
+	// Read data and padding.
+	frame := make([]byte, size+padLen)
+	_, err = io.ReadFull(r, frame)
+	if err != nil {
+		return nil, err
+	}
+	return frame[:size], nil
+}
+
+// Overhead returns the maximum amount of bytes a frame adds to the data.
+func (rp randomPad) Overhead() int {
+	return randomPadHeaderSize + randomPadMaxPadding
+}
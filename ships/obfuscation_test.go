@@ -0,0 +1,129 @@
+package ships
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/spn/hub"
+)
+
+func TestObfuscation(t *testing.T) { //nolint:paralleltest // Uses the registry, which is locked by other tests.
+	ctx := context.Background()
+	bigTestData := bytes.Repeat(testData, 2000)
+
+	for _, definition := range []string{
+		"tcp:1#tls-mimic",
+		"tcp:1#http-mimic",
+		"tcp:1#random-pad",
+		"ws://example.com:1/spn#tls-mimic",
+	} {
+		transport, err := hub.ParseTransport(definition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport.Port = getTestPort()
+
+		// Establish pier.
+		requests := make(chan *DockingRequest, 1)
+		pier, err := EstablishPier(transport, requests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			_ = pier.Docking(ctx)
+		}()
+
+		// Launch ship.
+		ship, err := Launch(ctx, &hub.Hub{}, transport, localhost)
+		if err != nil {
+			t.Fatalf("%s: %s", transport, err)
+		}
+		request := <-requests
+		if request.Err != nil {
+			t.Fatalf("%s failed to dock: %s", request.Pier, request.Err)
+		}
+		srvShip := request.Ship
+		assert.IsType(t, &ObfuscatedShip{}, ship, "ship should be obfuscated")
+		assert.IsType(t, &ObfuscatedShip{}, srvShip, "ship should be obfuscated")
+		assert.Equal(t, pier, request.Pier, "docking request should reference obfuscated pier")
+
+		// Exchange data in both directions.
+		for _, data := range [][]byte{testData, bigTestData} {
+			err = ship.Load(data)
+			if err != nil {
+				t.Fatalf("%s failed: %s", ship, err)
+			}
+			buf := make([]byte, len(data))
+			err = unloadUntilFull(srvShip, buf)
+			if err != nil {
+				t.Fatalf("%s failed: %s", srvShip, err)
+			}
+			assert.Equal(t, data, buf, "should match")
+
+			err = srvShip.Load(data)
+			if err != nil {
+				t.Fatalf("%s failed: %s", srvShip, err)
+			}
+			buf = make([]byte, len(data))
+			err = unloadUntilFull(ship, buf)
+			if err != nil {
+				t.Fatalf("%s failed: %s", ship, err)
+			}
+			assert.Equal(t, data, buf, "should match")
+		}
+
+		ship.Sink()
+		srvShip.Sink()
+		pier.Abolish()
+	}
+}
+
+func TestObfuscationMismatch(t *testing.T) { //nolint:paralleltest // Uses the registry, which is locked by other tests.
+	ctx := context.Background()
+
+	// Establish obfuscated pier.
+	transport := &hub.Transport{
+		Protocol: "tcp",
+		Port:     getTestPort(),
+		Option:   "tls-mimic",
+	}
+	requests := make(chan *DockingRequest, 1)
+	pier, err := EstablishPier(transport, requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pier.Abolish()
+	go func() {
+		_ = pier.Docking(ctx)
+	}()
+
+	// Launching with a different obfuscation must fail.
+	_, err = Launch(ctx, &hub.Hub{}, &hub.Transport{
+		Protocol: "tcp",
+		Port:     transport.Port,
+		Option:   "http-mimic",
+	}, localhost)
+	assert.Error(t, err, "mismatching obfuscation should fail")
+
+	// Unknown options must fail.
+	_, err = EstablishPier(&hub.Transport{
+		Protocol: "tcp",
+		Port:     getTestPort(),
+		Option:   "unknown",
+	}, requests)
+	assert.Error(t, err, "unknown option should fail")
+}
+
+func unloadUntilFull(ship Ship, buf []byte) error {
+	for len(buf) > 0 {
+		n, err := ship.UnloadTo(buf)
+		if err != nil {
+			return err
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package ships
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TLS record content types.
+const (
+	tlsRecordTypeChangeCipherSpec = 0x14
+	tlsRecordTypeHandshake        = 0x16
+	tlsRecordTypeApplicationData  = 0x17
+
+	tlsHandshakeTypeClientHello = 0x01
+	tlsHandshakeTypeServerHello = 0x02
+
+	tlsRecordHeaderSize = 5
+	tlsMaxRecordSize    = 16384
+)
+
+// tlsMimic makes the ship traffic look like a TLS 1.3 connection.
+// The handshake imitates a client and server hello, after which all data is
+// sent as application data records.
+type tlsMimic struct{}
+
+func init() {
+	RegisterObfuscation("tls-mimic", func() Obfuscation {
+		return tlsMimic{}
+	})
+}
+
+// Handshake performs the initial exchange before any data is transferred.
+func (tm tlsMimic) Handshake(ship Ship, r *bufio.Reader, w io.Writer) error {
+	if ship.IsMine() {
+		// Client: Send client hello and wait for server hello.
+		_, err := w.Write(tlsRecord(tlsRecordTypeHandshake, buildTLSClientHello(ship.Transport().Domain)))
+		if err != nil {
+			return fmt.Errorf("failed to send client hello: %w", err)
+		}
+		err = expectTLSHandshake(r, tlsHandshakeTypeServerHello)
+		if err != nil {
+			return fmt.Errorf("failed to receive server hello: %w", err)
+		}
+		err = expectTLSRecord(r, tlsRecordTypeChangeCipherSpec)
+		if err != nil {
+			return fmt.Errorf("failed to receive change cipher spec: %w", err)
+		}
+		_, err = w.Write(tlsRecord(tlsRecordTypeChangeCipherSpec, []byte{0x01}))
+		if err != nil {
+			return fmt.Errorf("failed to send change cipher spec: %w", err)
+		}
+		return nil
+	}
+
+	// Server: Wait for client hello and send server hello.
+	err := expectTLSHandshake(r, tlsHandshakeTypeClientHello)
+	if err != nil {
+		return fmt.Errorf("failed to receive client hello: %w", err)
+	}
+	_, err = w.Write(append(
+		tlsRecord(tlsRecordTypeHandshake, buildTLSServerHello()),
+		tlsRecord(tlsRecordTypeChangeCipherSpec, []byte{0x01})...,
+	))
+	if err != nil {
+		return fmt.Errorf("failed to send server hello: %w", err)
+	}
+	err = expectTLSRecord(r, tlsRecordTypeChangeCipherSpec)
+	if err != nil {
+		return fmt.Errorf("failed to receive change cipher spec: %w", err)
+	}
+	return nil
+}
+
+// Wrap wraps the given data into a frame for sending.
+func (tm tlsMimic) Wrap(data []byte) []byte {
+	// Fast path for data that fits into one record.
+	if len(data) <= tlsMaxRecordSize {
+		return tlsRecord(tlsRecordTypeApplicationData, data)
+	}
+
+	// Split data into multiple records.
+	frame := make([]byte, 0, len(data)+(len(data)/tlsMaxRecordSize+1)*tlsRecordHeaderSize)
+	for len(data) > 0 {
+		size := len(data)
+		if size > tlsMaxRecordSize {
+			size = tlsMaxRecordSize
+		}
+		frame = append(frame, tlsRecord(tlsRecordTypeApplicationData, data[:size])...)
+		data = data[size:]
+	}
+	return frame
+}
+
+// Unwrap reads the next frame from the reader and returns the contained data.
+func (tm tlsMimic) Unwrap(r *bufio.Reader) ([]byte, error) {
+	recordType, data, err := readTLSRecord(r)
+	if err != nil {
+		return nil, err
+	}
+	switch recordType {
+	case tlsRecordTypeApplicationData:
+		return data, nil
+	case tlsRecordTypeChangeCipherSpec:
+		// Ignore, as TLS 1.3 may send these at any time.
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected tls record type 0x%x", recordType)
+	}
+}
+
+// Overhead returns the maximum amount of bytes a frame adds to the data.
+func (tm tlsMimic) Overhead() int {
+	return tlsRecordHeaderSize
+}
+
+func tlsRecord(recordType byte, data []byte) []byte {
+	record := make([]byte, tlsRecordHeaderSize, tlsRecordHeaderSize+len(data))
+	record[0] = recordType
+	// Use legacy version TLS 1.0 for the client hello, as browsers do.
+	if recordType == tlsRecordTypeHandshake && len(data) > 0 && data[0] == tlsHandshakeTypeClientHello {
+		binary.BigEndian.PutUint16(record[1:3], 0x0301)
+	} else {
+		binary.BigEndian.PutUint16(record[1:3], 0x0303)
+	}
+	binary.BigEndian.PutUint16(record[3:5], uint16(len(data)))
+	return append(record, data...)
+}
+
+func readTLSRecord(r *bufio.Reader) (recordType byte, data []byte, err error) {
+	header := make([]byte, tlsRecordHeaderSize)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return 0, nil, err
+	}
+	if header[1] != 0x03 {
+		return 0, nil, errors.New("invalid tls record version")
+	}
+	size := int(binary.BigEndian.Uint16(header[3:5]))
+	if size > tlsMaxRecordSize+256 {
+		return 0, nil, errors.New("tls record too big")
+	}
+
+	data = make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return 0, nil, err
+	}
+	return header[0], data, nil
+}
+
+func expectTLSRecord(r *bufio.Reader, recordType byte) error {
+	receivedType, _, err := readTLSRecord(r)
+	if err != nil {
+		return err
+	}
+	if receivedType != recordType {
+		return fmt.Errorf("received tls record type 0x%x instead of 0x%x", receivedType, recordType)
+	}
+	return nil
+}
+
+func expectTLSHandshake(r *bufio.Reader, handshakeType byte) error {
+	recordType, data, err := readTLSRecord(r)
+	if err != nil {
+		return err
+	}
+	if recordType != tlsRecordTypeHandshake || len(data) == 0 || data[0] != handshakeType {
+		return errors.New("unexpected tls record")
+	}
+	return nil
+}
+
+func buildTLSClientHello(serverName string) []byte {
+	random := make([]byte, 32+32+32)
+	_, _ = rand.Read(random)
+
+	// Build extensions.
+	var ext []byte
+	if serverName != "" {
+		// Server name indication.
+		ext = appendTLSExtension(ext, 0x0000, appendUint16Prefixed(
+			nil,
+			append([]byte{0x00}, appendUint16Prefixed(nil, []byte(serverName))...),
+		))
+	}
+	ext = appendTLSExtension(ext, 0x000a, []byte{0x00, 0x04, 0x00, 0x1d, 0x00, 0x17})             // Supported groups: x25519, secp256r1
+	ext = appendTLSExtension(ext, 0x000d, []byte{0x00, 0x06, 0x04, 0x03, 0x08, 0x04, 0x04, 0x01}) // Signature algorithms.
+	ext = appendTLSExtension(ext, 0x0010, []byte{0x00, 0x03, 0x02, 0x68, 0x32})                   // ALPN: h2
+	ext = appendTLSExtension(ext, 0x002b, []byte{0x02, 0x03, 0x04})                               // Supported versions: TLS 1.3
+	ext = appendTLSExtension(ext, 0x0033, append(                                                 // Key share: x25519
+		[]byte{0x00, 0x24, 0x00, 0x1d, 0x00, 0x20},
+		random[64:96]...,
+	))
+
+	// Build client hello.
+	hello := []byte{0x03, 0x03}                   // Legacy version: TLS 1.2
+	hello = append(hello, random[:32]...)         // Random
+	hello = append(hello, 0x20)                   // Session ID length
+	hello = append(hello, random[32:64]...)       // Session ID
+	hello = append(hello, 0x00, 0x06)             // Cipher suites length
+	hello = append(hello, 0x13, 0x01, 0x13, 0x02) // TLS_AES_128_GCM_SHA256, TLS_AES_256_GCM_SHA384
+	hello = append(hello, 0x13, 0x03)             // TLS_CHACHA20_POLY1305_SHA256
+	hello = append(hello, 0x01, 0x00)             // Compression methods: null
+	hello = appendUint16Prefixed(hello, ext)      // Extensions
+
+	return appendTLSHandshake(tlsHandshakeTypeClientHello, hello)
+}
+
+func buildTLSServerHello() []byte {
+	random := make([]byte, 32+32+32)
+	_, _ = rand.Read(random)
+
+	// Build extensions.
+	var ext []byte
+	ext = appendTLSExtension(ext, 0x002b, []byte{0x03, 0x04}) // Supported version: TLS 1.3
+	ext = appendTLSExtension(ext, 0x0033, append(             // Key share: x25519
+		[]byte{0x00, 0x1d, 0x00, 0x20},
+		random[64:96]...,
+	))
+
+	// Build server hello.
+	hello := []byte{0x03, 0x03}              // Legacy version: TLS 1.2
+	hello = append(hello, random[:32]...)    // Random
+	hello = append(hello, 0x20)              // Session ID length
+	hello = append(hello, random[32:64]...)  // Session ID
+	hello = append(hello, 0x13, 0x01)        // TLS_AES_128_GCM_SHA256
+	hello = append(hello, 0x00)              // Compression method: null
+	hello = appendUint16Prefixed(hello, ext) // Extensions
+
+	return appendTLSHandshake(tlsHandshakeTypeServerHello, hello)
+}
+
+func appendTLSHandshake(handshakeType byte, data []byte) []byte {
+	msg := make([]byte, 4, 4+len(data))
+	msg[0] = handshakeType
+	msg[1] = byte(len(data) >> 16)
+	msg[2] = byte(len(data) >> 8)
+	msg[3] = byte(len(data))
+	return append(msg, data...)
+}
+
+func appendTLSExtension(b []byte, extType uint16, data []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, extType)
+	return appendUint16Prefixed(b, data)
+}
+
+func appendUint16Prefixed(b []byte, data []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
@@ -42,6 +42,19 @@ func EstablishPier(transport *hub.Transport, dockingRequests chan *DockingReques
 		return nil, fmt.Errorf("protocol %s not supported", transport.Protocol)
 	}
 
+	// Check if the pier should be obfuscated.
+	obfuscation, err := getObfuscation(transport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to establish pier on %s: %w", transport, err)
+	}
+	if obfuscation != nil {
+		pier, err := establishObfuscatedPier(builder, transport, dockingRequests, obfuscation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to establish pier on %s: %w", transport, err)
+		}
+		return pier, nil
+	}
+
 	pier, err := builder.EstablishPier(transport, dockingRequests)
 	if err != nil {
 		return nil, fmt.Errorf("failed to establish pier on %s: %w", transport, err)
@@ -0,0 +1,421 @@
+package ships
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/hub"
+)
+
+// QUICNextProto is the ALPN protocol name used for QUIC connections.
+const QUICNextProto = "spn"
+
+var (
+	quicConfig = &quic.Config{
+		HandshakeIdleTimeout: 30 * time.Second,
+		MaxIdleTimeout:       2 * time.Minute,
+		KeepAlivePeriod:      30 * time.Second,
+	}
+
+	// quicCertificate is the certificate used by QUIC piers.
+	quicCertificate     *tls.Certificate
+	quicCertificateLock sync.Mutex
+
+	// quicRootCAs overrides the system root CAs for QUIC connections.
+	// It is only used for testing.
+	quicRootCAs *x509.CertPool
+)
+
+// SetQUICCertificate sets the TLS certificate used by QUIC piers.
+// If a QUIC transport is announced with a domain, the certificate must be
+// valid for that domain, as ships will verify it. If no certificate is set, a
+// self-signed certificate is generated.
+// As the certificate is not bound to the Hub identity, QUIC ships are never
+// secure and cranes always set up their own encryption.
+func SetQUICCertificate(cert *tls.Certificate) {
+	quicCertificateLock.Lock()
+	defer quicCertificateLock.Unlock()
+
+	quicCertificate = cert
+}
+
+func getQUICCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	quicCertificateLock.Lock()
+	defer quicCertificateLock.Unlock()
+
+	// Generate self-signed certificate, if none is set.
+	if quicCertificate == nil {
+		cert, err := generateSelfSignedCertificate()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+		}
+		quicCertificate = cert
+	}
+
+	return quicCertificate, nil
+}
+
+// QUICShip is a ship that uses a QUIC stream.
+// Ships to the same destination share a QUIC connection, with each ship
+// using its own stream.
+// The load size is based on the initial datagram size of QUIC (QUICBaseMTU)
+// instead of the datagram size of the connection, as quic-go does not expose
+// the size found by its path MTU discovery. Loads therefore fit into a single
+// packet on every path, but larger MTUs are not used.
+type QUICShip struct {
+	ShipBase
+}
+
+// QUICPier is a pier that uses QUIC.
+type QUICPier struct {
+	PierBase
+}
+
+func init() {
+	Register("quic", &Builder{
+		LaunchShip:    launchQUICShip,
+		EstablishPier: establishQUICPier,
+	})
+}
+
+func launchQUICShip(ctx context.Context, transport *hub.Transport, ip, localIP net.IP) (Ship, error) {
+	// Get connection and open a new stream.
+	conn, err := getQUICClientConn(ctx, transport, ip, localIP)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		conn.release()
+		return nil, fmt.Errorf("failed to open stream: %w", err)
+	}
+
+	ship := &QUICShip{
+		ShipBase: ShipBase{
+			conn:      newQUICStreamConn(stream, conn.Connection, conn.release),
+			transport: transport,
+			mine:      true,
+			secure:    false,
+		},
+	}
+
+	// Use the initial datagram size, see QUICShip.
+	ship.calculateLoadSize(ip, nil, BaseMTU-QUICBaseMTU, UDPHeaderMTUSize, QUICHeaderMTUSize)
+	ship.initBase()
+	return ship, nil
+}
+
+func establishQUICPier(transport *hub.Transport, dockingRequests chan *DockingRequest) (Pier, error) {
+	tlsConfig := &tls.Config{
+		GetCertificate: getQUICCertificate,
+		NextProtos:     []string{QUICNextProto},
+		MinVersion:     tls.VersionTLS13,
+	}
+	quicListener, err := quic.ListenAddr(
+		net.JoinHostPort("", portToA(transport.Port)),
+		tlsConfig,
+		quicConfig,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	pier := &QUICPier{
+		PierBase: PierBase{
+			transport:       transport,
+			listener:        newQUICPierListener(quicListener),
+			dockingRequests: dockingRequests,
+		},
+	}
+	pier.PierBase.dockShip = pier.dockShip
+	pier.initBase()
+	return pier, nil
+}
+
+func (pier *QUICPier) dockShip() (Ship, error) {
+	conn, err := pier.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	ship := &QUICShip{
+		ShipBase: ShipBase{
+			transport: pier.transport,
+			conn:      conn,
+			mine:      false,
+			secure:    false,
+		},
+	}
+
+	// Use the initial datagram size, see QUICShip.
+	ship.calculateLoadSize(nil, conn.RemoteAddr(), BaseMTU-QUICBaseMTU, UDPHeaderMTUSize, QUICHeaderMTUSize)
+	ship.initBase()
+	return ship, nil
+}
+
+// quicClientConn is a QUIC connection shared by all ships to the same
+// destination from the same local IP.
+type quicClientConn struct {
+	quic.Connection
+
+	key     string
+	streams int
+
+	// packetConn holds the socket bound to a specific local IP, if used.
+	packetConn net.PacketConn
+}
+
+var (
+	quicClientConns     = make(map[string]*quicClientConn)
+	quicClientConnsLock sync.Mutex
+)
+
+func getQUICClientConn(ctx context.Context, transport *hub.Transport, ip, localIP net.IP) (*quicClientConn, error) {
+	address := net.JoinHostPort(ip.String(), portToA(transport.Port))
+	key := transport.Domain + "|" + address
+	if localIP != nil {
+		key += "|" + localIP.String()
+	}
+
+	quicClientConnsLock.Lock()
+	defer quicClientConnsLock.Unlock()
+
+	// Check for existing connection.
+	conn, ok := quicClientConns[key]
+	if ok {
+		select {
+		case <-conn.Context().Done():
+			// Connection is closed, create a new one.
+			delete(quicClientConns, key)
+		default:
+			conn.streams++
+			return conn, nil
+		}
+	}
+
+	// Create new connection.
+	tlsConfig := &tls.Config{
+		ServerName: transport.Domain,
+		RootCAs:    quicRootCAs,
+		NextProtos: []string{QUICNextProto},
+		MinVersion: tls.VersionTLS13,
+	}
+	if transport.Domain == "" {
+		// Without a domain, the certificate cannot be verified. This is fine,
+		// as the crane always sets up its own encryption.
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if localIP == nil {
+		quicConn, err := quic.DialAddr(ctx, address, tlsConfig, quicConfig)
+		if err != nil {
+			return nil, err
+		}
+		conn = &quicClientConn{
+			Connection: quicConn,
+			key:        key,
+			streams:    1,
+		}
+	} else {
+		// Bind to the local IP with a dedicated socket.
+		packetConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+		if err != nil {
+			return nil, fmt.Errorf("failed to bind to %s: %w", localIP, err)
+		}
+		quicConn, err := quic.Dial(ctx, packetConn, &net.UDPAddr{IP: ip, Port: int(transport.Port)}, tlsConfig, quicConfig)
+		if err != nil {
+			_ = packetConn.Close()
+			return nil, err
+		}
+		conn = &quicClientConn{
+			Connection: quicConn,
+			key:        key,
+			streams:    1,
+			packetConn: packetConn,
+		}
+	}
+	quicClientConns[key] = conn
+	return conn, nil
+}
+
+// release signifies that a stream of the connection was closed. The connection
+// is closed when there are no streams left.
+func (conn *quicClientConn) release() {
+	quicClientConnsLock.Lock()
+	defer quicClientConnsLock.Unlock()
+
+	conn.streams--
+	if conn.streams > 0 {
+		return
+	}
+
+	// Close connection if there are no more streams.
+	if quicClientConns[conn.key] == conn {
+		delete(quicClientConns, conn.key)
+	}
+	_ = conn.CloseWithError(0, "")
+	if conn.packetConn != nil {
+		_ = conn.packetConn.Close()
+	}
+}
+
+// quicStreamConn is a net.Conn that uses a QUIC stream.
+type quicStreamConn struct {
+	quic.Stream
+
+	conn      quic.Connection
+	onClose   func()
+	closeOnce sync.Once
+}
+
+func newQUICStreamConn(stream quic.Stream, conn quic.Connection, onClose func()) *quicStreamConn {
+	return &quicStreamConn{
+		Stream:  stream,
+		conn:    conn,
+		onClose: onClose,
+	}
+}
+
+// Close closes both directions of the stream.
+func (qsc *quicStreamConn) Close() error {
+	var err error
+	qsc.closeOnce.Do(func() {
+		qsc.Stream.CancelRead(0)
+		err = qsc.Stream.Close()
+		if qsc.onClose != nil {
+			qsc.onClose()
+		}
+	})
+	return err
+}
+
+// LocalAddr returns the local network address of the connection.
+func (qsc *quicStreamConn) LocalAddr() net.Addr {
+	return qsc.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address of the connection.
+func (qsc *quicStreamConn) RemoteAddr() net.Addr {
+	return qsc.conn.RemoteAddr()
+}
+
+// quicPierListener is a net.Listener that accepts the streams of all
+// connections of a QUIC listener. This enables QUIC piers to use the PierBase
+// as is.
+type quicPierListener struct {
+	listener *quic.Listener
+
+	streams   chan net.Conn
+	ctx       context.Context
+	cancelCtx context.CancelFunc
+}
+
+func newQUICPierListener(listener *quic.Listener) *quicPierListener {
+	ln := &quicPierListener{
+		listener: listener,
+		streams:  make(chan net.Conn),
+	}
+	ln.ctx, ln.cancelCtx = context.WithCancel(context.Background())
+
+	go ln.acceptConns()
+	return ln
+}
+
+func (ln *quicPierListener) acceptConns() {
+	for {
+		conn, err := ln.listener.Accept(ln.ctx)
+		if err != nil {
+			if ln.ctx.Err() == nil && !errors.Is(err, quic.ErrServerClosed) {
+				log.Warningf("spn/ships: failed to accept quic connection: %s", err)
+			}
+			_ = ln.Close()
+			return
+		}
+
+		go ln.acceptStreams(conn)
+	}
+}
+
+func (ln *quicPierListener) acceptStreams(conn quic.Connection) {
+	for {
+		stream, err := conn.AcceptStream(ln.ctx)
+		if err != nil {
+			// Connection was closed.
+			return
+		}
+
+		select {
+		case ln.streams <- newQUICStreamConn(stream, conn, nil):
+		case <-ln.ctx.Done():
+			stream.CancelRead(0)
+			_ = stream.Close()
+			return
+		}
+	}
+}
+
+// Accept waits for and returns the next stream to the listener.
+func (ln *quicPierListener) Accept() (net.Conn, error) {
+	select {
+	case stream := <-ln.streams:
+		return stream, nil
+	case <-ln.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener and all of its connections.
+func (ln *quicPierListener) Close() error {
+	ln.cancelCtx()
+	return ln.listener.Close()
+}
+
+// Addr returns the listener's network address.
+func (ln *quicPierListener) Addr() net.Addr {
+	return ln.listener.Addr()
+}
+
+func generateSelfSignedCertificate() (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: "SPN",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
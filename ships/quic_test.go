@@ -0,0 +1,137 @@
+package ships
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/spn/hub"
+)
+
+func TestQUICStreams(t *testing.T) { //nolint:paralleltest // Sets package variables.
+	ctx := context.Background()
+
+	// Borrow the test certificate from httptest, which is valid for example.com.
+	certServer := httptest.NewUnstartedServer(nil)
+	certServer.StartTLS()
+	cert := certServer.TLS.Certificates[0]
+	quicRootCAs = x509.NewCertPool()
+	quicRootCAs.AddCert(certServer.Certificate())
+	certServer.Close()
+	SetQUICCertificate(&cert)
+	defer func() {
+		SetQUICCertificate(nil)
+		quicRootCAs = nil
+	}()
+
+	// Establish pier.
+	requests := make(chan *DockingRequest, 2)
+	transport := &hub.Transport{
+		Protocol: "quic",
+		Domain:   "example.com",
+		Port:     getTestPort(),
+	}
+	pier, err := establishQUICPier(transport, requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pier.Abolish()
+	go func() {
+		_ = pier.Docking(ctx)
+	}()
+
+	// Launch two ships, which should share the same connection.
+	var ships, srvShips []Ship
+	for i := 0; i < 2; i++ {
+		ship, err := launchQUICShip(ctx, transport, localhost, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ships = append(ships, ship)
+
+		// Streams are only announced when data is sent.
+		err = ship.Load(testData)
+		if err != nil {
+			t.Fatalf("%s failed: %s", ship, err)
+		}
+
+		request := <-requests
+		if request.Err != nil {
+			t.Fatalf("%s failed to dock: %s", request.Pier, request.Err)
+		}
+		srvShips = append(srvShips, request.Ship)
+
+		buf := getTestBuf()
+		_, err = request.Ship.UnloadTo(buf)
+		if err != nil {
+			t.Fatalf("%s failed: %s", request.Ship, err)
+		}
+		assert.Equal(t, testData, buf, "should match")
+
+		// The certificate is not bound to the Hub identity.
+		assert.False(t, ship.IsSecure(), "quic ship should not be secure")
+		assert.False(t, request.Ship.IsSecure(), "quic ship should not be secure")
+		assert.Equal(t, localhost.To4(), ship.RemoteAddr().(*net.UDPAddr).IP.To4(), "should be connected to localhost") //nolint:forcetypeassert
+	}
+	assert.Equal(t, ships[0].LocalAddr().String(), ships[1].LocalAddr().String(), "ships should share the connection")
+	assert.Less(t, ships[0].LoadSize(), QUICBaseMTU, "load size should fit into initial quic datagram")
+
+	// A ship launched from a specific local IP must use its own connection.
+	boundShip, err := launchQUICShip(ctx, transport, localhost, localhost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, ships[0].LocalAddr().String(), boundShip.LocalAddr().String(), "bound ship should not share the connection")
+	assert.Equal(t, localhost.To4(), boundShip.LocalAddr().(*net.UDPAddr).IP.To4(), "bound ship should use the local IP") //nolint:forcetypeassert
+	boundShip.Sink()
+
+	// Sinking one ship must not affect the other.
+	ships[0].Sink()
+	err = srvShips[1].Load(testData)
+	if err != nil {
+		t.Fatalf("%s failed: %s", srvShips[1], err)
+	}
+	buf := getTestBuf()
+	_, err = ships[1].UnloadTo(buf)
+	if err != nil {
+		t.Fatalf("%s failed: %s", ships[1], err)
+	}
+	assert.Equal(t, testData, buf, "should match")
+
+	ships[1].Sink()
+	for _, srvShip := range srvShips {
+		srvShip.Sink()
+	}
+}
+
+func TestQUICInsecureWithoutDomain(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// Establish pier with self-signed certificate.
+	requests := make(chan *DockingRequest, 1)
+	transport := &hub.Transport{
+		Protocol: "quic",
+		Port:     getTestPort(),
+	}
+	pier, err := establishQUICPier(transport, requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pier.Abolish()
+	go func() {
+		_ = pier.Docking(ctx)
+	}()
+
+	ship, err := launchQUICShip(ctx, transport, localhost, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ship.Sink()
+	assert.False(t, ship.IsSecure(), "quic ship without domain should not be secure")
+}
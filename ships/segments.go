@@ -0,0 +1,74 @@
+package ships
+
+// SegmentStats holds the amount of segments sent on the connection of a ship.
+// Ships with retransmitting transports count the retransmitted segments, which
+// are used to estimate the packet loss of the connection.
+type SegmentStats struct {
+	// Sent is the amount of sent segments, including retransmissions.
+	Sent uint64
+	// Retransmitted is the amount of retransmitted segments.
+	Retransmitted uint64
+}
+
+// Since returns the stats of the segments sent after the given earlier stats.
+func (s SegmentStats) Since(earlier SegmentStats) SegmentStats {
+	if s.Sent < earlier.Sent || s.Retransmitted < earlier.Retransmitted {
+		return SegmentStats{}
+	}
+	return SegmentStats{
+		Sent:          s.Sent - earlier.Sent,
+		Retransmitted: s.Retransmitted - earlier.Retransmitted,
+	}
+}
+
+// Loss returns the share of retransmitted segments from 0 to 1.
+func (s SegmentStats) Loss() float32 {
+	if s.Sent == 0 {
+		return 0
+	}
+	if s.Retransmitted >= s.Sent {
+		return 1
+	}
+	return float32(s.Retransmitted) / float32(s.Sent)
+}
+
+// GetSegmentStats returns the segment stats of the given ship, if the ship
+// and its transport support it.
+func GetSegmentStats(ship Ship) (stats SegmentStats, ok bool) {
+	switch s := ship.(type) {
+	case interface{ SegmentStats() (SegmentStats, bool) }:
+		return s.SegmentStats()
+	case *ObfuscatedShip:
+		return GetSegmentStats(s.Ship)
+	case *peekedShip:
+		return GetSegmentStats(s.Ship)
+	default:
+		return SegmentStats{}, false
+	}
+}
+
+// SegmentStats returns the segment stats of the connection of the ship, if
+// supported.
+func (ship *ShipBase) SegmentStats() (stats SegmentStats, ok bool) {
+	return getConnSegmentStats(ship.conn)
+}
+
+// SegmentStats returns the summed segment stats of all members of the bond,
+// if all of them support it.
+func (bond *BondedShip) SegmentStats() (stats SegmentStats, ok bool) {
+	bond.membersLock.Lock()
+	defer bond.membersLock.Unlock()
+
+	if len(bond.members) == 0 {
+		return SegmentStats{}, false
+	}
+	for _, member := range bond.members {
+		memberStats, ok := GetSegmentStats(member.ship)
+		if !ok {
+			return SegmentStats{}, false
+		}
+		stats.Sent += memberStats.Sent
+		stats.Retransmitted += memberStats.Retransmitted
+	}
+	return stats, true
+}
@@ -0,0 +1,57 @@
+package ships
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// getConnSegmentStats returns the segment stats of TCP connections from the
+// TCP info of the kernel.
+func getConnSegmentStats(conn net.Conn) (stats SegmentStats, ok bool) {
+	tcpConn, ok := getTCPConn(conn)
+	if !ok {
+		return SegmentStats{}, false
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return SegmentStats{}, false
+	}
+
+	var (
+		info    *unix.TCPInfo
+		infoErr error
+	)
+	err = rawConn.Control(func(fd uintptr) {
+		info, infoErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if err != nil || infoErr != nil {
+		return SegmentStats{}, false
+	}
+
+	stats.Sent = uint64(info.Data_segs_out)
+	if stats.Sent == 0 {
+		// Older kernels do not count data segments separately.
+		stats.Sent = uint64(info.Segs_out)
+	}
+	stats.Retransmitted = uint64(info.Total_retrans)
+	return stats, true
+}
+
+// getTCPConn returns the TCP connection underlying the given connection.
+func getTCPConn(conn net.Conn) (*net.TCPConn, bool) {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			return c, true
+		case *bufferedConn:
+			conn = c.Conn
+		case *webSocketConn:
+			conn = c.UnderlyingConn()
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package ships
+
+import "net"
+
+// getConnSegmentStats is only supported on Linux.
+func getConnSegmentStats(_ net.Conn) (stats SegmentStats, ok bool) {
+	return SegmentStats{}, false
+}
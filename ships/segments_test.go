@@ -0,0 +1,77 @@
+package ships
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/safing/spn/hub"
+)
+
+func TestSegmentStats(t *testing.T) {
+	t.Parallel()
+
+	earlier := SegmentStats{Sent: 100, Retransmitted: 2}
+	later := SegmentStats{Sent: 300, Retransmitted: 12}
+
+	diff := later.Since(earlier)
+	if diff.Sent != 200 || diff.Retransmitted != 10 {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+	if loss := diff.Loss(); loss != 0.05 {
+		t.Fatalf("unexpected loss: %f", loss)
+	}
+	if diff := earlier.Since(later); diff.Sent != 0 || diff.Loss() != 0 {
+		t.Fatalf("reset counters should result in empty stats: %+v", diff)
+	}
+}
+
+func TestTCPSegmentStats(t *testing.T) {
+	t.Parallel()
+
+	// Accept test connection.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			_, _ = conn.Read(getTestBuf())
+			_ = conn.Close()
+		}
+	}()
+
+	ship, err := launchTCPShip(
+		context.Background(),
+		&hub.Transport{Protocol: "tcp", Port: uint16(listener.Addr().(*net.TCPAddr).Port)}, //nolint:forcetypeassert
+		localhost,
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ship.Sink()
+	if err := ship.Load(testData); err != nil {
+		t.Fatal(err)
+	}
+
+	// Get stats through an obfuscated ship wrapper.
+	stats, ok := GetSegmentStats(&ObfuscatedShip{Ship: ship})
+	if runtime.GOOS != "linux" {
+		if ok {
+			t.Fatal("segment stats should only be supported on linux")
+		}
+		return
+	}
+	if !ok {
+		t.Fatal("segment stats should be supported for tcp ships")
+	}
+	if stats.Sent == 0 {
+		t.Fatalf("segments should have been sent: %+v", stats)
+	}
+}
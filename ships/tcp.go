@@ -3,7 +3,6 @@ package ships
 import (
 	"context"
 	"net"
-	"time"
 
 	"github.com/safing/spn/hub"
 )
@@ -25,10 +24,8 @@ func init() {
 	})
 }
 
-func launchTCPShip(ctx context.Context, transport *hub.Transport, ip net.IP) (Ship, error) {
-	dialer := &net.Dialer{
-		Timeout: 30 * time.Second,
-	}
+func launchTCPShip(ctx context.Context, transport *hub.Transport, ip, localIP net.IP) (Ship, error) {
+	dialer := newDialer(localIP)
 	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), portToA(transport.Port)))
 	if err != nil {
 		return nil, err
@@ -2,6 +2,7 @@ package ships
 
 import (
 	"net"
+	"sync"
 
 	"github.com/mr-tron/base58"
 	"github.com/tevino/abool"
@@ -13,11 +14,14 @@ import (
 type TestShip struct {
 	mine      bool
 	secure    bool
+	private   bool
 	loadSize  int
 	forward   chan []byte
 	backward  chan []byte
 	unloadTmp []byte
 	sinking   *abool.AtomicBool
+	sunk      chan struct{}
+	sinkLock  sync.RWMutex
 }
 
 // NewTestShip returns a new TestShip for simulation.
@@ -29,6 +33,7 @@ func NewTestShip(secure bool, loadSize int) *TestShip {
 		forward:  make(chan []byte, 100),
 		backward: make(chan []byte, 100),
 		sinking:  abool.NewBool(false),
+		sunk:     make(chan struct{}),
 	}
 }
 
@@ -64,6 +69,12 @@ func (ship *TestShip) LoadSize() int {
 	return ship.loadSize
 }
 
+// MarkPrivate marks the ship as non-public in order to simulate a connection
+// from a client. Must be called before the ship is used.
+func (ship *TestShip) MarkPrivate() {
+	ship.private = true
+}
+
 // Reverse creates a connected TestShip. This is used to simulate a connection instead of using a Pier.
 func (ship *TestShip) Reverse() *TestShip {
 	return &TestShip{
@@ -73,6 +84,7 @@ func (ship *TestShip) Reverse() *TestShip {
 		forward:  ship.backward,
 		backward: ship.forward,
 		sinking:  abool.NewBool(false),
+		sunk:     make(chan struct{}),
 	}
 }
 
@@ -94,9 +106,15 @@ func (ship *TestShip) Load(data []byte) error {
 	}
 
 	// Send all given data.
-	ship.forward <- data
-
-	return nil
+	// Hold the lock so that the ship cannot be sunk while sending.
+	ship.sinkLock.RLock()
+	defer ship.sinkLock.RUnlock()
+	select {
+	case ship.forward <- data:
+		return nil
+	case <-ship.sunk:
+		return ErrSunk
+	}
 }
 
 // UnloadTo unloads data from the ship - ie. receives data from the
@@ -139,6 +157,10 @@ func (ship *TestShip) UnloadTo(buf []byte) (n int, err error) {
 // Sink closes the underlying connection and cleans up any related resources.
 func (ship *TestShip) Sink() {
 	if ship.sinking.SetToIf(false, true) {
+		close(ship.sunk)
+
+		ship.sinkLock.Lock()
+		defer ship.sinkLock.Unlock()
 		close(ship.forward)
 	}
 }
@@ -147,7 +169,7 @@ func (ship *TestShip) Sink() {
 
 func (ship *TestShip) LocalAddr() net.Addr              { return nil }                  //nolint:golint
 func (ship *TestShip) RemoteAddr() net.Addr             { return nil }                  //nolint:golint
-func (ship *TestShip) Public() bool                     { return true }                 //nolint:golint
+func (ship *TestShip) Public() bool                     { return !ship.private }        //nolint:golint
 func (ship *TestShip) MarkPublic()                      {}                              //nolint:golint
 func (ship *TestShip) MaskAddress(addr net.Addr) string { return addr.String() }        //nolint:golint
 func (ship *TestShip) MaskIP(ip net.IP) string          { return ip.String() }          //nolint:golint
@@ -0,0 +1,256 @@
+package ships
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/hub"
+)
+
+// WebSocketFrameMTUSize is the maximum size of a websocket frame header,
+// including the client mask.
+const WebSocketFrameMTUSize = 14
+
+// wssRootCAs overrides the system root CAs for wss connections.
+// It is only used for testing.
+var wssRootCAs *x509.CertPool
+
+// WebSocketShip is a ship that uses a websocket connection.
+type WebSocketShip struct {
+	ShipBase
+}
+
+// WebSocketPier is a pier that uses a websocket connection.
+// For wss, TLS is expected to be terminated in front of the pier, eg. by a
+// reverse proxy or CDN, so the pier itself always listens in plain HTTP.
+// As the TLS connection is neither end to end nor bound to the Hub identity,
+// ws and wss ships are never secure and cranes set up their own encryption.
+type WebSocketPier struct {
+	PierBase
+
+	upgrader *websocket.Upgrader
+}
+
+func init() {
+	Register("ws", &Builder{
+		LaunchShip:    launchWebSocketShip,
+		EstablishPier: establishWebSocketPier,
+	})
+	Register("wss", &Builder{
+		LaunchShip:    launchWebSocketShip,
+		EstablishPier: establishWebSocketPier,
+	})
+}
+
+func launchWebSocketShip(ctx context.Context, transport *hub.Transport, ip, localIP net.IP) (Ship, error) {
+	useTLS := transport.Protocol == "wss"
+
+	// Build URL.
+	host := transport.Domain
+	if host == "" {
+		if useTLS {
+			return nil, errors.New("wss transport requires a domain")
+		}
+		host = ip.String()
+	}
+	path := transport.Path
+	if path == "" {
+		path = "/"
+	}
+	u := &url.URL{
+		Scheme: transport.Protocol,
+		Host:   net.JoinHostPort(host, portToA(transport.Port)),
+		Path:   path,
+	}
+
+	// Always connect to the given IP, independent of the domain.
+	netDialer := newDialer(localIP)
+	address := net.JoinHostPort(ip.String(), portToA(transport.Port))
+	dialer := &websocket.Dialer{
+		NetDialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return netDialer.DialContext(ctx, network, address)
+		},
+		HandshakeTimeout: 30 * time.Second,
+	}
+	if useTLS {
+		dialer.TLSClientConfig = &tls.Config{
+			ServerName: transport.Domain,
+			RootCAs:    wssRootCAs,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	wsConn, resp, err := dialer.DialContext(ctx, u.String(), nil)
+	if resp != nil && resp.Body != nil {
+		_ = resp.Body.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", u, err)
+	}
+
+	ship := &WebSocketShip{
+		ShipBase: ShipBase{
+			conn:      newWebSocketConn(wsConn),
+			transport: transport,
+			mine:      true,
+			secure:    false,
+		},
+	}
+
+	ship.calculateLoadSize(ip, nil, TCPHeaderMTUSize, WebSocketFrameMTUSize)
+	ship.initBase()
+	return ship, nil
+}
+
+func establishWebSocketPier(transport *hub.Transport, dockingRequests chan *DockingRequest) (Pier, error) {
+	listener := newHTTPPierListener(transport.Port, transport.Path)
+	pier := &WebSocketPier{
+		PierBase: PierBase{
+			transport:       transport,
+			listener:        listener,
+			dockingRequests: dockingRequests,
+		},
+		upgrader: &websocket.Upgrader{
+			HandshakeTimeout: 30 * time.Second,
+			CheckOrigin: func(r *http.Request) bool {
+				// Ships do not send an origin.
+				return true
+			},
+		},
+	}
+	err := listener.register(pier.handleUpgrade)
+	if err != nil {
+		return nil, err
+	}
+
+	pier.PierBase.dockShip = pier.dockShip
+	pier.initBase()
+	return pier, nil
+}
+
+func (pier *WebSocketPier) handleUpgrade(w http.ResponseWriter, r *http.Request) {
+	wsConn, err := pier.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Warningf("spn/ships: failed to upgrade websocket connection from %s: %s", r.RemoteAddr, err)
+		return
+	}
+
+	// Reset deadlines that might have been set by the http server.
+	_ = wsConn.UnderlyingConn().SetDeadline(time.Time{})
+
+	// Submit connection to pier.
+	pier.PierBase.listener.(*httpPierListener).submit(newWebSocketConn(wsConn))
+}
+
+func (pier *WebSocketPier) dockShip() (Ship, error) {
+	conn, err := pier.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	ship := &WebSocketShip{
+		ShipBase: ShipBase{
+			transport: pier.transport,
+			conn:      conn,
+			mine:      false,
+			secure:    false,
+		},
+	}
+
+	ship.calculateLoadSize(nil, conn.RemoteAddr(), TCPHeaderMTUSize, WebSocketFrameMTUSize)
+	ship.initBase()
+	return ship, nil
+}
+
+// webSocketConn is a net.Conn that sends and receives data as binary
+// websocket messages.
+type webSocketConn struct {
+	*websocket.Conn
+
+	reader    io.Reader
+	readLock  sync.Mutex
+	writeLock sync.Mutex
+}
+
+func newWebSocketConn(wsConn *websocket.Conn) *webSocketConn {
+	return &webSocketConn{
+		Conn: wsConn,
+	}
+}
+
+// Read reads data from the connection.
+func (wc *webSocketConn) Read(b []byte) (n int, err error) {
+	wc.readLock.Lock()
+	defer wc.readLock.Unlock()
+
+	for {
+		// Get reader for next message.
+		if wc.reader == nil {
+			msgType, reader, err := wc.Conn.NextReader()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+			if msgType != websocket.BinaryMessage {
+				continue
+			}
+			wc.reader = reader
+		}
+
+		// Read from current message.
+		n, err = wc.reader.Read(b)
+		if errors.Is(err, io.EOF) {
+			wc.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Write writes data to the connection.
+func (wc *webSocketConn) Write(b []byte) (n int, err error) {
+	wc.writeLock.Lock()
+	defer wc.writeLock.Unlock()
+
+	err = wc.Conn.WriteMessage(websocket.BinaryMessage, b)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// SetDeadline sets the read and write deadlines associated with the connection.
+func (wc *webSocketConn) SetDeadline(t time.Time) error {
+	if err := wc.Conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return wc.Conn.SetWriteDeadline(t)
+}
+
+// Close closes the connection.
+func (wc *webSocketConn) Close() error {
+	// Control messages may be written concurrently to other messages.
+	_ = wc.Conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second),
+	)
+	return wc.Conn.Close()
+}
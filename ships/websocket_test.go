@@ -0,0 +1,207 @@
+package ships
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/spn/hub"
+)
+
+func TestWebSocketBehindTLSProxy(t *testing.T) { //nolint:paralleltest // Sets package variable.
+	ctx := context.Background()
+
+	// Establish pier, which listens in plain HTTP.
+	requests := make(chan *DockingRequest, 1)
+	pierTransport := &hub.Transport{
+		Protocol: "wss",
+		Domain:   "example.com",
+		Port:     getTestPort(),
+		Path:     "/spn",
+	}
+	pier, err := establishWebSocketPier(pierTransport, requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pier.Abolish()
+	go func() {
+		_ = pier.Docking(ctx)
+	}()
+
+	// Start TLS terminating reverse proxy in front of the pier.
+	port := startTestTLSProxy(t, pierTransport.Port)
+
+	// Launching without domain must fail.
+	_, err = launchWebSocketShip(ctx, &hub.Transport{
+		Protocol: "wss",
+		Port:     port,
+		Path:     "/spn",
+	}, localhost, nil)
+	assert.Error(t, err, "wss without domain should fail")
+
+	// Launching to the wrong path must fail.
+	_, err = launchWebSocketShip(ctx, &hub.Transport{
+		Protocol: "wss",
+		Domain:   "example.com",
+		Port:     port,
+		Path:     "/other",
+	}, localhost, nil)
+	assert.Error(t, err, "wss to unknown path should fail")
+
+	// Launch ship via proxy.
+	ship, err := launchWebSocketShip(ctx, &hub.Transport{
+		Protocol: "wss",
+		Domain:   "example.com",
+		Port:     port,
+		Path:     "/spn",
+	}, localhost, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ship.Sink()
+
+	// Dock ship.
+	request := <-requests
+	if request.Err != nil {
+		t.Fatalf("%s failed to dock: %s", request.Pier, request.Err)
+	}
+	srvShip := request.Ship
+	defer srvShip.Sink()
+
+	// TLS is terminated at the proxy, so the ships must not be secure.
+	assert.False(t, ship.IsSecure(), "wss ship should not be secure")
+	assert.False(t, srvShip.IsSecure(), "wss ship should not be secure")
+
+	// Exchange data.
+	for i := 0; i < 10; i++ {
+		err = ship.Load(testData)
+		if err != nil {
+			t.Fatalf("%s failed: %s", ship, err)
+		}
+		buf := getTestBuf()
+		_, err = srvShip.UnloadTo(buf)
+		if err != nil {
+			t.Fatalf("%s failed: %s", srvShip, err)
+		}
+		assert.Equal(t, testData, buf, "should match")
+
+		err = srvShip.Load(testData)
+		if err != nil {
+			t.Fatalf("%s failed: %s", srvShip, err)
+		}
+		buf = getTestBuf()
+		_, err = ship.UnloadTo(buf)
+		if err != nil {
+			t.Fatalf("%s failed: %s", ship, err)
+		}
+		assert.Equal(t, testData, buf, "should match")
+	}
+}
+
+func TestHTTPPiersSharePort(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	port := getTestPort()
+
+	// Use builders directly, as the registry might be locked by other tests.
+	testBuilders := map[string]*Builder{
+		"http": {LaunchShip: launchHTTPShip, EstablishPier: establishHTTPPier},
+		"ws":   {LaunchShip: launchWebSocketShip, EstablishPier: establishWebSocketPier},
+	}
+
+	// Establish piers on the same port with different paths.
+	requests := make(chan *DockingRequest, 2)
+	httpTransport := &hub.Transport{
+		Protocol: "http",
+		Port:     port,
+		Path:     "/http",
+	}
+	wsTransport := &hub.Transport{
+		Protocol: "ws",
+		Port:     port,
+		Path:     "/ws",
+	}
+	for _, transport := range []*hub.Transport{httpTransport, wsTransport} {
+		pier, err := testBuilders[transport.Protocol].EstablishPier(transport, requests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pier.Abolish()
+		go func() {
+			_ = pier.Docking(ctx)
+		}()
+	}
+
+	// The same path may not be used twice.
+	_, err := establishWebSocketPier(&hub.Transport{
+		Protocol: "ws",
+		Port:     port,
+		Path:     "/http",
+	}, requests)
+	assert.Error(t, err, "path should already be in use")
+
+	// Launch and dock ships.
+	for _, transport := range []*hub.Transport{httpTransport, wsTransport} {
+		ship, err := testBuilders[transport.Protocol].LaunchShip(ctx, transport, localhost, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ship.Sink()
+		assert.False(t, ship.IsSecure(), "ship should not be secure")
+
+		request := <-requests
+		if request.Err != nil {
+			t.Fatalf("%s failed to dock: %s", request.Pier, request.Err)
+		}
+		defer request.Ship.Sink()
+		assert.Equal(t, transport.Protocol, request.Pier.Transport().Protocol, "should dock at matching pier")
+
+		err = ship.Load(testData)
+		if err != nil {
+			t.Fatalf("%s failed: %s", ship, err)
+		}
+		buf := getTestBuf()
+		_, err = request.Ship.UnloadTo(buf)
+		if err != nil {
+			t.Fatalf("%s failed: %s", request.Ship, err)
+		}
+		assert.Equal(t, testData, buf, "should match")
+	}
+}
+
+// startTestTLSProxy starts a TLS terminating reverse proxy in front of the
+// pier on the given port, makes wss ships trust it and returns its port.
+func startTestTLSProxy(t *testing.T, pierPort uint16) uint16 {
+	t.Helper()
+
+	proxy := httptest.NewTLSServer(httputil.NewSingleHostReverseProxy(&url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort(localhost.String(), portToA(pierPort)),
+	}))
+	t.Cleanup(proxy.Close)
+	_, proxyPort, err := net.SplitHostPort(proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.ParseUint(proxyPort, 10, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only wss ships read the root CAs and tests using them do not run in
+	// parallel with each other.
+	if wssRootCAs == nil {
+		wssRootCAs = x509.NewCertPool()
+	}
+	wssRootCAs.AddCert(proxy.Certificate())
+
+	return uint16(port)
+}
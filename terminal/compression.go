@@ -0,0 +1,167 @@
+package terminal
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/varint"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/hub"
+)
+
+/*
+
+Terminal Compression Format:
+used when FeatureCompression is negotiated, applied before padding and encryption
+
+- Compression Type [varint]
+- Data [bytes; not blocked]
+	- Uncompressed: Operation messages, possibly followed by padding
+	- Deflate: Compressed operation messages [bytes block], possibly followed by padding
+
+Compression is skipped for messages that are marked as incompressible. This is
+the case for already encrypted data, such as data of nested terminals or
+connections that carry TLS. Every message is compressed independently. When
+messages of different operations are merged before sending, the merged message
+is not compressed, so that data of different operations never shares a
+compression context.
+
+*/
+
+// Compression Types.
+const (
+	compressionNone    = 0
+	compressionDeflate = 1
+)
+
+const (
+	// compressionMinSize defines the minimum amount of data for compression.
+	compressionMinSize = 256
+
+	// maxDecompressedSize defines the maximum size of decompressed data.
+	maxDecompressedSize = 1 << 20 // 1MB
+)
+
+var (
+	deflateWriters = sync.Pool{
+		New: func() any {
+			w, _ := flate.NewWriter(nil, flate.BestSpeed)
+			return w
+		},
+	}
+	deflateReaders = sync.Pool{
+		New: func() any {
+			return flate.NewReader(nil)
+		},
+	}
+)
+
+// RequestCompression requests compression, if the given remote Hub supports it.
+func (opts *TerminalOpts) RequestCompression(remoteHub *hub.Hub) {
+	if remoteHub == nil ||
+		remoteHub.Status == nil ||
+		!remoteHub.Status.HasFlag(hub.FlagCompression) {
+		return
+	}
+
+	if opts.Version < conf.VersionTwo {
+		opts.Version = conf.VersionTwo
+	}
+	opts.Features |= FeatureCompression
+}
+
+// compress compresses the data of the given message, if compression was
+// negotiated and the data is suitable for compression.
+func (t *TerminalBase) compress(msg *Msg) {
+	if !t.opts.Features.Has(FeatureCompression) {
+		return
+	}
+
+	// Only compress if there is enough data and it is permitted.
+	if msg.Incompressible || msg.Data.Length() < compressionMinSize {
+		msg.Data.Prepend(varint.Pack8(compressionNone))
+		return
+	}
+
+	// Only use compressed data if it is smaller.
+	compressed, err := deflate(msg.Data.CompileData())
+	if err != nil || len(compressed)+varint.EncodedSize(uint64(len(compressed))) >= msg.Data.Length() {
+		msg.Data.Prepend(varint.Pack8(compressionNone))
+		return
+	}
+
+	c := container.New(varint.Pack8(compressionDeflate))
+	c.AppendAsBlock(compressed)
+	msg.Data = c
+}
+
+// decompress decompresses the given data, if compression was negotiated.
+// If the data was compressed, any remaining data is padding and is returned
+// separately.
+func (t *TerminalBase) decompress(c *container.Container) (data, padding *container.Container, tErr *Error) {
+	if !t.opts.Features.Has(FeatureCompression) {
+		return c, nil, nil
+	}
+
+	compressionType, err := c.GetNextN8()
+	if err != nil {
+		return nil, nil, ErrMalformedData.With("failed to get compression type: %w", err)
+	}
+
+	switch compressionType {
+	case compressionNone:
+		return c, nil, nil
+
+	case compressionDeflate:
+		compressed, err := c.GetNextBlock()
+		if err != nil {
+			return nil, nil, ErrMalformedData.With("failed to get compressed data: %w", err)
+		}
+		decompressed, err := inflate(compressed)
+		if err != nil {
+			return nil, nil, ErrMalformedData.With("failed to decompress: %w", err)
+		}
+		return container.New(decompressed), c, nil
+
+	default:
+		return nil, nil, ErrMalformedData.With("unknown compression type %d", compressionType)
+	}
+}
+
+func deflate(data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)))
+
+	w, _ := deflateWriters.Get().(*flate.Writer)
+	defer deflateWriters.Put(w)
+	w.Reset(buf)
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+	r, _ := deflateReaders.Get().(io.ReadCloser)
+	defer deflateReaders.Put(r)
+	if err := r.(flate.Resetter).Reset(bytes.NewReader(data), nil); err != nil { //nolint:forcetypeassert // Always a flate reader.
+		return nil, err
+	}
+
+	// Read with limit to guard against decompression bombs.
+	decompressed, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decompressed) > maxDecompressedSize {
+		return nil, fmt.Errorf("decompressed data exceeds %d bytes", maxDecompressedSize)
+	}
+	return decompressed, nil
+}
@@ -28,9 +28,10 @@ type FlowControlType uint8
 
 // Flow Control Types.
 const (
-	FlowControlDefault FlowControlType = 0
-	FlowControlDFQ     FlowControlType = 1
-	FlowControlNone    FlowControlType = 2
+	FlowControlDefault    FlowControlType = 0
+	FlowControlDFQ        FlowControlType = 1
+	FlowControlNone       FlowControlType = 2
+	FlowControlCongestion FlowControlType = 3
 
 	defaultFlowControl = FlowControlDFQ
 )
@@ -42,7 +43,7 @@ func (fct FlowControlType) DefaultSize() uint32 {
 	}
 
 	switch fct {
-	case FlowControlDFQ:
+	case FlowControlDFQ, FlowControlCongestion:
 		return 50000
 	case FlowControlNone:
 		return 10000
@@ -93,6 +94,9 @@ type DuplexFlowQueue struct {
 	// flush is used to send a finish function to the handler, which will write
 	// all pending messages and then call the received function.
 	flush chan func()
+
+	// sampler takes samples for passive flow estimation.
+	sampler flowSampler
 }
 
 // NewDuplexFlowQueue returns a new duplex flow queue.
@@ -270,6 +274,7 @@ sending:
 			msg.Data.Prepend(varint.Pack64(uint64(dfq.reportableRecvSpace())))
 
 			// Submit for sending upstream.
+			dfq.sampler.sent(msg.Data.Length(), len(dfq.sendQueue) == 0)
 			dfq.submitUpstream(msg, 0)
 			// Decrease the send space and set flag if depleted.
 			if dfq.decrementSendSpace() <= 0 {
@@ -373,6 +378,29 @@ func (dfq *DuplexFlowQueue) Send(msg *Msg, timeout time.Duration) *Error {
 	}
 }
 
+// TrySend adds the given container to the send queue only if it can be sent
+// immediately, ie. if there is send space that is not claimed by already
+// queued messages. Otherwise, the message is finished and ErrTryAgainLater is
+// returned. This is useful for loss tolerant data, which should rather be
+// dropped than wait for flow control.
+func (dfq *DuplexFlowQueue) TrySend(msg *Msg) *Error {
+	if atomic.LoadInt32(dfq.sendSpace) <= int32(len(dfq.sendQueue)) {
+		msg.Finish()
+		return ErrTryAgainLater
+	}
+
+	select {
+	case dfq.sendQueue <- msg:
+		return nil
+	case <-dfq.ctx.Done():
+		msg.Finish()
+		return ErrStopping
+	default:
+		msg.Finish()
+		return ErrTryAgainLater
+	}
+}
+
 // Receive receives a container from the recv queue.
 func (dfq *DuplexFlowQueue) Receive() <-chan *Msg {
 	// If the reported recv space is nearing its end, force a report.
@@ -402,6 +430,7 @@ func (dfq *DuplexFlowQueue) Deliver(msg *Msg) *Error {
 	}
 	if addSpace > 0 {
 		dfq.addToSendSpace(int32(addSpace))
+		dfq.sampler.ackMore(uint64(addSpace))
 	}
 	// Abort processing if the container only contained a space update.
 	if !msg.Data.HoldsData() {
@@ -432,6 +461,12 @@ func (dfq *DuplexFlowQueue) Deliver(msg *Msg) *Error {
 	}
 }
 
+// SetFlowEstimator sets the flow estimator that is fed with samples of the
+// flow control traffic.
+func (dfq *DuplexFlowQueue) SetFlowEstimator(fe *FlowEstimator) {
+	dfq.sampler.setEstimator(fe)
+}
+
 // FlowStats returns a k=v formatted string of internal stats.
 func (dfq *DuplexFlowQueue) FlowStats() string {
 	return fmt.Sprintf(
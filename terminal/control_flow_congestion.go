@@ -0,0 +1,679 @@
+package terminal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/safing/portbase/formats/varint"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/hub"
+)
+
+/*
+
+Congestion Flow Queue Message Format:
+
+- Reported Receive Space [varint]
+- Echoed Probe Sequence [varint; 0 if none]
+- Probe Sequence [varint; 0 if none]
+- Data [bytes; optional]
+
+The congestion flow queue extends the duplex flow queue with a delay based
+congestion window, similar to TCP Vegas. Data messages are numbered and some
+of them are marked as probes. The other end echoes probes as soon as it
+receives them, which acknowledges all messages up to the probe and provides a
+round trip time sample. The congestion window is then adjusted by comparing
+the expected throughput (based on the lowest seen round trip time) to the
+actual throughput.
+
+*/
+
+// Congestion Flow Queue Configuration.
+const (
+	cfqInitialWindow = 10
+	cfqMinWindow     = 4
+	cfqProbesPerRTT  = 4
+
+	// cfqAlpha is the amount of queued msgs below which the window is increased.
+	cfqAlpha = 2
+	// cfqBeta is the amount of queued msgs above which the window is decreased.
+	cfqBeta = 4
+	// cfqGamma is the amount of queued msgs above which slow start is ended.
+	cfqGamma = 1
+
+	// cfqBaseRTTMaxAge defines after how long the base RTT is reset, so that
+	// the flow queue adapts to changed routes.
+	cfqBaseRTTMaxAge = 1 * time.Minute
+)
+
+// CongestionFlowQueue is a duplex flow control mechanism using queues and a
+// delay based congestion window.
+type CongestionFlowQueue struct {
+	ctx context.Context
+
+	// submitUpstream is used to submit messages to the upstream channel.
+	submitUpstream func(msg *Msg, timeout time.Duration)
+
+	// sendQueue holds the messages that are waiting to be sent.
+	sendQueue chan *Msg
+	// prioMsgs holds the number of messages to send with high priority.
+	prioMsgs *int32
+	// sendSpace indicates the amount free slots in the recvQueue on the other end.
+	sendSpace *int32
+	// readyToSend is used to notify sending components that there is free space.
+	readyToSend chan struct{}
+	// wakeSender is used to wake a sender in case the sendSpace or the
+	// congestion window was depleted and the sender is waiting.
+	wakeSender chan struct{}
+
+	// recvQueue holds the messages that are waiting to be processed.
+	recvQueue chan *Msg
+	// reportedSpace indicates the amount of free slots that the other end knows
+	// about.
+	reportedSpace *int32
+	// spaceReportLock locks the calculation of space to report.
+	spaceReportLock sync.Mutex
+	// forceSpaceReport forces the sender to send a space report.
+	forceSpaceReport chan struct{}
+	// receivedProbe holds the last received probe that needs to be echoed.
+	receivedProbe *uint64
+
+	// flush is used to send a finish function to the handler, which will write
+	// all pending messages and then call the received function.
+	flush chan func()
+
+	// congestionLock locks all congestion control fields below.
+	congestionLock sync.Mutex
+	// window is the congestion window in number of messages.
+	window float64
+	// slowStart specifies whether the window is still in the slow start phase.
+	slowStart bool
+	// sentSeq is the sequence number of the last sent message.
+	sentSeq uint64
+	// ackedSeq is the sequence number of the last acknowledged message.
+	ackedSeq uint64
+	// probes holds the outstanding probes.
+	probes []cfqProbe
+	// nextAdjustSeq is the sequence number that needs to be acknowledged before
+	// the window is adjusted again.
+	nextAdjustSeq uint64
+	// baseRTT is the lowest seen round trip time.
+	baseRTT time.Duration
+	// baseRTTSetAt is when the base RTT was last set.
+	baseRTTSetAt time.Time
+	// rtt is the smoothed round trip time.
+	rtt time.Duration
+
+	// sampler takes samples for passive flow estimation.
+	sampler flowSampler
+}
+
+type cfqProbe struct {
+	seq  uint64
+	sent time.Time
+}
+
+// RequestCongestionControl requests the congestion aware flow control, if
+// the given remote Hub and the given relay Hub support it. The relay Hub
+// forwards the terminal to the remote Hub and applies the flow control too.
+func (opts *TerminalOpts) RequestCongestionControl(remoteHub, relayHub *hub.Hub) {
+	for _, h := range []*hub.Hub{remoteHub, relayHub} {
+		if h == nil ||
+			h.Status == nil ||
+			!h.Status.HasFlag(hub.FlagCongestionControl) {
+			return
+		}
+	}
+
+	if opts.Version < conf.VersionTwo {
+		opts.Version = conf.VersionTwo
+	}
+	opts.Features |= FeatureCongestionControl
+	opts.FlowControl = FlowControlCongestion
+}
+
+// NewCongestionFlowQueue returns a new congestion flow queue.
+func NewCongestionFlowQueue(
+	ctx context.Context,
+	queueSize uint32,
+	submitUpstream func(msg *Msg, timeout time.Duration),
+) *CongestionFlowQueue {
+	cfq := &CongestionFlowQueue{
+		ctx:              ctx,
+		submitUpstream:   submitUpstream,
+		sendQueue:        make(chan *Msg, queueSize),
+		prioMsgs:         new(int32),
+		sendSpace:        new(int32),
+		readyToSend:      make(chan struct{}),
+		wakeSender:       make(chan struct{}, 1),
+		recvQueue:        make(chan *Msg, queueSize),
+		reportedSpace:    new(int32),
+		forceSpaceReport: make(chan struct{}, 1),
+		receivedProbe:    new(uint64),
+		flush:            make(chan func()),
+		window:           cfqInitialWindow,
+		slowStart:        true,
+	}
+	atomic.StoreInt32(cfq.sendSpace, int32(queueSize))
+	atomic.StoreInt32(cfq.reportedSpace, int32(queueSize))
+
+	return cfq
+}
+
+// StartWorkers starts the necessary workers to operate the flow queue.
+func (cfq *CongestionFlowQueue) StartWorkers(m *modules.Module, terminalName string) {
+	m.StartWorker(terminalName+" flow queue", cfq.FlowHandler)
+}
+
+// shouldReportRecvSpace returns whether the receive space should be reported.
+func (cfq *CongestionFlowQueue) shouldReportRecvSpace() bool {
+	return atomic.LoadInt32(cfq.reportedSpace) < int32(float32(cap(cfq.recvQueue))*forceReportBelowPercent)
+}
+
+// decrementReportedRecvSpace decreases the reported recv space by 1 and
+// returns if the receive space should be reported.
+func (cfq *CongestionFlowQueue) decrementReportedRecvSpace() (shouldReportRecvSpace bool) {
+	return atomic.AddInt32(cfq.reportedSpace, -1) < int32(float32(cap(cfq.recvQueue))*forceReportBelowPercent)
+}
+
+// reportableRecvSpace returns how much free space can be reported to the other
+// end. The returned number must be communicated to the other end and must not
+// be ignored.
+func (cfq *CongestionFlowQueue) reportableRecvSpace() int32 {
+	// See DuplexFlowQueue.reportableRecvSpace for details.
+	cfq.spaceReportLock.Lock()
+	defer cfq.spaceReportLock.Unlock()
+
+	reportedSpace := atomic.LoadInt32(cfq.reportedSpace)
+	toReport := int32(cap(cfq.recvQueue)-len(cfq.recvQueue)) - reportedSpace
+	if toReport <= 1 {
+		return 0
+	}
+
+	atomic.AddInt32(cfq.reportedSpace, toReport)
+	return toReport
+}
+
+// wake wakes the sender in case it is waiting.
+func (cfq *CongestionFlowQueue) wake() {
+	select {
+	case cfq.wakeSender <- struct{}{}:
+	default:
+	}
+}
+
+// canSend returns whether there is both send space and room in the congestion
+// window. If the congestion window is the limiting factor, windowFull is true.
+func (cfq *CongestionFlowQueue) canSend() (ok, windowFull bool) {
+	if atomic.LoadInt32(cfq.sendSpace) <= 0 {
+		return false, false
+	}
+
+	cfq.congestionLock.Lock()
+	defer cfq.congestionLock.Unlock()
+
+	if float64(cfq.sentSeq-cfq.ackedSeq) >= cfq.window {
+		return false, true
+	}
+	return true, false
+}
+
+// nextSeq registers a new message for sending and returns its sequence number
+// and whether it should be sent as a probe.
+func (cfq *CongestionFlowQueue) nextSeq() (seq uint64, probe bool) {
+	cfq.congestionLock.Lock()
+	defer cfq.congestionLock.Unlock()
+
+	cfq.sentSeq++
+	seq = cfq.sentSeq
+
+	// Check if a new probe should be sent.
+	probeInterval := uint64(cfq.window / cfqProbesPerRTT)
+	switch {
+	case len(cfq.probes) == 0:
+		probe = true
+	case seq-cfq.probes[len(cfq.probes)-1].seq >= probeInterval:
+		probe = true
+	}
+	if probe {
+		cfq.probes = append(cfq.probes, cfqProbe{
+			seq:  seq,
+			sent: time.Now(),
+		})
+	}
+
+	return seq, probe
+}
+
+// probeLastSeq registers a probe for the last sent message, if there are no
+// outstanding probes. This is used to get an acknowledgement when the
+// congestion window is full.
+func (cfq *CongestionFlowQueue) probeLastSeq() (seq uint64, ok bool) {
+	cfq.congestionLock.Lock()
+	defer cfq.congestionLock.Unlock()
+
+	if len(cfq.probes) > 0 || cfq.sentSeq == cfq.ackedSeq {
+		return 0, false
+	}
+
+	cfq.probes = append(cfq.probes, cfqProbe{
+		seq:  cfq.sentSeq,
+		sent: time.Now(),
+	})
+	return cfq.sentSeq, true
+}
+
+// handleEcho handles an echoed probe from the other end.
+func (cfq *CongestionFlowQueue) handleEcho(seq uint64) {
+	cfq.congestionLock.Lock()
+	defer cfq.congestionLock.Unlock()
+
+	// Find and remove acknowledged probes.
+	var sample time.Duration
+	for len(cfq.probes) > 0 && cfq.probes[0].seq <= seq {
+		if cfq.probes[0].seq == seq {
+			sample = time.Since(cfq.probes[0].sent)
+		}
+		cfq.probes = cfq.probes[1:]
+	}
+	if seq > cfq.ackedSeq {
+		cfq.ackedSeq = seq
+	}
+	cfq.sampler.ack(seq)
+	if sample <= 0 {
+		return
+	}
+	if estimator := cfq.sampler.estimator.Load(); estimator != nil {
+		estimator.AddRTTSample(sample)
+	}
+
+	// Update round trip times.
+	now := time.Now()
+	if cfq.baseRTT == 0 || sample < cfq.baseRTT || now.Sub(cfq.baseRTTSetAt) > cfqBaseRTTMaxAge {
+		cfq.baseRTT = sample
+		cfq.baseRTTSetAt = now
+	}
+	if cfq.rtt == 0 {
+		cfq.rtt = sample
+	} else {
+		cfq.rtt = (7*cfq.rtt + sample) / 8
+	}
+
+	// Adjust window only once per round trip.
+	if seq < cfq.nextAdjustSeq {
+		return
+	}
+	cfq.nextAdjustSeq = cfq.sentSeq + 1
+
+	// Calculate how many messages are queued in the network.
+	queued := cfq.window * (1 - float64(cfq.baseRTT)/float64(sample))
+
+	// Adjust window.
+	switch {
+	case cfq.slowStart && queued > cfqGamma:
+		cfq.slowStart = false
+		cfq.window -= cfq.window / 8
+	case cfq.slowStart:
+		cfq.window *= 2
+	case queued < cfqAlpha:
+		cfq.window++
+	case queued > cfqBeta:
+		cfq.window--
+	}
+
+	// Keep window in bounds.
+	maxWindow := float64(cap(cfq.recvQueue))
+	switch {
+	case cfq.window < cfqMinWindow:
+		cfq.window = cfqMinWindow
+	case cfq.window > maxWindow:
+		cfq.window = maxWindow
+		cfq.slowStart = false
+	}
+}
+
+// sendReport sends a message without data that reports receive space and
+// echoes probes, if there is anything to report.
+func (cfq *CongestionFlowQueue) sendReport(probe uint64) {
+	spaceToReport := cfq.reportableRecvSpace()
+	echo := atomic.SwapUint64(cfq.receivedProbe, 0)
+	if spaceToReport <= 0 && echo == 0 && probe == 0 {
+		return
+	}
+
+	msg := NewMsg(cfqHeader(spaceToReport, echo, probe))
+	cfq.submitUpstream(msg, 0)
+}
+
+func cfqHeader(space int32, echo, probe uint64) []byte {
+	header := varint.Pack32(uint32(space))
+	header = append(header, varint.Pack64(echo)...)
+	return append(header, varint.Pack64(probe)...)
+}
+
+// FlowHandler handles all flow queue internals and must be started as a worker
+// in the module where it is used.
+func (cfq *CongestionFlowQueue) FlowHandler(_ context.Context) error {
+	// The upstreamSender is started by the terminal module, but is tied to the
+	// flow owner instead. Make sure that the flow owner's module depends on the
+	// terminal module so that it is shut down earlier.
+
+	var flushFinished func()
+
+	// Drain all queues when shutting down.
+	defer func() {
+		for {
+			select {
+			case msg := <-cfq.sendQueue:
+				msg.Finish()
+			case msg := <-cfq.recvQueue:
+				msg.Finish()
+			default:
+				return
+			}
+		}
+	}()
+
+sending:
+	for {
+		// If we cannot send, wait to be woken.
+		if ok, windowFull := cfq.canSend(); !ok {
+			// Request an acknowledgement if the window is full.
+			if windowFull {
+				if probe, ok := cfq.probeLastSeq(); ok {
+					cfq.sendReport(probe)
+				}
+			}
+
+			select {
+			case <-cfq.wakeSender:
+				continue sending
+
+			case <-cfq.forceSpaceReport:
+				// Forced reporting of space and echoing of probes.
+				// We do not need to check if there is enough sending space, as there is
+				// no data included.
+				cfq.sendReport(0)
+				continue sending
+
+			case <-cfq.ctx.Done():
+				return nil
+			}
+		}
+
+		// Get message from send queue.
+
+		select {
+		case cfq.readyToSend <- struct{}{}:
+			// Notify that we are ready to send.
+
+		case msg := <-cfq.sendQueue:
+			// Send message from queue.
+
+			// If nil, the queue is being shut down.
+			if msg == nil {
+				return nil
+			}
+
+			// Check if we are handling a high priority message or waiting for one.
+			// Mark any msgs as high priority, when there is one in the pipeline.
+			remainingPrioMsgs := atomic.AddInt32(cfq.prioMsgs, -1)
+			switch {
+			case remainingPrioMsgs >= 0:
+				msg.Unit.MakeHighPriority()
+			case remainingPrioMsgs < -30_000:
+				// Prevent wrap to positive.
+				// Compatible with int16 or bigger.
+				atomic.StoreInt32(cfq.prioMsgs, 0)
+			}
+
+			// Wait for processing slot.
+			msg.Unit.WaitForSlot()
+
+			// Prepend header.
+			seq, isProbe := cfq.nextSeq()
+			var probe uint64
+			if isProbe {
+				probe = seq
+			}
+			msg.Data.Prepend(cfqHeader(
+				cfq.reportableRecvSpace(),
+				atomic.SwapUint64(cfq.receivedProbe, 0),
+				probe,
+			))
+
+			// Submit for sending upstream.
+			cfq.sampler.sent(msg.Data.Length(), len(cfq.sendQueue) == 0)
+			cfq.submitUpstream(msg, 0)
+			// Decrease the send space.
+			atomic.AddInt32(cfq.sendSpace, -1)
+
+			// Check if the send queue is empty now and signal flushers.
+			if flushFinished != nil && len(cfq.sendQueue) == 0 {
+				flushFinished()
+				flushFinished = nil
+			}
+
+		case <-cfq.wakeSender:
+			// Space was added or messages were acknowledged, nothing to do.
+
+		case <-cfq.forceSpaceReport:
+			// Forced reporting of space and echoing of probes.
+			cfq.sendReport(0)
+
+		case newFlushFinishedFn := <-cfq.flush:
+			// Signal immediately if send queue is empty.
+			if len(cfq.sendQueue) == 0 {
+				newFlushFinishedFn()
+			} else {
+				// If there already is a flush finished function, stack them.
+				if flushFinished != nil {
+					stackedFlushFinishFn := flushFinished
+					flushFinished = func() {
+						stackedFlushFinishFn()
+						newFlushFinishedFn()
+					}
+				} else {
+					flushFinished = newFlushFinishedFn
+				}
+			}
+
+		case <-cfq.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Flush waits for all waiting data to be sent.
+func (cfq *CongestionFlowQueue) Flush(timeout time.Duration) {
+	// Create channel and function for notifying.
+	wait := make(chan struct{})
+	finished := func() {
+		close(wait)
+	}
+	// Request flush and return when stopping.
+	select {
+	case cfq.flush <- finished:
+	case <-cfq.ctx.Done():
+		return
+	case <-TimedOut(timeout):
+		return
+	}
+	// Wait for flush to finish and return when stopping.
+	select {
+	case <-wait:
+	case <-cfq.ctx.Done():
+	case <-TimedOut(timeout):
+	}
+}
+
+// ReadyToSend returns a channel that can be read when data can be sent.
+func (cfq *CongestionFlowQueue) ReadyToSend() <-chan struct{} {
+	if ok, _ := cfq.canSend(); ok {
+		return ready
+	}
+	return cfq.readyToSend
+}
+
+// Send adds the given container to the send queue.
+func (cfq *CongestionFlowQueue) Send(msg *Msg, timeout time.Duration) *Error {
+	select {
+	case cfq.sendQueue <- msg:
+		if msg.Unit.IsHighPriority() {
+			// Reset prioMsgs to the current queue size, so that all waiting and the
+			// message we just added are all handled as high priority.
+			atomic.StoreInt32(cfq.prioMsgs, int32(len(cfq.sendQueue)))
+		}
+		return nil
+
+	case <-TimedOut(timeout):
+		msg.Finish()
+		return ErrTimeout
+
+	case <-cfq.ctx.Done():
+		msg.Finish()
+		return ErrStopping
+	}
+}
+
+// Receive receives a container from the recv queue.
+func (cfq *CongestionFlowQueue) Receive() <-chan *Msg {
+	// If the reported recv space is nearing its end, force a report.
+	if cfq.shouldReportRecvSpace() {
+		select {
+		case cfq.forceSpaceReport <- struct{}{}:
+		default:
+		}
+	}
+
+	return cfq.recvQueue
+}
+
+// Deliver submits a container for receiving from upstream.
+func (cfq *CongestionFlowQueue) Deliver(msg *Msg) *Error {
+	// Ignore nil containers.
+	if msg == nil || msg.Data == nil {
+		msg.Finish()
+		return ErrMalformedData.With("no data")
+	}
+
+	// Parse header.
+	addSpace, err := msg.Data.GetNextN32()
+	if err != nil {
+		msg.Finish()
+		return ErrMalformedData.With("failed to parse reported space: %w", err)
+	}
+	echo, err := msg.Data.GetNextN64()
+	if err != nil {
+		msg.Finish()
+		return ErrMalformedData.With("failed to parse echoed probe: %w", err)
+	}
+	probe, err := msg.Data.GetNextN64()
+	if err != nil {
+		msg.Finish()
+		return ErrMalformedData.With("failed to parse probe: %w", err)
+	}
+
+	// Handle reported space and echoed probes.
+	if addSpace > 0 {
+		atomic.AddInt32(cfq.sendSpace, int32(addSpace))
+	}
+	if echo > 0 {
+		cfq.handleEcho(echo)
+	}
+	if addSpace > 0 || echo > 0 {
+		cfq.wake()
+	}
+
+	// Echo probes as soon as possible.
+	if probe > 0 {
+		atomic.StoreUint64(cfq.receivedProbe, probe)
+		select {
+		case cfq.forceSpaceReport <- struct{}{}:
+		default:
+		}
+	}
+
+	// Abort processing if the container only contained a report.
+	if !msg.Data.HoldsData() {
+		msg.Finish()
+		return nil
+	}
+
+	select {
+	case cfq.recvQueue <- msg:
+
+		// If the recv queue accepted the Container, decrement the recv space.
+		shouldReportRecvSpace := cfq.decrementReportedRecvSpace()
+		// If the reported recv space is nearing its end, force a report, if the
+		// sender worker is idle.
+		if shouldReportRecvSpace {
+			select {
+			case cfq.forceSpaceReport <- struct{}{}:
+			default:
+			}
+		}
+
+		return nil
+	default:
+		// If the recv queue is full, return an error.
+		// The whole point of the flow queue is to guarantee that this never happens.
+		msg.Finish()
+		return ErrQueueOverflow
+	}
+}
+
+// Window returns the current congestion window in number of messages.
+func (cfq *CongestionFlowQueue) Window() int {
+	cfq.congestionLock.Lock()
+	defer cfq.congestionLock.Unlock()
+
+	return int(cfq.window)
+}
+
+// RTT returns the smoothed round trip time. Returns zero if no round trip
+// time has been measured yet.
+func (cfq *CongestionFlowQueue) RTT() time.Duration {
+	cfq.congestionLock.Lock()
+	defer cfq.congestionLock.Unlock()
+
+	return cfq.rtt
+}
+
+// SetFlowEstimator sets the flow estimator that is fed with samples of the
+// flow control traffic.
+func (cfq *CongestionFlowQueue) SetFlowEstimator(fe *FlowEstimator) {
+	cfq.sampler.setEstimator(fe)
+}
+
+// FlowStats returns a k=v formatted string of internal stats.
+func (cfq *CongestionFlowQueue) FlowStats() string {
+	cfq.congestionLock.Lock()
+	defer cfq.congestionLock.Unlock()
+
+	return fmt.Sprintf(
+		"sq=%d rq=%d sends=%d reps=%d cwnd=%.1f inflight=%d rtt=%s basertt=%s",
+		len(cfq.sendQueue),
+		len(cfq.recvQueue),
+		atomic.LoadInt32(cfq.sendSpace),
+		atomic.LoadInt32(cfq.reportedSpace),
+		cfq.window,
+		cfq.sentSeq-cfq.ackedSeq,
+		cfq.rtt,
+		cfq.baseRTT,
+	)
+}
+
+// RecvQueueLen returns the current length of the receive queue.
+func (cfq *CongestionFlowQueue) RecvQueueLen() int {
+	return len(cfq.recvQueue)
+}
+
+// SendQueueLen returns the current length of the send queue.
+func (cfq *CongestionFlowQueue) SendQueueLen() int {
+	return len(cfq.sendQueue)
+}
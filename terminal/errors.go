@@ -212,8 +212,11 @@ var (
 	ErrHubUnavailable         = registerError(101, errors.New("hub unavailable"))
 	ErrAbandonedTerminal      = registerError(102, errors.New("terminal is being abandoned"))
 	ErrShipSunk               = registerError(108, errors.New("ship sunk"))
+	ErrPeerUnresponsive       = registerError(112, errors.New("peer unresponsive"))
 	ErrDestinationUnavailable = registerError(113, errors.New("destination unavailable"))
 	ErrTryAgainLater          = registerError(114, errors.New("try again later"))
+	ErrQuotaExceeded          = registerError(115, errors.New("quota exceeded"))
+	ErrHubDraining            = registerError(116, errors.New("hub is draining"))
 	ErrConnectionError        = registerError(121, errors.New("connection error"))
 	ErrQueueOverflow          = registerError(122, errors.New("queue overflowed"))
 	ErrCanceled               = registerError(125, context.Canceled)
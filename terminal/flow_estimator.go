@@ -0,0 +1,308 @@
+package terminal
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+
+Passive Flow Estimation:
+
+Flow queues know when the other end has processed the messages they sent: the
+duplex flow queue through reported receive space, the congestion flow queue
+through echoed probes. Some of the sent messages are remembered as samples.
+When a sample is acknowledged, the time since it was sent is a round trip time
+sample and the amount of data acknowledged since the sample was sent yields a
+delivery rate sample.
+
+As the acknowledgement may be delayed by the other end, the lowest round trip
+time within the estimation window is used. As the delivery rate is limited by
+the amount of data there is to send, the highest delivery rate within the
+estimation window is used. Delivery rates measured while there was nothing
+more to send (application limited) are only used if they are higher than the
+current estimate.
+
+*/
+
+// Flow Estimation Configuration.
+const (
+	// FlowEstimationWindow defines the time window in which samples are
+	// considered. Estimations are based on at most two windows of samples.
+	FlowEstimationWindow = 1 * time.Minute
+
+	flowSampleInterval   = 10 * time.Millisecond
+	flowMaxOpenSamples   = 64
+	flowMinDeliveryBytes = 16384
+)
+
+// FlowEstimator passively estimates the round trip time and the throughput of
+// a connection from the flow control traffic of the flow queues using it.
+// It may be shared by multiple flow queues using the same connection.
+type FlowEstimator struct {
+	lock sync.Mutex
+
+	rtt         windowedFilter
+	rttSampleAt time.Time
+
+	deliveryRate         windowedFilter
+	deliveryRateSampleAt time.Time
+	saturatedAt          time.Time
+}
+
+// NewFlowEstimator returns a new flow estimator.
+func NewFlowEstimator() *FlowEstimator {
+	return &FlowEstimator{
+		rtt:          windowedFilter{keepLowest: true},
+		deliveryRate: windowedFilter{},
+	}
+}
+
+// AddRTTSample adds a round trip time sample.
+func (fe *FlowEstimator) AddRTTSample(rtt time.Duration) {
+	if rtt <= 0 {
+		return
+	}
+
+	fe.lock.Lock()
+	defer fe.lock.Unlock()
+
+	now := time.Now()
+	fe.rtt.add(float64(rtt), now)
+	fe.rttSampleAt = now
+}
+
+// AddDeliverySample adds a delivery rate sample of the given amount of bytes
+// that were delivered in the given time. If the sender was application
+// limited, ie. did not have more data to send, the sample is only used if it
+// raises the estimate.
+func (fe *FlowEstimator) AddDeliverySample(bytes uint64, elapsed time.Duration, appLimited bool) {
+	if bytes < flowMinDeliveryBytes || elapsed <= 0 {
+		return
+	}
+	rate := float64(bytes) / elapsed.Seconds()
+
+	fe.lock.Lock()
+	defer fe.lock.Unlock()
+
+	now := time.Now()
+	if appLimited && rate <= fe.deliveryRate.get(now) {
+		return
+	}
+	fe.deliveryRate.add(rate, now)
+	fe.deliveryRateSampleAt = now
+	if !appLimited {
+		fe.saturatedAt = now
+	}
+}
+
+// RTT returns the estimated round trip time and when the last sample was
+// added. Returns zero if there are no samples within the estimation window.
+func (fe *FlowEstimator) RTT() (rtt time.Duration, sampledAt time.Time) {
+	fe.lock.Lock()
+	defer fe.lock.Unlock()
+
+	return time.Duration(fe.rtt.get(time.Now())), fe.rttSampleAt
+}
+
+// Throughput returns the estimated throughput in bit/s and when the last
+// sample was added. Saturated reports whether the connection was fully used
+// within the estimation window. If not, the throughput is only a lower bound.
+// Returns zero if there are no samples within the estimation window.
+func (fe *FlowEstimator) Throughput() (bitRate int, saturated bool, sampledAt time.Time) {
+	fe.lock.Lock()
+	defer fe.lock.Unlock()
+
+	now := time.Now()
+	bitRate = int(fe.deliveryRate.get(now) * 8)
+	saturated = now.Sub(fe.saturatedAt) < 2*FlowEstimationWindow
+	return bitRate, saturated, fe.deliveryRateSampleAt
+}
+
+// windowedFilter keeps the lowest or highest value of the current and the
+// previous estimation window.
+type windowedFilter struct {
+	keepLowest bool
+
+	current        float64
+	currentStarted time.Time
+	previous       float64
+}
+
+func (wf *windowedFilter) rotate(now time.Time) {
+	switch {
+	case now.Sub(wf.currentStarted) >= 2*FlowEstimationWindow:
+		// Both windows expired.
+		wf.previous = 0
+		wf.current = 0
+		wf.currentStarted = now
+	case now.Sub(wf.currentStarted) >= FlowEstimationWindow:
+		wf.previous = wf.current
+		wf.current = 0
+		wf.currentStarted = now
+	}
+}
+
+func (wf *windowedFilter) add(value float64, now time.Time) {
+	wf.rotate(now)
+	if wf.current == 0 || wf.better(value, wf.current) {
+		wf.current = value
+	}
+}
+
+func (wf *windowedFilter) get(now time.Time) float64 {
+	wf.rotate(now)
+	switch {
+	case wf.current == 0:
+		return wf.previous
+	case wf.previous == 0:
+		return wf.current
+	case wf.better(wf.previous, wf.current):
+		return wf.previous
+	default:
+		return wf.current
+	}
+}
+
+func (wf *windowedFilter) better(a, b float64) bool {
+	if wf.keepLowest {
+		return a < b
+	}
+	return a > b
+}
+
+// flowSampler takes samples of sent messages for a flow estimator.
+type flowSampler struct {
+	estimator atomic.Pointer[FlowEstimator]
+
+	lock sync.Mutex
+	// sentMsgs and sentBytes hold the amount of sent messages and bytes.
+	sentMsgs  uint64
+	sentBytes uint64
+	// ackedMsgs holds the amount of acknowledged messages.
+	ackedMsgs uint64
+	// ackedBytes and ackedAt hold the sent bytes and time of the last
+	// acknowledged sample.
+	ackedBytes uint64
+	ackedAt    time.Time
+	// busySince holds when the sender started sending after everything was
+	// acknowledged.
+	busySince time.Time
+	// samples holds the samples that were not yet acknowledged.
+	samples []flowSample
+}
+
+type flowSample struct {
+	msgs       uint64
+	bytes      uint64
+	sentAt     time.Time
+	ackedBytes uint64
+	ackedAt    time.Time
+	appLimited bool
+}
+
+func (fs *flowSampler) setEstimator(fe *FlowEstimator) {
+	fs.estimator.Store(fe)
+}
+
+// sent registers a sent message with the given size. If there is nothing more
+// to send, appLimited must be true.
+func (fs *flowSampler) sent(size int, appLimited bool) {
+	if fs.estimator.Load() == nil {
+		return
+	}
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	now := time.Now()
+	if fs.sentMsgs == fs.ackedMsgs {
+		fs.busySince = now
+	}
+	fs.sentMsgs++
+	fs.sentBytes += uint64(size)
+
+	// Take a sample, if due.
+	if len(fs.samples) >= flowMaxOpenSamples {
+		return
+	}
+	if len(fs.samples) > 0 && now.Sub(fs.samples[len(fs.samples)-1].sentAt) < flowSampleInterval {
+		return
+	}
+	sample := flowSample{
+		msgs:       fs.sentMsgs,
+		bytes:      fs.sentBytes,
+		sentAt:     now,
+		ackedBytes: fs.ackedBytes,
+		ackedAt:    fs.ackedAt,
+		appLimited: appLimited,
+	}
+	// Do not measure delivery across idle periods.
+	if sample.ackedAt.Before(fs.busySince) {
+		sample.ackedAt = fs.busySince
+	}
+	fs.samples = append(fs.samples, sample)
+}
+
+// ackMore registers the given amount of messages as acknowledged.
+func (fs *flowSampler) ackMore(n uint64) {
+	if fs.estimator.Load() == nil {
+		return
+	}
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	fs.ackUpTo(fs.ackedMsgs + n)
+}
+
+// ack registers all messages up to the given message number as acknowledged.
+func (fs *flowSampler) ack(msgs uint64) {
+	if fs.estimator.Load() == nil {
+		return
+	}
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	fs.ackUpTo(msgs)
+}
+
+func (fs *flowSampler) ackUpTo(msgs uint64) {
+	if msgs <= fs.ackedMsgs {
+		return
+	}
+	if msgs > fs.sentMsgs {
+		msgs = fs.sentMsgs
+	}
+	fs.ackedMsgs = msgs
+
+	// Find the latest acknowledged sample.
+	var (
+		sample flowSample
+		found  bool
+	)
+	for len(fs.samples) > 0 && fs.samples[0].msgs <= msgs {
+		sample = fs.samples[0]
+		found = true
+		fs.samples = fs.samples[1:]
+	}
+	if !found {
+		return
+	}
+
+	// Submit samples to estimator.
+	now := time.Now()
+	estimator := fs.estimator.Load()
+	estimator.AddRTTSample(now.Sub(sample.sentAt))
+	if !sample.ackedAt.IsZero() {
+		estimator.AddDeliverySample(
+			sample.bytes-sample.ackedBytes,
+			now.Sub(sample.ackedAt),
+			sample.appLimited,
+		)
+	}
+	fs.ackedBytes = sample.bytes
+	fs.ackedAt = now
+}
@@ -0,0 +1,103 @@
+package terminal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlowEstimator(t *testing.T) {
+	t.Parallel()
+
+	fe := NewFlowEstimator()
+	rtt, _ := fe.RTT()
+	assert.Equal(t, time.Duration(0), rtt, "rtt should be zero without samples")
+
+	// The lowest rtt within the window is used.
+	fe.AddRTTSample(30 * time.Millisecond)
+	fe.AddRTTSample(10 * time.Millisecond)
+	fe.AddRTTSample(20 * time.Millisecond)
+	rtt, sampledAt := fe.RTT()
+	assert.Equal(t, 10*time.Millisecond, rtt, "lowest rtt should be used")
+	assert.WithinDuration(t, time.Now(), sampledAt, time.Second)
+
+	// Application limited samples are a lower bound only.
+	fe.AddDeliverySample(100000, time.Second, true)
+	bitRate, saturated, _ := fe.Throughput()
+	assert.Equal(t, 800000, bitRate)
+	assert.False(t, saturated, "app limited samples should not saturate")
+	fe.AddDeliverySample(50000, time.Second, true)
+	bitRate, _, _ = fe.Throughput()
+	assert.Equal(t, 800000, bitRate, "lower app limited samples should be ignored")
+
+	// The highest delivery rate within the window is used.
+	fe.AddDeliverySample(200000, time.Second, false)
+	fe.AddDeliverySample(150000, time.Second, false)
+	bitRate, saturated, _ = fe.Throughput()
+	assert.Equal(t, 1600000, bitRate, "highest delivery rate should be used")
+	assert.True(t, saturated)
+
+	// Tiny samples are too noisy to be used.
+	fe.AddDeliverySample(1000, time.Millisecond, false)
+	bitRate, _, _ = fe.Throughput()
+	assert.Equal(t, 1600000, bitRate, "tiny samples should be ignored")
+}
+
+func TestWindowedFilter(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+	wf := windowedFilter{keepLowest: true}
+	wf.add(20, start)
+	wf.add(30, start.Add(FlowEstimationWindow/2))
+	assert.Equal(t, float64(20), wf.get(start.Add(FlowEstimationWindow/2)))
+
+	// The previous window is still considered after a rotation.
+	wf.add(40, start.Add(FlowEstimationWindow))
+	assert.Equal(t, float64(20), wf.get(start.Add(FlowEstimationWindow)))
+
+	// Samples expire after two windows.
+	assert.Equal(t, float64(40), wf.get(start.Add(2*FlowEstimationWindow)))
+	assert.Equal(t, float64(0), wf.get(start.Add(4*FlowEstimationWindow)))
+}
+
+func TestFlowSampler(t *testing.T) {
+	t.Parallel()
+
+	var fs flowSampler
+
+	// Nothing is sampled without an estimator.
+	fs.sent(1000, false)
+	assert.Empty(t, fs.samples)
+
+	fe := NewFlowEstimator()
+	fs.setEstimator(fe)
+
+	// Send a first batch.
+	fs.sent(10000, false)
+	time.Sleep(2 * flowSampleInterval)
+	for i := 0; i < 10; i++ {
+		fs.sent(10000, false)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// The first acknowledgement yields an rtt sample.
+	fs.ackMore(1)
+	rtt, _ := fe.RTT()
+	assert.GreaterOrEqual(t, rtt, 20*time.Millisecond, "rtt should be sampled")
+	bitRate, _, _ := fe.Throughput()
+	assert.Zero(t, bitRate, "first ack should not yield a delivery rate")
+
+	// Acknowledging the rest yields a delivery rate.
+	time.Sleep(10 * time.Millisecond)
+	fs.ack(11)
+	bitRate, saturated, _ := fe.Throughput()
+	assert.Positive(t, bitRate, "delivery rate should be sampled")
+	assert.True(t, saturated)
+	assert.Empty(t, fs.samples, "all samples should be acknowledged")
+
+	// Acknowledging more than was sent is ignored.
+	fs.ackMore(100)
+	assert.Equal(t, uint64(11), fs.ackedMsgs)
+}
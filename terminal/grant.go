@@ -0,0 +1,154 @@
+package terminal
+
+import (
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Grant is the access granted to a terminal, consisting of permissions and
+// the scope in which they may be used. Zero values of the scope fields do not
+// restrict access.
+type Grant struct {
+	// Permission holds the granted permissions.
+	Permission Permission
+
+	// Ports holds the destination ports that may be connected to.
+	Ports []uint16
+	// Countries holds the country codes of destinations that may be connected to.
+	Countries []string
+
+	// MaxOps defines how many operations may be active at the same time.
+	MaxOps int
+	// Expires defines when the grant expires.
+	// No new operations may be started after that.
+	Expires time.Time
+	// ByteQuota defines how many bytes may be sent and received by the
+	// terminal. The terminal is abandoned when the quota is exceeded.
+	ByteQuota uint64
+}
+
+// AllowsPort returns whether the grant allows connecting to the given port.
+func (g *Grant) AllowsPort(port uint16) bool {
+	return len(g.Ports) == 0 || slices.Contains(g.Ports, port)
+}
+
+// AllowsCountry returns whether the grant allows connecting to destinations
+// in the given country.
+func (g *Grant) AllowsCountry(countryCode string) bool {
+	if len(g.Countries) == 0 {
+		return true
+	}
+	for _, allowed := range g.Countries {
+		if strings.EqualFold(allowed, countryCode) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired returns whether the grant has expired.
+func (g *Grant) Expired() bool {
+	return !g.Expires.IsZero() && time.Now().After(g.Expires)
+}
+
+func (g *Grant) clone() *Grant {
+	cloned := *g
+	cloned.Ports = slices.Clone(g.Ports)
+	cloned.Countries = slices.Clone(g.Countries)
+	return &cloned
+}
+
+// combine returns a new grant that allows everything that is allowed by either
+// of the grants.
+func (g *Grant) combine(other *Grant) *Grant {
+	combined := &Grant{
+		Permission: g.Permission | other.Permission,
+	}
+
+	// Combine allow lists. An empty list allows everything.
+	if len(g.Ports) > 0 && len(other.Ports) > 0 {
+		combined.Ports = append(slices.Clone(g.Ports), other.Ports...)
+		slices.Sort(combined.Ports)
+		combined.Ports = slices.Compact(combined.Ports)
+	}
+	if len(g.Countries) > 0 && len(other.Countries) > 0 {
+		combined.Countries = append(slices.Clone(g.Countries), other.Countries...)
+		slices.Sort(combined.Countries)
+		combined.Countries = slices.Compact(combined.Countries)
+	}
+
+	// Combine limits. A zero value is unlimited.
+	if g.MaxOps > 0 && other.MaxOps > 0 {
+		combined.MaxOps = max(g.MaxOps, other.MaxOps)
+	}
+	if !g.Expires.IsZero() && !other.Expires.IsZero() {
+		combined.Expires = g.Expires
+		if other.Expires.After(g.Expires) {
+			combined.Expires = other.Expires
+		}
+	}
+	if g.ByteQuota > 0 && other.ByteQuota > 0 {
+		combined.ByteQuota = g.ByteQuota + other.ByteQuota
+	}
+
+	return combined
+}
+
+// ApplyGrant applies the given grant to the Terminal. If the Terminal already
+// has a grant, the grants are combined so that everything allowed by either of
+// them is allowed. Grants without permissions are ignored.
+func (t *TerminalBase) ApplyGrant(grant *Grant) {
+	if grant == nil || grant.Permission == NoPermission {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.grant == nil {
+		t.grant = grant.clone()
+	} else {
+		t.grant = t.grant.combine(grant)
+	}
+	t.permission = t.grant.Permission
+	t.byteQuota.Store(t.grant.ByteQuota)
+}
+
+// GetGrant returns the grant of the Terminal, or nil if nothing was granted.
+// The returned grant must not be modified.
+func (t *TerminalBase) GetGrant() *Grant {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.grant
+}
+
+// checkGrantScope checks if a new operation may be started within the scope
+// of the grant of the Terminal.
+func (t *TerminalBase) checkGrantScope() *Error {
+	grant := t.GetGrant()
+	if grant == nil {
+		return nil
+	}
+
+	switch {
+	case grant.Expired():
+		return ErrPermissionDenied.With("grant expired")
+	case grant.ByteQuota > 0 && t.usedBytes.Load() >= grant.ByteQuota:
+		return ErrQuotaExceeded
+	case grant.MaxOps > 0 && t.GetActiveOpCount() >= grant.MaxOps:
+		return ErrPermissionDenied.With("maximum of %d active operations reached", grant.MaxOps)
+	}
+	return nil
+}
+
+// addUsedBytes adds the given amount of bytes to the used bytes of the
+// Terminal and abandons the Terminal if the byte quota is exceeded.
+func (t *TerminalBase) addUsedBytes(n int) {
+	used := t.usedBytes.Add(uint64(n))
+	if quota := t.byteQuota.Load(); quota > 0 && used > quota {
+		t.Abandon(ErrQuotaExceeded)
+	}
+}
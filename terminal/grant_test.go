@@ -0,0 +1,84 @@
+package terminal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrantCombine(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	webOnly := &Grant{
+		Permission: MayConnect,
+		Ports:      []uint16{443, 80},
+		MaxOps:     10,
+		Expires:    now,
+		ByteQuota:  1000,
+	}
+
+	// Combining limited grants must extend the limits.
+	combined := webOnly.combine(&Grant{
+		Permission: MayExpand,
+		Ports:      []uint16{443, 8080},
+		Countries:  []string{"AT"},
+		MaxOps:     20,
+		Expires:    now.Add(time.Hour),
+		ByteQuota:  500,
+	})
+	assert.Equal(t, AddPermissions(MayConnect, MayExpand), combined.Permission, "permissions should be combined")
+	assert.Equal(t, []uint16{80, 443, 8080}, combined.Ports, "ports should be combined")
+	assert.Empty(t, combined.Countries, "countries should not be limited")
+	assert.Equal(t, 20, combined.MaxOps, "max ops should be raised")
+	assert.Equal(t, now.Add(time.Hour), combined.Expires, "expiry should be extended")
+	assert.Equal(t, uint64(1500), combined.ByteQuota, "byte quotas should be added")
+
+	// Combining with an unlimited grant must remove the limits.
+	combined = webOnly.combine(&Grant{Permission: MayConnect})
+	assert.Equal(t, &Grant{Permission: MayConnect}, combined, "limits should be removed")
+
+	// Check allow lists.
+	assert.True(t, webOnly.AllowsPort(443), "port should be allowed")
+	assert.False(t, webOnly.AllowsPort(22), "port should not be allowed")
+	assert.True(t, webOnly.AllowsCountry("DE"), "country should be allowed")
+	assert.True(t, (&Grant{Countries: []string{"DE"}}).AllowsCountry("de"), "country should be allowed")
+	assert.False(t, (&Grant{Countries: []string{"DE"}}).AllowsCountry("AT"), "country should not be allowed")
+}
+
+func TestGrantScope(t *testing.T) {
+	t.Parallel()
+
+	// Expired grants must not allow starting new operations.
+	a, b, err := NewSimpleTestTerminalPair(0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Abandon(nil)
+	defer b.Abandon(nil)
+	b.ApplyGrant(&Grant{
+		Permission: MayConnect,
+		Expires:    time.Now().Add(-time.Minute),
+	})
+	_, tErr := testRPCMethod.Call(context.Background(), a, &testRPCRequest{Message: "hello"})
+	assert.True(t, tErr.Is(ErrPermissionDenied), "call should be denied, but got %s", tErr)
+
+	// Terminals must be abandoned when exceeding the byte quota.
+	a, b, err = NewSimpleTestTerminalPair(0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Abandon(nil)
+	b.ApplyGrant(&Grant{
+		Permission: MayConnect,
+		ByteQuota:  10,
+	})
+	_, _ = testRPCMethod.Call(context.Background(), a, &testRPCRequest{Message: "hello"})
+	select {
+	case <-b.Ctx().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("terminal should be abandoned after exceeding the byte quota")
+	}
+}
@@ -0,0 +1,136 @@
+package terminal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/safing/spn/hub"
+)
+
+const (
+	// HeartbeatOpType is the type ID of the heartbeat operation.
+	HeartbeatOpType = "heartbeat"
+
+	defaultHeartbeatInterval  = 1 * time.Minute
+	defaultHeartbeatMaxMisses = 3
+
+	// heartbeatRTTSmoothing defines the weight of a new sample in the
+	// smoothed round trip time.
+	heartbeatRTTSmoothing = 0.125
+)
+
+type heartbeatRequest struct{}
+
+type heartbeatResponse struct{}
+
+var heartbeatMethod = &RPCMethod[heartbeatRequest, heartbeatResponse]{
+	Type:         HeartbeatOpType,
+	TrafficClass: TrafficClassControl,
+	Handler: func(_ Terminal, _ *heartbeatRequest) (*heartbeatResponse, *Error) {
+		return &heartbeatResponse{}, nil
+	},
+}
+
+func init() {
+	heartbeatMethod.Register()
+}
+
+// heartbeatState holds the round trip times measured by heartbeats.
+type heartbeatState struct {
+	lock sync.Mutex
+
+	latestRTT   time.Duration
+	smoothedRTT time.Duration
+}
+
+// RequestHeartbeat enables heartbeats with the default settings, if the given
+// remote Hub supports them.
+func (opts *TerminalOpts) RequestHeartbeat(remoteHub *hub.Hub) {
+	if remoteHub == nil ||
+		remoteHub.Status == nil ||
+		!remoteHub.Status.HasFlag(hub.FlagHeartbeat) {
+		return
+	}
+
+	if opts.HeartbeatInterval == 0 {
+		opts.HeartbeatInterval = defaultHeartbeatInterval
+	}
+}
+
+// HeartbeatRTT returns the latest and the smoothed round trip time measured by
+// heartbeats. It returns zero values if no heartbeat has been answered yet.
+func (t *TerminalBase) HeartbeatRTT() (latest, smoothed time.Duration) {
+	t.heartbeat.lock.Lock()
+	defer t.heartbeat.lock.Unlock()
+
+	return t.heartbeat.latestRTT, t.heartbeat.smoothedRTT
+}
+
+func (t *TerminalBase) addHeartbeatRTT(rtt time.Duration) {
+	if fe := t.flowEstimator.Load(); fe != nil {
+		fe.AddRTTSample(rtt)
+	}
+
+	t.heartbeat.lock.Lock()
+	defer t.heartbeat.lock.Unlock()
+
+	t.heartbeat.latestRTT = rtt
+	if t.heartbeat.smoothedRTT == 0 {
+		t.heartbeat.smoothedRTT = rtt
+	} else {
+		t.heartbeat.smoothedRTT += time.Duration(heartbeatRTTSmoothing * float64(rtt-t.heartbeat.smoothedRTT))
+	}
+}
+
+// heartbeater regularly checks if the remote terminal is still responsive and
+// abandons the terminal when too many heartbeats were missed in a row.
+func (t *TerminalBase) heartbeater(_ context.Context) error {
+	interval := t.opts.HeartbeatInterval
+	maxMisses := int(t.opts.HeartbeatMaxMisses)
+	if maxMisses == 0 {
+		maxMisses = defaultHeartbeatMaxMisses
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var misses int
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.ctx.Done():
+			return nil
+		}
+
+		// Only check terminals that are in use, so that heartbeats do not keep
+		// idle terminals from timing out.
+		if t.GetActiveOpCount() == 0 {
+			misses = 0
+			continue
+		}
+
+		// Send heartbeat and wait for up to one interval for the response.
+		started := time.Now()
+		ctx, cancel := context.WithTimeout(t.ctx, interval)
+		_, tErr := heartbeatMethod.Call(ctx, t, &heartbeatRequest{})
+		cancel()
+
+		switch {
+		case t.ctx.Err() != nil:
+			return nil
+		case tErr == nil:
+			t.addHeartbeatRTT(time.Since(started))
+			misses = 0
+		case tErr.IsExternal():
+			// Any reply shows that the remote terminal is responsive.
+			misses = 0
+		default:
+			misses++
+			if misses >= maxMisses {
+				t.Abandon(ErrPeerUnresponsive.With("missed %d heartbeats", misses))
+				return nil
+			}
+		}
+	}
+}
@@ -2,12 +2,14 @@ package terminal
 
 import (
 	"context"
+	"time"
 
 	"github.com/safing/jess"
 	"github.com/safing/portbase/container"
 	"github.com/safing/portbase/formats/dsd"
 	"github.com/safing/portbase/formats/varint"
 	"github.com/safing/spn/cabin"
+	"github.com/safing/spn/conf"
 	"github.com/safing/spn/hub"
 )
 
@@ -19,44 +21,137 @@ Terminal Init Message Format:
 - Data Block [bytes; not blocked]
 	- TerminalOpts as DSD
 
+Terminal Init Message Format (Version 2 and later):
+
+- Version [varint]
+- Features [varint]
+- Data Block [bytes; not blocked]
+	- TerminalOpts as DSD
+
+All versions from version 2 onwards share the same base format. Newer versions
+may only add fields to the TerminalOpts and bits to the feature bitmap. This
+enables a receiving terminal to negotiate down to the highest common version
+by ignoring what it does not know.
+
+As the initiating terminal starts sending right away, it cannot wait for the
+result of the negotiation. Instead, it requests the agreed version and features
+from the remote terminal after starting. If they differ from what was
+requested, the terminal is abandoned, as messages were already sent in a
+format the remote terminal does not understand. Features should therefore
+only be requested when the remote Hub announces them in its Status.
+
 */
 
 const (
 	minSupportedTerminalVersion = 1
-	maxSupportedTerminalVersion = 1
+	maxSupportedTerminalVersion = 2
+
+	// featureTerminalVersion is the first version that supports features.
+	featureTerminalVersion = 2
+)
+
+// FeatureFlags is a bitmap of terminal features.
+type FeatureFlags uint64
+
+// Terminal Features.
+const (
+	// FeatureCompression enables compression of terminal messages.
+	FeatureCompression FeatureFlags = 1 << iota
+
+	// FeatureRekeying enables regularly switching to new encryption sessions.
+	FeatureRekeying
+
+	// FeatureCongestionControl enables the congestion aware flow control.
+	FeatureCongestionControl
+
+	// supportedFeatures holds all features supported by this implementation.
+	supportedFeatures = FeatureCompression | FeatureRekeying | FeatureCongestionControl
 )
 
+// Has returns whether the given features are all set.
+func (ff FeatureFlags) Has(features FeatureFlags) bool {
+	return ff&features == features
+}
+
 // TerminalOpts holds configuration for the terminal.
 type TerminalOpts struct { //nolint:golint,maligned // TODO: Rename.
-	Version uint8  `json:"-"`
-	Encrypt bool   `json:"e,omitempty"`
-	Padding uint16 `json:"p,omitempty"`
+	Version  uint8        `json:"-"`
+	Features FeatureFlags `json:"-"`
+	Encrypt  bool         `json:"e,omitempty"`
+	Padding  uint16       `json:"p,omitempty"`
 
 	FlowControl     FlowControlType `json:"fc,omitempty"`
 	FlowControlSize uint32          `json:"qs,omitempty"` // Previously was "QueueSize".
 
 	UsePriorityDataMsgs bool `json:"pr,omitempty"`
+
+	// CoverTrafficRate is the requested rate of constant cover traffic in bytes
+	// per second. It is only used by crane controllers.
+	CoverTrafficRate uint32 `json:"ct,omitempty"`
+
+	// RekeyAfterBytes and RekeyAfter define after how much sent data or time
+	// the initiating terminal switches to a new encryption session. They are
+	// only used locally and default to defaultRekeyAfterBytes and
+	// defaultRekeyAfter.
+	RekeyAfterBytes uint64        `json:"-"`
+	RekeyAfter      time.Duration `json:"-"`
+
+	// HeartbeatInterval defines how often the terminal checks whether the
+	// remote terminal is still responsive. HeartbeatMaxMisses defines after
+	// how many missed heartbeats in a row the terminal is abandoned. They are
+	// only used locally. Heartbeats are disabled when the interval is zero and
+	// HeartbeatMaxMisses defaults to defaultHeartbeatMaxMisses.
+	HeartbeatInterval  time.Duration `json:"-"`
+	HeartbeatMaxMisses uint8         `json:"-"`
 }
 
 // ParseTerminalOpts parses terminal options from the container and checks if
-// they are valid.
+// they are valid. If the requested version is newer than the supported
+// versions, the options are negotiated down to the highest common version and
+// unknown features are removed.
 func ParseTerminalOpts(c *container.Container) (*TerminalOpts, *Error) {
+	return parseTerminalOpts(c, maxSupportedTerminalVersion, supportedFeatures)
+}
+
+func parseTerminalOpts(c *container.Container, maxVersion uint8, features FeatureFlags) (*TerminalOpts, *Error) {
 	// Parse and check version.
 	version, err := c.GetNextN8()
 	if err != nil {
 		return nil, ErrMalformedData.With("failed to parse version: %w", err)
 	}
-	if version < minSupportedTerminalVersion || version > maxSupportedTerminalVersion {
+	switch {
+	case version < minSupportedTerminalVersion:
+		return nil, ErrUnsupportedVersion.With("requested terminal version %d", version)
+	case version > maxVersion && maxVersion < featureTerminalVersion:
+		// Versions before the feature version cannot negotiate.
 		return nil, ErrUnsupportedVersion.With("requested terminal version %d", version)
 	}
 
+	// Parse features.
+	var requestedFeatures FeatureFlags
+	if version >= featureTerminalVersion {
+		f, err := c.GetNextN64()
+		if err != nil {
+			return nil, ErrMalformedData.With("failed to parse features: %w", err)
+		}
+		requestedFeatures = FeatureFlags(f)
+	}
+
 	// Parse init message.
 	initMsg := &TerminalOpts{}
 	_, err = dsd.Load(c.CompileData(), initMsg)
 	if err != nil {
 		return nil, ErrMalformedData.With("failed to parse init message: %w", err)
 	}
+
+	// Negotiate down to the highest common version and features.
+	if version > maxVersion {
+		version = maxVersion
+	}
 	initMsg.Version = version
+	if version >= featureTerminalVersion {
+		initMsg.Features = requestedFeatures & features
+	}
 
 	// Check if options are valid.
 	tErr := initMsg.Check(false)
@@ -82,22 +177,34 @@ func (opts *TerminalOpts) Pack() (*container.Container, *Error) {
 	}
 
 	// Compile init message.
-	return container.New(
-		varint.Pack8(opts.Version),
-		optsData,
-	), nil
+	c := container.New(varint.Pack8(opts.Version))
+	if opts.Version >= featureTerminalVersion {
+		c.Append(varint.Pack64(uint64(opts.Features)))
+	}
+	c.Append(optsData)
+	return c, nil
 }
 
 // Check checks if terminal options are valid.
 func (opts *TerminalOpts) Check(useDefaultsForRequired bool) *Error {
 	// Version is required - use default when permitted.
 	if opts.Version == 0 && useDefaultsForRequired {
-		opts.Version = 1
+		opts.Version = conf.CurrentVersion
 	}
 	if opts.Version < minSupportedTerminalVersion || opts.Version > maxSupportedTerminalVersion {
 		return ErrInvalidOptions.With("unsupported terminal version %d", opts.Version)
 	}
 
+	// Features are only available in newer versions.
+	if opts.Features != 0 {
+		if opts.Version < featureTerminalVersion {
+			return ErrInvalidOptions.With("terminal version %d does not support features", opts.Version)
+		}
+		if !supportedFeatures.Has(opts.Features) {
+			return ErrInvalidOptions.With("unsupported features %b", opts.Features&^supportedFeatures)
+		}
+	}
+
 	// FlowControl is optional.
 	switch opts.FlowControl {
 	case FlowControlDefault:
@@ -105,6 +212,11 @@ func (opts *TerminalOpts) Check(useDefaultsForRequired bool) *Error {
 		opts.FlowControl = defaultFlowControl
 	case FlowControlNone, FlowControlDFQ:
 		// Ok.
+	case FlowControlCongestion:
+		// Only available if negotiated.
+		if !opts.Features.Has(FeatureCongestionControl) {
+			return ErrInvalidOptions.With("congestion flow control was not negotiated")
+		}
 	default:
 		return ErrInvalidOptions.With("unknown flow control type: %d", opts.FlowControl)
 	}
@@ -146,26 +258,25 @@ func NewLocalBaseTerminal(
 		return nil, nil, err
 	}
 
+	// Features are only used if the remote terminal agrees to them.
+	t.confirmNegotiation = initMsg.Features != 0
+
 	// Setup encryption if enabled.
 	if remoteHub != nil {
 		initMsg.Encrypt = true
 
-		// Select signet (public key) of remote Hub to use.
-		s := remoteHub.SelectSignet()
-		if s == nil {
-			return nil, nil, ErrHubNotReady.With("failed to select signet of remote hub")
-		}
-
 		// Create new session.
-		env := jess.NewUnconfiguredEnvelope()
-		env.SuiteID = jess.SuiteWireV1
-		env.Recipients = []*jess.Signet{s}
-		jession, err := env.WireCorrespondence(nil)
+		jession, err := newLocalJession(remoteHub)
 		if err != nil {
-			return nil, nil, ErrIntegrity.With("failed to initialize encryption: %w", err)
+			return nil, nil, err
 		}
 		t.jession = jession
 
+		// Setup rekeying if enabled.
+		if initMsg.Features.Has(FeatureRekeying) {
+			t.rekeying = newRekeyingState(remoteHub, initMsg, jession)
+		}
+
 		// Encryption is ready for sending.
 		close(t.encryptionReady)
 	}
@@ -204,7 +315,32 @@ func NewRemoteBaseTerminal(
 			return nil, nil, ErrInternalError.With("missing identity for setting up incoming encryption")
 		}
 		t.identity = identity
+
+		// Setup rekeying if enabled.
+		if initMsg.Features.Has(FeatureRekeying) {
+			t.rekeying = newRekeyingState(nil, initMsg, nil)
+		}
 	}
 
 	return t, initMsg, nil
 }
+
+// newLocalJession creates a new jess session for encrypting to the given Hub.
+func newLocalJession(remoteHub *hub.Hub) (*jess.Session, *Error) {
+	// Select signet (public key) of remote Hub to use.
+	s := remoteHub.SelectSignet()
+	if s == nil {
+		return nil, ErrHubNotReady.With("failed to select signet of remote hub")
+	}
+
+	// Create new session.
+	env := jess.NewUnconfiguredEnvelope()
+	env.SuiteID = cabin.WireSuite(s)
+	env.Recipients = []*jess.Signet{s}
+	jession, err := env.WireCorrespondence(nil)
+	if err != nil {
+		return nil, ErrIntegrity.With("failed to initialize encryption: %w", err)
+	}
+
+	return jession, nil
+}
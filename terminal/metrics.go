@@ -101,6 +101,25 @@ func registerMetrics() (err error) {
 		return err
 	}
 
+	// Register metrics from terminal send queues.
+
+	for class := TrafficClass(0); class < numTrafficClasses; class++ {
+		_, err = metrics.NewGauge(
+			"spn/terminal/sendqueue/depth",
+			map[string]string{
+				"class": class.String(),
+			},
+			getSendQueueDepth(class),
+			&metrics.Options{
+				Name:       "SPN Terminal Send Queue Depth (" + class.String() + ")",
+				Permission: api.PermitUser,
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -17,12 +17,17 @@ var (
 	scheduler *unit.Scheduler
 
 	debugUnitScheduling bool
+
+	recordMsgsTo       string
+	recordMsgsWithData bool
 )
 
 func init() {
 	flag.BoolVar(&debugUnitScheduling, "debug-unit-scheduling", false, "enable debug logs of the SPN unit scheduler")
+	flag.StringVar(&recordMsgsTo, "record-terminal-msgs", "", "record the decrypted messages of all terminals to the given file")
+	flag.BoolVar(&recordMsgsWithData, "record-terminal-msgs-data", false, "also record the data of terminal messages, which may include user data")
 
-	module = modules.Register("terminal", nil, start, nil, "base")
+	module = modules.Register("terminal", nil, start, stop, "base")
 }
 
 func start() error {
@@ -37,9 +42,19 @@ func start() error {
 
 	lockOpRegistry()
 
+	if recordMsgsTo != "" {
+		if err := StartMsgRecorder(recordMsgsTo, recordMsgsWithData); err != nil {
+			return err
+		}
+	}
+
 	return registerMetrics()
 }
 
+func stop() error {
+	return StopMsgRecorder()
+}
+
 var waitForever chan time.Time
 
 // TimedOut returns a channel that triggers when the timeout is reached.
@@ -15,6 +15,10 @@ type Msg struct {
 	Type   MsgType
 	Data   *container.Container
 
+	// Incompressible marks the data as not suitable for compression, for
+	// example because it is already encrypted.
+	Incompressible bool
+
 	// Unit scheduling.
 	// Note: With just 100B per packet, a uint64 (the Unit ID) is enough for
 	// over 1800 Exabyte. No need for overflow support.
@@ -61,7 +65,7 @@ func (msg *Msg) Pack() {
 // Consume adds another Message to itself.
 // The given Msg is packed before adding it to the data.
 // The data is moved - not copied!
-// High priority mark is inherited.
+// High priority and incompressible marks are inherited.
 func (msg *Msg) Consume(other *Msg) {
 	// Pack message to be added.
 	other.Pack()
@@ -74,6 +78,11 @@ func (msg *Msg) Consume(other *Msg) {
 		msg.Unit.MakeHighPriority()
 	}
 
+	// Inherit incompressible mark.
+	if other.Incompressible {
+		msg.Incompressible = true
+	}
+
 	// Finish other unit.
 	other.Finish()
 }
@@ -43,6 +43,22 @@ const (
 	MsgTypeStop MsgType = 3
 )
 
+// String returns the message type as a string.
+func (msgType MsgType) String() string {
+	switch msgType {
+	case MsgTypeInit:
+		return "init"
+	case MsgTypeData:
+		return "data"
+	case MsgTypePriorityData:
+		return "prio"
+	case MsgTypeStop:
+		return "stop"
+	default:
+		return "unknown"
+	}
+}
+
 // AddIDType prepends the ID and Type header to the message.
 func AddIDType(c *container.Container, id uint32, msgType MsgType) {
 	c.Prepend(varint.Pack32(id | uint32(msgType)))
@@ -0,0 +1,187 @@
+package terminal
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/dsd"
+)
+
+/*
+
+RPC Operation Format:
+used by operations registered with RPCMethod
+
+- Init Data [bytes; not blocked]
+	- Request as DSD
+- Response Msg [bytes; not blocked]
+	- Response as DSD
+
+The server handles the request, sends the response and stops the operation.
+Errors are reported as the stop error of the operation.
+
+*/
+
+const defaultRPCTimeout = 10 * time.Second
+
+// RPCHandler handles a request of an RPC method and returns the response.
+type RPCHandler[Request, Response any] func(t Terminal, request *Request) (*Response, *Error)
+
+// RPCMethod is a typed request/response method that runs as an operation.
+type RPCMethod[Request, Response any] struct {
+	// Type is the type ID of the operation.
+	Type string
+	// Requires defines the required permissions to call the method.
+	Requires Permission
+	// TrafficClass is the traffic class used for the method's messages.
+	TrafficClass TrafficClass
+	// Timeout defines how long to wait for a response.
+	// Defaults to defaultRPCTimeout.
+	Timeout time.Duration
+	// Handler handles requests on the called side.
+	Handler RPCHandler[Request, Response]
+}
+
+// Register registers the RPC method as an operation type and may only be
+// called during Go's init and a module's prep phase.
+func (m *RPCMethod[Request, Response]) Register() {
+	RegisterOpType(OperationFactory{
+		Type:     m.Type,
+		Requires: m.Requires,
+		Start:    m.start,
+	})
+}
+
+func (m *RPCMethod[Request, Response]) timeout() time.Duration {
+	if m.Timeout > 0 {
+		return m.Timeout
+	}
+	return defaultRPCTimeout
+}
+
+// Call calls the RPC method on the other side of the given terminal and
+// waits for the response.
+func (m *RPCMethod[Request, Response]) Call(ctx context.Context, t Terminal, request *Request) (*Response, *Error) {
+	// Pack request.
+	data, err := dsd.Dump(request, dsd.CBOR)
+	if err != nil {
+		return nil, ErrInternalError.With("failed to pack %s request: %w", m.Type, err)
+	}
+
+	// Create operation and send request.
+	op := &rpcOp[Request, Response]{
+		method: m,
+	}
+	op.Init()
+	tErr := t.StartOperation(op, container.New(data), m.timeout())
+	if tErr != nil {
+		return nil, tErr
+	}
+
+	// Wait for response.
+	select {
+	case tErr = <-op.Result:
+	case <-ctx.Done():
+		op.Stop(op, ErrCanceled)
+		return nil, ErrCanceled
+	case <-time.After(m.timeout()):
+		op.Stop(op, ErrTimeout)
+		return nil, ErrTimeout.With("waiting for %s response", m.Type)
+	}
+
+	switch {
+	case tErr.Is(ErrExplicitAck):
+		return op.response, nil
+	case tErr.IsError():
+		return nil, tErr
+	default:
+		return nil, ErrMalformedData.With("%s operation ended without response", m.Type)
+	}
+}
+
+func (m *RPCMethod[Request, Response]) start(t Terminal, opID uint32, data *container.Container) (Operation, *Error) {
+	// Parse request.
+	request := new(Request)
+	_, err := dsd.Load(data.CompileData(), request)
+	if err != nil {
+		return nil, ErrMalformedData.With("failed to parse %s request: %w", m.Type, err)
+	}
+
+	// Handle request.
+	response, tErr := m.Handler(t, request)
+	if tErr != nil {
+		return nil, tErr
+	}
+	if response == nil {
+		response = new(Response)
+	}
+
+	// Send response.
+	responseData, err := dsd.Dump(response, dsd.CBOR)
+	if err != nil {
+		return nil, ErrInternalError.With("failed to pack %s response: %w", m.Type, err)
+	}
+	msg := NewMsg(responseData)
+	msg.FlowID = opID
+	msg.Unit.MakeHighPriority()
+	if UsePriorityDataMsgs {
+		msg.Type = MsgTypePriorityData
+	}
+	tErr = t.Send(msg, m.timeout())
+	if tErr != nil {
+		// Finish message unit on failure.
+		msg.Finish()
+		return nil, tErr.With("failed to send %s response", m.Type)
+	}
+
+	// Operation is just one response and finished successfully.
+	return nil, nil
+}
+
+// rpcOp is the calling side of an RPC method.
+type rpcOp[Request, Response any] struct {
+	OneOffOperationBase
+
+	method   *RPCMethod[Request, Response]
+	response *Response
+}
+
+// Type returns the type ID.
+func (op *rpcOp[Request, Response]) Type() string {
+	return op.method.Type
+}
+
+// TrafficClass returns the traffic class used for scheduling the operation's
+// messages.
+func (op *rpcOp[Request, Response]) TrafficClass() TrafficClass {
+	return op.method.TrafficClass
+}
+
+// Deliver delivers a message to the operation.
+func (op *rpcOp[Request, Response]) Deliver(msg *Msg) *Error {
+	defer msg.Finish()
+
+	// Parse response.
+	response := new(Response)
+	_, err := dsd.Load(msg.Data.CompileData(), response)
+	if err != nil {
+		return ErrMalformedData.With("failed to parse %s response: %w", op.method.Type, err)
+	}
+	op.response = response
+
+	return ErrExplicitAck
+}
+
+// HandleStop gives the operation the ability to cleanly shut down.
+// The returned error is the error to send to the other side.
+// Should never be called directly. Call Stop() instead.
+func (op *rpcOp[Request, Response]) HandleStop(err *Error) (errorToSend *Error) {
+	// Prevent remote from sending explicit ack, as we use it as a success signal internally.
+	if err.Is(ErrExplicitAck) && err.IsExternal() {
+		err = ErrStopping.AsExternal()
+	}
+
+	// Continue with usual handling of inherited base.
+	return op.OneOffOperationBase.HandleStop(err)
+}
@@ -0,0 +1,67 @@
+package terminal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testRPCRequest struct {
+	Message string
+	Fail    bool
+}
+
+type testRPCResponse struct {
+	Message string
+}
+
+var testRPCMethod = &RPCMethod[testRPCRequest, testRPCResponse]{
+	Type:     "debug/rpc",
+	Requires: MayConnect,
+	Handler: func(t Terminal, request *testRPCRequest) (*testRPCResponse, *Error) {
+		if request.Fail {
+			return nil, ErrInvalidOptions.With("requested to fail")
+		}
+		return &testRPCResponse{
+			Message: "re: " + request.Message,
+		}, nil
+	},
+}
+
+func init() {
+	testRPCMethod.Register()
+}
+
+func TestRPCMethod(t *testing.T) {
+	t.Parallel()
+
+	a, b, err := NewSimpleTestTerminalPair(0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Abandon(nil)
+	defer b.Abandon(nil)
+
+	// Calls must be denied without the required permission.
+	_, tErr := testRPCMethod.Call(context.Background(), a, &testRPCRequest{Message: "hello"})
+	assert.True(t, tErr.Is(ErrPermissionDenied), "call should be denied, but got %s", tErr)
+
+	// Calls must succeed with the required permission.
+	b.GrantPermission(MayConnect)
+	response, tErr := testRPCMethod.Call(context.Background(), a, &testRPCRequest{Message: "hello"})
+	if assert.Nil(t, tErr, "call should succeed") {
+		assert.Equal(t, "re: hello", response.Message, "response must match")
+	}
+
+	// Errors of the handler must be returned to the caller.
+	_, tErr = testRPCMethod.Call(context.Background(), a, &testRPCRequest{Fail: true})
+	assert.True(t, tErr.Is(ErrInvalidOptions), "call should fail with handler error, but got %s", tErr)
+	assert.True(t, tErr.IsExternal(), "handler error should be external")
+
+	// Canceled calls must return early.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, tErr = testRPCMethod.Call(ctx, a, &testRPCRequest{Message: "hello"})
+	assert.True(t, tErr.Is(ErrCanceled), "call should be canceled, but got %s", tErr)
+}
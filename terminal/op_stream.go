@@ -0,0 +1,410 @@
+package terminal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/varint"
+)
+
+/*
+
+Stream Operation Message Format:
+used by operations built on StreamOperationBase, carried in the flow queue
+
+- MsgType [varint]
+- Data [bytes; not blocked; only when MsgType is Data]
+
+*/
+
+// Stream Msg Types.
+const (
+	streamMsgTypeData       = 1
+	streamMsgTypeCloseWrite = 2
+)
+
+const (
+	// streamChunkSize is the maximum amount of data sent in one message.
+	streamChunkSize = 1500
+
+	// streamFlushTimeout defines how long written data may take to be sent
+	// when the stream is closed.
+	streamFlushTimeout = 5 * time.Minute
+)
+
+// StreamOperationBase is an operation base that exposes the operation as a
+// net.Conn. All data is sent through a duplex flow queue, so that writing
+// blocks when the other side does not read.
+// Operations embedding it must call InitStreamOperationBase before they are
+// started or returned from their OperationStarter.
+type StreamOperationBase struct {
+	OperationBase
+
+	self      Operation
+	ctx       context.Context
+	cancelCtx context.CancelFunc
+	dfq       *DuplexFlowQueue
+
+	readLock sync.Mutex
+	readBuf  []byte
+	readEOF  bool
+
+	writeLock   sync.Mutex
+	writeClosed bool
+
+	readDeadline  *streamDeadline
+	writeDeadline *streamDeadline
+
+	// closed is closed when the stream is closed locally.
+	closed    chan struct{}
+	closeOnce sync.Once
+	// stopErr holds the error the operation was stopped with.
+	stopErr atomic.Pointer[Error]
+}
+
+var _ net.Conn = &StreamOperationBase{}
+
+// InitStreamOperationBase initializes the stream. The given operation must
+// be the operation that embeds the stream operation base. The queue size
+// must be the same on both sides.
+func (op *StreamOperationBase) InitStreamOperationBase(self Operation, t Terminal, queueSize uint32) {
+	op.self = self
+	op.ctx, op.cancelCtx = context.WithCancel(t.Ctx())
+	op.dfq = NewDuplexFlowQueue(op.ctx, queueSize, op.submitUpstream)
+	op.readDeadline = newStreamDeadline()
+	op.writeDeadline = newStreamDeadline()
+	op.closed = make(chan struct{})
+
+	module.StartWorker("stream op flow handler", op.dfq.FlowHandler)
+}
+
+func (op *StreamOperationBase) submitUpstream(msg *Msg, timeout time.Duration) {
+	err := op.Send(msg, timeout)
+	if err != nil {
+		msg.Finish()
+		op.Stop(op.self, err.Wrap("failed to send stream data"))
+	}
+}
+
+// Deliver delivers a message to the operation.
+func (op *StreamOperationBase) Deliver(msg *Msg) *Error {
+	return op.dfq.Deliver(msg)
+}
+
+// Read reads data from the stream. It returns io.EOF when the other side
+// closed the stream or its writing side and all data has been read.
+func (op *StreamOperationBase) Read(b []byte) (n int, err error) {
+	op.readLock.Lock()
+	defer op.readLock.Unlock()
+
+	for len(op.readBuf) == 0 {
+		if op.readEOF {
+			return 0, io.EOF
+		}
+
+		// Check the state first, as select chooses randomly.
+		select {
+		case <-op.closed:
+			return 0, net.ErrClosed
+		case <-op.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		default:
+		}
+
+		var msg *Msg
+		select {
+		case msg = <-op.dfq.Receive():
+		case <-op.closed:
+			return 0, net.ErrClosed
+		case <-op.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		case <-op.ctx.Done():
+			// Read all remaining data before reporting the end.
+			select {
+			case msg = <-op.dfq.Receive():
+			default:
+				return 0, op.streamEndErr(io.EOF)
+			}
+		}
+
+		if tErr := op.handleStreamMsg(msg); tErr != nil {
+			op.Stop(op.self, tErr)
+			return 0, tErr
+		}
+	}
+
+	n = copy(b, op.readBuf)
+	op.readBuf = op.readBuf[n:]
+	return n, nil
+}
+
+func (op *StreamOperationBase) handleStreamMsg(msg *Msg) *Error {
+	defer msg.Finish()
+
+	msgType, err := msg.Data.GetNextN8()
+	if err != nil {
+		return ErrMalformedData.With("failed to parse stream msg type: %w", err)
+	}
+
+	switch msgType {
+	case streamMsgTypeData:
+		op.readBuf = msg.Data.CompileData()
+	case streamMsgTypeCloseWrite:
+		op.readEOF = true
+	default:
+		return ErrMalformedData.With("unknown stream msg type %d", msgType)
+	}
+
+	return nil
+}
+
+// Write writes data to the stream. It blocks while the flow queue is full.
+func (op *StreamOperationBase) Write(b []byte) (n int, err error) {
+	op.writeLock.Lock()
+	defer op.writeLock.Unlock()
+
+	for {
+		if err := op.checkWritable(); err != nil {
+			return n, err
+		}
+		if len(b) == 0 {
+			return n, nil
+		}
+
+		// Copy data to message, as the caller may reuse the buffer.
+		chunk := b
+		if len(chunk) > streamChunkSize {
+			chunk = chunk[:streamChunkSize]
+		}
+		data := make([]byte, len(chunk))
+		copy(data, chunk)
+		msg := op.NewEmptyMsg()
+		msg.Data = container.New(varint.Pack8(streamMsgTypeData), data)
+
+		if err := op.queueStreamMsg(msg); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		b = b[len(chunk):]
+	}
+}
+
+// CloseWrite shuts down the writing side of the stream. The other side reads
+// io.EOF after all data written before, but may continue to write.
+func (op *StreamOperationBase) CloseWrite() error {
+	op.writeLock.Lock()
+	defer op.writeLock.Unlock()
+
+	if err := op.checkWritable(); err != nil {
+		return err
+	}
+	op.writeClosed = true
+
+	return op.queueStreamMsg(op.NewMsg(varint.Pack8(streamMsgTypeCloseWrite)))
+}
+
+func (op *StreamOperationBase) checkWritable() error {
+	// Check the state first, as select chooses randomly.
+	select {
+	case <-op.closed:
+		return net.ErrClosed
+	case <-op.ctx.Done():
+		return op.streamEndErr(io.ErrClosedPipe)
+	case <-op.writeDeadline.wait():
+		return os.ErrDeadlineExceeded
+	default:
+	}
+
+	if op.writeClosed {
+		return net.ErrClosed
+	}
+	return nil
+}
+
+func (op *StreamOperationBase) queueStreamMsg(msg *Msg) error {
+	select {
+	case op.dfq.sendQueue <- msg:
+		return nil
+	case <-op.closed:
+		msg.Finish()
+		return net.ErrClosed
+	case <-op.ctx.Done():
+		msg.Finish()
+		return op.streamEndErr(io.ErrClosedPipe)
+	case <-op.writeDeadline.wait():
+		msg.Finish()
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// streamEndErr returns the error the operation was stopped with, or the
+// given error if it stopped cleanly.
+func (op *StreamOperationBase) streamEndErr(cleanErr error) error {
+	if stopErr := op.stopErr.Load(); stopErr.IsError() {
+		return stopErr
+	}
+	return cleanErr
+}
+
+// Close closes the stream. All data written before is still sent to the
+// other side in the background.
+func (op *StreamOperationBase) Close() error {
+	err := net.ErrClosed
+	op.closeOnce.Do(func() {
+		close(op.closed)
+		err = nil
+	})
+	if err != nil {
+		return err
+	}
+
+	module.StartWorker("stream op closer", op.closer)
+	return nil
+}
+
+func (op *StreamOperationBase) closer(_ context.Context) error {
+	// Discard received data, so that the other side does not block while we
+	// are sending the remaining data.
+	module.StartWorker("stream op drainer", op.drainer)
+
+	// Send all data before stopping, as stopped operations do not receive
+	// flow control updates anymore.
+	op.dfq.Flush(streamFlushTimeout)
+	op.Stop(op.self, ErrStopping)
+	return nil
+}
+
+func (op *StreamOperationBase) drainer(_ context.Context) error {
+	for {
+		select {
+		case msg := <-op.dfq.Receive():
+			msg.Finish()
+		case <-op.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// HandleStop gives the operation the ability to cleanly shut down.
+// The returned error is the error to send to the other side.
+// Should never be called directly. Call Stop() instead.
+func (op *StreamOperationBase) HandleStop(err *Error) (errorToSend *Error) {
+	// Save the error for readers and writers and cancel workers.
+	op.stopErr.Store(err)
+	op.cancelCtx()
+
+	return err
+}
+
+// LocalAddr returns the address of the operation.
+// Both sides of a stream share the same address.
+func (op *StreamOperationBase) LocalAddr() net.Addr {
+	return op.addr()
+}
+
+// RemoteAddr returns the address of the operation.
+// Both sides of a stream share the same address.
+func (op *StreamOperationBase) RemoteAddr() net.Addr {
+	return op.addr()
+}
+
+func (op *StreamOperationBase) addr() net.Addr {
+	return streamAddr(fmt.Sprintf("%s>%d", op.Terminal().FmtID(), op.ID()))
+}
+
+// SetDeadline sets the read and write deadlines of the stream.
+func (op *StreamOperationBase) SetDeadline(t time.Time) error {
+	op.readDeadline.set(t)
+	op.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the read deadline of the stream.
+func (op *StreamOperationBase) SetReadDeadline(t time.Time) error {
+	op.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline of the stream.
+func (op *StreamOperationBase) SetWriteDeadline(t time.Time) error {
+	op.writeDeadline.set(t)
+	return nil
+}
+
+// streamAddr is the net.Addr of a stream operation.
+type streamAddr string
+
+func (a streamAddr) Network() string { return "spn" }
+func (a streamAddr) String() string  { return string(a) }
+
+// streamDeadline is a deadline that can be changed while it is waited on.
+type streamDeadline struct {
+	lock   sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newStreamDeadline() *streamDeadline {
+	return &streamDeadline{
+		cancel: make(chan struct{}),
+	}
+}
+
+// set sets the deadline. A zero time removes the deadline.
+func (d *streamDeadline) set(t time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// Stop the current timer, or wait for it to fire.
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel
+	}
+	d.timer = nil
+
+	// Check if the deadline has passed.
+	passed := false
+	select {
+	case <-d.cancel:
+		passed = true
+	default:
+	}
+
+	switch wait := time.Until(t); {
+	case t.IsZero():
+		// Remove deadline.
+		if passed {
+			d.cancel = make(chan struct{})
+		}
+
+	case wait > 0:
+		// Set deadline in the future.
+		if passed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(wait, func() {
+			close(cancel)
+		})
+
+	default:
+		// Deadline is in the past.
+		if !passed {
+			close(d.cancel)
+		}
+	}
+}
+
+// wait returns a channel that is closed when the deadline passes.
+func (d *streamDeadline) wait() <-chan struct{} {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.cancel
+}
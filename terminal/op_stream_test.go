@@ -0,0 +1,108 @@
+package terminal
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/nettest"
+
+	"github.com/safing/portbase/container"
+)
+
+const (
+	testStreamOpType    = "debug/stream"
+	testStreamQueueSize = defaultTestQueueSize
+)
+
+type testStreamOp struct {
+	StreamOperationBase
+}
+
+// testStreamOps receives the operations started by the remote side.
+var testStreamOps = make(chan *testStreamOp, 1)
+
+func init() {
+	RegisterOpType(OperationFactory{
+		Type:  testStreamOpType,
+		Start: startTestStreamOp,
+	})
+}
+
+func newTestStreamOp(t Terminal) (*testStreamOp, *Error) {
+	op := &testStreamOp{}
+	op.InitStreamOperationBase(op, t, testStreamQueueSize)
+
+	tErr := t.StartOperation(op, nil, 3*time.Second)
+	if tErr != nil {
+		op.Stop(op, tErr)
+		return nil, tErr
+	}
+	return op, nil
+}
+
+func startTestStreamOp(t Terminal, opID uint32, _ *container.Container) (Operation, *Error) {
+	op := &testStreamOp{}
+	op.InitOperationBase(t, opID)
+	op.InitStreamOperationBase(op, t, testStreamQueueSize)
+
+	testStreamOps <- op
+	return op, nil
+}
+
+func (op *testStreamOp) Type() string {
+	return testStreamOpType
+}
+
+func makeTestStreamPipe() (c1, c2 net.Conn, stop func(), err error) {
+	a, b, err := NewSimpleTestTerminalPair(0, 0, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	op, tErr := newTestStreamOp(a)
+	if tErr != nil {
+		return nil, nil, nil, tErr
+	}
+	remoteOp := <-testStreamOps
+
+	return op, remoteOp, func() {
+		_ = op.Close()
+		_ = remoteOp.Close()
+		a.Abandon(nil)
+		b.Abandon(nil)
+	}, nil
+}
+
+func TestStreamOpConn(t *testing.T) { //nolint:paralleltest // Pipes are created in sequence.
+	nettest.TestConn(t, makeTestStreamPipe)
+}
+
+func TestStreamOpHalfClose(t *testing.T) { //nolint:paralleltest // Pipes are created in sequence.
+	c1, c2, stop, err := makeTestStreamPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	// Close writing on one side.
+	_, err = c1.Write([]byte("request"))
+	assert.NoError(t, err, "write should succeed")
+	assert.NoError(t, c1.(*testStreamOp).CloseWrite(), "close write should succeed") //nolint:forcetypeassert
+	_, err = c1.Write([]byte("more"))
+	assert.Error(t, err, "write after close write should fail")
+
+	// The other side reads until EOF and can still reply.
+	data, err := io.ReadAll(c2)
+	assert.NoError(t, err, "read until EOF should succeed")
+	assert.Equal(t, "request", string(data), "request must match")
+	_, err = c2.Write([]byte("response"))
+	assert.NoError(t, err, "write after receiving EOF should succeed")
+	assert.NoError(t, c2.Close(), "close should succeed")
+
+	data, err = io.ReadAll(c1)
+	assert.NoError(t, err, "read until EOF should succeed")
+	assert.Equal(t, "response", string(data), "response must match")
+}
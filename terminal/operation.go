@@ -64,6 +64,11 @@ type Operation interface {
 	// Terminal returns the terminal the operation is linked to.
 	// Should not be overridden by implementations.
 	Terminal() Terminal
+
+	// TrafficClass returns the traffic class used for scheduling the
+	// operation's messages.
+	// Meant to be overridden by implementations.
+	TrafficClass() TrafficClass
 }
 
 // OperationFactory defines an operation factory.
@@ -138,6 +143,15 @@ func (t *TerminalBase) handleOperationStart(opID uint32, initData *container.Con
 		return
 	}
 
+	// Check if the operation may be started within the scope of the grant.
+	// Operations that do not require permissions are not limited.
+	if factory.Requires != NoPermission {
+		if tErr := t.checkGrantScope(); tErr != nil {
+			t.StopOperation(newUnknownOp(opID, factory.Type), tErr)
+			return
+		}
+	}
+
 	// Get terminal to attach to.
 	attachToTerminal := t.ext
 	if attachToTerminal == nil {
@@ -207,23 +221,32 @@ func (t *TerminalBase) Send(msg *Msg, timeout time.Duration) *Error {
 	// Wait for processing slot.
 	msg.Unit.WaitForSlot()
 
-	// Check if the send queue has available space.
-	select {
-	case t.sendQueue <- msg:
-		return nil
-	default:
-	}
+	// Add message to the send queue and wait for space, if needed.
+	var timedOut <-chan time.Time
+	for {
+		wait, err := t.sendQueue.Push(msg)
+		switch {
+		case err != nil:
+			msg.Finish()
+			return err
+		case wait == nil:
+			return nil
+		}
 
-	// Submit message to buffer, if space is available.
-	select {
-	case t.sendQueue <- msg:
-		return nil
-	case <-TimedOut(timeout):
-		msg.Finish()
-		return ErrTimeout.With("sending via terminal")
-	case <-t.Ctx().Done():
-		msg.Finish()
-		return ErrStopping
+		// Start timeout on first wait.
+		if timedOut == nil {
+			timedOut = TimedOut(timeout)
+		}
+
+		select {
+		case <-wait:
+		case <-timedOut:
+			msg.Finish()
+			return ErrTimeout.With("sending via terminal")
+		case <-t.Ctx().Done():
+			msg.Finish()
+			return ErrStopping
+		}
 	}
 }
 
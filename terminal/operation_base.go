@@ -32,6 +32,13 @@ func (op *OperationBase) Type() string {
 	return "unknown"
 }
 
+// TrafficClass returns the traffic class used for scheduling the operation's
+// messages.
+// Meant to be overridden by implementations.
+func (op *OperationBase) TrafficClass() TrafficClass {
+	return TrafficClassInteractive
+}
+
 // Deliver delivers a message to the operation.
 // Meant to be overridden by implementations.
 func (op *OperationBase) Deliver(_ *Msg) *Error {
@@ -0,0 +1,167 @@
+package terminal
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/log"
+)
+
+const (
+	// NegotiationOpType is the type ID of the operation that returns the
+	// agreed version and features to the initiating terminal.
+	NegotiationOpType = "negotiation"
+
+	negotiationOpTimeout = 10 * time.Second
+)
+
+// NegotiationOp requests the agreed version and features from the remote
+// terminal and checks if they match the requested ones.
+type NegotiationOp struct {
+	OneOffOperationBase
+
+	version  uint8
+	features FeatureFlags
+}
+
+// NegotiationResponse holds the version and features the remote terminal
+// agreed to.
+type NegotiationResponse struct {
+	Version  uint8        `json:"v,omitempty"`
+	Features FeatureFlags `json:"f,omitempty"`
+}
+
+// Type returns the type ID.
+func (op *NegotiationOp) Type() string {
+	return NegotiationOpType
+}
+
+func init() {
+	RegisterOpType(OperationFactory{
+		Type:  NegotiationOpType,
+		Start: startNegotiationOp,
+	})
+}
+
+// NewNegotiationOp requests the agreed version and features from the remote
+// terminal. The operation fails with ErrUnsupportedVersion if they differ from
+// the ones of the given terminal.
+func NewNegotiationOp(t *TerminalBase) (*NegotiationOp, *Error) {
+	op := &NegotiationOp{
+		version:  t.opts.Version,
+		features: t.opts.Features,
+	}
+	op.OneOffOperationBase.Init()
+
+	tErr := t.StartOperation(op, container.New(), negotiationOpTimeout)
+	if tErr != nil {
+		return nil, tErr
+	}
+
+	return op, nil
+}
+
+// Deliver delivers a message to the operation.
+func (op *NegotiationOp) Deliver(msg *Msg) *Error {
+	defer msg.Finish()
+
+	// Parse response.
+	agreed := &NegotiationResponse{}
+	_, err := dsd.Load(msg.Data.CompileData(), agreed)
+	if err != nil {
+		return ErrMalformedData.With("failed to parse negotiation response: %w", err)
+	}
+
+	return op.check(agreed)
+}
+
+// check checks if the remote terminal agreed to the requested version and
+// features.
+func (op *NegotiationOp) check(agreed *NegotiationResponse) *Error {
+	if agreed.Version != op.version || agreed.Features != op.features {
+		return ErrUnsupportedVersion.With(
+			"remote terminal agreed to version %d with features %b, but version %d with features %b was requested",
+			agreed.Version,
+			agreed.Features,
+			op.version,
+			op.features,
+		)
+	}
+	return ErrExplicitAck
+}
+
+func startNegotiationOp(t Terminal, opID uint32, _ *container.Container) (Operation, *Error) {
+	nt, ok := t.(interface {
+		Version() uint8
+		negotiatedFeatures() FeatureFlags
+	})
+	if !ok {
+		return nil, ErrInternalError.With("terminal %T does not support negotiation", t)
+	}
+
+	// Create response.
+	response, err := dsd.Dump(&NegotiationResponse{
+		Version:  nt.Version(),
+		Features: nt.negotiatedFeatures(),
+	}, dsd.CBOR)
+	if err != nil {
+		return nil, ErrInternalError.With("failed to create negotiation response: %w", err)
+	}
+
+	// Send response.
+	msg := NewMsg(response)
+	msg.FlowID = opID
+	msg.Unit.MakeHighPriority()
+	tErr := t.Send(msg, negotiationOpTimeout)
+	if tErr != nil {
+		// Finish message unit on failure.
+		msg.Finish()
+		return nil, tErr.With("failed to send negotiation response")
+	}
+
+	// Operation is just one response and finished successfully.
+	return nil, nil
+}
+
+// HandleStop gives the operation the ability to cleanly shut down.
+// The returned error is the error to send to the other side.
+// Should never be called directly. Call Stop() instead.
+func (op *NegotiationOp) HandleStop(err *Error) (errorToSend *Error) {
+	// Prevent remote from sending explicit ack, as we use it as a success signal internally.
+	if err.Is(ErrExplicitAck) && err.IsExternal() {
+		err = ErrStopping.AsExternal()
+	}
+
+	// Continue with usual handling of inherited base.
+	return op.OneOffOperationBase.HandleStop(err)
+}
+
+// negotiationConfirmer requests the agreed version and features from the
+// remote terminal and abandons the terminal if they differ from the requested.
+func (t *TerminalBase) negotiationConfirmer(_ context.Context) error {
+	op, tErr := NewNegotiationOp(t)
+	if tErr != nil {
+		log.Warningf("spn/terminal: %s failed to start negotiation confirmation: %s", t.FmtID(), tErr)
+		return nil
+	}
+
+	select {
+	case tErr = <-op.Result:
+	case <-t.ctx.Done():
+		return nil
+	}
+
+	switch {
+	case tErr.Is(ErrExplicitAck):
+		// Negotiation confirmed.
+	case tErr.Is(ErrUnsupportedVersion) && !tErr.IsExternal():
+		// Messages were already sent in a format the remote does not understand.
+		t.Abandon(tErr)
+	default:
+		// Unresponsive terminals are handled elsewhere.
+		log.Warningf("spn/terminal: %s failed to confirm negotiation: %s", t.FmtID(), tErr)
+	}
+	return nil
+}
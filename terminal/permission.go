@@ -17,14 +17,14 @@ const (
 type AuthorizingTerminal interface {
 	GrantPermission(grant Permission)
 	HasPermission(required Permission) bool
+	ApplyGrant(grant *Grant)
+	GetGrant() *Grant
 }
 
-// GrantPermission grants the specified permissions to the Terminal.
+// GrantPermission grants the specified permissions to the Terminal without
+// restricting their scope.
 func (t *TerminalBase) GrantPermission(grant Permission) {
-	t.lock.Lock()
-	defer t.lock.Unlock()
-
-	t.permission |= grant
+	t.ApplyGrant(&Grant{Permission: grant})
 }
 
 // HasPermission returns if the Terminal has the specified permission.
@@ -0,0 +1,309 @@
+package terminal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/varint"
+	"github.com/safing/portbase/log"
+)
+
+/*
+
+Message Recording Format:
+a recording is a sequence of records of decrypted operation messages
+
+- Record [bytes block]
+	- Time [varint; unix nanoseconds]
+	- Direction [varint]
+	- Parent ID [bytes block]
+	- Terminal ID [varint]
+	- Operation ID [varint]
+	- Msg Type [varint]
+	- Size [varint]
+	- Data [bytes; not blocked; only when recorded with data]
+
+Records are limited to maxRecordSize. Messages that would exceed this limit
+are recorded without data.
+
+*/
+
+const (
+	// maxRecordSize defines the maximum size of a single record.
+	maxRecordSize = 4 << 20 // 4MB
+
+	// recorderFlushInterval defines how long recorded messages are buffered
+	// at most before they are written to the file.
+	recorderFlushInterval = 1 * time.Second
+)
+
+// RecordDirection is the direction of a recorded message.
+type RecordDirection uint8
+
+// Record Directions.
+const (
+	RecordReceived RecordDirection = 1
+	RecordSent     RecordDirection = 2
+)
+
+// String returns the direction as a string.
+func (d RecordDirection) String() string {
+	switch d {
+	case RecordReceived:
+		return "recv"
+	case RecordSent:
+		return "sent"
+	default:
+		return "unknown"
+	}
+}
+
+// RecordedMsg is a recorded operation message.
+type RecordedMsg struct {
+	Time       time.Time
+	Direction  RecordDirection
+	ParentID   string
+	TerminalID uint32
+	OpID       uint32
+	Type       MsgType
+	Size       int
+
+	// Data holds the message data, if recorded with data.
+	// Data messages of operations using flow control start with the
+	// reported space.
+	Data []byte
+}
+
+// FmtTerminalID formats the terminal ID together with the parent's ID.
+func (rec *RecordedMsg) FmtTerminalID() string {
+	return fmtTerminalID(rec.ParentID, rec.TerminalID)
+}
+
+// String returns a short human readable representation of the message.
+func (rec *RecordedMsg) String() string {
+	return fmt.Sprintf(
+		"%s %s %s op=%d type=%s size=%d",
+		rec.Time.Format("15:04:05.000000"),
+		rec.Direction,
+		rec.FmtTerminalID(),
+		rec.OpID,
+		rec.Type,
+		rec.Size,
+	)
+}
+
+// MsgRecorder records the decrypted operation messages of all terminals.
+type MsgRecorder struct {
+	lock     sync.Mutex
+	file     *os.File
+	writer   *bufio.Writer
+	withData bool
+
+	flushScheduled bool
+	closed         bool
+}
+
+var activeRecorder atomic.Pointer[MsgRecorder]
+
+// StartMsgRecorder starts recording the decrypted operation messages of all
+// terminals to the given file. The data of messages is only recorded if
+// withData is set. Any running recorder is stopped.
+func StartMsgRecorder(path string, withData bool) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o0600)
+	if err != nil {
+		return fmt.Errorf("failed to open recording file: %w", err)
+	}
+
+	r := &MsgRecorder{
+		file:     file,
+		writer:   bufio.NewWriter(file),
+		withData: withData,
+	}
+	if old := activeRecorder.Swap(r); old != nil {
+		return old.close()
+	}
+	return nil
+}
+
+// StopMsgRecorder stops the running recorder.
+func StopMsgRecorder() error {
+	if r := activeRecorder.Swap(nil); r != nil {
+		return r.close()
+	}
+	return nil
+}
+
+func (r *MsgRecorder) close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.closed = true
+	if err := r.writer.Flush(); err != nil {
+		_ = r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// recordMsg records the given operation message, if the recorder is running.
+func (t *TerminalBase) recordMsg(direction RecordDirection, opID uint32, msgType MsgType, data *container.Container) {
+	r := activeRecorder.Load()
+	if r == nil {
+		return
+	}
+
+	c := container.New(
+		varint.Pack64(uint64(time.Now().UnixNano())),
+		varint.Pack8(uint8(direction)),
+	)
+	c.AppendAsBlock([]byte(t.parentID))
+	c.AppendNumber(uint64(t.id))
+	c.AppendNumber(uint64(opID))
+	c.AppendNumber(uint64(msgType))
+	c.AppendNumber(uint64(data.Length()))
+	if r.withData && c.Length()+data.Length() <= maxRecordSize-varint.EncodedSize(maxRecordSize) {
+		c.Append(data.CompileData())
+	}
+	c.PrependLength()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return
+	}
+	if _, err := r.writer.Write(c.CompileData()); err != nil {
+		log.Warningf("spn/terminal: failed to record msg: %s", err)
+	}
+
+	// Write buffered records to the file soon.
+	if !r.flushScheduled {
+		r.flushScheduled = true
+		time.AfterFunc(recorderFlushInterval, r.flush)
+	}
+}
+
+// flush writes all buffered records to the file.
+func (r *MsgRecorder) flush() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.flushScheduled = false
+	if r.closed {
+		return
+	}
+	if err := r.writer.Flush(); err != nil {
+		log.Warningf("spn/terminal: failed to write recorded msgs: %s", err)
+	}
+}
+
+// ReadRecording reads all messages from the given recording.
+func ReadRecording(r io.Reader) ([]*RecordedMsg, error) {
+	reader := bufio.NewReader(r)
+
+	var msgs []*RecordedMsg
+	for {
+		// Read record.
+		length, err := binary.ReadUvarint(reader)
+		switch {
+		case errors.Is(err, io.EOF):
+			return msgs, nil
+		case err != nil:
+			return msgs, fmt.Errorf("failed to read record length: %w", err)
+		case length > maxRecordSize:
+			return msgs, fmt.Errorf("record %d exceeds maximum size with %d bytes", len(msgs)+1, length)
+		}
+		record := make([]byte, length)
+		if _, err := io.ReadFull(reader, record); err != nil {
+			return msgs, fmt.Errorf("failed to read record: %w", err)
+		}
+
+		// Parse record.
+		msg, err := parseRecordedMsg(container.New(record))
+		if err != nil {
+			return msgs, fmt.Errorf("failed to parse record %d: %w", len(msgs)+1, err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func parseRecordedMsg(c *container.Container) (*RecordedMsg, error) {
+	msg := &RecordedMsg{}
+
+	timestamp, err := c.GetNextN64()
+	if err != nil {
+		return nil, err
+	}
+	msg.Time = time.Unix(0, int64(timestamp))
+	direction, err := c.GetNextN8()
+	if err != nil {
+		return nil, err
+	}
+	msg.Direction = RecordDirection(direction)
+	parentID, err := c.GetNextBlock()
+	if err != nil {
+		return nil, err
+	}
+	msg.ParentID = string(parentID)
+	if msg.TerminalID, err = c.GetNextN32(); err != nil {
+		return nil, err
+	}
+	if msg.OpID, err = c.GetNextN32(); err != nil {
+		return nil, err
+	}
+	msgType, err := c.GetNextN8()
+	if err != nil {
+		return nil, err
+	}
+	msg.Type = MsgType(msgType)
+	size, err := c.GetNextN64()
+	if err != nil {
+		return nil, err
+	}
+	msg.Size = int(size)
+	if c.HoldsData() {
+		msg.Data = c.CompileData()
+	}
+
+	return msg, nil
+}
+
+// ReplayRecording delivers the given received messages to the terminal, as if
+// they were received from the remote terminal. Sent messages and messages
+// recorded without data are skipped. If withTiming is set, the recorded time
+// between messages is kept.
+func (t *TerminalBase) ReplayRecording(msgs []*RecordedMsg, withTiming bool) *Error {
+	var last time.Time
+	for _, msg := range msgs {
+		if msg.Direction != RecordReceived || len(msg.Data) != msg.Size {
+			continue
+		}
+
+		// Wait for the recorded time between messages.
+		if withTiming && !last.IsZero() {
+			select {
+			case <-time.After(msg.Time.Sub(last)):
+			case <-t.ctx.Done():
+				return ErrStopping
+			}
+		}
+		last = msg.Time
+
+		// Handle message like a received message.
+		data := container.New(msg.Data)
+		AddIDType(data, msg.OpID, msg.Type)
+		if tErr := t.handleOpMsg(data); tErr != nil {
+			return tErr
+		}
+	}
+
+	return nil
+}
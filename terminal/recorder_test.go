@@ -0,0 +1,98 @@
+package terminal
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/portbase/formats/varint"
+)
+
+var recorderTestCalls atomic.Int32
+
+var recorderTestMethod = &RPCMethod[testRPCRequest, testRPCResponse]{
+	Type: "debug/recorder",
+	Handler: func(t Terminal, request *testRPCRequest) (*testRPCResponse, *Error) {
+		recorderTestCalls.Add(1)
+		return &testRPCResponse{Message: request.Message}, nil
+	},
+}
+
+func init() {
+	recorderTestMethod.Register()
+}
+
+func TestMsgRecorder(t *testing.T) { //nolint:paralleltest // The recorder is global.
+	recordingFile := filepath.Join(t.TempDir(), "recording")
+
+	// Record a call.
+	a, b, err := NewSimpleTestTerminalPair(0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := StartMsgRecorder(recordingFile, true); err != nil {
+		t.Fatal(err)
+	}
+	_, tErr := recorderTestMethod.Call(context.Background(), a, &testRPCRequest{Message: "hello"})
+	assert.Nil(t, tErr, "call should succeed")
+	assert.Eventually(t, func() bool {
+		info, err := os.Stat(recordingFile)
+		return err == nil && info.Size() > 0
+	}, 3*recorderFlushInterval, recorderFlushInterval/10, "records should be written while recording")
+	if err := StopMsgRecorder(); err != nil {
+		t.Fatal(err)
+	}
+	a.Abandon(nil)
+	b.Abandon(nil)
+
+	// Read recording.
+	f, err := os.Open(recordingFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() //nolint:errcheck
+	msgs, err := ReadRecording(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that both directions were recorded.
+	var received []*RecordedMsg
+	var sentInit bool
+	for _, msg := range msgs {
+		switch {
+		case msg.ParentID == "b" && msg.Direction == RecordReceived:
+			received = append(received, msg)
+			assert.Equal(t, msg.Size, len(msg.Data), "size must match data")
+		case msg.ParentID == "a" && msg.Direction == RecordSent && msg.Type == MsgTypeInit:
+			sentInit = true
+		}
+	}
+	assert.True(t, sentInit, "sent init msg should be recorded")
+	if !assert.NotEmpty(t, received, "received msgs should be recorded") {
+		return
+	}
+	assert.Equal(t, MsgTypeInit, received[0].Type, "first received msg should be the init msg")
+
+	// Replay received messages into a new terminal.
+	_, c, err := NewSimpleTestTerminalPair(0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Abandon(nil)
+	calls := recorderTestCalls.Load()
+	assert.Nil(t, c.ReplayRecording(received, false), "replay should succeed")
+	assert.Equal(t, calls+1, recorderTestCalls.Load(), "replay should call the method again")
+}
+
+func TestReadRecordingLimit(t *testing.T) {
+	t.Parallel()
+
+	_, err := ReadRecording(bytes.NewReader(varint.Pack64(maxRecordSize + 1)))
+	assert.Error(t, err, "oversized record should be rejected")
+}
@@ -0,0 +1,267 @@
+package terminal
+
+import (
+	"time"
+
+	"github.com/safing/jess"
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/varint"
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/hub"
+)
+
+/*
+
+Terminal Rekeying Format:
+used when FeatureRekeying is negotiated, applied to encrypted data
+
+- Session ID [varint]
+- Letter [bytes; not blocked]
+	- First letter of a new session: Letter of the previous session, which
+	  contains the letter of the new session.
+
+The initiating terminal regularly starts a new encryption session with the
+remote Hub and uses it for all further messages. The first letter of a new
+session carries everything needed to set it up. It is sent within a letter of
+the previous session, so that only the other end of the previous session can
+start a new one. When the remote terminal receives a letter with a higher
+session ID, it sets up the new session and uses it for all further messages
+too. The remote terminal only switches to the new session after the letter was
+successfully decrypted. The initiating terminal keeps older sessions for
+receiving until the remote terminal has switched.
+
+Both sides use the session ID of the session they are sending with, so that
+messages in transit can always be decrypted and running operations are not
+interrupted.
+
+*/
+
+const (
+	defaultRekeyAfterBytes = 1 << 30 // 1GB
+	defaultRekeyAfter      = 1 * time.Hour
+)
+
+// rekeyingState holds the state for rekeying encryption sessions.
+// It is locked by the jessionLock of the terminal.
+type rekeyingState struct {
+	// encryptFor is the Hub to which new sessions are started.
+	// It is only set on the initiating terminal.
+	encryptFor *hub.Hub
+	afterBytes uint64
+	after      time.Duration
+
+	// sendID is the ID of the session used for sending.
+	sendID uint32
+	// recvID is the ID of the session used for receiving.
+	recvID uint32
+	// jessions holds all sessions that may be used for receiving. On the
+	// initiating terminal, these are all sessions from recvID to sendID.
+	jessions map[uint32]*jess.Session
+	// announceWith holds the previous session, with which the first letter of
+	// a new session is sent.
+	announceWith *jess.Session
+
+	// sentBytes holds the amount of data sent with the current session.
+	sentBytes uint64
+	// started holds when the current session was started.
+	started time.Time
+	// rekeys holds the amount of session switches.
+	rekeys int
+}
+
+// RequestRekeying requests rekeying, if the given remote Hub supports it.
+func (opts *TerminalOpts) RequestRekeying(remoteHub *hub.Hub) {
+	if remoteHub == nil ||
+		remoteHub.Status == nil ||
+		!remoteHub.Status.HasFlag(hub.FlagRekeying) {
+		return
+	}
+
+	if opts.Version < conf.VersionTwo {
+		opts.Version = conf.VersionTwo
+	}
+	opts.Features |= FeatureRekeying
+}
+
+func newRekeyingState(encryptFor *hub.Hub, opts *TerminalOpts, jession *jess.Session) *rekeyingState {
+	r := &rekeyingState{
+		encryptFor: encryptFor,
+		afterBytes: opts.RekeyAfterBytes,
+		after:      opts.RekeyAfter,
+		jessions:   make(map[uint32]*jess.Session),
+		started:    time.Now(),
+	}
+	if r.afterBytes == 0 {
+		r.afterBytes = defaultRekeyAfterBytes
+	}
+	if r.after == 0 {
+		r.after = defaultRekeyAfter
+	}
+	if jession != nil {
+		r.jessions[0] = jession
+	}
+
+	return r
+}
+
+// rekeyNeeded returns whether the initiating terminal should start a new
+// session.
+func (r *rekeyingState) rekeyNeeded() bool {
+	return r.encryptFor != nil &&
+		(r.sentBytes >= r.afterBytes || time.Since(r.started) >= r.after)
+}
+
+// rekey starts a new session for sending. The jessionLock must be held.
+func (t *TerminalBase) rekey() *Error {
+	r := t.rekeying
+
+	// Reset counters in any case, so that a failed rekey is only retried
+	// after the next threshold.
+	r.sentBytes = 0
+	r.started = time.Now()
+
+	// Create new session.
+	jession, tErr := newLocalJession(r.encryptFor)
+	if tErr != nil {
+		return tErr
+	}
+
+	// Switch to new session for sending.
+	r.announceWith = t.jession
+	r.sendID++
+	r.jessions[r.sendID] = jession
+	t.jession = jession
+	r.rekeys++
+
+	return nil
+}
+
+// encryptWithRekeying encrypts the given data and rekeys when needed.
+// The jessionLock must be held.
+func (t *TerminalBase) encryptWithRekeying(c *container.Container) (*container.Container, *Error) {
+	r := t.rekeying
+
+	// Start a new session, if needed.
+	if r.rekeyNeeded() {
+		if tErr := t.rekey(); tErr != nil {
+			log.Warningf("spn/terminal: %s failed to rekey: %s", t.FmtID(), tErr)
+		}
+	}
+
+	data := c.CompileData()
+	letter, err := t.jession.Close(data)
+	if err != nil {
+		return nil, ErrIntegrity.With("failed to encrypt: %w", err)
+	}
+
+	encryptedData, err := letter.ToWire()
+	if err != nil {
+		return nil, ErrInternalError.With("failed to pack letter: %w", err)
+	}
+
+	// Send the first letter of a new session within the previous session.
+	if r.announceWith != nil {
+		announcement, err := r.announceWith.Close(encryptedData.CompileData())
+		if err != nil {
+			return nil, ErrIntegrity.With("failed to encrypt session announcement: %w", err)
+		}
+		encryptedData, err = announcement.ToWire()
+		if err != nil {
+			return nil, ErrInternalError.With("failed to pack session announcement: %w", err)
+		}
+		r.announceWith = nil
+	}
+	encryptedData.Prepend(varint.Pack32(r.sendID))
+	r.sentBytes += uint64(len(data))
+
+	return encryptedData, nil
+}
+
+// decryptWithRekeying decrypts the given data and switches to new sessions
+// when the other side did. The jessionLock must be held.
+func (t *TerminalBase) decryptWithRekeying(c *container.Container) (*container.Container, *Error) {
+	r := t.rekeying
+
+	sessionID, err := c.GetNextN32()
+	if err != nil {
+		return nil, ErrMalformedData.With("failed to get session ID: %w", err)
+	}
+	letter, err := jess.LetterFromWire(c)
+	if err != nil {
+		return nil, ErrMalformedData.With("failed to parse letter: %w", err)
+	}
+
+	// Check which session to use and how to switch sessions after the letter
+	// was successfully decrypted.
+	var switchSession func()
+	jession, ok := r.jessions[sessionID]
+	switch {
+	case ok && sessionID == r.recvID:
+		// Current session.
+
+	case ok && sessionID > r.recvID:
+		// The remote terminal switched to a session we started.
+		switchSession = func() {
+			for id := range r.jessions {
+				if id < sessionID {
+					delete(r.jessions, id)
+				}
+			}
+			r.recvID = sessionID
+		}
+
+	case !ok && t.identity != nil && t.jession == nil:
+		// The remote terminal started the first session.
+		jession, err = letter.WireCorrespondence(t.identity)
+		if err != nil {
+			return nil, ErrIntegrity.With("failed to initialize incoming encryption: %w", err)
+		}
+		switchSession = func() {
+			r.jessions = map[uint32]*jess.Session{sessionID: jession}
+			r.sendID = sessionID
+			r.recvID = sessionID
+			t.jession = jession
+
+			// Encryption is ready for sending when the first session is set up.
+			close(t.encryptionReady)
+		}
+
+	case !ok && t.identity != nil && sessionID > r.recvID:
+		// The remote terminal started a new session within the current session.
+		announcement, err := t.jession.Open(letter)
+		if err != nil {
+			return nil, ErrIntegrity.With("failed to decrypt session announcement: %w", err)
+		}
+		letter, err = jess.LetterFromWire(container.New(announcement))
+		if err != nil {
+			return nil, ErrMalformedData.With("failed to parse announced letter: %w", err)
+		}
+		jession, err = letter.WireCorrespondence(t.identity)
+		if err != nil {
+			return nil, ErrIntegrity.With("failed to initialize incoming encryption: %w", err)
+		}
+		switchSession = func() {
+			r.jessions = map[uint32]*jess.Session{sessionID: jession}
+			r.sendID = sessionID
+			r.recvID = sessionID
+			t.jession = jession
+			r.rekeys++
+		}
+
+	default:
+		return nil, ErrIntegrity.With("received letter for unknown session %d", sessionID)
+	}
+
+	decryptedData, err := jession.Open(letter)
+	if err != nil {
+		return nil, ErrIntegrity.With("failed to decrypt: %w", err)
+	}
+
+	// Switch sessions only after the letter was verified.
+	if switchSession != nil {
+		switchSession()
+	}
+
+	return container.New(decryptedData), nil
+}
@@ -0,0 +1,281 @@
+package terminal
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// TrafficClass defines how the messages of an operation are scheduled for
+// sending within a terminal.
+type TrafficClass uint8
+
+// Traffic Classes.
+const (
+	// TrafficClassInteractive is for operations that need a low latency.
+	// It is the default traffic class.
+	TrafficClassInteractive TrafficClass = iota
+
+	// TrafficClassControl is for operations that control or measure the network.
+	TrafficClassControl
+
+	// TrafficClassBulk is for operations that may transfer large amounts of data.
+	TrafficClassBulk
+
+	numTrafficClasses
+)
+
+// trafficClassWeights defines the share of sending capacity every traffic
+// class gets when competing with other traffic classes.
+var trafficClassWeights = [numTrafficClasses]int{
+	TrafficClassInteractive: 4,
+	TrafficClassControl:     8,
+	TrafficClassBulk:        1,
+}
+
+const (
+	// sendQueueFlowSize defines how many messages an operation may queue.
+	// Operations must wait while their queue is full. This keeps the
+	// backpressure on operations when the terminal is busy.
+	sendQueueFlowSize = 1
+
+	// sendQueueQuantum defines how many bytes a traffic class with the weight 1
+	// may send per round.
+	sendQueueQuantum = 1500
+)
+
+// sendQueueDepth holds the amount of queued messages per traffic class over
+// all terminals.
+var sendQueueDepth [numTrafficClasses]int64
+
+func (tc TrafficClass) String() string {
+	switch tc {
+	case TrafficClassInteractive:
+		return "interactive"
+	case TrafficClassControl:
+		return "control"
+	case TrafficClassBulk:
+		return "bulk"
+	default:
+		return "unknown"
+	}
+}
+
+// sendQueue queues messages of operations for sending. Traffic classes are
+// scheduled with deficit round robin according to their weight and the
+// operations within a traffic class are scheduled with round robin.
+type sendQueue struct {
+	lock sync.Mutex
+
+	// flows holds the queues of all operations with queued messages.
+	flows map[uint32]*sendQueueFlow
+	// classes holds the scheduling state of all traffic classes.
+	classes [numTrafficClasses]sendQueueClass
+	// current is the traffic class that is currently scheduled.
+	current TrafficClass
+	// turnStarted signifies whether the current traffic class has already
+	// received its quantum for the current round.
+	turnStarted bool
+	// queued holds the amount of queued messages.
+	queued int
+	// closed signifies that the queue does not accept messages anymore.
+	closed bool
+
+	// notify signals that messages were queued.
+	notify chan struct{}
+	// getClass returns the traffic class of an operation.
+	getClass func(flowID uint32) TrafficClass
+}
+
+type sendQueueClass struct {
+	active  []*sendQueueFlow
+	deficit int
+}
+
+type sendQueueFlow struct {
+	id    uint32
+	class TrafficClass
+	msgs  []*Msg
+	space chan struct{}
+}
+
+func newSendQueue(getClass func(flowID uint32) TrafficClass) *sendQueue {
+	return &sendQueue{
+		flows:    make(map[uint32]*sendQueueFlow),
+		notify:   make(chan struct{}, 1),
+		getClass: getClass,
+	}
+}
+
+// Push adds the message to the queue of its operation. If the queue of the
+// operation is full, a channel is returned that is closed when there is space
+// available again.
+func (sq *sendQueue) Push(msg *Msg) (wait <-chan struct{}, err *Error) {
+	// Get traffic class of new flows without holding the lock.
+	sq.lock.Lock()
+	_, ok := sq.flows[msg.FlowID]
+	sq.lock.Unlock()
+	class := TrafficClassInteractive
+	if !ok {
+		class = sq.getClass(msg.FlowID)
+		if class >= numTrafficClasses {
+			class = TrafficClassInteractive
+		}
+	}
+
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+
+	if sq.closed {
+		return nil, ErrStopping
+	}
+
+	// Get or create flow.
+	flow, ok := sq.flows[msg.FlowID]
+	if !ok {
+		flow = &sendQueueFlow{
+			id:    msg.FlowID,
+			class: class,
+			space: make(chan struct{}),
+		}
+		sq.flows[msg.FlowID] = flow
+		sq.classes[class].active = append(sq.classes[class].active, flow)
+	}
+
+	// Check if there is space in the queue of the flow.
+	if len(flow.msgs) >= sendQueueFlowSize {
+		return flow.space, nil
+	}
+
+	// Queue message.
+	flow.msgs = append(flow.msgs, msg)
+	sq.queued++
+	atomic.AddInt64(&sendQueueDepth[flow.class], 1)
+	sq.signal()
+
+	return nil, nil
+}
+
+// Pop returns the next message to send, or nil if no message is queued.
+func (sq *sendQueue) Pop() *Msg {
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+
+	if sq.queued == 0 {
+		return nil
+	}
+
+	for {
+		class := &sq.classes[sq.current]
+
+		// Skip traffic classes without queued messages.
+		if len(class.active) == 0 {
+			class.deficit = 0
+			sq.nextClass()
+			continue
+		}
+
+		// Add quantum at the start of the turn.
+		if !sq.turnStarted {
+			class.deficit += trafficClassWeights[sq.current] * sendQueueQuantum
+			sq.turnStarted = true
+		}
+
+		// Continue with the next traffic class, if the deficit is used up.
+		flow := class.active[0]
+		msg := flow.msgs[0]
+		size := msgSize(msg)
+		if size > class.deficit {
+			sq.nextClass()
+			continue
+		}
+		class.deficit -= size
+
+		// Take message from flow.
+		wasFull := len(flow.msgs) >= sendQueueFlowSize
+		flow.msgs[0] = nil
+		flow.msgs = flow.msgs[1:]
+		sq.queued--
+		atomic.AddInt64(&sendQueueDepth[flow.class], -1)
+
+		// Signal waiting senders.
+		if wasFull {
+			close(flow.space)
+			flow.space = make(chan struct{})
+		}
+
+		// Move flow to the end of the traffic class, or remove it if empty.
+		class.active[0] = nil
+		class.active = class.active[1:]
+		if len(flow.msgs) > 0 {
+			class.active = append(class.active, flow)
+		} else {
+			delete(sq.flows, flow.id)
+		}
+
+		return msg
+	}
+}
+
+// nextClass continues scheduling with the next traffic class.
+// The lock must be held.
+func (sq *sendQueue) nextClass() {
+	sq.current = (sq.current + 1) % numTrafficClasses
+	sq.turnStarted = false
+}
+
+// Len returns the amount of queued messages.
+func (sq *sendQueue) Len() int {
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+
+	return sq.queued
+}
+
+// Signal signals that messages are queued, if there are any.
+func (sq *sendQueue) Signal() {
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+
+	if sq.queued > 0 {
+		sq.signal()
+	}
+}
+
+// signal signals that messages are queued. The lock must be held.
+func (sq *sendQueue) signal() {
+	select {
+	case sq.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Close closes the queue and finishes all queued messages.
+func (sq *sendQueue) Close() {
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+
+	sq.closed = true
+	for _, flow := range sq.flows {
+		for _, msg := range flow.msgs {
+			msg.Finish()
+		}
+		atomic.AddInt64(&sendQueueDepth[flow.class], -int64(len(flow.msgs)))
+		close(flow.space)
+	}
+	sq.flows = make(map[uint32]*sendQueueFlow)
+	sq.classes = [numTrafficClasses]sendQueueClass{}
+	sq.queued = 0
+}
+
+func msgSize(msg *Msg) int {
+	if msg.Data == nil {
+		return 0
+	}
+	return msg.Data.Length()
+}
+
+func getSendQueueDepth(class TrafficClass) func() float64 {
+	return func() float64 {
+		return float64(atomic.LoadInt64(&sendQueueDepth[class]))
+	}
+}
@@ -0,0 +1,91 @@
+package terminal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendQueue(t *testing.T) {
+	t.Parallel()
+
+	const (
+		controlFlow          = 1
+		bulkFlowStart        = 100
+		interactiveFlowStart = 200
+		flowsPerClass        = 10
+	)
+	sq := newSendQueue(func(flowID uint32) TrafficClass {
+		switch {
+		case flowID == controlFlow:
+			return TrafficClassControl
+		case flowID >= interactiveFlowStart:
+			return TrafficClassInteractive
+		case flowID >= bulkFlowStart:
+			return TrafficClassBulk
+		default:
+			return TrafficClassInteractive
+		}
+	})
+	push := func(flowID uint32) <-chan struct{} {
+		msg := NewMsg(make([]byte, 1000))
+		msg.FlowID = flowID
+		wait, err := sq.Push(msg)
+		assert.Nil(t, err, "push should succeed")
+		if wait != nil {
+			msg.Finish()
+		}
+		return wait
+	}
+	pop := func() uint32 {
+		msg := sq.Pop()
+		if msg == nil {
+			return 0
+		}
+		msg.Finish()
+		return msg.FlowID
+	}
+
+	// Fill the queue of a bulk operation.
+	for i := 0; i < sendQueueFlowSize; i++ {
+		assert.Nil(t, push(bulkFlowStart), "bulk queue should have space")
+	}
+	wait := push(bulkFlowStart)
+	assert.NotNil(t, wait, "bulk queue should be full")
+
+	// Control messages must not wait for bulk messages.
+	assert.Nil(t, push(controlFlow), "control queue should have space")
+	assert.Equal(t, uint32(controlFlow), pop(), "control message should be sent first")
+	for i := 0; i < sendQueueFlowSize; i++ {
+		assert.Equal(t, uint32(bulkFlowStart), pop(), "bulk message should be sent next")
+	}
+	select {
+	case <-wait:
+	default:
+		t.Error("bulk queue should have space again")
+	}
+
+	// Interactive messages get a higher share than bulk messages.
+	for i := uint32(0); i < flowsPerClass; i++ {
+		assert.Nil(t, push(bulkFlowStart+i), "bulk queue should have space")
+		assert.Nil(t, push(interactiveFlowStart+i), "interactive queue should have space")
+	}
+	var interactive int
+	for i := 0; i < flowsPerClass; i++ {
+		if pop() >= interactiveFlowStart {
+			interactive++
+		}
+	}
+	assert.GreaterOrEqual(t, interactive, 7, "interactive messages should get a higher share")
+
+	// Drain and close.
+	for sq.Len() > 0 {
+		pop()
+	}
+	assert.Nil(t, sq.Pop(), "queue should be empty")
+	sq.Close()
+	msg := NewMsg(nil)
+	defer msg.Finish()
+	_, err := sq.Push(msg)
+	assert.True(t, err.Is(ErrStopping), "closed queue should not accept messages")
+}
@@ -82,10 +82,12 @@ type TerminalBase struct { //nolint:golint,maligned // Being explicit is helpful
 
 	// ext holds the extended terminal so that the base terminal can access custom functions.
 	ext Terminal
-	// sendQueue holds message to be sent.
-	sendQueue chan *Msg
+	// sendQueue holds messages of operations to be sent.
+	sendQueue *sendQueue
 	// flowControl holds the flow control system.
 	flowControl FlowControl
+	// flowEstimator is fed with samples of the traffic of the Terminal.
+	flowEstimator atomic.Pointer[FlowEstimator]
 	// upstream represents the upstream (parent) terminal.
 	upstream Upstream
 
@@ -118,6 +120,14 @@ type TerminalBase struct { //nolint:golint,maligned // Being explicit is helpful
 	encryptionReady chan struct{}
 	// identity is the identity used by a remote Terminal.
 	identity *cabin.Identity
+	// rekeying holds the rekeying state, if rekeying is enabled.
+	// It is locked by jessionLock.
+	rekeying *rekeyingState
+	// heartbeat holds the round trip times measured by heartbeats.
+	heartbeat heartbeatState
+	// confirmNegotiation defines whether the initiating terminal checks if the
+	// remote terminal agreed to the requested features.
+	confirmNegotiation bool
 
 	// operations holds references to all active operations that require persistence.
 	operations map[uint32]Operation
@@ -125,6 +135,13 @@ type TerminalBase struct { //nolint:golint,maligned // Being explicit is helpful
 	nextOpID *uint32
 	// permission holds the permissions of the terminal.
 	permission Permission
+	// grant holds the grant of the terminal, including the permissions.
+	grant *Grant
+	// byteQuota holds the byte quota of the grant for quick access.
+	byteQuota atomic.Uint64
+	// usedBytes holds the amount of bytes of operation messages sent and
+	// received by the terminal.
+	usedBytes atomic.Uint64
 
 	// opts holds the terminal options. It must not be modified after the terminal
 	// has started.
@@ -156,7 +173,6 @@ func createTerminalBase(
 	t := &TerminalBase{
 		id:              id,
 		parentID:        parentID,
-		sendQueue:       make(chan *Msg),
 		upstream:        upstream,
 		waitForFlush:    abool.New(),
 		flush:           make(chan func()),
@@ -168,6 +184,7 @@ func createTerminalBase(
 		opts:            initMsg,
 		Abandoning:      abool.New(),
 	}
+	t.sendQueue = newSendQueue(t.getTrafficClass)
 	// Stop ticking to disable timeout.
 	t.idleTicker.Stop()
 	// Shift next operation ID if remote.
@@ -183,6 +200,10 @@ func createTerminalBase(
 		t.flowControl = NewDuplexFlowQueue(t.Ctx(), initMsg.FlowControlSize, t.submitToUpstream)
 		t.deliverProxy = t.flowControl.Deliver
 		t.recvProxy = t.flowControl.Receive
+	case FlowControlCongestion:
+		t.flowControl = NewCongestionFlowQueue(t.Ctx(), initMsg.FlowControlSize, t.submitToUpstream)
+		t.deliverProxy = t.flowControl.Deliver
+		t.recvProxy = t.flowControl.Receive
 	case FlowControlNone:
 		deliver := make(chan *Msg, initMsg.FlowControlSize)
 		t.deliverProxy = MakeDirectDeliveryDeliverFunc(ctx, deliver)
@@ -206,6 +227,29 @@ func (t *TerminalBase) Ctx() context.Context {
 	return t.ctx
 }
 
+// Version returns the Terminal's negotiated protocol version.
+func (t *TerminalBase) Version() uint8 {
+	return t.opts.Version
+}
+
+// HasFeatures returns whether all of the given features were negotiated.
+func (t *TerminalBase) HasFeatures(features FeatureFlags) bool {
+	return t.opts.Features.Has(features)
+}
+
+func (t *TerminalBase) negotiatedFeatures() FeatureFlags {
+	return t.opts.Features
+}
+
+// getTrafficClass returns the traffic class of the operation with the given ID.
+func (t *TerminalBase) getTrafficClass(opID uint32) TrafficClass {
+	op, ok := t.GetActiveOp(opID)
+	if !ok {
+		return TrafficClassInteractive
+	}
+	return op.TrafficClass()
+}
+
 // SetTerminalExtension sets the Terminal's extension. This function is not
 // guarded and may only be used during initialization.
 func (t *TerminalBase) SetTerminalExtension(ext Terminal) {
@@ -240,6 +284,16 @@ func (t *TerminalBase) StartWorkers(m *modules.Module, terminalName string) {
 	if t.flowControl != nil {
 		t.flowControl.StartWorkers(m, terminalName)
 	}
+
+	// Start heartbeats, if enabled.
+	if t.opts.HeartbeatInterval > 0 {
+		m.StartWorker(terminalName+" heartbeat", t.heartbeater)
+	}
+
+	// Confirm negotiated features, if requested.
+	if t.confirmNegotiation {
+		m.StartWorker(terminalName+" negotiation confirmer", t.negotiationConfirmer)
+	}
 }
 
 const (
@@ -332,6 +386,7 @@ func (t *TerminalBase) Sender(_ context.Context) error {
 			// Call Abandon just in case.
 			// Normally, the only the StopProcedure function should cancel the context.
 			t.Abandon(nil)
+			t.sendQueue.Close()
 			return nil // Controlled worker exit.
 		case <-t.encryptionReady:
 		}
@@ -341,22 +396,26 @@ func (t *TerminalBase) Sender(_ context.Context) error {
 	defer t.Abandon(ErrInternalError.With("sender died"))
 
 	var msgBufferMsg *Msg
+	var msgBufferFlowID uint32
 	var msgBufferLen int
 	var msgBufferLimitReached bool
 	var sendMsgs bool
 	var sendMaxWait *time.Timer
 	var flushFinished func()
 
-	// Finish any current unit when returning.
-	defer msgBufferMsg.Finish()
+	// Finish any current unit and queued messages when returning.
+	defer func() {
+		msgBufferMsg.Finish()
+		t.sendQueue.Close()
+	}()
 
 	// Only receive message when not sending the current msg buffer.
-	sendQueueOpMsgs := func() <-chan *Msg {
+	sendQueueOpMsgs := func() <-chan struct{} {
 		// Don't handle more messages, if the buffer is full.
 		if msgBufferLimitReached {
 			return nil
 		}
-		return t.sendQueue
+		return t.sendQueue.notify
 	}
 
 	// Only wait for sending slot when the current msg buffer is ready to be sent.
@@ -399,35 +458,46 @@ handling:
 				atomic.StoreUint32(t.idleCounter, 0)
 			}
 
-		case msg := <-sendQueueOpMsgs():
-			if msg == nil {
-				continue handling
-			}
-
-			// Add unit to buffer unit, or use it as new buffer.
-			if msgBufferMsg != nil {
-				// Pack, append and finish additional message.
-				msgBufferMsg.Consume(msg)
-			} else {
-				// Pack operation message.
-				msg.Pack()
-				// Convert to message of terminal.
-				msgBufferMsg = msg
-				msgBufferMsg.FlowID = t.ID()
-				msgBufferMsg.Type = MsgTypeData
-			}
-			msgBufferLen += msg.Data.Length()
+		case <-sendQueueOpMsgs():
+			// Take messages from the send queue until the buffer is full.
+			for !msgBufferLimitReached {
+				msg := t.sendQueue.Pop()
+				if msg == nil {
+					break
+				}
+				t.recordMsg(RecordSent, msg.FlowID, msg.Type, msg.Data)
+				t.addUsedBytes(msg.Data.Length())
+
+				// Add unit to buffer unit, or use it as new buffer.
+				if msgBufferMsg != nil {
+					// Never compress data of different operations together.
+					if msg.FlowID != msgBufferFlowID {
+						msgBufferMsg.Incompressible = true
+					}
+					// Pack, append and finish additional message.
+					msgBufferMsg.Consume(msg)
+				} else {
+					// Pack operation message.
+					msgBufferFlowID = msg.FlowID
+					msg.Pack()
+					// Convert to message of terminal.
+					msgBufferMsg = msg
+					msgBufferMsg.FlowID = t.ID()
+					msgBufferMsg.Type = MsgTypeData
+				}
+				msgBufferLen += msg.Data.Length()
 
-			// Check if there is enough data to hit the sending threshold.
-			if msgBufferLen >= sendThresholdLength {
-				sendMsgs = true
-			} else if sendMaxWait == nil && t.waitForFlush.IsNotSet() {
-				sendMaxWait = time.NewTimer(sendThresholdMaxWait)
-			}
+				// Check if there is enough data to hit the sending threshold.
+				if msgBufferLen >= sendThresholdLength || flushFinished != nil {
+					sendMsgs = true
+				} else if sendMaxWait == nil && t.waitForFlush.IsNotSet() {
+					sendMaxWait = time.NewTimer(sendThresholdMaxWait)
+				}
 
-			// Check if we have reached the maximum buffer size.
-			if msgBufferLen >= sendMaxLength {
-				msgBufferLimitReached = true
+				// Check if we have reached the maximum buffer size.
+				if msgBufferLen >= sendMaxLength {
+					msgBufferLimitReached = true
+				}
 			}
 
 			// Register activity.
@@ -444,8 +514,8 @@ handling:
 			// We are flushing - stop waiting.
 			t.waitForFlush.UnSet()
 
-			// Signal immediately if msg buffer is empty.
-			if msgBufferLen == 0 {
+			// Signal immediately if msg buffer and send queue are empty.
+			if msgBufferLen == 0 && t.sendQueue.Len() == 0 {
 				newFlushFinishedFn()
 			} else {
 				// If there already is a flush finished function, stack them.
@@ -494,8 +564,12 @@ handling:
 				sendMaxWait = nil
 			}
 
+			// Continue with queued messages.
+			t.sendQueue.Signal()
+
 			// Check if we are flushing and need to notify.
-			if flushFinished != nil {
+			// Flushing is finished when the send queue is empty.
+			if flushFinished != nil && t.sendQueue.Len() == 0 {
 				flushFinished()
 				flushFinished = nil
 			}
@@ -545,6 +619,17 @@ func (t *TerminalBase) Flush(timeout time.Duration) {
 	}
 }
 
+// SetFlowEstimator sets the flow estimator that is fed with samples of the
+// flow control traffic and heartbeats of the Terminal.
+func (t *TerminalBase) SetFlowEstimator(fe *FlowEstimator) {
+	t.flowEstimator.Store(fe)
+	if estimating, ok := t.flowControl.(interface {
+		SetFlowEstimator(fe *FlowEstimator)
+	}); ok {
+		estimating.SetFlowEstimator(fe)
+	}
+}
+
 func (t *TerminalBase) encrypt(c *container.Container) (*container.Container, *Error) {
 	if !t.opts.Encrypt {
 		return c, nil
@@ -553,6 +638,10 @@ func (t *TerminalBase) encrypt(c *container.Container) (*container.Container, *E
 	t.jessionLock.Lock()
 	defer t.jessionLock.Unlock()
 
+	if t.rekeying != nil {
+		return t.encryptWithRekeying(c)
+	}
+
 	letter, err := t.jession.Close(c.CompileData())
 	if err != nil {
 		return nil, ErrIntegrity.With("failed to encrypt: %w", err)
@@ -574,6 +663,10 @@ func (t *TerminalBase) decrypt(c *container.Container) (*container.Container, *E
 	t.jessionLock.Lock()
 	defer t.jessionLock.Unlock()
 
+	if t.rekeying != nil {
+		return t.decryptWithRekeying(c)
+	}
+
 	letter, err := jess.LetterFromWire(c)
 	if err != nil {
 		return nil, ErrMalformedData.With("failed to parse letter: %w", err)
@@ -626,6 +719,16 @@ func (t *TerminalBase) handleReceive(msg *Msg) *Error {
 		return tErr
 	}
 
+	// Decompress if enabled.
+	var padding *container.Container
+	msg.Data, padding, tErr = t.decompress(msg.Data)
+	if tErr != nil {
+		return tErr
+	}
+	if padding != nil {
+		t.handlePaddingMsg(padding)
+	}
+
 	// Handle operation messages.
 	for msg.Data.HoldsData() {
 		// Get next message length.
@@ -664,6 +767,8 @@ func (t *TerminalBase) handleOpMsg(data *container.Container) *Error {
 	if err != nil {
 		return ErrMalformedData.With("failed to parse operation msg id/type: %w", err)
 	}
+	t.recordMsg(RecordReceived, opID, msgType, data)
+	t.addUsedBytes(data.Length())
 
 	switch msgType {
 	case MsgTypeInit:
@@ -746,6 +851,9 @@ func (t *TerminalBase) handlePaddingMsg(c *container.Container) {
 func (t *TerminalBase) sendOpMsgs(msg *Msg) *Error {
 	msg.Unit.WaitForSlot()
 
+	// Compress if enabled.
+	t.compress(msg)
+
 	// Add Padding if needed.
 	if t.opts.Padding > 0 {
 		paddingNeeded := (int(t.opts.Padding) - msg.Data.Length()) % int(t.opts.Padding)
@@ -773,6 +881,11 @@ func (t *TerminalBase) sendOpMsgs(msg *Msg) *Error {
 		return tErr
 	}
 
+	// Encrypted data cannot be compressed by upstream terminals.
+	if t.opts.Encrypt {
+		msg.Incompressible = true
+	}
+
 	// Send data.
 	t.submit(msg, 0)
 	return nil
@@ -1,6 +1,7 @@
 package terminal
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"runtime/pprof"
@@ -9,7 +10,10 @@ import (
 	"time"
 
 	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/formats/varint"
 	"github.com/safing/spn/cabin"
+	"github.com/safing/spn/conf"
 	"github.com/safing/spn/hub"
 )
 
@@ -27,6 +31,7 @@ func TestTerminals(t *testing.T) {
 		for _, fc := range []struct {
 			flowControl     FlowControlType
 			flowControlSize uint32
+			features        FeatureFlags
 		}{
 			{
 				flowControl:     FlowControlNone,
@@ -36,14 +41,24 @@ func TestTerminals(t *testing.T) {
 				flowControl:     FlowControlDFQ,
 				flowControlSize: defaultTestQueueSize,
 			},
+			{
+				flowControl:     FlowControlCongestion,
+				flowControlSize: defaultTestQueueSize,
+				features:        FeatureCongestionControl,
+			},
 		} {
 			// Run tests with combined options.
-			testTerminals(t, identity, &TerminalOpts{
+			opts := &TerminalOpts{
+				Features:        fc.features,
 				Encrypt:         encrypt,
 				Padding:         defaultTestPadding,
 				FlowControl:     fc.flowControl,
 				FlowControlSize: fc.flowControlSize,
-			})
+			}
+			if fc.features != 0 {
+				opts.Version = conf.VersionTwo
+			}
+			testTerminals(t, identity, opts)
 		}
 	}
 }
@@ -51,39 +66,8 @@ func TestTerminals(t *testing.T) {
 func testTerminals(t *testing.T, identity *cabin.Identity, terminalOpts *TerminalOpts) {
 	t.Helper()
 
-	// Prepare encryption.
-	var dstHub *hub.Hub
-	if terminalOpts.Encrypt {
-		dstHub = identity.Hub
-	} else {
-		identity = nil
-	}
-
 	// Create test terminals.
-	var term1 *TestTerminal
-	var term2 *TestTerminal
-	var initData *container.Container
-	var err *Error
-	term1, initData, err = NewLocalTestTerminal(
-		module.Ctx, 127, "c1", dstHub, terminalOpts, createForwardingUpstream(
-			t, "c1", "c2", func(msg *Msg) *Error {
-				return term2.Deliver(msg)
-			},
-		),
-	)
-	if err != nil {
-		t.Fatalf("failed to create local terminal: %s", err)
-	}
-	term2, _, err = NewRemoteTestTerminal(
-		module.Ctx, 127, "c2", identity, initData, createForwardingUpstream(
-			t, "c2", "c1", func(msg *Msg) *Error {
-				return term1.Deliver(msg)
-			},
-		),
-	)
-	if err != nil {
-		t.Fatalf("failed to create remote terminal: %s", err)
-	}
+	term1, term2 := createTestTerminals(t, identity, terminalOpts)
 
 	// Start testing with counters.
 	countToQueueSize := uint64(terminalOpts.FlowControlSize)
@@ -200,6 +184,44 @@ func testTerminals(t *testing.T, identity *cabin.Identity, terminalOpts *Termina
 	time.Sleep(100 * time.Millisecond)
 }
 
+func createTestTerminals(t *testing.T, identity *cabin.Identity, terminalOpts *TerminalOpts) (term1, term2 *TestTerminal) {
+	t.Helper()
+
+	// Prepare encryption.
+	var dstHub *hub.Hub
+	if terminalOpts.Encrypt {
+		dstHub = identity.Hub
+	} else {
+		identity = nil
+	}
+
+	// Create test terminals.
+	var initData *container.Container
+	var err *Error
+	term1, initData, err = NewLocalTestTerminal(
+		module.Ctx, 127, "c1", dstHub, terminalOpts, createForwardingUpstream(
+			t, "c1", "c2", func(msg *Msg) *Error {
+				return term2.Deliver(msg)
+			},
+		),
+	)
+	if err != nil {
+		t.Fatalf("failed to create local terminal: %s", err)
+	}
+	term2, _, err = NewRemoteTestTerminal(
+		module.Ctx, 127, "c2", identity, initData, createForwardingUpstream(
+			t, "c2", "c1", func(msg *Msg) *Error {
+				return term1.Deliver(msg)
+			},
+		),
+	)
+	if err != nil {
+		t.Fatalf("failed to create remote terminal: %s", err)
+	}
+
+	return term1, term2
+}
+
 func createForwardingUpstream(t *testing.T, srcName, dstName string, deliverFunc func(*Msg) *Error) Upstream {
 	t.Helper()
 
@@ -236,6 +258,8 @@ type testWithCounterOpts struct {
 	clientCountTo   uint64
 	serverCountTo   uint64
 	waitBetweenMsgs time.Duration
+	// linkDelay is the delay of the link between the terminals.
+	linkDelay time.Duration
 }
 
 func testTerminalWithCounters(t *testing.T, term1, term2 *TestTerminal, opts *testWithCounterOpts) {
@@ -282,18 +306,42 @@ func testTerminalWithCounters(t *testing.T, term1, term2 *TestTerminal, opts *te
 	printCTStats(t, opts.testName, "term2", term2)
 
 	// Check if stats match, if DFQ is used on both sides.
+	// On delayed links, wait for messages in transit.
+	checkUntil := time.Now().Add(100 * opts.linkDelay)
+	for !spaceCountersMatch(term1, term2) {
+		if time.Now().After(checkUntil) {
+			t.Fatalf("terminal test %s has non-matching space counters", opts.testName)
+		}
+		time.Sleep(opts.linkDelay)
+	}
+}
+
+func spaceCountersMatch(term1, term2 *TestTerminal) bool {
 	dfq1, ok1 := term1.flowControl.(*DuplexFlowQueue)
 	dfq2, ok2 := term2.flowControl.(*DuplexFlowQueue)
-	if ok1 && ok2 &&
-		(atomic.LoadInt32(dfq1.sendSpace) != atomic.LoadInt32(dfq2.reportedSpace) ||
-			atomic.LoadInt32(dfq2.sendSpace) != atomic.LoadInt32(dfq1.reportedSpace)) {
-		t.Fatalf("terminal test %s has non-matching space counters", opts.testName)
+	if ok1 && ok2 {
+		return atomic.LoadInt32(dfq1.sendSpace) == atomic.LoadInt32(dfq2.reportedSpace) &&
+			atomic.LoadInt32(dfq2.sendSpace) == atomic.LoadInt32(dfq1.reportedSpace)
+	}
+
+	cfq1, ok1 := term1.flowControl.(*CongestionFlowQueue)
+	cfq2, ok2 := term2.flowControl.(*CongestionFlowQueue)
+	if ok1 && ok2 {
+		return atomic.LoadInt32(cfq1.sendSpace) == atomic.LoadInt32(cfq2.reportedSpace) &&
+			atomic.LoadInt32(cfq2.sendSpace) == atomic.LoadInt32(cfq1.reportedSpace)
 	}
+
+	return true
 }
 
 func printCTStats(t *testing.T, testName, name string, term *TestTerminal) {
 	t.Helper()
 
+	if cfq, ok := term.flowControl.(*CongestionFlowQueue); ok {
+		t.Logf("%s: %s: %s", testName, name, cfq.FlowStats())
+		return
+	}
+
 	dfq, ok := term.flowControl.(*DuplexFlowQueue)
 	if !ok {
 		return
@@ -309,3 +357,435 @@ func printCTStats(t *testing.T, testName, name string, term *TestTerminal) {
 		atomic.LoadInt32(dfq.reportedSpace),
 	)
 }
+
+func TestCongestionFlowQueue(t *testing.T) {
+	t.Parallel()
+
+	// Create terminal pair with a delay.
+	delay := 10 * time.Millisecond
+	term1, term2, err := NewSimpleTestTerminalPair(delay, 1000, &TerminalOpts{
+		Version:         conf.VersionTwo,
+		Features:        FeatureCongestionControl,
+		Padding:         defaultTestPadding,
+		FlowControl:     FlowControlCongestion,
+		FlowControlSize: 1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTerminalWithCounters(t, term1, term2, &testWithCounterOpts{
+		testName:      "congestion-delayed",
+		serverCountTo: 2000,
+		clientCountTo: 2000,
+		linkDelay:     delay,
+	})
+
+	// Check if the round trip time was measured and the window is in bounds.
+	for _, term := range []*TestTerminal{term1, term2} {
+		cfq, ok := term.flowControl.(*CongestionFlowQueue)
+		if !ok {
+			t.Fatal("terminal should use the congestion flow queue")
+		}
+		if cfq.RTT() < 2*delay {
+			t.Errorf("round trip time %s should be at least %s", cfq.RTT(), 2*delay)
+		}
+		if cfq.Window() < cfqMinWindow || cfq.Window() > 1000 {
+			t.Errorf("window %d is out of bounds", cfq.Window())
+		}
+	}
+}
+
+func TestRequestCongestionControl(t *testing.T) {
+	t.Parallel()
+
+	supporting := &hub.Hub{Status: &hub.Status{Flags: []string{hub.FlagCongestionControl}}}
+	other := &hub.Hub{Status: &hub.Status{}}
+
+	// Congestion control is only requested if both Hubs support it.
+	for _, test := range []struct {
+		name      string
+		remoteHub *hub.Hub
+		relayHub  *hub.Hub
+		requested bool
+	}{
+		{name: "both", remoteHub: supporting, relayHub: supporting, requested: true},
+		{name: "remote", remoteHub: supporting, relayHub: other},
+		{name: "relay", remoteHub: other, relayHub: supporting},
+		{name: "unknown relay", remoteHub: supporting},
+	} {
+		opts := DefaultExpansionTerminalOpts()
+		opts.RequestCongestionControl(test.remoteHub, test.relayHub)
+		if test.requested != (opts.FlowControl == FlowControlCongestion) ||
+			test.requested != opts.Features.Has(FeatureCongestionControl) {
+			t.Errorf("%s: unexpected flow control %d with features %b", test.name, opts.FlowControl, opts.Features)
+		}
+		if tErr := opts.Check(true); tErr != nil {
+			t.Errorf("%s: options should be valid: %s", test.name, tErr)
+		}
+	}
+
+	// Congestion control must not be used without negotiating it.
+	opts := &TerminalOpts{
+		FlowControl: FlowControlCongestion,
+	}
+	if tErr := opts.Check(true); !tErr.Is(ErrInvalidOptions) {
+		t.Errorf("congestion flow control without the feature should fail, got: %s", tErr)
+	}
+}
+
+func TestTerminalVersions(t *testing.T) {
+	t.Parallel()
+
+	// Simulate a future version with unknown features and options.
+	futureInitData := func(opts *TerminalOpts) *container.Container {
+		t.Helper()
+
+		optsData, err := dsd.Dump(&struct {
+			*TerminalOpts
+			Unknown string `json:"future,omitempty"`
+		}{
+			TerminalOpts: opts,
+			Unknown:      "option",
+		}, dsd.CBOR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return container.New(
+			varint.Pack8(maxSupportedTerminalVersion+1),
+			varint.Pack64(uint64(opts.Features|1<<63)),
+			optsData,
+		)
+	}
+
+	for _, test := range []struct {
+		name            string
+		version         uint8
+		future          bool
+		expectedVersion uint8
+	}{
+		{name: "v1", version: 1, expectedVersion: 1},
+		{name: "v2", version: 2, expectedVersion: 2},
+		{name: "future", version: 2, future: true, expectedVersion: maxSupportedTerminalVersion},
+	} {
+		opts := &TerminalOpts{
+			Version:         test.version,
+			Padding:         defaultTestPadding,
+			FlowControl:     FlowControlDFQ,
+			FlowControlSize: defaultTestQueueSize,
+		}
+
+		// Create mixed version test terminals.
+		var term1 *TestTerminal
+		var term2 *TestTerminal
+		var initData *container.Container
+		var err *Error
+		term1, initData, err = NewLocalTestTerminal(
+			module.Ctx, 127, "c1", nil, opts, createForwardingUpstream(
+				t, "c1", "c2", func(msg *Msg) *Error {
+					return term2.Deliver(msg)
+				},
+			),
+		)
+		if err != nil {
+			t.Fatalf("%s: failed to create local terminal: %s", test.name, err)
+		}
+		if test.future {
+			initData = futureInitData(opts)
+		}
+		term2, _, err = NewRemoteTestTerminal(
+			module.Ctx, 127, "c2", nil, initData, createForwardingUpstream(
+				t, "c2", "c1", func(msg *Msg) *Error {
+					return term1.Deliver(msg)
+				},
+			),
+		)
+		if err != nil {
+			t.Fatalf("%s: failed to create remote terminal: %s", test.name, err)
+		}
+
+		// Check negotiated version and features.
+		if term2.Version() != test.expectedVersion {
+			t.Errorf("%s: negotiated version %d, expected %d", test.name, term2.Version(), test.expectedVersion)
+		}
+		if term2.HasFeatures(1 << 63) {
+			t.Errorf("%s: unknown feature should not be negotiated", test.name)
+		}
+
+		testTerminalWithCounters(t, term1, term2, &testWithCounterOpts{
+			testName:      "versions-" + test.name,
+			clientCountTo: defaultTestQueueSize * 2,
+			serverCountTo: defaultTestQueueSize * 2,
+		})
+
+		term1.Abandon(nil)
+		term2.Abandon(nil)
+	}
+
+	// Version 1 Hubs cannot negotiate down.
+	initData, err := (&TerminalOpts{Version: 2}).Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parseTerminalOpts(initData, 1, 0)
+	if !err.Is(ErrUnsupportedVersion) {
+		t.Errorf("version 1 should not accept version 2, got: %s", err)
+	}
+
+	// Features require version 2.
+	err = (&TerminalOpts{Version: 1, Features: 1}).Check(true)
+	if !err.Is(ErrInvalidOptions) {
+		t.Errorf("version 1 should not support features, got: %s", err)
+	}
+}
+
+func TestTerminalNegotiation(t *testing.T) {
+	t.Parallel()
+
+	a, b, err := NewSimpleTestTerminalPair(0, 0, &TerminalOpts{
+		Version:         featureTerminalVersion,
+		Padding:         defaultTestPadding,
+		FlowControl:     FlowControlDFQ,
+		FlowControlSize: defaultTestQueueSize,
+	})
+	if err != nil {
+		t.Fatalf("failed to create test terminal pair: %s", err)
+	}
+	defer a.Abandon(nil)
+	defer b.Abandon(nil)
+
+	// The remote terminal returns the agreed version and features.
+	op, tErr := NewNegotiationOp(a.TerminalBase)
+	if tErr != nil {
+		t.Fatalf("failed to start negotiation op: %s", tErr)
+	}
+	if tErr := <-op.Result; !tErr.Is(ErrExplicitAck) {
+		t.Fatalf("negotiation should be confirmed, got: %s", tErr)
+	}
+
+	// Version or features the remote terminal did not agree to must be detected.
+	if tErr := op.check(&NegotiationResponse{Version: minSupportedTerminalVersion}); !tErr.Is(ErrUnsupportedVersion) {
+		t.Errorf("older version should not be accepted, got: %s", tErr)
+	}
+	if tErr := op.check(&NegotiationResponse{Version: featureTerminalVersion, Features: 1}); !tErr.Is(ErrUnsupportedVersion) {
+		t.Errorf("other features should not be accepted, got: %s", tErr)
+	}
+}
+
+func TestTerminalCompression(t *testing.T) {
+	t.Parallel()
+
+	identity, erro := cabin.CreateIdentity(module.Ctx, "test")
+	if erro != nil {
+		t.Fatalf("failed to create identity: %s", erro)
+	}
+
+	// Test compression of messages.
+	term := &TerminalBase{
+		opts: &TerminalOpts{Features: FeatureCompression},
+	}
+	testData := bytes.Repeat([]byte("compressible "), 100)
+	for _, test := range []struct {
+		name           string
+		data           []byte
+		incompressible bool
+		compressed     bool
+	}{
+		{name: "compressible", data: testData, compressed: true},
+		{name: "incompressible", data: testData, incompressible: true},
+		{name: "small", data: testData[:compressionMinSize-1]},
+	} {
+		msg := &Msg{
+			Data:           container.New(test.data),
+			Incompressible: test.incompressible,
+		}
+		term.compress(msg)
+		if test.compressed != (msg.Data.Length() < len(test.data)) {
+			t.Errorf("%s: compressed=%v, but size is %d/%d", test.name, test.compressed, msg.Data.Length(), len(test.data))
+		}
+		msg.Data.Append([]byte{0, 1, 2, 3}) // Padding.
+
+		data, _, tErr := term.decompress(msg.Data)
+		if tErr != nil {
+			t.Fatalf("%s: failed to decompress: %s", test.name, tErr)
+		}
+		if !bytes.HasPrefix(data.CompileData(), test.data) {
+			t.Errorf("%s: decompressed data does not match", test.name)
+		}
+	}
+
+	// Test decompression bomb protection.
+	bomb, err := deflate(make([]byte, maxDecompressedSize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := container.New(varint.Pack8(compressionDeflate))
+	c.AppendAsBlock(bomb)
+	if _, _, tErr := term.decompress(c); !tErr.Is(ErrMalformedData) {
+		t.Errorf("oversized decompressed data should fail, got: %s", tErr)
+	}
+
+	// Test compressed terminals.
+	testTerminals(t, identity, &TerminalOpts{
+		Version:         conf.VersionTwo,
+		Features:        FeatureCompression,
+		Encrypt:         true,
+		Padding:         defaultTestPadding,
+		FlowControl:     FlowControlDFQ,
+		FlowControlSize: defaultTestQueueSize,
+	})
+}
+
+func TestTerminalRekeying(t *testing.T) {
+	t.Parallel()
+
+	identity, erro := cabin.CreateIdentity(module.Ctx, "test")
+	if erro != nil {
+		t.Fatalf("failed to create identity: %s", erro)
+	}
+
+	// Test rekeying by data volume and by time.
+	for _, test := range []struct {
+		name            string
+		rekeyAfterBytes uint64
+		rekeyAfter      time.Duration
+		counterOpts     *testWithCounterOpts
+	}{
+		{
+			name:            "bytes",
+			rekeyAfterBytes: 1000,
+			counterOpts: &testWithCounterOpts{
+				clientCountTo: defaultTestQueueSize * 100,
+				serverCountTo: defaultTestQueueSize * 100,
+			},
+		},
+		{
+			name:       "time",
+			rekeyAfter: sendThresholdMaxWait,
+			counterOpts: &testWithCounterOpts{
+				flush:           true,
+				clientCountTo:   20,
+				serverCountTo:   20,
+				waitBetweenMsgs: sendThresholdMaxWait * 2,
+			},
+		},
+	} {
+		term1, term2 := createTestTerminals(t, identity, &TerminalOpts{
+			Version:         conf.VersionTwo,
+			Features:        FeatureRekeying,
+			Encrypt:         true,
+			Padding:         defaultTestPadding,
+			FlowControl:     FlowControlDFQ,
+			FlowControlSize: defaultTestQueueSize,
+			RekeyAfterBytes: test.rekeyAfterBytes,
+			RekeyAfter:      test.rekeyAfter,
+		})
+
+		// Run counters in both directions multiple times.
+		for i := 0; i < 3; i++ {
+			test.counterOpts.testName = fmt.Sprintf("rekeying-%s-%d", test.name, i)
+			testTerminalWithCounters(t, term1, term2, test.counterOpts)
+		}
+
+		// Check if both terminals switched sessions several times.
+		for _, term := range []*TestTerminal{term1, term2} {
+			term.jessionLock.Lock()
+			rekeys := term.rekeying.rekeys
+			term.jessionLock.Unlock()
+			if rekeys < 3 {
+				t.Errorf("%s: terminal %s only rekeyed %d times", test.name, term.FmtID(), rekeys)
+			}
+		}
+
+		// Check that a new session cannot be started outside of the current session.
+		injected, tErr := newLocalJession(identity.Hub)
+		if tErr != nil {
+			t.Fatalf("failed to create session: %s", tErr)
+		}
+		letter, err := injected.Close([]byte("injected"))
+		if err != nil {
+			t.Fatalf("failed to encrypt: %s", err)
+		}
+		injectedData, err := letter.ToWire()
+		if err != nil {
+			t.Fatalf("failed to pack letter: %s", err)
+		}
+		term2.jessionLock.Lock()
+		recvID := term2.rekeying.recvID
+		injectedData.Prepend(varint.Pack32(recvID + 1))
+		_, tErr = term2.decryptWithRekeying(injectedData)
+		if tErr == nil {
+			t.Errorf("%s: injected session was accepted", test.name)
+		}
+		if term2.rekeying.recvID != recvID {
+			t.Errorf("%s: switched to injected session", test.name)
+		}
+		term2.jessionLock.Unlock()
+
+		// Clean up.
+		term1.Abandon(nil)
+		term2.Abandon(nil)
+	}
+}
+
+func TestTerminalHeartbeat(t *testing.T) {
+	t.Parallel()
+
+	// Create test terminals with a connection that can go half-dead.
+	var dead atomic.Bool
+	var term1, term2 *TestTerminal
+	term1, initData, tErr := NewLocalTestTerminal(
+		module.Ctx, 127, "h1", nil, &TerminalOpts{
+			Padding:           defaultTestPadding,
+			FlowControl:       FlowControlDFQ,
+			FlowControlSize:   defaultTestQueueSize,
+			HeartbeatInterval: 50 * time.Millisecond,
+		}, UpstreamSendFunc(func(msg *Msg, _ time.Duration) *Error {
+			return term2.Deliver(msg)
+		}),
+	)
+	if tErr != nil {
+		t.Fatalf("failed to create local terminal: %s", tErr)
+	}
+	term2, _, tErr = NewRemoteTestTerminal(
+		module.Ctx, 127, "h2", nil, initData, UpstreamSendFunc(func(msg *Msg, _ time.Duration) *Error {
+			if dead.Load() {
+				msg.Finish()
+				return nil
+			}
+			return term1.Deliver(msg)
+		}),
+	)
+	if tErr != nil {
+		t.Fatalf("failed to create remote terminal: %s", tErr)
+	}
+	defer term2.Abandon(nil)
+
+	// Heartbeats are only sent while the terminal is in use.
+	op, tErr := newTestStreamOp(term1)
+	if tErr != nil {
+		t.Fatalf("failed to start operation: %s", tErr)
+	}
+	defer op.Close() //nolint:errcheck
+	<-testStreamOps
+
+	// Wait for RTT samples.
+	for i := 0; i < 100; i++ {
+		if latest, _ := term1.HeartbeatRTT(); latest > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	latest, smoothed := term1.HeartbeatRTT()
+	if latest <= 0 || smoothed <= 0 {
+		t.Fatalf("no heartbeat RTT samples: latest=%s smoothed=%s", latest, smoothed)
+	}
+
+	// The terminal must be abandoned when the remote stops responding.
+	dead.Store(true)
+	select {
+	case <-term1.Ctx().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("terminal was not abandoned after missing heartbeats")
+	}
+}